@@ -2,7 +2,9 @@ package main
 
 import (
 	"backup/internal/script"
+	"backup/internal/timer"
 	"backup/internal/ui"
+	"fmt"
 	"io"
 
 	"log"
@@ -16,6 +18,9 @@ type args struct {
 	config     string
 	log        string
 	disableLog bool
+	dryRun     bool
+	output     string
+	policy     script.Policy
 }
 
 func main() {
@@ -48,6 +53,325 @@ func main() {
 					return runUI(args)
 				},
 			},
+			{
+				Name:      "verify",
+				Usage:     "check a backup directory or archive against its manifest",
+				ArgsUsage: "[backup directory or archive, defaults to archive or backup directory from config]",
+				Action: func(cCtx *cli.Context) error {
+					if !script.Verify(cCtx.String("config"), cCtx.Args().First()) {
+						return cli.Exit("", 1)
+					}
+					return nil
+				},
+			},
+			{
+				Name:      "upload",
+				Usage:     "upload an archive to the destinations and drives from the config, continues an interrupted upload",
+				ArgsUsage: "[archive, defaults to zip file from config]",
+				Action: func(cCtx *cli.Context) error {
+					if !script.Upload(cCtx.String("config"), cCtx.Args().First()) {
+						return cli.Exit("", 1)
+					}
+					return nil
+				},
+			},
+			{
+				Name:  "drives",
+				Usage: "show which backups the removable drives from the config hold, the most out of date first",
+				Action: func(cCtx *cli.Context) error {
+					if !script.Drives(cCtx.String("config")) {
+						return cli.Exit("", 1)
+					}
+					return nil
+				},
+			},
+			{
+				Name:  "test-notify",
+				Usage: "send a test notification to every channel from the config",
+				Action: func(cCtx *cli.Context) error {
+					if !script.TestNotify(cCtx.String("config")) {
+						return cli.Exit("", 1)
+					}
+					return nil
+				},
+			},
+			{
+				Name:  "daemon",
+				Usage: "run backups on the schedules from the config, the TUI shows the status",
+				Action: func(cCtx *cli.Context) error {
+					if !script.Daemon(cCtx.String("config")) {
+						return cli.Exit("", 1)
+					}
+					return nil
+				},
+			},
+			{
+				Name:  "install-timer",
+				Usage: "install a systemd user timer that runs the backup with the config on a schedule, logs go to the journal",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "schedule",
+						Value: "daily",
+						Usage: "systemd calendar expression, e.g. daily, \"*-*-* 02:00\" or \"Mon..Fri 12:30\"",
+					},
+					&cli.StringFlag{
+						Name:  "name",
+						Value: timer.DefaultName,
+						Usage: "name of the units, e.g. to schedule backups with several configs",
+					},
+					&cli.StringFlag{
+						Name:  "on-existing-dir",
+						Value: "continue",
+						Usage: "what scheduled runs do if the backup directory is not empty: continue or abort",
+					},
+					&cli.StringFlag{
+						Name:  "on-missing-parent",
+						Value: "abort",
+						Usage: "what scheduled runs do if the parent of the backup directory does not exist: continue or abort",
+					},
+					&cli.StringFlag{
+						Name:  "on-low-space",
+						Value: "abort",
+						Usage: "what scheduled runs do if there is not enough free space: continue or abort",
+					},
+					&cli.IntFlag{
+						Name:  "retries",
+						Value: 2,
+						Usage: "how often scheduled runs try loading and cloning repos again",
+					},
+				},
+				Action: func(cCtx *cli.Context) error {
+					policy, err := parsePolicy(cCtx)
+					if err != nil {
+						return cli.Exit(err, 1)
+					}
+					args := script.TimerArgs{
+						Name:       cCtx.String("name"),
+						Schedule:   cCtx.String("schedule"),
+						ConfigFile: cCtx.String("config"),
+						Policy:     policy,
+					}
+					if !script.InstallTimer(args) {
+						return cli.Exit("", 1)
+					}
+					return nil
+				},
+			},
+			{
+				Name:  "uninstall-timer",
+				Usage: "stop the systemd user timer and remove its units",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "name",
+						Value: timer.DefaultName,
+						Usage: "name of the units",
+					},
+				},
+				Action: func(cCtx *cli.Context) error {
+					if !script.UninstallTimer(cCtx.String("name")) {
+						return cli.Exit("", 1)
+					}
+					return nil
+				},
+			},
+			{
+				Name:  "timer-status",
+				Usage: "show when the systemd user timer runs next and how the last run went",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "name",
+						Value: timer.DefaultName,
+						Usage: "name of the units",
+					},
+				},
+				Action: func(cCtx *cli.Context) error {
+					if !script.TimerStatus(cCtx.String("name")) {
+						return cli.Exit("", 1)
+					}
+					return nil
+				},
+			},
+			{
+				Name:      "restore",
+				Usage:     "restore files from a backup directory or archive",
+				ArgsUsage: "<backup directory or archive> [original paths to restore, defaults to everything]",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:    "target",
+						Aliases: []string{"t"},
+						Value:   "",
+						Usage:   "restore below this directory instead of the original locations",
+					},
+					&cli.StringFlag{
+						Name:  "conflict",
+						Value: "skip",
+						Usage: "what to do with existing files: skip, overwrite, keep-both or newer-wins",
+					},
+					&cli.BoolFlag{
+						Name:  "dry-run",
+						Value: false,
+						Usage: "only show what would be restored",
+					},
+				},
+				Action: func(cCtx *cli.Context) error {
+					if cCtx.NArg() == 0 {
+						return cli.Exit("no backup directory or archive given", 1)
+					}
+					args := script.RestoreArgs{
+						Backup: cCtx.Args().First(),
+						Paths:  cCtx.Args().Tail(),
+						Target: cCtx.String("target"),
+						Policy: cCtx.String("conflict"),
+						DryRun: cCtx.Bool("dry-run"),
+					}
+					if !script.Restore(args) {
+						return cli.Exit("", 1)
+					}
+					return nil
+				},
+			},
+			{
+				Name:      "ls",
+				Usage:     "list the files of a backup directory or archive",
+				ArgsUsage: "<backup directory or archive> [path in the backup, defaults to everything]",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "find",
+						Value: "",
+						Usage: "only list files whose name contains this, ignoring case",
+					},
+				},
+				Action: func(cCtx *cli.Context) error {
+					if cCtx.NArg() == 0 {
+						return cli.Exit("no backup directory or archive given", 1)
+					}
+					args := script.LsArgs{
+						Archive: cCtx.Args().First(),
+						Path:    cCtx.Args().Get(1),
+						Find:    cCtx.String("find"),
+					}
+					if !script.Ls(args) {
+						return cli.Exit("", 1)
+					}
+					return nil
+				},
+			},
+			{
+				Name:      "extract",
+				Usage:     "extract files and directories of a backup into a directory",
+				ArgsUsage: "<backup directory or archive> [paths in the backup as listed by ls, defaults to everything]",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:    "output",
+						Aliases: []string{"o"},
+						Value:   "",
+						Usage:   "directory to extract to, defaults to the current directory",
+					},
+					&cli.BoolFlag{
+						Name:  "overwrite",
+						Value: false,
+						Usage: "overwrite existing files instead of skipping them",
+					},
+				},
+				Action: func(cCtx *cli.Context) error {
+					if cCtx.NArg() == 0 {
+						return cli.Exit("no backup directory or archive given", 1)
+					}
+					args := script.ExtractArgs{
+						Archive:   cCtx.Args().First(),
+						Paths:     cCtx.Args().Tail(),
+						Output:    cCtx.String("output"),
+						Overwrite: cCtx.Bool("overwrite"),
+					}
+					if !script.Extract(args) {
+						return cli.Exit("", 1)
+					}
+					return nil
+				},
+			},
+			{
+				Name:      "decrypt",
+				Usage:     "decrypt an encrypted archive",
+				ArgsUsage: "<encrypted archive>",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:    "identity",
+						Aliases: []string{"i"},
+						Value:   "",
+						Usage:   "secret key file, defaults to the identity file from the config",
+					},
+					&cli.StringFlag{
+						Name:  "passphrase-file",
+						Value: "",
+						Usage: "file with the passphrase, defaults to the passphrase file from the config",
+					},
+					&cli.StringFlag{
+						Name:    "output",
+						Aliases: []string{"o"},
+						Value:   "",
+						Usage:   "decrypted archive, defaults to the name without .enc",
+					},
+				},
+				Action: func(cCtx *cli.Context) error {
+					if cCtx.NArg() == 0 {
+						return cli.Exit("no encrypted archive given", 1)
+					}
+					args := script.DecryptArgs{
+						Config:         cCtx.String("config"),
+						File:           cCtx.Args().First(),
+						Output:         cCtx.String("output"),
+						Identity:       cCtx.String("identity"),
+						PassphraseFile: cCtx.String("passphrase-file"),
+					}
+					if !script.Decrypt(args) {
+						return cli.Exit("", 1)
+					}
+					return nil
+				},
+			},
+			{
+				Name:      "join",
+				Usage:     "verify the volumes of a split archive and join them",
+				ArgsUsage: "<index, volume or archive>",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:    "output",
+						Aliases: []string{"o"},
+						Value:   "",
+						Usage:   "joined archive, defaults to the original name next to the volumes",
+					},
+					&cli.BoolFlag{
+						Name:  "check",
+						Value: false,
+						Usage: "only verify the volumes, nothing is written",
+					},
+				},
+				Action: func(cCtx *cli.Context) error {
+					if cCtx.NArg() == 0 {
+						return cli.Exit("no split archive given", 1)
+					}
+					args := script.JoinArgs{
+						Path:   cCtx.Args().First(),
+						Output: cCtx.String("output"),
+						Check:  cCtx.Bool("check"),
+					}
+					if !script.Join(args) {
+						return cli.Exit("", 1)
+					}
+					return nil
+				},
+			},
+			{
+				Name:      "keygen",
+				Usage:     "create a key pair to encrypt archives for",
+				ArgsUsage: "[secret key file, the key is printed if not given]",
+				Action: func(cCtx *cli.Context) error {
+					if !script.Keygen(cCtx.Args().First()) {
+						return cli.Exit("", 1)
+					}
+					return nil
+				},
+			},
 		},
 		Flags: []cli.Flag{
 			&cli.StringFlag{
@@ -56,10 +380,55 @@ func main() {
 				Value:   "",
 				Usage:   "config file",
 			},
+			&cli.BoolFlag{
+				Name:  "dry-run",
+				Value: false,
+				Usage: "only show what would be backed up, nothing is created, copied or zipped",
+			},
+			&cli.StringFlag{
+				Name:  "output",
+				Value: "text",
+				Usage: "output format: text or json, a backup run prints an event per line and a summary with json",
+			},
+			&cli.BoolFlag{
+				Name:    "yes",
+				Aliases: []string{"y"},
+				Value:   false,
+				Usage:   "never ask, continue wherever a question would be asked unless a policy says otherwise",
+			},
+			&cli.BoolFlag{
+				Name:  "non-interactive",
+				Value: false,
+				Usage: "never ask, e.g. for cron or systemd, abort wherever a question would be asked unless a policy says otherwise",
+			},
+			&cli.StringFlag{
+				Name:  "on-existing-dir",
+				Usage: "what to do if the backup directory is not empty: continue or abort",
+			},
+			&cli.StringFlag{
+				Name:  "on-missing-parent",
+				Usage: "what to do if the parent of the backup directory does not exist: continue or abort",
+			},
+			&cli.StringFlag{
+				Name:  "on-low-space",
+				Usage: "what to do if there is not enough free space: continue or abort",
+			},
+			&cli.IntFlag{
+				Name:  "retries",
+				Value: 2,
+				Usage: "how often loading and cloning repos is tried again without asking",
+			},
 		},
 		Action: func(cCtx *cli.Context) error {
+			policy, err := parsePolicy(cCtx)
+			if err != nil {
+				return cli.Exit(err, 1)
+			}
 			args := args{
 				config: cCtx.String("config"),
+				dryRun: cCtx.Bool("dry-run"),
+				output: cCtx.String("output"),
+				policy: policy,
 			}
 			return run(args)
 		},
@@ -71,10 +440,59 @@ func main() {
 }
 
 func run(args args) error {
-	script.Backup(args.config)
+	if args.dryRun {
+		if !script.DryRun(args.config, args.output) {
+			return cli.Exit("", 1)
+		}
+		return nil
+	}
+	if args.output == "json" {
+		// questions would end up in the middle of the events
+		args.policy.NonInteractive = true
+	}
+	if result := script.Backup(args.config, args.policy, args.output); result != script.ResultSuccess {
+		return cli.Exit("", int(result))
+	}
 	return nil
 }
 
+// Any of the policy flags makes the run non-interactive, policies that are not given default to --yes or abort.
+func parsePolicy(cCtx *cli.Context) (script.Policy, error) {
+	p := script.Policy{Retries: cCtx.Int("retries")}
+	if cCtx.Bool("yes") {
+		p = script.YesPolicy(p.Retries)
+	}
+	if cCtx.Bool("non-interactive") {
+		p.NonInteractive = true
+	}
+	if p.Retries < 0 {
+		return p, fmt.Errorf("invalid retries %v: cannot be negative", p.Retries)
+	}
+	for _, f := range []struct {
+		name   string
+		answer *bool
+	}{
+		{"on-existing-dir", &p.ExistingDir},
+		{"on-missing-parent", &p.MissingParent},
+		{"on-low-space", &p.LowSpace},
+	} {
+		v := cCtx.String(f.name)
+		if v == "" {
+			continue
+		}
+		switch v {
+		case "continue":
+			*f.answer = true
+		case "abort":
+			*f.answer = false
+		default:
+			return p, fmt.Errorf("invalid %s %q: must be continue or abort", f.name, v)
+		}
+		p.NonInteractive = true
+	}
+	return p, nil
+}
+
 // Note: the log package is safe to use with multiple goroutines, fmt is not and might produce mixed output.
 func runUI(args args) error {
 	if args.disableLog {
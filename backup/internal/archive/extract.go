@@ -0,0 +1,192 @@
+package archive
+
+import (
+	"backup/internal/fs"
+	"errors"
+	"fmt"
+	"io"
+	iofs "io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// CleanPath returns a path of the backup in the form of Entry.Path, e.g. "/files/home/" becomes "files/home".
+// The root of the backup is the empty string.
+func CleanPath(p string) string {
+	return strings.Trim(path.Clean("/"+filepath.ToSlash(p)), "/")
+}
+
+// Below returns true if the entry is at p or inside the directory p, every entry is below the root "".
+func (e Entry) Below(p string) bool {
+	return p == "" || e.Path == p || strings.HasPrefix(e.Path, p+"/")
+}
+
+type ExtractOptions struct {
+	// existing files are skipped unless set
+	Overwrite bool
+	// called regularly while extracting and once more when done
+	OnProgress func(Progress)
+}
+
+// Extracted is the result for a single file.
+type Extracted struct {
+	Entry Entry
+	Dest  string
+	// the file exists and was not overwritten
+	Skipped bool
+	Err     error
+}
+
+// Extract writes the files at the given paths and everything below them into dir. A selected file or directory is
+// extracted with its name, e.g. files/home/user/docs to <dir>/docs/..., the root "" extracts the whole backup with
+// its full paths. An error is only returned if nothing could be extracted, errors of single files are in the result.
+func Extract(r Reader, paths []string, dir string, opts ExtractOptions) ([]Extracted, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		paths = []string{""}
+	}
+
+	var results []Extracted
+	seen := map[string]bool{}
+	var bytesTotal int64
+	for _, p := range paths {
+		p = CleanPath(p)
+		// the name of the selected path is kept, everything above it is stripped
+		parent := path.Dir(p)
+		found := false
+		for _, e := range r.Entries() {
+			if !e.Below(p) {
+				continue
+			}
+			found = true
+			if seen[e.Path] {
+				continue
+			}
+			seen[e.Path] = true
+			rel := e.Path
+			if parent != "." {
+				rel = strings.TrimPrefix(e.Path, parent+"/")
+			}
+			results = append(results, Extracted{Entry: e, Dest: filepath.Join(dir, filepath.FromSlash(rel))})
+			bytesTotal += e.Size
+		}
+		if !found {
+			return nil, fmt.Errorf("%s: %w", p, iofs.ErrNotExist)
+		}
+	}
+
+	start := time.Now()
+	var lastProgress time.Time
+	progress := Progress{FilesTotal: len(results), BytesTotal: bytesTotal}
+	report := func(done bool) {
+		if opts.OnProgress == nil || (!done && time.Since(lastProgress) < progressInterval) {
+			return
+		}
+		lastProgress = time.Now()
+		progress.Elapsed = time.Since(start)
+		progress.Done = done
+		opts.OnProgress(progress)
+	}
+
+	for i := range results {
+		x := &results[i]
+		progress.Current = x.Entry.Path
+		report(false)
+		// paths of a broken or malicious archive could point anywhere, e.g. ../../.bashrc
+		if !strings.HasPrefix(x.Dest, dir+string(filepath.Separator)) {
+			x.Err = errors.New("path is outside of the target directory")
+		} else if err := CheckParents(dir, x.Dest); err != nil {
+			x.Err = err
+		} else if _, err := os.Lstat(x.Dest); err == nil && !opts.Overwrite {
+			x.Skipped = true
+		} else {
+			x.Err = ExtractFile(r, x.Entry, x.Dest)
+		}
+		// skipped and failed files count as done, the progress is about what is left to do
+		progress.FilesDone++
+		progress.BytesDone += x.Entry.Size
+	}
+	progress.Current = ""
+	report(true)
+	return results, nil
+}
+
+// CheckParents returns an error if a directory between dir and dest is a symlink. An archive with a -> /etc followed
+// by a/passwd would otherwise write through the link that was just extracted.
+func CheckParents(dir string, dest string) error {
+	rel, err := filepath.Rel(dir, filepath.Dir(dest))
+	if err != nil || rel == "." {
+		return err
+	}
+	p := dir
+	for _, name := range strings.Split(rel, string(filepath.Separator)) {
+		p = filepath.Join(p, name)
+		info, err := os.Lstat(p)
+		if errors.Is(err, iofs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&iofs.ModeSymlink != 0 {
+			return fmt.Errorf("%s is a symlink", p)
+		}
+	}
+	return nil
+}
+
+// ExtractFile writes a single file of the backup to dest, an existing file is replaced.
+// Modes and modification times are restored where they were preserved.
+func ExtractFile(r Reader, e Entry, dest string) error {
+	err := fs.CreateDir(filepath.Dir(dest))
+	if err != nil {
+		return fmt.Errorf("could not create directory: %w", err)
+	}
+
+	if e.IsSymlink() {
+		// os.Symlink fails if the file exists
+		if err := os.Remove(dest); err != nil && !errors.Is(err, iofs.ErrNotExist) {
+			return err
+		}
+		return os.Symlink(e.Link, dest)
+	}
+
+	// write to a temporary file first so that an existing file is not destroyed if something goes wrong
+	tmp, err := os.CreateTemp(filepath.Dir(dest), ".restore-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	rc, err := r.Open(e.Path)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	_, err = io.Copy(tmp, rc)
+	rc.Close()
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Chmod(tmp.Name(), e.Mode.Perm()); err != nil {
+		return err
+	}
+	if !e.ModTime.IsZero() {
+		if err := os.Chtimes(tmp.Name(), e.ModTime, e.ModTime); err != nil {
+			return err
+		}
+	}
+
+	return os.Rename(tmp.Name(), dest)
+}
@@ -0,0 +1,51 @@
+package archive
+
+import (
+	"archive/tar"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestExtractThroughSymlink(t *testing.T) {
+	outside := t.TempDir()
+	file := filepath.Join(t.TempDir(), "backup.tar")
+	f, err := os.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := tar.NewWriter(f)
+	if err := w.WriteHeader(&tar.Header{Name: "a", Typeflag: tar.TypeSymlink, Linkname: outside, Mode: 0o777, ModTime: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	content := []byte("root:x:0:0")
+	if err := w.WriteHeader(&tar.Header{Name: "a/passwd", Mode: 0o644, Size: int64(len(content)), ModTime: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(content); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	r, err := Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	results, err := Extract(r, nil, t.TempDir(), ExtractOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, x := range results {
+		if x.Entry.Path == "a/passwd" && x.Err == nil {
+			t.Errorf("no error for %s extracted to %s", x.Entry.Path, x.Dest)
+		}
+	}
+	if _, err := os.Lstat(filepath.Join(outside, "passwd")); err == nil {
+		t.Error("file was written through the symlink")
+	}
+}
@@ -0,0 +1,206 @@
+package archive
+
+import (
+	"backup/internal/crypt"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+type Format string
+
+const (
+	FormatZip    Format = "zip"
+	FormatTar    Format = "tar"
+	FormatTarGz  Format = "tar.gz"
+	FormatTarXz  Format = "tar.xz"
+	FormatTarZst Format = "tar.zst"
+)
+
+// Formats are all supported archive formats, zip is the default.
+var Formats = []Format{FormatZip, FormatTar, FormatTarGz, FormatTarXz, FormatTarZst}
+
+// ParseFormat returns the format with the given name, an empty name is zip.
+func ParseFormat(name string) (Format, error) {
+	if name == "" {
+		return FormatZip, nil
+	}
+	for _, f := range Formats {
+		if string(f) == strings.ToLower(name) {
+			return f, nil
+		}
+	}
+	return "", fmt.Errorf("unknown archive format %q, expected one of zip, tar, tar.gz, tar.xz, tar.zst", name)
+}
+
+// Extension of archive files of this format including the leading dot.
+func (f Format) Extension() string {
+	return "." + string(f)
+}
+
+// FormatOf guesses the format from the file extension, ok is false for unknown extensions.
+func FormatOf(file string) (Format, bool) {
+	name := strings.ToLower(filepath.Base(file))
+	switch {
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return FormatTarGz, true
+	case strings.HasSuffix(name, ".tar.xz"), strings.HasSuffix(name, ".txz"):
+		return FormatTarXz, true
+	case strings.HasSuffix(name, ".tar.zst"), strings.HasSuffix(name, ".tzst"):
+		return FormatTarZst, true
+	case strings.HasSuffix(name, ".tar"):
+		return FormatTar, true
+	case strings.HasSuffix(name, ".zip"):
+		return FormatZip, true
+	}
+	return "", false
+}
+
+// ReplaceExtension replaces a known archive extension of file with the one of format.
+// Files without a known extension are returned unchanged.
+func ReplaceExtension(file string, format Format) string {
+	current, ok := FormatOf(file)
+	if !ok {
+		return file
+	}
+	lower := strings.ToLower(file)
+	for _, ext := range []string{current.Extension(), ".tgz", ".txz", ".tzst"} {
+		if strings.HasSuffix(lower, ext) {
+			return file[:len(file)-len(ext)] + format.Extension()
+		}
+	}
+	return file
+}
+
+// ValidateLevel checks that level is a valid compression level for the format, 0 is always the default level.
+func ValidateLevel(format Format, level int) error {
+	if level == 0 {
+		return nil
+	}
+	var max int
+	switch format {
+	case FormatZip, FormatTarGz, FormatTarXz:
+		max = 9
+	case FormatTarZst:
+		max = 22
+	default:
+		return fmt.Errorf("%s does not support compression levels", format)
+	}
+	if level < 1 || level > max {
+		return fmt.Errorf("compression level for %s must be between 1 and %v", format, max)
+	}
+	return nil
+}
+
+// Extensions of files that are already compressed, they are stored as they are in zip files.
+// Compressing them again costs a lot of time and usually makes them bigger.
+var compressedExtensions = map[string]bool{
+	".zip": true, ".gz": true, ".tgz": true, ".bz2": true, ".xz": true, ".zst": true, ".7z": true, ".rar": true,
+	".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true, ".heic": true,
+	".mp3": true, ".m4a": true, ".ogg": true, ".opus": true, ".flac": true,
+	".mp4": true, ".m4v": true, ".mkv": true, ".mov": true, ".webm": true, ".avi": true,
+	".pdf": true, ".docx": true, ".xlsx": true, ".pptx": true, ".odt": true, ".ods": true, ".epub": true,
+	".jar": true, ".apk": true, ".deb": true, ".rpm": true,
+}
+
+func isCompressed(name string) bool {
+	return compressedExtensions[strings.ToLower(filepath.Ext(name))]
+}
+
+// Dictionary sizes of the presets 1-9 of the xz command, larger dictionaries compress better and need more memory.
+// The xz package has no other settings for the level.
+var xzDictSizes = [...]int{1 << 20, 2 << 20, 4 << 20, 4 << 20, 8 << 20, 8 << 20, 16 << 20, 32 << 20, 64 << 20}
+
+// Wraps w with the compression of the format, tar without compression and zip are returned as they are.
+func compress(w io.Writer, format Format, level int) (io.WriteCloser, error) {
+	switch format {
+	case FormatTarGz:
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		return gzip.NewWriterLevel(w, level)
+	case FormatTarXz:
+		if level == 0 {
+			return xz.NewWriter(w)
+		}
+		return xz.WriterConfig{DictCap: xzDictSizes[level-1]}.NewWriter(w)
+	case FormatTarZst:
+		if level == 0 {
+			return zstd.NewWriter(w)
+		}
+		return zstd.NewWriter(w, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+	default:
+		return nopWriteCloser{w}, nil
+	}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+var (
+	magicZip      = []byte("PK")
+	magicGzip     = []byte{0x1f, 0x8b}
+	magicXz       = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+	magicZstd     = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	magicTar      = []byte("ustar")
+	magicTarStart = 257
+)
+
+// Detects the format of an archive from its first bytes, the extension does not matter.
+func detectFormat(r io.Reader, file string) (Format, error) {
+	header := make([]byte, 512)
+	n, err := io.ReadFull(r, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	header = header[:n]
+
+	switch {
+	case crypt.IsEncrypted(header):
+		return "", fmt.Errorf("%s is encrypted, decrypt it first with backup decrypt", file)
+	case bytes.HasPrefix(header, magicZip):
+		return FormatZip, nil
+	case bytes.HasPrefix(header, magicGzip):
+		return FormatTarGz, nil
+	case bytes.HasPrefix(header, magicXz):
+		return FormatTarXz, nil
+	case bytes.HasPrefix(header, magicZstd):
+		return FormatTarZst, nil
+	case len(header) >= magicTarStart+len(magicTar) && bytes.Equal(header[magicTarStart:magicTarStart+len(magicTar)], magicTar):
+		return FormatTar, nil
+	}
+	return "", fmt.Errorf("%s is neither a zip nor a tar file", file)
+}
+
+// Wraps r with the decompression of the format.
+func decompress(r io.Reader, format Format) (io.ReadCloser, error) {
+	switch format {
+	case FormatTarGz:
+		return gzip.NewReader(r)
+	case FormatTarXz:
+		xr, err := xz.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(xr), nil
+	case FormatTarZst:
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return zr.IOReadCloser(), nil
+	default:
+		return io.NopCloser(r), nil
+	}
+}
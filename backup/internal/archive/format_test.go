@@ -0,0 +1,60 @@
+package archive
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestValidateLevel(t *testing.T) {
+	for _, test := range []struct {
+		format Format
+		level  int
+		ok     bool
+	}{
+		{FormatZip, 0, true},
+		{FormatZip, 9, true},
+		{FormatZip, 10, false},
+		{FormatTarGz, -1, false},
+		{FormatTarXz, 1, true},
+		{FormatTarXz, 9, true},
+		{FormatTarXz, 10, false},
+		{FormatTarZst, 22, true},
+		{FormatTar, 0, true},
+		{FormatTar, 1, false},
+	} {
+		if err := ValidateLevel(test.format, test.level); (err == nil) != test.ok {
+			t.Errorf("%s level %v: got %v, expected ok %v", test.format, test.level, err, test.ok)
+		}
+	}
+}
+
+func TestXzLevels(t *testing.T) {
+	data := []byte(strings.Repeat("backup of the backup directory\n", 1000))
+	for _, level := range []int{0, 1, 9} {
+		var buf bytes.Buffer
+		w, err := compress(&buf, FormatTarXz, level)
+		if err != nil {
+			t.Fatalf("level %v: %v", level, err)
+		}
+		if _, err := w.Write(data); err != nil {
+			t.Fatalf("level %v: %v", level, err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("level %v: %v", level, err)
+		}
+
+		r, err := decompress(&buf, FormatTarXz)
+		if err != nil {
+			t.Fatalf("level %v: %v", level, err)
+		}
+		got, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("level %v: %v", level, err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("level %v: data changed", level)
+		}
+	}
+}
@@ -0,0 +1,420 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"backup/internal/volume"
+	"fmt"
+	"io"
+	iofs "io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// An Entry is a file in a backup, either in a backup directory or in an archive.
+type Entry struct {
+	// relative to the root of the backup, always uses forward slashes
+	Path    string
+	Size    int64
+	Mode    iofs.FileMode
+	ModTime time.Time
+	// target of a symlink
+	Link string
+}
+
+func (e Entry) IsSymlink() bool {
+	return e.Mode&iofs.ModeSymlink != 0
+}
+
+// A Reader provides uniform access to the files of a backup, no matter if it is a directory or an archive.
+// Directories are not returned as entries.
+type Reader interface {
+	// sorted by path
+	Entries() []Entry
+	// Open returns the content of the file with the given path.
+	// For archives with checksums, reading the file until EOF will return an error if the content is corrupt.
+	Open(path string) (io.ReadCloser, error)
+	Close() error
+}
+
+// Open returns a reader for a backup directory or archive.
+// Archives that were split into volumes can be opened by their index, any of the volumes or the name of the archive.
+func Open(path string) (Reader, error) {
+	info, err := os.Stat(path)
+	if err != nil || !info.IsDir() {
+		if index, ok := volume.FindIndex(path); ok {
+			return openVolumes(index)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return openDir(path)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	format, err := detectFormat(f, path)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if format == FormatZip {
+		return openZip(f, info.Size(), f)
+	}
+	f.Close()
+	return openTar(func() (io.ReadCloser, error) { return os.Open(path) }, format, nil)
+}
+
+func openVolumes(index string) (Reader, error) {
+	v, err := volume.Open(index)
+	if err != nil {
+		return nil, err
+	}
+	format, err := detectFormat(v.NewReader(), v.Index().File)
+	if err != nil {
+		v.Close()
+		return nil, err
+	}
+	if format == FormatZip {
+		return openZip(v, v.Size(), v)
+	}
+	return openTar(func() (io.ReadCloser, error) { return io.NopCloser(v.NewReader()), nil }, format, v)
+}
+
+type dirReader struct {
+	dir     string
+	entries []Entry
+}
+
+func openDir(dir string) (*dirReader, error) {
+	var entries []Entry
+	err := filepath.WalkDir(dir, func(p string, d iofs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		entry := Entry{
+			Path:    filepath.ToSlash(rel),
+			Size:    info.Size(),
+			Mode:    info.Mode(),
+			ModTime: info.ModTime(),
+		}
+		if entry.IsSymlink() {
+			link, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+			entry.Link = link
+		}
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sortEntries(entries)
+	return &dirReader{dir: dir, entries: entries}, nil
+}
+
+func (r *dirReader) Entries() []Entry {
+	return r.entries
+}
+
+func (r *dirReader) Open(path string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(r.dir, filepath.FromSlash(path)))
+}
+
+func (r *dirReader) Close() error {
+	return nil
+}
+
+type zipReader struct {
+	r       *zip.Reader
+	closer  io.Closer
+	entries []Entry
+	files   map[string]*zip.File
+}
+
+// closer is closed with the reader
+func openZip(ra io.ReaderAt, size int64, closer io.Closer) (*zipReader, error) {
+	r, err := zip.NewReader(ra, size)
+	if err != nil {
+		closer.Close()
+		return nil, fmt.Errorf("could not open zip file: %w", err)
+	}
+
+	// zip files created by this program contain the backup directory itself
+	// e.g. backup-2024-01-01/github/... instead of just github/..., we are only interested in its contents
+	names := make([]string, len(r.File))
+	for i, f := range r.File {
+		names[i] = f.Name
+	}
+	prefix := commonRoot(names)
+
+	var entries []Entry
+	files := map[string]*zip.File{}
+	for _, f := range r.File {
+		if f.Mode().IsDir() || strings.HasSuffix(f.Name, "/") {
+			continue
+		}
+		entry := Entry{
+			Path:    strings.TrimPrefix(f.Name, prefix),
+			Size:    int64(f.UncompressedSize64),
+			Mode:    f.Mode(),
+			ModTime: f.Modified,
+		}
+		if entry.IsSymlink() {
+			link, err := readAll(f)
+			if err != nil {
+				closer.Close()
+				return nil, fmt.Errorf("could not read symlink %s: %w", f.Name, err)
+			}
+			entry.Link = link
+		}
+		entries = append(entries, entry)
+		files[entry.Path] = f
+	}
+	sortEntries(entries)
+
+	return &zipReader{r: r, closer: closer, entries: entries, files: files}, nil
+}
+
+func (r *zipReader) Entries() []Entry {
+	return r.entries
+}
+
+func (r *zipReader) Open(path string) (io.ReadCloser, error) {
+	f, ok := r.files[path]
+	if !ok {
+		return nil, iofs.ErrNotExist
+	}
+	return f.Open()
+}
+
+func (r *zipReader) Close() error {
+	return r.closer.Close()
+}
+
+// Returns the name of the top level directory including a trailing slash if all files are contained in it.
+func commonRoot(names []string) string {
+	var root string
+	for _, name := range names {
+		i := strings.Index(name, "/")
+		if i == -1 {
+			return ""
+		}
+		if root == "" {
+			root = name[:i+1]
+		} else if root != name[:i+1] {
+			return ""
+		}
+	}
+	return root
+}
+
+// Tar files cannot be read at random positions, especially when they are compressed.
+// Files are read by going forward through the stream, it is only started again when a file before the current position is opened.
+// Opening files in the order of the archive, which is close to the order of Entries, only reads the archive once.
+type tarReader struct {
+	// returns the archive from the beginning
+	open    func() (io.ReadCloser, error)
+	closer  io.Closer
+	format  Format
+	entries []Entry
+	// position of every file in the archive
+	positions map[string]int
+
+	f   io.ReadCloser
+	d   io.ReadCloser
+	t   *tar.Reader
+	pos int
+}
+
+// closer is closed with the reader, it may be nil
+func openTar(open func() (io.ReadCloser, error), format Format, closer io.Closer) (*tarReader, error) {
+	r := &tarReader{open: open, closer: closer, format: format, positions: map[string]int{}}
+	if err := r.restart(); err != nil {
+		r.Close()
+		return nil, err
+	}
+	defer r.closeStream()
+
+	type header struct {
+		name string
+		h    *tar.Header
+	}
+	var headers []header
+	var names []string
+	for {
+		h, err := r.t.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			r.Close()
+			return nil, fmt.Errorf("could not read tar file: %w", err)
+		}
+		names = append(names, h.Name)
+		headers = append(headers, header{name: h.Name, h: h})
+	}
+
+	// same as zip, the backup directory itself is not part of the paths
+	prefix := commonRoot(names)
+
+	var entries []Entry
+	for i, h := range headers {
+		info := h.h.FileInfo()
+		if info.IsDir() || (!info.Mode().IsRegular() && h.h.Typeflag != tar.TypeSymlink) {
+			continue
+		}
+		entry := Entry{
+			Path:    strings.TrimPrefix(h.name, prefix),
+			Size:    info.Size(),
+			Mode:    info.Mode(),
+			ModTime: h.h.ModTime,
+			Link:    h.h.Linkname,
+		}
+		if entry.IsSymlink() {
+			entry.Size = 0
+		}
+		entries = append(entries, entry)
+		// index of the header, every call of Next moves to the next header
+		r.positions[entry.Path] = i + 1
+	}
+	sortEntries(entries)
+	r.entries = entries
+	return r, nil
+}
+
+func (r *tarReader) restart() error {
+	r.closeStream()
+	f, err := r.open()
+	if err != nil {
+		return err
+	}
+	d, err := decompress(f, r.format)
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("could not open %s file: %w", r.format, err)
+	}
+	r.f = f
+	r.d = d
+	r.t = tar.NewReader(d)
+	r.pos = 0
+	return nil
+}
+
+func (r *tarReader) Entries() []Entry {
+	return r.entries
+}
+
+// The returned reader is only valid until the next call of Open.
+func (r *tarReader) Open(path string) (io.ReadCloser, error) {
+	pos, ok := r.positions[path]
+	if !ok {
+		return nil, iofs.ErrNotExist
+	}
+	if r.t == nil || pos <= r.pos {
+		if err := r.restart(); err != nil {
+			return nil, err
+		}
+	}
+	for r.pos < pos {
+		if _, err := r.t.Next(); err != nil {
+			return nil, fmt.Errorf("could not read tar file: %w", err)
+		}
+		r.pos++
+	}
+	return io.NopCloser(r.t), nil
+}
+
+func (r *tarReader) closeStream() error {
+	if r.f == nil {
+		return nil
+	}
+	r.d.Close()
+	err := r.f.Close()
+	r.f = nil
+	r.d = nil
+	r.t = nil
+	return err
+}
+
+func (r *tarReader) Close() error {
+	err := r.closeStream()
+	if r.closer != nil {
+		if closeErr := r.closer.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+func readAll(f *zip.File) (string, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+	b, err := io.ReadAll(rc)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func sortEntries(entries []Entry) {
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Path < entries[j].Path
+	})
+}
+
+// Find returns the entry with the given path.
+func Find(r Reader, path string) (Entry, bool) {
+	entries := r.Entries()
+	i := sort.Search(len(entries), func(i int) bool {
+		return entries[i].Path >= path
+	})
+	if i < len(entries) && entries[i].Path == path {
+		return entries[i], true
+	}
+	return Entry{}, false
+}
+
+// Files returns the files an archive consists of, the volumes and the index if it was split.
+func Files(file string) ([]string, error) {
+	index, ok := volume.FindIndex(file)
+	if !ok {
+		if _, err := os.Stat(file); err != nil {
+			return nil, err
+		}
+		return []string{file}, nil
+	}
+	idx, err := volume.ReadIndex(index)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, v := range idx.Volumes {
+		files = append(files, filepath.Join(filepath.Dir(index), v.Name))
+	}
+	// the index comes last, it is only there once all volumes are
+	return append(files, index), nil
+}
@@ -0,0 +1,271 @@
+package archive
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	iofs "io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// A Stream writes an archive entry by entry, e.g. while a backup is running, without a directory on disk.
+// Names are relative to the prefix, the top level directory of the archive. Parent directories that were
+// not added explicitly are added automatically.
+type Stream struct {
+	file   string
+	prefix string
+	opts   Options
+
+	out target
+	dst io.WriteCloser
+	w   Writer
+
+	// directories that are in the archive already, with prefix
+	dirs map[string]bool
+
+	start        time.Time
+	filesDone    int
+	filesTotal   int
+	bytesDone    int64
+	bytesTotal   int64
+	current      string
+	lastProgress time.Time
+}
+
+// NewStream creates the archive file and returns a stream to add entries to it.
+// Like Create, the archive only gets its final name when Close succeeds, Abort removes everything written so far.
+func NewStream(file string, prefix string, opts Options) (*Stream, error) {
+	file, err := filepath.Abs(file)
+	if err != nil {
+		return nil, err
+	}
+	format := opts.Format
+	if format == "" {
+		format = FormatZip
+	}
+	if err := ValidateLevel(format, opts.Level); err != nil {
+		return nil, err
+	}
+	if prefix == "" || strings.ContainsAny(prefix, "/\\") || prefix == "." || prefix == ".." {
+		return nil, fmt.Errorf("invalid archive prefix %q", prefix)
+	}
+
+	out, err := newTarget(file, opts.SplitSize)
+	if err != nil {
+		return nil, fmt.Errorf("could not create archive: %w", err)
+	}
+	var dst io.WriteCloser = nopWriteCloser{out}
+	if opts.Encrypt != nil {
+		dst, err = opts.Encrypt(out)
+		if err != nil {
+			out.abort()
+			return nil, fmt.Errorf("could not encrypt archive: %w", err)
+		}
+	}
+	var w Writer
+	if format == FormatZip {
+		w = NewZipWriter(dst, opts.Level)
+	} else {
+		w, err = NewTarWriter(dst, format, opts.Level)
+		if err != nil {
+			out.abort()
+			return nil, err
+		}
+	}
+
+	return &Stream{
+		file:   file,
+		prefix: prefix,
+		opts:   opts,
+		out:    out,
+		dst:    dst,
+		w:      w,
+		dirs:   map[string]bool{},
+		start:  time.Now(),
+	}, nil
+}
+
+// File returns the absolute path of the archive.
+func (s *Stream) File() string {
+	return s.file
+}
+
+// full name in the archive, an empty name is the prefix directory itself
+func (s *Stream) name(name string) (string, error) {
+	name = strings.Trim(name, "/")
+	if name == "" {
+		return s.prefix, nil
+	}
+	clean := path.Clean(name)
+	if clean != name || clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", fmt.Errorf("invalid name %q", name)
+	}
+	return s.prefix + "/" + name, nil
+}
+
+// adds the missing parent directories of a full name
+func (s *Stream) addParents(full string) error {
+	dir := path.Dir(full)
+	if dir == "." || s.dirs[dir] {
+		return nil
+	}
+	if err := s.addParents(dir); err != nil {
+		return err
+	}
+	if err := s.w.AddDir(dir, NewFileInfo(path.Base(dir), 0, iofs.ModeDir|0755, time.Now())); err != nil {
+		return err
+	}
+	s.dirs[dir] = true
+	return nil
+}
+
+func (s *Stream) AddDir(name string, info iofs.FileInfo) error {
+	full, err := s.name(name)
+	if err != nil {
+		return err
+	}
+	if s.dirs[full] {
+		return nil
+	}
+	if err := s.addParents(full); err != nil {
+		return err
+	}
+	if err := s.w.AddDir(full, info); err != nil {
+		return err
+	}
+	s.dirs[full] = true
+	return nil
+}
+
+// AddFile adds a regular file with the content of r, info.Size() has to match the content.
+func (s *Stream) AddFile(name string, info iofs.FileInfo, r io.Reader) error {
+	full, err := s.name(name)
+	if err != nil {
+		return err
+	}
+	if err := s.addParents(full); err != nil {
+		return err
+	}
+	s.current = name
+	err = s.w.AddFile(full, info, &progressReader{r: r, n: &s.bytesDone, progress: s.progress})
+	if err != nil {
+		return err
+	}
+	s.filesDone++
+	s.progress(false)
+	return nil
+}
+
+func (s *Stream) AddSymlink(name string, info iofs.FileInfo, target string) error {
+	full, err := s.name(name)
+	if err != nil {
+		return err
+	}
+	if err := s.addParents(full); err != nil {
+		return err
+	}
+	if err := s.w.AddSymlink(full, info, target); err != nil {
+		return err
+	}
+	s.filesDone++
+	s.progress(false)
+	return nil
+}
+
+// AddPath adds a directory, file or symlink from disk. Only the entry itself is added, not the content of a directory.
+func (s *Stream) AddPath(name string, p string, info iofs.FileInfo) error {
+	mode := info.Mode()
+	switch {
+	case mode.IsDir():
+		return s.AddDir(name, info)
+	case mode&iofs.ModeSymlink != 0:
+		target, err := os.Readlink(p)
+		if err != nil {
+			return err
+		}
+		return s.AddSymlink(name, info, target)
+	case mode.IsRegular():
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return s.AddFile(name, info, f)
+	default:
+		return errors.New("not a regular file, directory or symlink")
+	}
+}
+
+func (s *Stream) progress(done bool) {
+	if s.opts.OnProgress == nil {
+		return
+	}
+	if !done && time.Since(s.lastProgress) < progressInterval {
+		return
+	}
+	s.lastProgress = time.Now()
+	s.opts.OnProgress(Progress{
+		FilesDone:  s.filesDone,
+		FilesTotal: s.filesTotal,
+		BytesDone:  s.bytesDone,
+		BytesTotal: s.bytesTotal,
+		Elapsed:    time.Since(s.start),
+		Current:    s.current,
+		Done:       done,
+	})
+}
+
+// Close finishes the archive and moves it to its final name. The stream is aborted if that fails.
+func (s *Stream) Close() (Result, error) {
+	result := Result{File: s.file}
+	if err := s.w.Close(); err != nil {
+		s.out.abort()
+		return result, err
+	}
+	if err := s.dst.Close(); err != nil {
+		s.out.abort()
+		return result, fmt.Errorf("could not encrypt archive: %w", err)
+	}
+	if err := s.out.commit(); err != nil {
+		s.out.abort()
+		return result, fmt.Errorf("could not create archive: %w", err)
+	}
+
+	s.current = ""
+	s.progress(true)
+
+	result.Files = s.filesDone
+	result.Size = s.bytesDone
+	result.Time = time.Since(s.start)
+	result.ArchiveSize = s.out.size()
+	result.Volumes = s.out.volumes()
+	return result, nil
+}
+
+// Abort removes everything that was written so far.
+func (s *Stream) Abort() {
+	s.out.abort()
+}
+
+type fileInfo struct {
+	name    string
+	size    int64
+	mode    iofs.FileMode
+	modTime time.Time
+}
+
+// NewFileInfo returns file info for entries that do not exist on disk, e.g. the output of a command.
+func NewFileInfo(name string, size int64, mode iofs.FileMode, modTime time.Time) iofs.FileInfo {
+	return fileInfo{name: name, size: size, mode: mode, modTime: modTime}
+}
+
+func (f fileInfo) Name() string        { return f.name }
+func (f fileInfo) Size() int64         { return f.size }
+func (f fileInfo) Mode() iofs.FileMode { return f.mode }
+func (f fileInfo) ModTime() time.Time  { return f.modTime }
+func (f fileInfo) IsDir() bool         { return f.mode.IsDir() }
+func (f fileInfo) Sys() any            { return nil }
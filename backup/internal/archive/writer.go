@@ -0,0 +1,402 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"backup/internal/volume"
+	"compress/flate"
+	"errors"
+	"fmt"
+	"io"
+	iofs "io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// A Writer adds files to an archive. Names are relative and use forward slashes.
+type Writer interface {
+	AddDir(name string, info iofs.FileInfo) error
+	AddFile(name string, info iofs.FileInfo, r io.Reader) error
+	AddSymlink(name string, info iofs.FileInfo, target string) error
+	// Close writes the remaining data of the archive, it does not close the underlying writer.
+	Close() error
+}
+
+type zipWriter struct {
+	w *zip.Writer
+}
+
+// NewZipWriter returns a writer for zip files, level 0 is the default compression level.
+// Files that are already compressed, e.g. images or videos, are stored without compression.
+func NewZipWriter(w io.Writer, level int) Writer {
+	zw := zip.NewWriter(w)
+	if level != 0 {
+		zw.RegisterCompressor(zip.Deflate, func(w io.Writer) (io.WriteCloser, error) {
+			return flate.NewWriter(w, level)
+		})
+	}
+	return &zipWriter{w: zw}
+}
+
+func (z *zipWriter) AddDir(name string, info iofs.FileInfo) error {
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	header.Name = strings.TrimSuffix(name, "/") + "/"
+	header.Method = zip.Store
+	_, err = z.w.CreateHeader(header)
+	return err
+}
+
+func (z *zipWriter) AddFile(name string, info iofs.FileInfo, r io.Reader) error {
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	header.Method = zip.Deflate
+	if isCompressed(name) {
+		header.Method = zip.Store
+	}
+	w, err := z.w.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, r)
+	return err
+}
+
+// like the zip command with -y, the content of a symlink is its target
+func (z *zipWriter) AddSymlink(name string, info iofs.FileInfo, target string) error {
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	header.Method = zip.Store
+	w, err := z.w.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, target)
+	return err
+}
+
+func (z *zipWriter) Close() error {
+	return z.w.Close()
+}
+
+// unlike zip, tar keeps permissions, owners and symlinks as they are
+type tarWriter struct {
+	w *tar.Writer
+	c io.WriteCloser
+}
+
+// NewTarWriter returns a writer for tar files that are compressed as a whole according to format.
+func NewTarWriter(w io.Writer, format Format, level int) (Writer, error) {
+	c, err := compress(w, format, level)
+	if err != nil {
+		return nil, err
+	}
+	return &tarWriter{w: tar.NewWriter(c), c: c}, nil
+}
+
+func (t *tarWriter) header(name string, info iofs.FileInfo, link string) (*tar.Header, error) {
+	header, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return nil, err
+	}
+	header.Name = name
+	// PAX keeps long names and sub-second modification times
+	header.Format = tar.FormatPAX
+	// entries that do not exist on disk, e.g. of a streamed backup, belong to the user that runs the backup,
+	// the same as if they had been written to the backup directory
+	if info.Sys() == nil {
+		header.Uid = os.Getuid()
+		header.Gid = os.Getgid()
+	}
+	return header, nil
+}
+
+func (t *tarWriter) AddDir(name string, info iofs.FileInfo) error {
+	header, err := t.header(strings.TrimSuffix(name, "/")+"/", info, "")
+	if err != nil {
+		return err
+	}
+	return t.w.WriteHeader(header)
+}
+
+func (t *tarWriter) AddFile(name string, info iofs.FileInfo, r io.Reader) error {
+	header, err := t.header(name, info, "")
+	if err != nil {
+		return err
+	}
+	if err := t.w.WriteHeader(header); err != nil {
+		return err
+	}
+	// the file may have changed since info was read, tar needs exactly the size of the header
+	n, err := io.Copy(t.w, io.LimitReader(r, header.Size))
+	if err != nil {
+		return err
+	}
+	if n != header.Size {
+		return fmt.Errorf("file shrank while archiving, expected %v bytes but got %v", header.Size, n)
+	}
+	return nil
+}
+
+func (t *tarWriter) AddSymlink(name string, info iofs.FileInfo, target string) error {
+	header, err := t.header(name, info, target)
+	if err != nil {
+		return err
+	}
+	return t.w.WriteHeader(header)
+}
+
+func (t *tarWriter) Close() error {
+	if err := t.w.Close(); err != nil {
+		return err
+	}
+	return t.c.Close()
+}
+
+type Progress struct {
+	FilesDone  int
+	FilesTotal int
+	// uncompressed
+	BytesDone  int64
+	BytesTotal int64
+	Elapsed    time.Duration
+	// file that is currently being added
+	Current string
+	Done    bool
+}
+
+type Options struct {
+	// zip if empty
+	Format Format
+	// compression level, 0 is the default level of the format
+	Level int
+	// wraps the archive file, e.g. to encrypt it, Close must not close the underlying writer
+	Encrypt func(w io.Writer) (io.WriteCloser, error)
+	// the archive is split into volumes of at most this size if greater than 0
+	SplitSize int64
+	// called regularly while writing and once more when done
+	OnProgress func(Progress)
+}
+
+type Result struct {
+	File  string
+	Files int
+	// size of all files before compression
+	Size int64
+	// size of the archive, all volumes together if it was split
+	ArchiveSize int64
+	// files of the archive, only one unless it was split
+	Volumes []string
+	Time    time.Duration
+}
+
+// how often progress is reported at most
+const progressInterval = 100 * time.Millisecond
+
+// Create writes the contents of dir into a new archive.
+// Entries are prefixed with the name of dir, e.g. backup-2024-01-01/github/..., paths are never passed to a shell.
+// The archive is written to a temporary file first so that a failed run does not leave a broken archive behind.
+func Create(dir string, file string, opts Options) (Result, error) {
+	result := Result{File: file}
+	start := time.Now()
+
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return result, err
+	}
+	file, err = filepath.Abs(file)
+	if err != nil {
+		return result, err
+	}
+	result.File = file
+	format := opts.Format
+	if format == "" {
+		format = FormatZip
+	}
+	if err := ValidateLevel(format, opts.Level); err != nil {
+		return result, err
+	}
+	prefix := filepath.Base(dir)
+	if prefix == "/" || prefix == "." {
+		return result, errors.New("cannot archive the root directory")
+	}
+
+	type item struct {
+		path string
+		name string
+		info iofs.FileInfo
+	}
+
+	// collect everything first to know the total size
+	var items []item
+	var bytesTotal int64
+	var files int
+	err = filepath.Walk(dir, func(p string, info iofs.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		// an archive inside the backup directory would otherwise contain itself, the same for its volumes
+		if p == file || strings.HasPrefix(p, file+".") {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		// relative to the prefix, the directory itself has an empty name
+		var name string
+		if rel != "." {
+			name = filepath.ToSlash(rel)
+		}
+		mode := info.Mode()
+		if !mode.IsDir() && !mode.IsRegular() && mode&iofs.ModeSymlink == 0 {
+			// sockets, devices, named pipes etc.
+			return nil
+		}
+		items = append(items, item{path: p, name: name, info: info})
+		if mode.IsRegular() {
+			bytesTotal += info.Size()
+		}
+		if !mode.IsDir() {
+			files++
+		}
+		return nil
+	})
+	if err != nil {
+		return result, err
+	}
+
+	stream, err := NewStream(file, prefix, opts)
+	if err != nil {
+		return result, err
+	}
+	stream.filesTotal = files
+	stream.bytesTotal = bytesTotal
+	stream.start = start
+
+	for _, it := range items {
+		if err := stream.AddPath(it.name, it.path, it.info); err != nil {
+			stream.Abort()
+			return result, fmt.Errorf("%s: %w", it.path, err)
+		}
+	}
+	return stream.Close()
+}
+
+// where the archive is written to, either a single file or volumes
+type target interface {
+	io.Writer
+	// makes the archive available under its final name
+	commit() error
+	// removes everything that was written
+	abort()
+	size() int64
+	// files that were created, in order
+	volumes() []string
+}
+
+func newTarget(file string, splitSize int64) (target, error) {
+	if splitSize > 0 {
+		w, err := volume.Create(file, splitSize)
+		if err != nil {
+			return nil, err
+		}
+		return &volumeTarget{w: w, file: file}, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0775); err != nil {
+		return nil, err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(file), filepath.Base(file)+".*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	return &fileTarget{tmp: tmp, file: file}, nil
+}
+
+type fileTarget struct {
+	tmp     *os.File
+	file    string
+	written int64
+}
+
+func (t *fileTarget) Write(p []byte) (int, error) {
+	n, err := t.tmp.Write(p)
+	t.written += int64(n)
+	return n, err
+}
+
+func (t *fileTarget) commit() error {
+	if err := t.tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(t.tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(t.tmp.Name(), t.file)
+}
+
+func (t *fileTarget) abort() {
+	t.tmp.Close()
+	os.Remove(t.tmp.Name())
+}
+
+func (t *fileTarget) size() int64 {
+	return t.written
+}
+
+func (t *fileTarget) volumes() []string {
+	return []string{t.file}
+}
+
+type volumeTarget struct {
+	w    *volume.Writer
+	file string
+}
+
+func (t *volumeTarget) Write(p []byte) (int, error) {
+	return t.w.Write(p)
+}
+
+func (t *volumeTarget) commit() error {
+	return t.w.Close()
+}
+
+func (t *volumeTarget) abort() {
+	t.w.Abort()
+}
+
+func (t *volumeTarget) size() int64 {
+	return t.w.Index().Size
+}
+
+func (t *volumeTarget) volumes() []string {
+	var names []string
+	for _, v := range t.w.Index().Volumes {
+		names = append(names, filepath.Join(filepath.Dir(t.file), v.Name))
+	}
+	return names
+}
+
+// counts the bytes read and reports progress while large files are added
+type progressReader struct {
+	r        io.Reader
+	n        *int64
+	progress func(done bool)
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	*p.n += int64(n)
+	p.progress(false)
+	return n, err
+}
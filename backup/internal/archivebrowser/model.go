@@ -0,0 +1,780 @@
+// Package archivebrowser shows the contents of a backup archive and extracts selected files from it.
+package archivebrowser
+
+import (
+	"backup/internal/archive"
+	"backup/internal/fs"
+	"backup/internal/style"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/help"
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/list"
+	"github.com/charmbracelet/bubbles/progress"
+	"github.com/charmbracelet/bubbles/spinner"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+type state int
+
+const (
+	stateInput state = iota
+	stateLoading
+	stateBrowse
+	// typing a search query, the list shows the results while typing
+	stateSearch
+	// entering the directory to extract to
+	stateTarget
+	stateExtracting
+	stateResult
+)
+
+// at most this many failed files are listed after extracting
+const maxFailures = 10
+
+type Model struct {
+	state      state
+	inputError error
+
+	file    string
+	reader  archive.Reader
+	entries []archive.Entry
+
+	// current directory in the archive, "" is the root
+	dir string
+	// search results are shown instead of the directory if set
+	query string
+	// by path in the archive
+	marked map[string]bool
+
+	overwrite bool
+	target    string
+	progress  archive.Progress
+	updates   chan tea.Msg
+	results   []archive.Extracted
+
+	textInput    textinput.Model
+	searchInput  textinput.Model
+	targetInput  textinput.Model
+	list         list.Model
+	spinner      spinner.Model
+	progressBar  progress.Model
+	helpView     help.Model
+	keyMap       keyMap
+	listDelegate *entryItemDelegate
+
+	styles style.Styles
+
+	width, height int
+}
+
+// The given path is used as the initial value of the input, e.g. the zip file from the config.
+func NewModel(path string, styles style.Styles) *Model {
+	ti := textinput.New()
+	ti.CharLimit = 250
+	ti.Width = 40
+	ti.SetValue(path)
+	ti.Focus()
+
+	si := textinput.New()
+	si.CharLimit = 100
+	si.Width = 40
+	si.Prompt = "/"
+
+	target := ""
+	if wd, err := os.Getwd(); err == nil {
+		target = fs.HomePath(wd)
+	}
+	di := textinput.New()
+	di.CharLimit = 250
+	di.Width = 40
+	di.SetValue(target)
+
+	helpView := help.New()
+	helpView.Styles = styles.HelpStyles
+
+	keyMap := defaultKeyMap()
+	marked := map[string]bool{}
+	listDelegate := newEntryItemDelegate(marked)
+	list := list.New(nil, listDelegate, 0, 0)
+	list.SetFilteringEnabled(false)
+	list.SetShowHelp(false)
+	list.DisableQuitKeybindings()
+	list.SetShowStatusBar(false)
+	list.SetShowPagination(true)
+	list.SetShowTitle(false)
+	list.KeyMap = keyMap.listKeyMap()
+
+	return &Model{
+		state:        stateInput,
+		marked:       marked,
+		textInput:    ti,
+		searchInput:  si,
+		targetInput:  di,
+		list:         list,
+		listDelegate: listDelegate,
+		spinner: spinner.New(
+			spinner.WithSpinner(spinner.Dot),
+			spinner.WithStyle(styles.ListItemSelectedStyle),
+		),
+		progressBar: progress.New(progress.WithDefaultGradient()),
+		helpView:    helpView,
+		keyMap:      keyMap,
+		styles:      styles,
+	}
+}
+
+func (m *Model) Init() tea.Cmd {
+	return textinput.Blink
+}
+
+// Open loads the archive of the input right away, e.g. when the archive was just created.
+func (m *Model) Open() tea.Cmd {
+	return m.open()
+}
+
+func (m *Model) open() tea.Cmd {
+	p := strings.TrimSpace(m.textInput.Value())
+	if p == "" {
+		m.inputError = errors.New("path cannot be empty")
+		return nil
+	}
+	absPath, err := fs.AbsPath(p)
+	if err != nil {
+		m.inputError = err
+		return nil
+	}
+	m.inputError = nil
+	m.state = stateLoading
+	return tea.Batch(openCmd(absPath), m.spinner.Tick)
+}
+
+func (m *Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	var cmd tea.Cmd
+
+	switch m.state {
+	case stateInput:
+		switch msg := msg.(type) {
+		case tea.KeyMsg:
+			switch {
+			case key.Matches(msg, m.keyMap.InputConfirm):
+				cmd = m.open()
+			case key.Matches(msg, m.keyMap.Back):
+				cmd = done()
+			default:
+				m.textInput, cmd = m.textInput.Update(msg)
+			}
+		default:
+			m.textInput, cmd = m.textInput.Update(msg)
+		}
+
+	case stateLoading:
+		switch msg := msg.(type) {
+		case openResult:
+			if msg.err != nil {
+				m.state = stateInput
+				m.inputError = msg.err
+				break
+			}
+			m.state = stateBrowse
+			m.file = msg.file
+			m.reader = msg.reader
+			m.entries = msg.reader.Entries()
+			m.query = ""
+			for p := range m.marked {
+				delete(m.marked, p)
+			}
+			m.openDir("", "")
+		case spinner.TickMsg:
+			m.spinner, cmd = m.spinner.Update(msg)
+		}
+
+	case stateBrowse:
+		switch msg := msg.(type) {
+		case tea.KeyMsg:
+			switch {
+			case key.Matches(msg, m.keyMap.Open):
+				if e, ok := m.list.SelectedItem().(entry); ok {
+					if e.isDir {
+						m.openDir(e.path, "")
+					} else if m.query != "" {
+						// a file found by searching is shown in its directory
+						m.openDir(parentDir(e.path), e.path)
+					}
+				}
+			case key.Matches(msg, m.keyMap.Parent):
+				if m.query != "" {
+					m.openDir(m.dir, "")
+				} else if m.dir != "" {
+					m.openDir(parentDir(m.dir), m.dir)
+				}
+			case key.Matches(msg, m.keyMap.Mark):
+				if e, ok := m.list.SelectedItem().(entry); ok {
+					if m.marked[e.path] {
+						delete(m.marked, e.path)
+					} else {
+						m.marked[e.path] = true
+					}
+				}
+			case key.Matches(msg, m.keyMap.Search):
+				m.state = stateSearch
+				m.searchInput.SetValue(m.query)
+				m.searchInput.CursorEnd()
+				cmd = m.searchInput.Focus()
+			case key.Matches(msg, m.keyMap.Extract):
+				if len(m.extractPaths()) > 0 {
+					m.state = stateTarget
+					m.inputError = nil
+					cmd = m.targetInput.Focus()
+				}
+			case key.Matches(msg, m.keyMap.OpenOther):
+				m.closeReader()
+				m.state = stateInput
+			case key.Matches(msg, m.keyMap.Back):
+				if m.query != "" {
+					m.openDir(m.dir, "")
+				} else {
+					m.closeReader()
+					cmd = done()
+				}
+			default:
+				m.list, cmd = m.list.Update(msg)
+			}
+		default:
+			m.list, cmd = m.list.Update(msg)
+		}
+
+	case stateSearch:
+		switch msg := msg.(type) {
+		case tea.KeyMsg:
+			switch {
+			case key.Matches(msg, m.keyMap.SearchConfirm):
+				m.searchInput.Blur()
+				m.state = stateBrowse
+				if m.query == "" {
+					m.openDir(m.dir, "")
+				}
+			case key.Matches(msg, m.keyMap.Back):
+				m.searchInput.Blur()
+				m.state = stateBrowse
+				m.openDir(m.dir, "")
+			default:
+				m.searchInput, cmd = m.searchInput.Update(msg)
+				if query := strings.TrimSpace(m.searchInput.Value()); query != m.query {
+					m.setSearch(query)
+				}
+			}
+		default:
+			m.searchInput, cmd = m.searchInput.Update(msg)
+		}
+
+	case stateTarget:
+		switch msg := msg.(type) {
+		case tea.KeyMsg:
+			switch {
+			case key.Matches(msg, m.keyMap.TargetConfirm):
+				dir := strings.TrimSpace(m.targetInput.Value())
+				if dir == "" {
+					m.inputError = errors.New("directory cannot be empty")
+					break
+				}
+				absDir, err := fs.AbsPath(dir)
+				if err != nil {
+					m.inputError = err
+					break
+				}
+				m.inputError = nil
+				m.targetInput.Blur()
+				m.target = absDir
+				m.progress = archive.Progress{}
+				m.state = stateExtracting
+				m.updates = extract(m.reader, m.extractPaths(), absDir, m.overwrite)
+				cmd = waitForUpdate(m.updates)
+			case key.Matches(msg, m.keyMap.TargetOverwrite):
+				m.overwrite = !m.overwrite
+			case key.Matches(msg, m.keyMap.Back):
+				m.targetInput.Blur()
+				m.inputError = nil
+				m.state = stateBrowse
+			default:
+				m.targetInput, cmd = m.targetInput.Update(msg)
+			}
+		default:
+			m.targetInput, cmd = m.targetInput.Update(msg)
+		}
+
+	case stateExtracting:
+		switch msg := msg.(type) {
+		case progressMsg:
+			m.progress = archive.Progress(msg)
+			cmd = waitForUpdate(m.updates)
+		case extractResult:
+			m.state = stateResult
+			m.results = msg.results
+			m.inputError = msg.err
+		}
+
+	case stateResult:
+		if msg, ok := msg.(tea.KeyMsg); ok && key.Matches(msg, m.keyMap.ResultContinue) {
+			// the marks were extracted, the next extraction most likely wants different ones
+			if m.inputError == nil {
+				for p := range m.marked {
+					delete(m.marked, p)
+				}
+			}
+			m.inputError = nil
+			m.state = stateBrowse
+		}
+	}
+
+	return m, cmd
+}
+
+// Changes to another directory of the archive and ends a search, if selectPath is in that directory its entry
+// gets selected.
+func (m *Model) openDir(dir string, selectPath string) {
+	m.dir = dir
+	m.query = ""
+	m.setItems(listDir(m.entries, dir), selectPath)
+}
+
+func (m *Model) setSearch(query string) {
+	m.query = query
+	if query == "" {
+		m.setItems(listDir(m.entries, m.dir), "")
+		return
+	}
+	m.setItems(search(m.entries, query), "")
+}
+
+func (m *Model) setItems(entries []entry, selectPath string) {
+	items := make([]list.Item, len(entries))
+	selected := 0
+	for i, e := range entries {
+		if e.path == selectPath {
+			selected = i
+		}
+		items[i] = e
+	}
+	m.list.SetItems(items)
+	m.list.Select(selected)
+}
+
+// Returns the marked paths or the selected one if nothing is marked.
+// Marks inside a marked directory are dropped, the directory includes them anyway.
+func (m *Model) extractPaths() []string {
+	var paths []string
+	for p := range m.marked {
+		inside := false
+		for q := range m.marked {
+			if strings.HasPrefix(p, q+"/") {
+				inside = true
+				break
+			}
+		}
+		if !inside {
+			paths = append(paths, p)
+		}
+	}
+	if len(paths) == 0 {
+		if e, ok := m.list.SelectedItem().(entry); ok {
+			paths = append(paths, e.path)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func (m *Model) closeReader() {
+	if m.reader != nil {
+		m.reader.Close()
+		m.reader = nil
+		m.entries = nil
+	}
+}
+
+func parentDir(p string) string {
+	dir := path.Dir(p)
+	if dir == "." {
+		return ""
+	}
+	return dir
+}
+
+func (m *Model) SetSize(width, height int) {
+	m.width = width
+	m.height = height
+	m.helpView.Width = width
+	m.progressBar.Width = width
+	if m.progressBar.Width > 60 {
+		m.progressBar.Width = 60
+	}
+	// 2 lines for the title, 4 lines for archive, directory and status and 5 lines for help text
+	listHeight := height - 11
+	if listHeight < 2 {
+		listHeight = 2
+	}
+	m.list.SetSize(width, listHeight)
+}
+
+func (m *Model) View() string {
+	styles := m.styles
+	title := styles.TitleStyle.Render("Browse Archive")
+
+	switch m.state {
+	case stateInput:
+		parts := []string{
+			title,
+			"",
+			styles.NormalTextStyle.Render("Enter backup archive"),
+			"",
+			m.textInput.View(),
+			"",
+		}
+		if m.inputError != nil {
+			parts = append(parts, styles.ErrorTextStyle.Render(m.inputError.Error()), "")
+		}
+		parts = append(parts, m.helpView.ShortHelpView(m.keyMap.inputKeys()))
+		return lipgloss.JoinVertical(lipgloss.Left, parts...)
+
+	case stateLoading:
+		return lipgloss.JoinVertical(
+			lipgloss.Left,
+			title,
+			"",
+			fmt.Sprintf("%s %s", styles.NormalTextStyle.UnsetWidth().Render("Reading archive"), m.spinner.View()),
+		)
+
+	case stateBrowse, stateSearch:
+		var location string
+		if m.state == stateSearch || m.query != "" {
+			location = m.searchInput.View()
+		} else {
+			location = styles.ListItemSelectedStyle.Render("/" + m.dir)
+		}
+		var helpView string
+		if m.state == stateSearch {
+			helpView = m.helpView.ShortHelpView(m.keyMap.searchKeys())
+		} else {
+			helpView = m.helpView.FullHelpView(m.keyMap.browseKeys())
+		}
+		return lipgloss.JoinVertical(
+			lipgloss.Left,
+			title,
+			"",
+			styles.NormalTextStyle.Render(fs.HomePath(m.file)),
+			location,
+			m.statusView(),
+			"",
+			m.list.View(),
+			"",
+			helpView,
+		)
+
+	case stateTarget:
+		paths := m.extractPaths()
+		what := "/" + paths[0]
+		if len(paths) > 1 {
+			what = fmt.Sprintf("%v marked paths", len(paths))
+		}
+		overwrite := "no, existing files are skipped"
+		if m.overwrite {
+			overwrite = "yes"
+		}
+		parts := []string{
+			title,
+			"",
+			styles.NormalTextStyle.Render(fmt.Sprintf("Extract %s to directory", what)),
+			"",
+			m.targetInput.View(),
+			"",
+			styles.NormalTextStyle.Render("Overwrite: " + overwrite),
+			"",
+		}
+		if m.inputError != nil {
+			parts = append(parts, styles.ErrorTextStyle.Render(m.inputError.Error()), "")
+		}
+		parts = append(parts, m.helpView.ShortHelpView(m.keyMap.targetKeys()))
+		return lipgloss.JoinVertical(lipgloss.Left, parts...)
+
+	case stateExtracting:
+		p := m.progress
+		var percent float64
+		if p.BytesTotal > 0 {
+			percent = float64(p.BytesDone) / float64(p.BytesTotal)
+		}
+		return lipgloss.JoinVertical(
+			lipgloss.Left,
+			title,
+			"",
+			m.progressBar.ViewAs(percent),
+			styles.NormalTextStyle.Render(fmt.Sprintf("%v of %v files, %s of %s", p.FilesDone, p.FilesTotal, fileSizeString(p.BytesDone), fileSizeString(p.BytesTotal))),
+			styles.ListItemDescriptionStyle.Render(p.Current),
+		)
+
+	case stateResult:
+		parts := []string{title, ""}
+		if m.inputError != nil {
+			parts = append(parts, styles.ErrorTextStyle.Render(fmt.Sprintf("Could not extract: %v", m.inputError)))
+		} else {
+			var extracted, skipped int
+			var size int64
+			var failures []archive.Extracted
+			for _, r := range m.results {
+				switch {
+				case r.Err != nil:
+					failures = append(failures, r)
+				case r.Skipped:
+					skipped++
+				default:
+					extracted++
+					size += r.Entry.Size
+				}
+			}
+			parts = append(parts, styles.NormalTextStyle.Render(fmt.Sprintf("Extracted %v files (%s) to %s", extracted, fileSizeString(size), fs.HomePath(m.target))))
+			if skipped > 0 {
+				parts = append(parts, styles.NormalTextStyle.Render(fmt.Sprintf("%v files already existed and were skipped", skipped)))
+			}
+			if len(failures) > 0 {
+				parts = append(parts, styles.ErrorTextStyle.Render(fmt.Sprintf("%v files failed", len(failures))), "")
+				for i, f := range failures {
+					if i == maxFailures {
+						parts = append(parts, styles.ListItemDescriptionStyle.Render(fmt.Sprintf("and %v more", len(failures)-maxFailures)))
+						break
+					}
+					parts = append(parts, styles.ListItemDescriptionStyle.Render(fmt.Sprintf("%s: %v", f.Entry.Path, f.Err)))
+				}
+			}
+		}
+		parts = append(parts, "", m.helpView.ShortHelpView(m.keyMap.resultKeys()))
+		return lipgloss.JoinVertical(lipgloss.Left, parts...)
+	}
+
+	return ""
+}
+
+func (m *Model) statusView() string {
+	var files int
+	var size int64
+	for _, e := range m.entries {
+		for p := range m.marked {
+			if e.Below(p) {
+				files++
+				size += e.Size
+				break
+			}
+		}
+	}
+	status := fmt.Sprintf("%v items", len(m.list.Items()))
+	if m.query != "" {
+		status = fmt.Sprintf("%v results", len(m.list.Items()))
+	}
+	if len(m.marked) > 0 {
+		status += fmt.Sprintf(", %v marked (%v files, %s)", len(m.marked), files, fileSizeString(size))
+	}
+	return m.styles.ListItemDescriptionStyle.Render(status)
+}
+
+type openResult struct {
+	file   string
+	reader archive.Reader
+	err    error
+}
+
+func openCmd(path string) tea.Cmd {
+	return func() tea.Msg {
+		r, err := archive.Open(path)
+		return openResult{file: path, reader: r, err: err}
+	}
+}
+
+type extractResult struct {
+	results []archive.Extracted
+	err     error
+}
+
+type progressMsg archive.Progress
+
+// Extracts in the background, progress updates and the final result are sent on the returned channel.
+func extract(r archive.Reader, paths []string, dir string, overwrite bool) chan tea.Msg {
+	// buffered so that extracting never has to wait for the UI, if an update is still pending the new one is dropped
+	updates := make(chan tea.Msg, 1)
+	go func() {
+		opts := archive.ExtractOptions{
+			Overwrite: overwrite,
+			OnProgress: func(p archive.Progress) {
+				if p.Done {
+					return
+				}
+				select {
+				case updates <- progressMsg(p):
+				default:
+				}
+			},
+		}
+		results, err := archive.Extract(r, paths, dir, opts)
+		// make sure the final message is not dropped
+		for len(updates) > 0 {
+			time.Sleep(10 * time.Millisecond)
+		}
+		updates <- extractResult{results: results, err: err}
+	}()
+	return updates
+}
+
+func waitForUpdate(updates chan tea.Msg) tea.Cmd {
+	return func() tea.Msg {
+		return <-updates
+	}
+}
+
+type Done struct{}
+
+func done() tea.Cmd {
+	return func() tea.Msg {
+		return Done{}
+	}
+}
+
+type keyMap struct {
+	InputConfirm key.Binding
+
+	CursorUp   key.Binding
+	CursorDown key.Binding
+	PrevPage   key.Binding
+	NextPage   key.Binding
+	Open       key.Binding
+	Parent     key.Binding
+	Mark       key.Binding
+	Search     key.Binding
+	Extract    key.Binding
+	OpenOther  key.Binding
+
+	SearchConfirm key.Binding
+
+	TargetConfirm   key.Binding
+	TargetOverwrite key.Binding
+
+	ResultContinue key.Binding
+
+	Back key.Binding
+}
+
+func defaultKeyMap() keyMap {
+	return keyMap{
+		InputConfirm: key.NewBinding(
+			key.WithKeys("enter"),
+			key.WithHelp("enter", "open"),
+		),
+		CursorUp: key.NewBinding(
+			key.WithKeys("k", "up"),
+			key.WithHelp("k", "up"),
+		),
+		CursorDown: key.NewBinding(
+			key.WithKeys("j", "down"),
+			key.WithHelp("j", "down"),
+		),
+		PrevPage: key.NewBinding(
+			key.WithKeys("pgup"),
+			key.WithHelp("pgup", "prev page"),
+		),
+		NextPage: key.NewBinding(
+			key.WithKeys("pgdown"),
+			key.WithHelp("pgdn", "next page"),
+		),
+		Open: key.NewBinding(
+			key.WithKeys("l", "right", "enter"),
+			key.WithHelp("l", "open dir"),
+		),
+		Parent: key.NewBinding(
+			key.WithKeys("h", "left", "backspace"),
+			key.WithHelp("h", "parent dir"),
+		),
+		Mark: key.NewBinding(
+			key.WithKeys(" "),
+			key.WithHelp("space", "(un)mark"),
+		),
+		Search: key.NewBinding(
+			key.WithKeys("/"),
+			key.WithHelp("/", "search"),
+		),
+		Extract: key.NewBinding(
+			key.WithKeys("x"),
+			key.WithHelp("x", "extract"),
+		),
+		OpenOther: key.NewBinding(
+			key.WithKeys("o"),
+			key.WithHelp("o", "other archive"),
+		),
+		SearchConfirm: key.NewBinding(
+			key.WithKeys("enter"),
+			key.WithHelp("enter", "done"),
+		),
+		TargetConfirm: key.NewBinding(
+			key.WithKeys("enter"),
+			key.WithHelp("enter", "extract"),
+		),
+		TargetOverwrite: key.NewBinding(
+			key.WithKeys("tab"),
+			key.WithHelp("tab", "overwrite"),
+		),
+		ResultContinue: key.NewBinding(
+			key.WithKeys("enter"),
+			key.WithHelp("enter", "continue"),
+		),
+		Back: key.NewBinding(
+			key.WithKeys("esc"),
+			key.WithHelp("esc", "back"),
+		),
+	}
+}
+
+func (m keyMap) listKeyMap() list.KeyMap {
+	return list.KeyMap{
+		CursorUp:             m.CursorUp,
+		CursorDown:           m.CursorDown,
+		PrevPage:             m.PrevPage,
+		NextPage:             m.NextPage,
+		GoToStart:            key.NewBinding(key.WithDisabled()),
+		GoToEnd:              key.NewBinding(key.WithDisabled()),
+		Filter:               key.NewBinding(key.WithDisabled()),
+		ClearFilter:          key.NewBinding(key.WithDisabled()),
+		CancelWhileFiltering: key.NewBinding(key.WithDisabled()),
+		AcceptWhileFiltering: key.NewBinding(key.WithDisabled()),
+		ShowFullHelp:         key.NewBinding(key.WithDisabled()),
+		CloseFullHelp:        key.NewBinding(key.WithDisabled()),
+	}
+}
+
+func (m keyMap) inputKeys() []key.Binding {
+	return []key.Binding{m.Back, m.InputConfirm}
+}
+
+func (m keyMap) browseKeys() [][]key.Binding {
+	return [][]key.Binding{
+		{m.CursorUp, m.CursorDown, m.PrevPage, m.NextPage},
+		{m.Open, m.Parent, m.Search},
+		{m.Mark, m.Extract},
+		{m.OpenOther, m.Back},
+	}
+}
+
+func (m keyMap) searchKeys() []key.Binding {
+	return []key.Binding{m.Back, m.SearchConfirm}
+}
+
+func (m keyMap) targetKeys() []key.Binding {
+	return []key.Binding{m.Back, m.TargetOverwrite, m.TargetConfirm}
+}
+
+func (m keyMap) resultKeys() []key.Binding {
+	return []key.Binding{m.ResultContinue}
+}
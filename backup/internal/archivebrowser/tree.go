@@ -0,0 +1,210 @@
+package archivebrowser
+
+import (
+	"backup/internal/archive"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/list"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// An entry is a file or directory in the current directory of the archive or a search result.
+// Archives only contain files, directories are derived from their paths.
+type entry struct {
+	// name in the current directory, search results show the full path instead
+	name string
+	// path in the archive, the same as archive.Entry.Path
+	path   string
+	isDir  bool
+	isLink bool
+	// for directories the total of all files inside
+	size    int64
+	files   int
+	modTime time.Time
+}
+
+func (e entry) FilterValue() string {
+	return e.name
+}
+
+// Returns the entries directly inside dir, directories first and both sorted by name.
+// Sizes and modification times of directories are aggregated from the files inside.
+func listDir(entries []archive.Entry, dir string) []entry {
+	var result []entry
+	dirs := map[string]int{}
+	for _, e := range entries {
+		if !e.Below(dir) || e.Path == dir {
+			continue
+		}
+		rel := e.Path
+		if dir != "" {
+			rel = strings.TrimPrefix(e.Path, dir+"/")
+		}
+		name, _, isDir := strings.Cut(rel, "/")
+		if !isDir {
+			result = append(result, entry{name: name, path: e.Path, isLink: e.IsSymlink(), size: e.Size, files: 1, modTime: e.ModTime})
+			continue
+		}
+		i, ok := dirs[name]
+		if !ok {
+			i = len(result)
+			dirs[name] = i
+			result = append(result, entry{name: name, path: path.Join(dir, name), isDir: true})
+		}
+		d := &result[i]
+		d.size += e.Size
+		d.files++
+		if e.ModTime.After(d.modTime) {
+			d.modTime = e.ModTime
+		}
+	}
+	sortEntries(result)
+	return result
+}
+
+// Returns the files and directories whose name contains the query, ignoring case.
+func search(entries []archive.Entry, query string) []entry {
+	query = strings.ToLower(query)
+	var result []entry
+	dirs := map[string]bool{}
+	for _, e := range entries {
+		// directories are only known from the files inside them
+		for dir := path.Dir(e.Path); dir != "." && !dirs[dir]; dir = path.Dir(dir) {
+			dirs[dir] = true
+			if strings.Contains(strings.ToLower(path.Base(dir)), query) {
+				result = append(result, entry{name: dir, path: dir, isDir: true})
+			}
+		}
+		if strings.Contains(strings.ToLower(path.Base(e.Path)), query) {
+			result = append(result, entry{name: e.Path, path: e.Path, isLink: e.IsSymlink(), size: e.Size, files: 1, modTime: e.ModTime})
+		}
+	}
+	// sizes of directories are added afterwards, a search usually only matches a few
+	for i := range result {
+		d := &result[i]
+		if !d.isDir {
+			continue
+		}
+		for _, e := range entries {
+			if e.Below(d.path) {
+				d.size += e.Size
+				d.files++
+				if e.ModTime.After(d.modTime) {
+					d.modTime = e.ModTime
+				}
+			}
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].path < result[j].path
+	})
+	return result
+}
+
+func sortEntries(entries []entry) {
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].isDir != entries[j].isDir {
+			return entries[i].isDir
+		}
+		return strings.ToLower(entries[i].name) < strings.ToLower(entries[j].name)
+	})
+}
+
+type entryItemDelegate struct {
+	itemStyle         lipgloss.Style
+	selectedItemStyle lipgloss.Style
+	infoStyle         lipgloss.Style
+
+	// by path in the archive
+	marked map[string]bool
+}
+
+func newEntryItemDelegate(marked map[string]bool) *entryItemDelegate {
+	return &entryItemDelegate{
+		itemStyle:         lipgloss.NewStyle().PaddingLeft(4),
+		selectedItemStyle: lipgloss.NewStyle().PaddingLeft(2).Foreground(lipgloss.Color("170")),
+		infoStyle:         lipgloss.NewStyle().Foreground(lipgloss.AdaptiveColor{Light: "#A49FA5", Dark: "#777777"}),
+		marked:            marked,
+	}
+}
+
+func (d entryItemDelegate) Height() int {
+	return 1
+}
+
+func (d entryItemDelegate) Spacing() int {
+	return 0
+}
+
+func (d entryItemDelegate) Update(msg tea.Msg, m *list.Model) tea.Cmd {
+	return nil
+}
+
+// Returns the checkbox of an entry:
+// [x] if it is marked, [+] if a parent directory is marked and [~] if something inside the directory is marked.
+func (d entryItemDelegate) checkbox(e entry) string {
+	if d.marked[e.path] {
+		return "[x]"
+	}
+	for p := range d.marked {
+		if strings.HasPrefix(e.path, p+"/") {
+			return "[+]"
+		}
+	}
+	if e.isDir {
+		for p := range d.marked {
+			if strings.HasPrefix(p, e.path+"/") {
+				return "[~]"
+			}
+		}
+	}
+	return "[ ]"
+}
+
+var sizeStyle = lipgloss.NewStyle().Width(7).Align(lipgloss.Right)
+
+func (d entryItemDelegate) Render(w io.Writer, m list.Model, index int, listItem list.Item) {
+	e, ok := listItem.(entry)
+	if !ok {
+		return
+	}
+
+	name := e.name
+	if e.isDir {
+		name += "/"
+	} else if e.isLink {
+		name += "@"
+	}
+
+	var date string
+	if !e.modTime.IsZero() {
+		date = e.modTime.Local().Format("2006-01-02 15:04")
+	}
+	info := fmt.Sprintf("%s  %-16s", sizeStyle.Render(fileSizeString(e.size)), date)
+	s := fmt.Sprintf("%s %s  %s", d.checkbox(e), d.infoStyle.Render(info), name)
+
+	if index == m.Index() {
+		s = d.selectedItemStyle.Render("> " + s)
+	} else {
+		s = d.itemStyle.Render(s)
+	}
+	fmt.Fprint(w, s)
+}
+
+func fileSizeString(size int64) string {
+	if size >= 1024*1024*1024 {
+		return fmt.Sprintf("%.1fG", float64(size)/(1024*1024*1024))
+	} else if size >= 1024*1024 {
+		return fmt.Sprintf("%vM", size/(1024*1024))
+	} else if size >= 1024 {
+		return fmt.Sprintf("%vK", size/1024)
+	} else {
+		return fmt.Sprintf("%v", size)
+	}
+}
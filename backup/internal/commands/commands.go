@@ -0,0 +1,66 @@
+package commands
+
+import (
+	"backup/internal/exec"
+	"backup/internal/fs"
+	"errors"
+	"os"
+	"strings"
+	"time"
+)
+
+// A Command is run during a backup and its output is stored in the backup directory,
+// e.g. to keep a list of installed packages.
+type Command struct {
+	// name of the file in the commands directory the output is written to
+	Name string `json:"name"`
+	// run with sh -c
+	Run string `json:"run"`
+	// in seconds, defaults to 120
+	Timeout int `json:"timeout,omitempty"`
+}
+
+const defaultTimeout = 120 * time.Second
+
+// Dir returns the directory in the backup directory the output of commands is written to.
+func Dir(backupDir string) string {
+	return fs.JoinPath(backupDir, "commands")
+}
+
+func (c Command) Validate() error {
+	if strings.TrimSpace(c.Run) == "" {
+		return errors.New("no command to run")
+	}
+	if c.Name == "" || c.Name == "." || c.Name == ".." || strings.ContainsRune(c.Name, os.PathSeparator) {
+		return errors.New("invalid name, must be a file name")
+	}
+	return nil
+}
+
+// Run runs the command and writes its standard output to the commands directory.
+// The output is only written if the command succeeded, the result contains stdout and stderr either way.
+func Run(backupDir string, c Command) (exec.Result, error) {
+	result, err := Output(c)
+	if err != nil || result.Err != nil || result.ExitCode != 0 {
+		return result, err
+	}
+
+	dir := Dir(backupDir)
+	if err := fs.CreateDir(dir); err != nil {
+		return result, err
+	}
+	return result, os.WriteFile(fs.JoinPath(dir, c.Name), []byte(result.Stdout), 0644)
+}
+
+// Output runs the command without writing its output anywhere, the output is in Stdout of the result.
+func Output(c Command) (exec.Result, error) {
+	if err := c.Validate(); err != nil {
+		return exec.Result{}, err
+	}
+
+	timeout := defaultTimeout
+	if c.Timeout > 0 {
+		timeout = time.Duration(c.Timeout) * time.Second
+	}
+	return exec.Background([]string{"sh", "-c", c.Run}, exec.WithTimeout(timeout)), nil
+}
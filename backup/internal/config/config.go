@@ -1,33 +1,54 @@
 package config
 
 import (
+	"backup/internal/commands"
+	"backup/internal/drive"
 	"backup/internal/fs"
 	"backup/internal/github"
+	"backup/internal/notify"
+	"backup/internal/s3"
+	"backup/internal/schedule"
+	"backup/internal/ssh"
 	"backup/internal/zip"
 	"encoding/json"
+	"errors"
 	"fmt"
+	iofs "io/fs"
 	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 )
 
 type Config struct {
-	BackupDir string        `json:"backupDir"`
+	BackupDir string        `json:"backupDir,omitempty"`
 	Github    github.Config `json:"github"`
 	Zip       zip.Config    `json:"zip"`
-	Files     []string      `json:"files"`
+	Files     []string      `json:"files,omitempty"`
+	// files and directories to skip when copying files
+	// patterns containing a slash are matched against the full path, e.g. "~/.cache/*",
+	// all others against the name of a file or directory, e.g. "node_modules" or "*.tmp"
+	Exclude []string `json:"exclude,omitempty"`
+	// number of files that are copied concurrently, defaults to 4
+	CopyWorkers int `json:"copyWorkers,omitempty"`
+	// commands whose output is stored in the backup directory, e.g. a list of installed packages
+	Commands []commands.Command `json:"commands,omitempty"`
+	// finished archives are uploaded to this bucket
+	S3 *s3.Config `json:"s3,omitempty"`
+	// finished archives are copied to this server, or the backup directory is synced to it
+	SSH *ssh.Config `json:"ssh,omitempty"`
+	// finished archives or the backup directory are copied to these drives if they are plugged in
+	Removable *drive.Config `json:"removable,omitempty"`
+	// times at which the daemon runs backups
+	Schedule *schedule.Config `json:"schedule,omitempty"`
+	// where to tell about finished backups
+	Notify *notify.Config `json:"notify,omitempty"`
 }
 
 func LoadConfig(file string) (Config, error) {
-	var config Config
-
-	if file != "" {
-		s, err := os.ReadFile(file)
-		if err != nil {
-			return config, fmt.Errorf("could not read file: %w", err)
-		}
-		err = json.Unmarshal(s, &config)
-		if err != nil {
-			return config, fmt.Errorf("could not decode json: %w", err)
-		}
+	config, err := ReadConfig(file)
+	if err != nil {
+		return config, err
 	}
 
 	// validate and set defaults
@@ -47,3 +68,137 @@ func LoadConfig(file string) (Config, error) {
 
 	return config, nil
 }
+
+// ReadConfig decodes the config file as it is, without validation and defaults, e.g. to edit it.
+func ReadConfig(file string) (Config, error) {
+	var config Config
+
+	if file != "" {
+		s, err := os.ReadFile(file)
+		if err != nil {
+			return config, fmt.Errorf("could not read file: %w", err)
+		}
+		err = json.Unmarshal(s, &config)
+		if err != nil {
+			return config, fmt.Errorf("could not decode json: %w", err)
+		}
+	}
+
+	return config, nil
+}
+
+// Save writes the config to the config file, settings unknown to this version are kept.
+// The previous version of the file is kept with a .bak suffix.
+func Save(file string, config Config) error {
+	data, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	values := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &values); err != nil {
+		return err
+	}
+
+	return update(file, func(settings map[string]json.RawMessage) {
+		// settings that were removed are not part of values because of omitempty
+		for _, key := range knownKeys() {
+			delete(settings, key)
+		}
+		for key, value := range values {
+			// empty sections
+			if string(value) == "{}" {
+				continue
+			}
+			settings[key] = value
+		}
+	})
+}
+
+// SaveFiles replaces the files section of the config file with the given paths.
+// All other settings are kept as they are.
+func SaveFiles(file string, files []string) error {
+	if files == nil {
+		files = []string{}
+	}
+	value, err := json.Marshal(files)
+	if err != nil {
+		return err
+	}
+	return update(file, func(settings map[string]json.RawMessage) {
+		settings["files"] = value
+	})
+}
+
+// returns the json names of all fields of Config
+func knownKeys() []string {
+	var keys []string
+	t := reflect.TypeOf(Config{})
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			keys = append(keys, name)
+		}
+	}
+	return keys
+}
+
+// Reads the config file, applies the changes and writes it back.
+// The previous version is kept as a backup and the file is replaced atomically.
+func update(file string, change func(settings map[string]json.RawMessage)) error {
+	if file == "" {
+		return fmt.Errorf("no config file given")
+	}
+
+	// decode into raw messages so that settings unknown to this version are not lost
+	settings := map[string]json.RawMessage{}
+	s, err := os.ReadFile(file)
+	if err != nil && !errors.Is(err, iofs.ErrNotExist) {
+		return fmt.Errorf("could not read file: %w", err)
+	}
+	if len(s) > 0 {
+		if err := json.Unmarshal(s, &settings); err != nil {
+			return fmt.Errorf("could not decode json: %w", err)
+		}
+		if err := writeFile(file+".bak", s); err != nil {
+			return fmt.Errorf("could not create backup of config file: %w", err)
+		}
+	}
+
+	change(settings)
+
+	data, err := json.MarshalIndent(settings, "", "    ")
+	if err != nil {
+		return err
+	}
+	return writeFile(file, append(data, '\n'))
+}
+
+// writes to a temporary file first and renames it so that the file is never left half written
+func writeFile(file string, data []byte) error {
+	mode := iofs.FileMode(0600)
+	if info, err := os.Stat(file); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(file), filepath.Base(file)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("could not create file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("could not write file: %w", err)
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("could not write file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), file); err != nil {
+		return fmt.Errorf("could not replace file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,417 @@
+// Package crypt encrypts archives so that they can be stored on drives and cloud storage that are not trusted.
+//
+// An encrypted file starts with a header that contains a random file key, wrapped once for every recipient
+// (X25519 public key) and once for the passphrase (scrypt). The data follows in chunks of 64 KiB, each encrypted
+// with AES-256-GCM. Every chunk has its own nonce and the last chunk is marked, which means that chunks cannot be
+// reordered, dropped or the file truncated without decryption failing. The header is authenticated with every chunk.
+package crypt
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Magic is the beginning of every encrypted file.
+const Magic = "backup-encrypted/v1\n"
+
+// Extension is appended to the names of encrypted archives.
+const Extension = ".enc"
+
+const (
+	chunkSize = 64 * 1024
+	keySize   = 32
+	tagSize   = 16
+	nonceSize = 12
+	saltSize  = 16
+
+	stanzaX25519 = 1
+	stanzaScrypt = 2
+	// the number of stanzas is a single byte of the header
+	maxStanzas = 255
+
+	// 2^18 like age, takes about a second and 256M of memory
+	scryptLogN = 18
+	// files with a higher work factor are rejected, they would take forever to decrypt
+	maxScryptLogN = 22
+)
+
+var ErrNoKey = errors.New("no recipient or passphrase given")
+
+// ErrWrongKey is returned if none of the identities or the passphrase can decrypt the file.
+var ErrWrongKey = errors.New("the file cannot be decrypted with the given keys or passphrase")
+
+// ErrCorrupt is returned if the encrypted data was changed or truncated.
+var ErrCorrupt = errors.New("the encrypted file is corrupt or was modified")
+
+// Keys are used to encrypt a file, at least one recipient or a passphrase is needed.
+type Keys struct {
+	Recipients []*ecdh.PublicKey
+	Passphrase []byte
+}
+
+// Returns an error if the keys cannot be written to the header.
+func (k Keys) validate() error {
+	if len(k.Recipients) == 0 && len(k.Passphrase) == 0 {
+		return ErrNoKey
+	}
+	if k.stanzas() > maxStanzas {
+		return fmt.Errorf("too many recipients, at most %v are supported including the passphrase", maxStanzas)
+	}
+	return nil
+}
+
+// one for every recipient and one for the passphrase
+func (k Keys) stanzas() int {
+	n := len(k.Recipients)
+	if len(k.Passphrase) > 0 {
+		n++
+	}
+	return n
+}
+
+// Identities are used to decrypt a file, it is enough if one of them matches.
+type Identities struct {
+	Keys       []*ecdh.PrivateKey
+	Passphrase []byte
+}
+
+// IsEncrypted returns true if header is the beginning of an encrypted file.
+func IsEncrypted(header []byte) bool {
+	return bytes.HasPrefix(header, []byte(Magic))
+}
+
+// Encrypt returns a writer that encrypts everything written to it and writes it to w.
+// Close has to be called to write the last chunk, it does not close w.
+func Encrypt(w io.Writer, keys Keys) (io.WriteCloser, error) {
+	if err := keys.validate(); err != nil {
+		return nil, err
+	}
+
+	fileKey := make([]byte, keySize)
+	if _, err := rand.Read(fileKey); err != nil {
+		return nil, err
+	}
+
+	var header bytes.Buffer
+	header.WriteString(Magic)
+	header.WriteByte(byte(keys.stanzas()))
+
+	for _, recipient := range keys.Recipients {
+		ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		shared, err := ephemeral.ECDH(recipient)
+		if err != nil {
+			return nil, err
+		}
+		wrapKey, err := x25519WrapKey(shared, ephemeral.PublicKey(), recipient)
+		if err != nil {
+			return nil, err
+		}
+		wrapped, err := wrap(wrapKey, fileKey)
+		if err != nil {
+			return nil, err
+		}
+		header.WriteByte(stanzaX25519)
+		header.Write(ephemeral.PublicKey().Bytes())
+		header.Write(wrapped)
+	}
+
+	if len(keys.Passphrase) > 0 {
+		salt := make([]byte, saltSize)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, err
+		}
+		wrapKey, err := scryptWrapKey(keys.Passphrase, salt, scryptLogN)
+		if err != nil {
+			return nil, err
+		}
+		wrapped, err := wrap(wrapKey, fileKey)
+		if err != nil {
+			return nil, err
+		}
+		header.WriteByte(stanzaScrypt)
+		header.Write(salt)
+		header.WriteByte(scryptLogN)
+		header.Write(wrapped)
+	}
+
+	payloadSalt := make([]byte, saltSize)
+	if _, err := rand.Read(payloadSalt); err != nil {
+		return nil, err
+	}
+	header.Write(payloadSalt)
+
+	aead, err := payloadCipher(fileKey, payloadSalt)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(header.Bytes()); err != nil {
+		return nil, err
+	}
+
+	hash := sha256.Sum256(header.Bytes())
+	return &writer{w: w, aead: aead, ad: hash[:], buf: make([]byte, 0, chunkSize)}, nil
+}
+
+type writer struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	ad      []byte
+	buf     []byte
+	counter uint64
+	closed  bool
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, errors.New("write to closed encrypted writer")
+	}
+	n := 0
+	for len(p) > 0 {
+		// a full chunk is only written when more data follows, the last one has to be marked
+		if len(w.buf) == chunkSize {
+			if err := w.flush(false); err != nil {
+				return n, err
+			}
+		}
+		c := copy(w.buf[len(w.buf):chunkSize], p)
+		w.buf = w.buf[:len(w.buf)+c]
+		p = p[c:]
+		n += c
+	}
+	return n, nil
+}
+
+func (w *writer) flush(last bool) error {
+	sealed := w.aead.Seal(nil, chunkNonce(w.counter, last), w.buf, w.ad)
+	if _, err := w.w.Write(sealed); err != nil {
+		return err
+	}
+	w.counter++
+	w.buf = w.buf[:0]
+	return nil
+}
+
+func (w *writer) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	return w.flush(true)
+}
+
+// Decrypt returns a reader that decrypts r. Reading returns ErrCorrupt if the data was changed or is incomplete.
+func Decrypt(r io.Reader, ids Identities) (io.Reader, error) {
+	br := bufio.NewReaderSize(r, chunkSize+tagSize+1)
+	var header bytes.Buffer
+	hr := io.TeeReader(br, &header)
+
+	magic := make([]byte, len(Magic))
+	if _, err := io.ReadFull(hr, magic); err != nil || !IsEncrypted(magic) {
+		return nil, errors.New("not an encrypted file")
+	}
+
+	count := make([]byte, 1)
+	if _, err := io.ReadFull(hr, count); err != nil {
+		return nil, ErrCorrupt
+	}
+
+	var fileKey []byte
+	for i := 0; i < int(count[0]); i++ {
+		kind := make([]byte, 1)
+		if _, err := io.ReadFull(hr, kind); err != nil {
+			return nil, ErrCorrupt
+		}
+		switch kind[0] {
+		case stanzaX25519:
+			b := make([]byte, keySize+keySize+tagSize)
+			if _, err := io.ReadFull(hr, b); err != nil {
+				return nil, ErrCorrupt
+			}
+			if fileKey != nil {
+				continue
+			}
+			ephemeral, err := ecdh.X25519().NewPublicKey(b[:keySize])
+			if err != nil {
+				return nil, ErrCorrupt
+			}
+			for _, key := range ids.Keys {
+				shared, err := key.ECDH(ephemeral)
+				if err != nil {
+					continue
+				}
+				wrapKey, err := x25519WrapKey(shared, ephemeral, key.PublicKey())
+				if err != nil {
+					return nil, err
+				}
+				if k, err := unwrap(wrapKey, b[keySize:]); err == nil {
+					fileKey = k
+					break
+				}
+			}
+		case stanzaScrypt:
+			b := make([]byte, saltSize+1+keySize+tagSize)
+			if _, err := io.ReadFull(hr, b); err != nil {
+				return nil, ErrCorrupt
+			}
+			if fileKey != nil || len(ids.Passphrase) == 0 {
+				continue
+			}
+			logN := int(b[saltSize])
+			if logN > maxScryptLogN {
+				return nil, fmt.Errorf("scrypt work factor 2^%v is too high", logN)
+			}
+			wrapKey, err := scryptWrapKey(ids.Passphrase, b[:saltSize], logN)
+			if err != nil {
+				return nil, err
+			}
+			if k, err := unwrap(wrapKey, b[saltSize+1:]); err == nil {
+				fileKey = k
+			}
+		default:
+			return nil, fmt.Errorf("unknown key type %v", kind[0])
+		}
+	}
+
+	payloadSalt := make([]byte, saltSize)
+	if _, err := io.ReadFull(hr, payloadSalt); err != nil {
+		return nil, ErrCorrupt
+	}
+	if fileKey == nil {
+		return nil, ErrWrongKey
+	}
+
+	aead, err := payloadCipher(fileKey, payloadSalt)
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.Sum256(header.Bytes())
+	return &reader{r: br, aead: aead, ad: hash[:], chunk: make([]byte, chunkSize+tagSize)}, nil
+}
+
+type reader struct {
+	r       *bufio.Reader
+	aead    cipher.AEAD
+	ad      []byte
+	chunk   []byte
+	plain   []byte
+	counter uint64
+	done    bool
+	err     error
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	for len(r.plain) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		if r.done {
+			return 0, io.EOF
+		}
+		r.err = r.next()
+	}
+	n := copy(p, r.plain)
+	r.plain = r.plain[n:]
+	return n, nil
+}
+
+// decrypts the next chunk, a chunk that is followed by nothing has to be marked as the last one
+func (r *reader) next() error {
+	n, err := io.ReadFull(r.r, r.chunk)
+	last := false
+	switch {
+	case err == io.ErrUnexpectedEOF || err == io.EOF:
+		last = true
+	case err != nil:
+		return err
+	default:
+		if _, err := r.r.Peek(1); err == io.EOF {
+			last = true
+		}
+	}
+	if n < tagSize {
+		return ErrCorrupt
+	}
+	plain, err := r.aead.Open(r.chunk[:0], chunkNonce(r.counter, last), r.chunk[:n], r.ad)
+	if err != nil {
+		return ErrCorrupt
+	}
+	r.counter++
+	r.plain = plain
+	r.done = last
+	return nil
+}
+
+func chunkNonce(counter uint64, last bool) []byte {
+	nonce := make([]byte, nonceSize)
+	binary.BigEndian.PutUint64(nonce[nonceSize-9:nonceSize-1], counter)
+	if last {
+		nonce[nonceSize-1] = 1
+	}
+	return nonce
+}
+
+func payloadCipher(fileKey []byte, salt []byte) (cipher.AEAD, error) {
+	key, err := deriveKey(fileKey, salt, "payload")
+	if err != nil {
+		return nil, err
+	}
+	return newGCM(key)
+}
+
+// the ephemeral public key and the recipient are part of the derivation so that a wrapped key cannot be reused
+func x25519WrapKey(shared []byte, ephemeral *ecdh.PublicKey, recipient *ecdh.PublicKey) ([]byte, error) {
+	salt := append(append([]byte{}, ephemeral.Bytes()...), recipient.Bytes()...)
+	return deriveKey(shared, salt, "x25519")
+}
+
+func scryptWrapKey(passphrase []byte, salt []byte, logN int) ([]byte, error) {
+	return scrypt.Key(passphrase, append([]byte("backup-scrypt"), salt...), 1<<logN, 8, 1, keySize)
+}
+
+func deriveKey(secret []byte, salt []byte, info string) ([]byte, error) {
+	key := make([]byte, keySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secret, salt, []byte("backup-encrypted/v1 "+info)), key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// wrap keys are only ever used once, a zero nonce is fine
+func wrap(wrapKey []byte, fileKey []byte) ([]byte, error) {
+	aead, err := newGCM(wrapKey)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(nil, make([]byte, nonceSize), fileKey, nil), nil
+}
+
+func unwrap(wrapKey []byte, wrapped []byte) ([]byte, error) {
+	aead, err := newGCM(wrapKey)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Open(nil, make([]byte, nonceSize), wrapped, nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
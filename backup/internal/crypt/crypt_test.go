@@ -0,0 +1,220 @@
+package crypt_test
+
+import (
+	"backup/internal/archive"
+	"backup/internal/crypt"
+	"bytes"
+	"crypto/ecdh"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// creates a small backup directory with a text file, a file bigger than one chunk and a symlink
+func createBackupDir(t *testing.T) (string, map[string][]byte) {
+	dir := filepath.Join(t.TempDir(), "backup-2024-01-01")
+	files := map[string][]byte{
+		"files/home/user/.config/app.conf": []byte("key = value\n"),
+		"github/repo/data.bin":             bytes.Repeat([]byte("0123456789abcdef"), 10000),
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, content, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("app.conf", filepath.Join(dir, "files/home/user/.config/link")); err != nil {
+		t.Fatal(err)
+	}
+	return dir, files
+}
+
+func createEncryptedArchive(t *testing.T, dir string, format archive.Format, keys crypt.Keys) string {
+	file := filepath.Join(t.TempDir(), "backup"+format.Extension()+crypt.Extension)
+	_, err := archive.Create(dir, file, archive.Options{
+		Format: format,
+		Encrypt: func(w io.Writer) (io.WriteCloser, error) {
+			return crypt.Encrypt(w, keys)
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func decryptFile(t *testing.T, file string, ids crypt.Identities) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	r, err := crypt.Decrypt(f, ids)
+	if err != nil {
+		return "", err
+	}
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+	output := filepath.Join(t.TempDir(), "decrypted")
+	if err := os.WriteFile(output, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return output, nil
+}
+
+func checkArchive(t *testing.T, file string, files map[string][]byte) {
+	r, err := archive.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	for name, content := range files {
+		rc, err := r.Open(name)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		b, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !bytes.Equal(b, content) {
+			t.Errorf("%s: content differs after decryption", name)
+		}
+	}
+	link, ok := archive.Find(r, "files/home/user/.config/link")
+	if !ok || link.Link != "app.conf" {
+		t.Errorf("symlink was not restored, got %+v", link)
+	}
+}
+
+func newKey(t *testing.T) (*ecdh.PrivateKey, *ecdh.PublicKey) {
+	secret, public, err := crypt.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	secretKey, err := crypt.ParseSecretKey(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKey, err := crypt.ParsePublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return secretKey, publicKey
+}
+
+func TestRoundTrip(t *testing.T) {
+	dir, files := createBackupDir(t)
+	secret, public := newKey(t)
+	_, otherPublic := newKey(t)
+	keys := crypt.Keys{
+		Recipients: []*ecdh.PublicKey{otherPublic, public},
+		Passphrase: []byte("correct horse battery staple"),
+	}
+
+	for _, format := range []archive.Format{archive.FormatZip, archive.FormatTarZst} {
+		file := createEncryptedArchive(t, dir, format, keys)
+
+		if _, err := archive.Open(file); err == nil {
+			t.Fatalf("%s: encrypted archive could be opened without decrypting it", format)
+		}
+
+		t.Run(string(format)+" with key", func(t *testing.T) {
+			output, err := decryptFile(t, file, crypt.Identities{Keys: []*ecdh.PrivateKey{secret}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkArchive(t, output, files)
+		})
+		t.Run(string(format)+" with passphrase", func(t *testing.T) {
+			output, err := decryptFile(t, file, crypt.Identities{Passphrase: keys.Passphrase})
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkArchive(t, output, files)
+		})
+	}
+}
+
+func TestWrongKey(t *testing.T) {
+	dir, _ := createBackupDir(t)
+	_, public := newKey(t)
+	otherSecret, _ := newKey(t)
+	file := createEncryptedArchive(t, dir, archive.FormatTar, crypt.Keys{Recipients: []*ecdh.PublicKey{public}})
+
+	_, err := decryptFile(t, file, crypt.Identities{Keys: []*ecdh.PrivateKey{otherSecret}, Passphrase: []byte("guess")})
+	if !errors.Is(err, crypt.ErrWrongKey) {
+		t.Fatalf("expected ErrWrongKey, got %v", err)
+	}
+}
+
+func TestCorrupt(t *testing.T) {
+	dir, _ := createBackupDir(t)
+	secret, public := newKey(t)
+	file := createEncryptedArchive(t, dir, archive.FormatTar, crypt.Keys{Recipients: []*ecdh.PublicKey{public}})
+	ids := crypt.Identities{Keys: []*ecdh.PrivateKey{secret}}
+
+	b, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// magic, number of keys, one X25519 key and the payload salt
+	headerSize := len(crypt.Magic) + 1 + 1 + 32 + 48 + 16
+	chunkSize := 64*1024 + 16
+	if len(b) < headerSize+2*chunkSize {
+		t.Fatalf("archive is too small to be truncated after a chunk: %v bytes", len(b))
+	}
+
+	tests := map[string][]byte{
+		// a single flipped bit in the data
+		"modified": func() []byte {
+			c := bytes.Clone(b)
+			c[len(c)/2] ^= 1
+			return c
+		}(),
+		// cut at a chunk boundary, the last complete chunk is not marked as the last one
+		"truncated": b[:headerSize+chunkSize],
+		"no data":   b[:headerSize],
+	}
+	for name, content := range tests {
+		t.Run(name, func(t *testing.T) {
+			corrupt := filepath.Join(t.TempDir(), "corrupt.enc")
+			if err := os.WriteFile(corrupt, content, 0600); err != nil {
+				t.Fatal(err)
+			}
+			_, err := decryptFile(t, corrupt, ids)
+			if !errors.Is(err, crypt.ErrCorrupt) {
+				t.Fatalf("expected ErrCorrupt, got %v", err)
+			}
+		})
+	}
+}
+
+func TestTooManyRecipients(t *testing.T) {
+	_, public := newKey(t)
+	keys := crypt.Keys{Passphrase: []byte("correct horse battery staple")}
+	for i := 0; i < 255; i++ {
+		keys.Recipients = append(keys.Recipients, public)
+	}
+	// the number of keys is stored in a single byte, 256 would be written as 0
+	if _, err := crypt.Encrypt(io.Discard, keys); err == nil {
+		t.Fatal("no error for 256 keys")
+	}
+	keys.Passphrase = nil
+	w, err := crypt.Encrypt(io.Discard, keys)
+	if err != nil {
+		t.Fatalf("255 keys: %v", err)
+	}
+	w.Close()
+}
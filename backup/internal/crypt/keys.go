@@ -0,0 +1,200 @@
+package crypt
+
+import (
+	"backup/internal/fs"
+	"bufio"
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const (
+	publicKeyPrefix = "bkpub1"
+	secretKeyPrefix = "BKSECRET1"
+)
+
+// Config references the keys that are used to encrypt archives, no secrets are stored in the config itself.
+type Config struct {
+	// public keys, e.g. bkpub1...
+	Recipients []string `json:"recipients,omitempty"`
+	// files with one public key per line
+	RecipientFiles []string `json:"recipientFiles,omitempty"`
+	// the first line of the file is the passphrase
+	PassphraseFile string `json:"passphraseFile,omitempty"`
+	// secret key used by decrypt, created with keygen
+	IdentityFile string `json:"identityFile,omitempty"`
+}
+
+// Enabled returns true if archives should be encrypted.
+func (c *Config) Enabled() bool {
+	return c != nil && (len(c.Recipients) > 0 || len(c.RecipientFiles) > 0 || c.PassphraseFile != "")
+}
+
+// Keys reads the recipients and the passphrase that are used for encryption.
+func (c *Config) Keys() (Keys, error) {
+	var keys Keys
+	if !c.Enabled() {
+		return keys, ErrNoKey
+	}
+	for _, r := range c.Recipients {
+		key, err := ParsePublicKey(r)
+		if err != nil {
+			return keys, err
+		}
+		keys.Recipients = append(keys.Recipients, key)
+	}
+	for _, file := range c.RecipientFiles {
+		lines, err := readKeyFile(file)
+		if err != nil {
+			return keys, err
+		}
+		for _, line := range lines {
+			key, err := ParsePublicKey(line)
+			if err != nil {
+				return keys, fmt.Errorf("%s: %w", file, err)
+			}
+			keys.Recipients = append(keys.Recipients, key)
+		}
+	}
+	if c.PassphraseFile != "" {
+		passphrase, err := ReadPassphraseFile(c.PassphraseFile)
+		if err != nil {
+			return keys, err
+		}
+		keys.Passphrase = passphrase
+	}
+	return keys, keys.validate()
+}
+
+// GenerateKey creates a new key pair and returns the encoded secret and public key.
+func GenerateKey() (secret string, public string, err error) {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	return secretKeyPrefix + base64.RawURLEncoding.EncodeToString(key.Bytes()),
+		publicKeyPrefix + base64.RawURLEncoding.EncodeToString(key.PublicKey().Bytes()), nil
+}
+
+func ParsePublicKey(s string) (*ecdh.PublicKey, error) {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, publicKeyPrefix) {
+		return nil, fmt.Errorf("invalid public key %q, expected %s...", s, publicKeyPrefix)
+	}
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(s, publicKeyPrefix))
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key %q: %w", s, err)
+	}
+	key, err := ecdh.X25519().NewPublicKey(b)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key %q: %w", s, err)
+	}
+	return key, nil
+}
+
+// the secret key is never part of the error
+func ParseSecretKey(s string) (*ecdh.PrivateKey, error) {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, secretKeyPrefix) {
+		return nil, fmt.Errorf("invalid secret key, expected %s...", secretKeyPrefix)
+	}
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(s, secretKeyPrefix))
+	if err != nil {
+		return nil, errors.New("invalid secret key")
+	}
+	key, err := ecdh.X25519().NewPrivateKey(b)
+	if err != nil {
+		return nil, errors.New("invalid secret key")
+	}
+	return key, nil
+}
+
+// ReadIdentityFile reads all secret keys of a file created by keygen.
+func ReadIdentityFile(file string) ([]*ecdh.PrivateKey, error) {
+	lines, err := readKeyFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var keys []*ecdh.PrivateKey
+	for _, line := range lines {
+		key, err := ParseSecretKey(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%s: no secret key found", file)
+	}
+	return keys, nil
+}
+
+// ReadPassphraseFile returns the first line of the file without the line break.
+func ReadPassphraseFile(file string) ([]byte, error) {
+	path, err := fs.AbsPath(file)
+	if err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read passphrase file: %w", err)
+	}
+	passphrase := strings.TrimRight(strings.SplitN(string(b), "\n", 2)[0], "\r")
+	if passphrase == "" {
+		return nil, fmt.Errorf("passphrase file %s is empty", file)
+	}
+	return []byte(passphrase), nil
+}
+
+// WriteIdentityFile writes a new secret key that only the owner can read, existing files are never overwritten.
+func WriteIdentityFile(file string, secret string, public string) error {
+	path, err := fs.AbsPath(file)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(f, "# public key: %s\n%s\n", public, secret)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// FileName returns the name of the encrypted version of an archive.
+func FileName(file string) string {
+	if strings.HasSuffix(file, Extension) {
+		return file
+	}
+	return file + Extension
+}
+
+// lines without comments and empty lines
+func readKeyFile(file string) ([]string, error) {
+	path, err := fs.AbsPath(file)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read key file: %w", err)
+	}
+	defer f.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines, scanner.Err()
+}
@@ -0,0 +1,106 @@
+package daemon
+
+import (
+	"backup/internal/fs"
+	"backup/internal/schedule"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// ErrNotRunning is returned by QueryStatus if no daemon listens on the socket.
+var ErrNotRunning = errors.New("daemon is not running")
+
+// SocketPath returns the socket of the status API, $XDG_RUNTIME_DIR/backup/daemon.sock unless the config says otherwise.
+// Without a runtime directory the state directory is used.
+func SocketPath(c *schedule.Config) (string, error) {
+	if c != nil && c.Socket != "" {
+		return fs.AbsPath(c.Socket)
+	}
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); filepath.IsAbs(dir) {
+		return filepath.Join(dir, "backup", "daemon.sock"), nil
+	}
+	dir, err := fs.StateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "daemon.sock"), nil
+}
+
+// Only the user can connect, the status contains paths and error messages.
+func listen(socket string) (net.Listener, error) {
+	if err := os.MkdirAll(filepath.Dir(socket), 0700); err != nil {
+		return nil, err
+	}
+	if _, err := QueryStatus(socket); err == nil {
+		return nil, fmt.Errorf("another daemon is already running, its status is on %s", socket)
+	}
+	// left behind by a daemon that was killed
+	if err := os.Remove(socket); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(socket, 0600); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// GET /status returns the Status as JSON.
+func (d *daemon) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		d.mu.Lock()
+		data, err := json.Marshal(d.status)
+		d.mu.Unlock()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+	})
+	return mux
+}
+
+// QueryStatus asks the daemon listening on the socket for its status.
+func QueryStatus(socket string) (Status, error) {
+	var status Status
+	client := &http.Client{
+		Timeout: 2 * time.Second,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		},
+	}
+	resp, err := client.Get("http://daemon/status")
+	if err != nil {
+		if errors.Is(err, syscall.ENOENT) || errors.Is(err, syscall.ECONNREFUSED) {
+			return status, ErrNotRunning
+		}
+		return status, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return status, fmt.Errorf("daemon responded with %s", resp.Status)
+	}
+	err = json.NewDecoder(resp.Body).Decode(&status)
+	return status, err
+}
@@ -0,0 +1,402 @@
+// Package daemon runs full backups on the schedules of the config.
+//
+// The daemon checks every minute whether a backup is due. Since the check compares against the wall clock and the time
+// of the last backup is kept in the state directory, runs missed during sleep or shutdown are caught up right after
+// the computer wakes up or the daemon starts again, several missed runs result in a single backup.
+// Its status is served as JSON on a Unix socket, see QueryStatus.
+package daemon
+
+import (
+	"backup/internal/config"
+	"backup/internal/fs"
+	"backup/internal/lock"
+	"backup/internal/notify"
+	"backup/internal/pipeline"
+	"backup/internal/schedule"
+	"encoding/json"
+	"errors"
+	"fmt"
+	iofs "io/fs"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// how often the daemon checks whether a backup is due
+const checkInterval = time.Minute
+
+type Status struct {
+	PID     int       `json:"pid"`
+	Started time.Time `json:"started"`
+	// the times from the config
+	Schedules []string `json:"schedules"`
+	// when the next backup is due, in the past if a backup is waiting, zero if the schedules never run
+	Next time.Time `json:"next"`
+	// why a backup that is due does not start, e.g. "on battery"
+	Waiting string     `json:"waiting,omitempty"`
+	Running *RunStatus `json:"running,omitempty"`
+	Last    *RunStatus `json:"last,omitempty"`
+}
+
+type RunStatus struct {
+	// when the backup was due, earlier than Start if it was caught up
+	Due   time.Time `json:"due"`
+	Start time.Time `json:"start"`
+	// zero while running
+	End    time.Time     `json:"end"`
+	Phases []PhaseStatus `json:"phases"`
+	// set if the run could not start, e.g. because of an invalid config
+	Err string `json:"error,omitempty"`
+}
+
+type PhaseStatus struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// CatchUp returns true if the run started later than it was due, e.g. after sleep.
+func (r *RunStatus) CatchUp() bool {
+	return r.Start.Sub(r.Due) > checkInterval
+}
+
+// Failed returns the number of failed phases.
+func (r *RunStatus) Failed() int {
+	failed := 0
+	for _, p := range r.Phases {
+		if p.Status == pipeline.StatusFailed.String() {
+			failed++
+		}
+	}
+	return failed
+}
+
+// kept in the state directory between restarts
+type savedState struct {
+	// when the daemon started for the first time, runs missed since then are caught up
+	Since time.Time  `json:"since"`
+	Last  *RunStatus `json:"last,omitempty"`
+}
+
+type daemon struct {
+	configFile string
+	config     *schedule.Config
+	schedules  []schedule.Schedule
+	logf       func(format string, a ...any)
+
+	// guards state and status, the status is read by the API while a backup runs
+	mu     sync.Mutex
+	state  savedState
+	status Status
+}
+
+// Run runs backups on the schedules of the config until the process receives SIGINT or SIGTERM.
+// The config file is read again for every backup, changes of the schedules need a restart.
+func Run(configFile string, c *schedule.Config, logf func(format string, a ...any)) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	schedules, err := c.Schedules()
+	if err != nil {
+		return err
+	}
+
+	d := &daemon{configFile: configFile, config: c, schedules: schedules, logf: logf}
+	d.state, err = readState()
+	if err != nil {
+		return fmt.Errorf("could not read state: %w", err)
+	}
+	if d.state.Since.IsZero() {
+		d.state.Since = time.Now()
+		if err := writeState(d.state); err != nil {
+			return fmt.Errorf("could not write state: %w", err)
+		}
+	}
+	d.status = Status{PID: os.Getpid(), Started: time.Now(), Schedules: c.Times, Last: d.state.Last}
+
+	socket, err := SocketPath(c)
+	if err != nil {
+		return err
+	}
+	l, err := listen(socket)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(socket)
+	server := &http.Server{Handler: d.handler()}
+	go server.Serve(l)
+	defer server.Close()
+	logf("status on %s", socket)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+	// buffered, a backup that finishes after a signal must not wait for a receiver that is gone
+	finished := make(chan struct{}, 1)
+	for {
+		d.check(finished)
+		select {
+		case <-ticker.C:
+		case <-finished:
+		case s := <-signals:
+			d.mu.Lock()
+			if d.status.Running != nil {
+				logf("received %v, the running backup is interrupted", s)
+			} else {
+				logf("received %v, stopping", s)
+			}
+			d.mu.Unlock()
+			return nil
+		}
+	}
+}
+
+// Starts a backup if one is due and nothing prevents it, finished receives a value when it is done.
+// The checks before starting can take a while, e.g. busctl, they run without holding the mutex so that the status
+// can be served meanwhile.
+func (d *daemon) check(finished chan<- struct{}) {
+	due, ok := d.due()
+	if !ok {
+		return
+	}
+
+	reason := d.skipReason()
+	// the config is read again for every backup
+	config, configErr := config.LoadConfig(d.configFile)
+	var r *pipeline.Run
+	if reason == "" && configErr == nil {
+		r = pipeline.NewRun(config, "backup daemon")
+		r.Logf = d.logf
+		if err := r.Lock(); err != nil {
+			var locked *lock.LockedError
+			if !errors.As(err, &locked) {
+				d.logf("error: %v", err)
+				return
+			}
+			reason = fmt.Sprintf("backup directory in use by %s", locked.Holder)
+		}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.status.Running != nil {
+		if r != nil {
+			r.Release()
+		}
+		return
+	}
+	if reason != "" {
+		if reason != d.status.Waiting {
+			d.logf("backup due at %s skipped: %s, it runs once that changes", due.Format("2006-01-02 15:04"), reason)
+		}
+		d.status.Waiting = reason
+		return
+	}
+	d.status.Waiting = ""
+
+	run := &RunStatus{Due: due, Start: time.Now()}
+	for _, p := range pipeline.Phases {
+		run.Phases = append(run.Phases, PhaseStatus{Name: p.Name, Status: pipeline.StatusPending.String()})
+	}
+	d.status.Running = run
+	go func() {
+		if configErr != nil {
+			d.logf("error: could not load config: %v", configErr)
+			d.finish(run, fmt.Sprintf("could not load config: %v", configErr))
+		} else {
+			d.backup(run, r)
+			d.notify(config.Notify, run)
+		}
+		finished <- struct{}{}
+	}()
+}
+
+// Updates when the next backup is due and returns it, false if it is not due yet or a backup is running.
+func (d *daemon) due() (time.Time, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.status.Running != nil {
+		return time.Time{}, false
+	}
+
+	base := d.state.Since
+	if d.state.Last != nil {
+		// times that passed while a backup ran are not caught up
+		base = d.state.Last.End
+	}
+	due := schedule.Next(d.schedules, base)
+	if !due.Equal(d.status.Next) {
+		if due.IsZero() {
+			d.logf("none of the schedules ever runs")
+		} else if due.After(time.Now()) {
+			d.logf("next backup at %s", due.Format("2006-01-02 15:04"))
+		}
+	}
+	d.status.Next = due
+	if due.IsZero() || due.After(time.Now()) {
+		d.status.Waiting = ""
+		return due, false
+	}
+	return due, true
+}
+
+// Returns why a backup should not run now, empty if it can run. States that cannot be detected do not prevent it.
+func (d *daemon) skipReason() string {
+	if !d.config.OnBattery && onBattery() {
+		return "on battery"
+	}
+	if !d.config.OnMetered && metered() {
+		return "metered connection"
+	}
+	return ""
+}
+
+// Runs all phases of the pipeline, failed phases do not stop the ones after them. r holds the run lock.
+func (d *daemon) backup(run *RunStatus, r *pipeline.Run) {
+	if run.CatchUp() {
+		d.logf("starting backup that was due at %s", run.Due.Format("2006-01-02 15:04"))
+	} else {
+		d.logf("starting backup")
+	}
+
+	defer r.Release()
+	for i, p := range pipeline.Phases {
+		d.setPhase(run, i, pipeline.StatusRunning, "")
+		result := p.Run(r, func(detail string) {
+			d.setPhase(run, i, pipeline.StatusRunning, detail)
+		})
+		d.setPhase(run, i, result.Status, result.Detail)
+		if result.Status == pipeline.StatusFailed {
+			d.logf("%s failed: %s", p.Name, result.Detail)
+		} else {
+			d.logf("%s %s: %s", p.Name, result.Status, result.Detail)
+		}
+	}
+	d.finish(run, "")
+}
+
+func (d *daemon) setPhase(run *RunStatus, i int, status pipeline.Status, detail string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	run.Phases[i].Status = status.String()
+	run.Phases[i].Detail = detail
+}
+
+func (d *daemon) finish(run *RunStatus, err string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	run.End = time.Now()
+	run.Err = err
+	d.status.Running = nil
+	d.status.Last = run
+	d.state.Last = run
+
+	if failed := run.Failed(); failed > 0 {
+		d.logf("backup finished in %s, %v phases failed", run.End.Sub(run.Start).Round(time.Second), failed)
+	} else if err == "" {
+		d.logf("backup finished in %s", run.End.Sub(run.Start).Round(time.Second))
+	}
+	if err := writeState(d.state); err != nil {
+		d.logf("error: could not write state: %v", err)
+	}
+}
+
+// Sends the notifications of a finished run, channels that fail are logged.
+func (d *daemon) notify(c *notify.Config, run *RunStatus) {
+	d.mu.Lock()
+	n := run.notification()
+	d.mu.Unlock()
+	for _, r := range notify.Send(c, n) {
+		if r.Err != nil {
+			d.logf("warning: could not send %s notification: %v", r.Channel, r.Err)
+		} else {
+			d.logf("sent %s notification", r.Channel)
+		}
+	}
+}
+
+func (r *RunStatus) notification() notify.Notification {
+	host, _ := os.Hostname()
+	status, title := notify.StatusSuccess, "finished"
+	if r.Err != "" {
+		status, title = notify.StatusFailure, "failed"
+	} else if r.Failed() > 0 {
+		// the other phases still ran, like a run of the script that exits with code 2
+		status, title = notify.StatusWarnings, "finished with errors"
+	}
+
+	lines := []string{fmt.Sprintf("Started %s, took %s.", r.Start.Local().Format("2006-01-02 15:04"), r.End.Sub(r.Start).Round(time.Second))}
+	if r.CatchUp() {
+		lines = append(lines, fmt.Sprintf("It was due at %s.", r.Due.Local().Format("2006-01-02 15:04")))
+	}
+	lines = append(lines, "")
+	for _, p := range r.Phases {
+		lines = append(lines, fmt.Sprintf("%-10s %-8s %s", p.Name, p.Status, p.Detail))
+	}
+	return notify.Notification{
+		Status: status,
+		Title:  fmt.Sprintf("Scheduled backup on %s %s", host, title),
+		Text:   strings.Join(lines, "\n"),
+		Data:   r,
+	}
+}
+
+func stateFile() (string, error) {
+	dir, err := fs.StateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "daemon.json"), nil
+}
+
+// Returns an empty state if the daemon never ran.
+func readState() (savedState, error) {
+	var s savedState
+	file, err := stateFile()
+	if err != nil {
+		return s, err
+	}
+	data, err := os.ReadFile(file)
+	if errors.Is(err, iofs.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return s, err
+	}
+	err = json.Unmarshal(data, &s)
+	return s, err
+}
+
+func writeState(s savedState) error {
+	file, err := stateFile()
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := fs.CreateDir(filepath.Dir(file)); err != nil {
+		return err
+	}
+	tmp := file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+// LastRun returns the last backup of the daemon from the state directory, e.g. while the daemon is not running.
+// Returns nil if there is none.
+func LastRun() (*RunStatus, error) {
+	s, err := readState()
+	return s.Last, err
+}
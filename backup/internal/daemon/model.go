@@ -0,0 +1,260 @@
+package daemon
+
+import (
+	"backup/internal/config"
+	"backup/internal/pipeline"
+	"backup/internal/style"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/help"
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/spinner"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// how often the status is queried while the screen is open
+const refreshInterval = 2 * time.Second
+
+type state int
+
+const (
+	stateLoading state = iota
+	stateStatus
+)
+
+type Model struct {
+	state  state
+	config config.Config
+	socket string
+
+	status Status
+	// last backup from the state directory if the daemon is not running
+	last *RunStatus
+	err  error
+
+	spinner  spinner.Model
+	helpView help.Model
+	keyMap   keyMap
+
+	styles style.Styles
+
+	width  int
+	height int
+}
+
+func NewModel(config config.Config, styles style.Styles) *Model {
+	helpView := help.New()
+	helpView.Styles = styles.HelpStyles
+
+	return &Model{
+		state:  stateLoading,
+		config: config,
+		spinner: spinner.New(
+			spinner.WithSpinner(spinner.Dot),
+			spinner.WithStyle(styles.ListItemSelectedStyle),
+		),
+		helpView: helpView,
+		keyMap:   defaultKeyMap(),
+		styles:   styles,
+	}
+}
+
+func (m *Model) Init() tea.Cmd {
+	socket, err := SocketPath(m.config.Schedule)
+	if err != nil {
+		m.state = stateStatus
+		m.err = err
+		return nil
+	}
+	m.socket = socket
+	return tea.Batch(queryCmd(socket), m.spinner.Tick)
+}
+
+func (m *Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	var cmd tea.Cmd
+
+	switch msg := msg.(type) {
+	case queryResult:
+		m.state = stateStatus
+		m.status, m.last, m.err = msg.status, msg.last, msg.err
+		cmd = tea.Tick(refreshInterval, func(time.Time) tea.Msg {
+			return refresh{}
+		})
+	case refresh:
+		cmd = queryCmd(m.socket)
+	case spinner.TickMsg:
+		// keeps running, running phases show the spinner as well
+		m.spinner, cmd = m.spinner.Update(msg)
+	case tea.KeyMsg:
+		if key.Matches(msg, m.keyMap.Return) {
+			cmd = done()
+		}
+	}
+
+	return m, cmd
+}
+
+func (m *Model) SetSize(width, height int) {
+	m.width = width
+	m.height = height
+	m.helpView.Width = width
+}
+
+var okStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("#7ef542"))
+var failedStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("#de0d18"))
+var warningStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("#f5a442"))
+var nameStyle = lipgloss.NewStyle().Width(10)
+
+func (m *Model) View() string {
+	styles := m.styles
+
+	var content string
+	switch {
+	case m.state == stateLoading:
+		content = fmt.Sprintf("%s %s", styles.NormalTextStyle.UnsetWidth().Render("Querying daemon"), m.spinner.View())
+	case errors.Is(m.err, ErrNotRunning):
+		lines := []string{
+			styles.NormalTextStyle.Render("The daemon is not running, start it with \"backup daemon\"."),
+		}
+		if m.last != nil {
+			lines = append(lines, "", m.runView("Last backup", m.last))
+		}
+		content = lipgloss.JoinVertical(lipgloss.Left, lines...)
+	case m.err != nil:
+		content = styles.ErrorTextStyle.Render(fmt.Sprintf("Could not query the daemon: %v", m.err))
+	default:
+		content = m.statusView()
+	}
+
+	return lipgloss.JoinVertical(
+		lipgloss.Left,
+		styles.TitleStyle.Render("Daemon"),
+		"",
+		content,
+		"",
+		m.helpView.ShortHelpView(m.keyMap.keys()),
+	)
+}
+
+func (m *Model) statusView() string {
+	styles := m.styles
+	s := m.status
+
+	next := "never"
+	if !s.Next.IsZero() {
+		next = fmt.Sprintf("%s (%s)", s.Next.Local().Format("2006-01-02 15:04"), relative(s.Next))
+	}
+	lines := []string{
+		styles.NormalTextStyle.Render(fmt.Sprintf("Running since %s, pid %v", s.Started.Local().Format("2006-01-02 15:04"), s.PID)),
+		styles.ListItemDescriptionStyle.Render("schedules: " + strings.Join(s.Schedules, ", ")),
+		styles.ListItemDescriptionStyle.Render("next backup: " + next),
+	}
+	if s.Waiting != "" {
+		lines = append(lines, warningStyle.Render(fmt.Sprintf("A backup is due but waits: %s", s.Waiting)))
+	}
+	if s.Running != nil {
+		lines = append(lines, "", m.runView("Running backup", s.Running))
+	}
+	if s.Last != nil {
+		lines = append(lines, "", m.runView("Last backup", s.Last))
+	}
+	return lipgloss.JoinVertical(lipgloss.Left, lines...)
+}
+
+func (m *Model) runView(title string, r *RunStatus) string {
+	styles := m.styles
+
+	header := fmt.Sprintf("%s, started %s", title, r.Start.Local().Format("2006-01-02 15:04"))
+	if r.CatchUp() {
+		header += fmt.Sprintf(", due %s", r.Due.Local().Format("2006-01-02 15:04"))
+	}
+	if !r.End.IsZero() {
+		header += fmt.Sprintf(", took %s", r.End.Sub(r.Start).Round(time.Second))
+	}
+	lines := []string{styles.ListItemSelectedStyle.Render(header)}
+	if r.Err != "" {
+		return lipgloss.JoinVertical(lipgloss.Left, append(lines, styles.ErrorTextStyle.Render(r.Err))...)
+	}
+
+	for _, p := range r.Phases {
+		var status string
+		switch p.Status {
+		case pipeline.StatusRunning.String():
+			status = m.spinner.View()
+		case pipeline.StatusDone.String():
+			status = okStyle.Render("✓")
+		case pipeline.StatusFailed.String():
+			status = failedStyle.Render("x")
+		case pipeline.StatusSkipped.String():
+			status = "-"
+		default:
+			status = "·"
+		}
+		detail := p.Detail
+		if detail == "" {
+			detail = p.Status
+		}
+		lines = append(lines, fmt.Sprintf("%s %s %s", status, nameStyle.Render(p.Name), styles.ListItemDescriptionStyle.Render(detail)))
+	}
+	return lipgloss.JoinVertical(lipgloss.Left, lines...)
+}
+
+// e.g. "in 3h20m" or "overdue"
+func relative(t time.Time) string {
+	d := time.Until(t)
+	if d < 0 {
+		return "overdue"
+	}
+	if d < time.Minute {
+		return "in less than a minute"
+	}
+	return "in " + strings.TrimSuffix(d.Round(time.Minute).String(), "0s")
+}
+
+type queryResult struct {
+	status Status
+	last   *RunStatus
+	err    error
+}
+
+type refresh struct{}
+
+func queryCmd(socket string) tea.Cmd {
+	return func() tea.Msg {
+		status, err := QueryStatus(socket)
+		var last *RunStatus
+		if errors.Is(err, ErrNotRunning) {
+			last, _ = LastRun()
+		}
+		return queryResult{status: status, last: last, err: err}
+	}
+}
+
+type Done struct{}
+
+func done() tea.Cmd {
+	return func() tea.Msg {
+		return Done{}
+	}
+}
+
+type keyMap struct {
+	Return key.Binding
+}
+
+func defaultKeyMap() keyMap {
+	return keyMap{
+		Return: key.NewBinding(
+			key.WithKeys("enter", "esc"),
+			key.WithHelp("enter", "return"),
+		),
+	}
+}
+
+func (m keyMap) keys() []key.Binding {
+	return []key.Binding{m.Return}
+}
@@ -0,0 +1,63 @@
+package daemon
+
+import (
+	"backup/internal/exec"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var powerSupplyDir = "/sys/class/power_supply"
+
+// Returns true if a battery is discharging and no charger is connected, false if there is no battery.
+func onBattery() bool {
+	entries, err := os.ReadDir(powerSupplyDir)
+	if err != nil {
+		return false
+	}
+	discharging := false
+	for _, e := range entries {
+		dir := filepath.Join(powerSupplyDir, e.Name())
+		switch readValue(dir, "type") {
+		case "Mains", "USB":
+			if readValue(dir, "online") == "1" {
+				return false
+			}
+		case "Battery":
+			// batteries of mice and keyboards are reported as well
+			if readValue(dir, "scope") == "Device" {
+				continue
+			}
+			if readValue(dir, "status") == "Discharging" {
+				discharging = true
+			}
+		}
+	}
+	return discharging
+}
+
+func readValue(dir string, name string) string {
+	data, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// Returns true if NetworkManager considers the primary connection metered, false if that is unknown.
+func metered() bool {
+	if exec.CommandAvailable("busctl") != nil {
+		return false
+	}
+	result := exec.Background([]string{
+		"busctl", "--system", "get-property",
+		"org.freedesktop.NetworkManager", "/org/freedesktop/NetworkManager", "org.freedesktop.NetworkManager", "Metered",
+	}, exec.WithTimeout(5*time.Second))
+	if result.Err != nil || result.ExitCode != 0 {
+		return false
+	}
+	// e.g. "u 1", 1 is yes and 3 is guessed yes
+	value := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(result.Stdout), "u"))
+	return value == "1" || value == "3"
+}
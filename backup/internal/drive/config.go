@@ -0,0 +1,62 @@
+// Package drive copies backups onto removable drives, e.g. two USB drives that are swapped regularly.
+//
+// Drives are found by filesystem label or UUID among the drives mounted under /media and /run/media. Every backup
+// goes to its own folder <dir>/<time>/ on the drive, and every drive holds a record of its backups. A copy of the
+// record is kept locally, so the age of the backups on drives that are not plugged in is known as well.
+package drive
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+type Config struct {
+	// filesystem labels or UUIDs, e.g. ["BACKUP-A", "BACKUP-B"]
+	Drives []string `json:"drives,omitempty"`
+	// directory on the drive, "backups" if empty
+	Dir string `json:"dir,omitempty"`
+	// number of backups to keep on each drive, older ones are deleted after a copy, 0 keeps all
+	Keep int `json:"keep,omitempty"`
+	// copy the backup directory instead of the archive
+	Snapshot bool `json:"snapshot,omitempty"`
+}
+
+const DefaultDir = "backups"
+
+// Enabled returns true if backups should be copied to drives.
+func (c *Config) Enabled() bool {
+	return c != nil && len(c.Drives) > 0
+}
+
+// Validate checks the config without looking for the drives.
+func (c *Config) Validate() error {
+	if !c.Enabled() {
+		return errors.New("no drives specified")
+	}
+	for _, id := range c.Drives {
+		if id == "" || id == "." || id == ".." || strings.ContainsAny(id, "/\x00") {
+			return fmt.Errorf("invalid drive %q: must be a filesystem label or UUID", id)
+		}
+	}
+	if filepath.IsAbs(c.Dir) || strings.HasPrefix(filepath.Clean(c.Dir), "..") {
+		return fmt.Errorf("invalid dir %q: must be a relative path on the drive", c.Dir)
+	}
+	if c.Keep < 0 {
+		return errors.New("keep cannot be negative")
+	}
+	return nil
+}
+
+// Names returns the drives, e.g. "BACKUP-A, BACKUP-B".
+func (c *Config) Names() string {
+	return strings.Join(c.Drives, ", ")
+}
+
+func (c *Config) dir() string {
+	if c.Dir == "" {
+		return DefaultDir
+	}
+	return filepath.Clean(c.Dir)
+}
@@ -0,0 +1,363 @@
+package drive
+
+import (
+	"backup/internal/files"
+	"backup/internal/fs"
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// format of the folders of the backups, sorting them by name sorts them by time
+const folderFormat = "2006-01-02T15-04-05Z"
+
+// suffix of a folder that is still being copied
+const partSuffix = ".part"
+
+type Progress struct {
+	BytesDone  int64
+	BytesTotal int64
+	// drive that is currently written
+	Drive string
+	// file that is currently copied
+	Current string
+}
+
+type Result struct {
+	Drive Mount
+	// folder of the backup on the drive
+	Path   string
+	Backup Backup
+	// folders that were deleted by the retention rule
+	Deleted []string
+	// deleting old backups failed, the copy itself succeeded
+	DeleteErr error
+	Time      time.Duration
+	// the copy to this drive failed, the other drives are still written
+	Err error
+}
+
+// Copy copies the files of an archive to every mounted drive, into a folder named after the time of the archive.
+// Every file is compared with the archive afterwards. Returns no results if none of the drives is mounted, a drive
+// that fails has the error in its result.
+func Copy(c *Config, archiveFiles []string, onProgress func(Progress)) ([]Result, error) {
+	if len(archiveFiles) == 0 {
+		return nil, errors.New("nothing to copy")
+	}
+	info, err := os.Stat(archiveFiles[0])
+	if err != nil {
+		return nil, err
+	}
+	var size int64
+	for _, f := range archiveFiles {
+		s, err := fs.FileSize(f)
+		if err != nil {
+			return nil, err
+		}
+		size += s
+	}
+
+	name := info.ModTime().UTC().Format(folderFormat)
+	backup := Backup{Name: name, Files: len(archiveFiles), Size: size}
+	return copyToDrives(c, backup, onProgress, func(folder string, report func(done int64, current string)) error {
+		var done int64
+		for _, f := range archiveFiles {
+			err := copyFile(f, filepath.Join(folder, filepath.Base(f)), func(n int64) {
+				report(done+n, filepath.Base(f))
+			})
+			if err != nil {
+				return err
+			}
+			s, _ := fs.FileSize(f)
+			done += s
+		}
+		return nil
+	})
+}
+
+// CopyDir copies the backup directory to every mounted drive, into a folder named after the current time.
+// Returns no results if none of the drives is mounted, a drive that fails has the error in its result.
+func CopyDir(c *Config, dir string, onProgress func(Progress)) ([]Result, error) {
+	var count int
+	var size int64
+	err := files.Walk(dir, nil, func(path string, info os.FileInfo) error {
+		if info.Mode().IsRegular() {
+			count++
+			size += info.Size()
+		}
+		return nil
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	name := time.Now().UTC().Format(folderFormat)
+	backup := Backup{Name: name, Snapshot: true, Files: count, Size: size}
+	return copyToDrives(c, backup, onProgress, func(folder string, report func(done int64, current string)) error {
+		results := files.CopyAll([]files.Job{{Source: dir, Target: folder}}, files.Options{
+			OnProgress: func(p files.Progress) {
+				report(p.BytesDone, p.Current)
+			},
+		})
+		if err := results[0].Err; err != nil {
+			return fmt.Errorf("%v files could not be copied: %w", results[0].Failed, err)
+		}
+		return nil
+	})
+}
+
+// Copies a backup to every mounted drive with copyFn, which writes the backup into the given folder.
+// The folder only gets its final name when everything was copied. A drive that fails, e.g. because it is full,
+// does not stop the copies to the other drives.
+func copyToDrives(c *Config, backup Backup, onProgress func(Progress), copyFn func(folder string, report func(done int64, current string)) error) ([]Result, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	mounts, err := c.Mounted()
+	if err != nil {
+		return nil, fmt.Errorf("could not find drives: %w", err)
+	}
+
+	var results []Result
+	for _, m := range mounts {
+		start := time.Now()
+		result, err := copyToDrive(c, m, backup, onProgress, copyFn)
+		if err != nil {
+			result.Err = fmt.Errorf("%s: %w", m.ID, err)
+		}
+		result.Time = time.Since(start)
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func copyToDrive(c *Config, m Mount, backup Backup, onProgress func(Progress), copyFn func(folder string, report func(done int64, current string)) error) (Result, error) {
+	result := Result{Drive: m}
+	dir := filepath.Join(m.Path, c.dir())
+	if err := fs.CreateDir(dir); err != nil {
+		return result, err
+	}
+	free, err := fs.FreeSpace(dir)
+	if err != nil {
+		return result, err
+	}
+	if free < backup.Size && c.Keep > 0 {
+		// the backups that are deleted after the copy anyway make room for it
+		result.Deleted, err = prune(c, dir, backup.Name)
+		if err != nil {
+			return result, fmt.Errorf("could not delete old backups: %w", err)
+		}
+		if free, err = fs.FreeSpace(dir); err != nil {
+			return result, err
+		}
+	}
+	if free < backup.Size {
+		return result, fmt.Errorf("not enough space, %s needed and %s free", fileSizeString(backup.Size), fileSizeString(free))
+	}
+
+	tmp := filepath.Join(dir, backup.Name+partSuffix)
+	if err := os.RemoveAll(tmp); err != nil {
+		return result, err
+	}
+	if err := fs.CreateDir(tmp); err != nil {
+		return result, err
+	}
+	err = copyFn(tmp, func(done int64, current string) {
+		if onProgress != nil {
+			onProgress(Progress{BytesDone: done, BytesTotal: backup.Size, Drive: m.ID, Current: current})
+		}
+	})
+	if err != nil {
+		os.RemoveAll(tmp)
+		return result, err
+	}
+	// the same archive copied again replaces the previous copy
+	result.Path = filepath.Join(dir, backup.Name)
+	if err := os.RemoveAll(result.Path); err != nil {
+		return result, err
+	}
+	if err := os.Rename(tmp, result.Path); err != nil {
+		return result, err
+	}
+
+	record, err := readRecord(filepath.Join(dir, recordFile))
+	if err != nil {
+		record = Record{}
+	}
+	record.ID = m.ID
+	var backups []Backup
+	for _, b := range record.Backups {
+		if b.Name != backup.Name {
+			backups = append(backups, b)
+		}
+	}
+	result.Backup = backup
+	result.Backup.Time = time.Now()
+	record.Backups = append(backups, result.Backup)
+
+	deleted, err := prune(c, dir, backup.Name)
+	result.Deleted = append(result.Deleted, deleted...)
+	result.DeleteErr = err
+	record.Backups = existing(dir, record.Backups)
+	if err := writeRecord(filepath.Join(dir, recordFile), record); err != nil {
+		return result, fmt.Errorf("could not write record: %w", err)
+	}
+	local, err := localRecordFile(m.ID)
+	if err == nil {
+		err = writeRecord(local, record)
+	}
+	if err != nil {
+		return result, fmt.Errorf("could not write local copy of record: %w", err)
+	}
+	return result, nil
+}
+
+// Deletes the backups beyond the retention count and leftovers of interrupted copies, current is always kept.
+// Only folders named like backups are touched, anything else on the drive is left alone.
+func prune(c *Config, dir string, current string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	var deleted []string
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		name := strings.TrimSuffix(e.Name(), partSuffix)
+		if _, err := time.Parse(folderFormat, name); err != nil {
+			continue
+		}
+		if name != e.Name() {
+			if err := os.RemoveAll(filepath.Join(dir, e.Name())); err != nil {
+				return deleted, err
+			}
+			deleted = append(deleted, e.Name())
+			continue
+		}
+		names = append(names, name)
+	}
+	if c.Keep == 0 {
+		return deleted, nil
+	}
+
+	// newest first, current counts as one of the kept backups
+	sort.Sort(sort.Reverse(sort.StringSlice(names)))
+	kept := 1
+	for _, name := range names {
+		if name == current {
+			continue
+		}
+		if kept < c.Keep {
+			kept++
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(dir, name)); err != nil {
+			return deleted, err
+		}
+		deleted = append(deleted, name)
+	}
+	return deleted, nil
+}
+
+// the backups whose folders exist, backups deleted by hand are dropped from the record
+func existing(dir string, backups []Backup) []Backup {
+	var result []Backup
+	for _, b := range backups {
+		if exists, err := fs.DirExists(filepath.Join(dir, b.Name)); err == nil && exists {
+			result = append(result, b)
+		}
+	}
+	return result
+}
+
+// Copies a file and compares the copy with the file afterwards, report is called with the bytes copied so far.
+func copyFile(source string, target string, report func(n int64)) error {
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	h := sha256.New()
+	w := &progressWriter{report: report}
+	_, err = io.Copy(io.MultiWriter(out, h, w), in)
+	if err == nil {
+		// the data has to be on the drive before it is unplugged
+		err = out.Sync()
+	}
+	if err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	report(info.Size())
+
+	copied, err := hashFile(target)
+	if err != nil {
+		return fmt.Errorf("could not check copy: %w", err)
+	}
+	if !bytes.Equal(copied, h.Sum(nil)) {
+		return fmt.Errorf("copy of %s is broken", filepath.Base(source))
+	}
+	return os.Chtimes(target, info.ModTime(), info.ModTime())
+}
+
+// calls report every few megabytes
+type progressWriter struct {
+	n        int64
+	reported int64
+	report   func(n int64)
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	if w.n-w.reported >= 4*1024*1024 {
+		w.reported = w.n
+		w.report(w.n)
+	}
+	return len(p), nil
+}
+
+func hashFile(file string) ([]byte, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+func fileSizeString(size int64) string {
+	if size >= 1024*1024*1024 {
+		return fmt.Sprintf("%.1fG", float64(size)/(1024*1024*1024))
+	} else if size >= 1024*1024 {
+		return fmt.Sprintf("%vM", size/(1024*1024))
+	} else if size >= 1024 {
+		return fmt.Sprintf("%vK", size/1024)
+	} else {
+		return fmt.Sprintf("%v", size)
+	}
+}
@@ -0,0 +1,215 @@
+package drive
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Mounts the given drives below a temporary media root, returns their mount points by id.
+func fakeMounts(t *testing.T, ids ...string) map[string]string {
+	t.Helper()
+	root := t.TempDir()
+	oldMounts, oldDisk, oldRoots := mountsFile, diskDir, mediaRoots
+	t.Cleanup(func() {
+		mountsFile, diskDir, mediaRoots = oldMounts, oldDisk, oldRoots
+	})
+	t.Setenv("XDG_STATE_HOME", filepath.Join(root, "state"))
+
+	mediaRoots = []string{filepath.Join(root, "media")}
+	diskDir = filepath.Join(root, "disk")
+	lines := []string{"/dev/sda1 / ext4 rw 0 0"}
+	paths := map[string]string{}
+	for i, id := range ids {
+		path := filepath.Join(root, "media", id)
+		if err := os.MkdirAll(path, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		paths[id] = path
+		lines = append(lines, "/dev/sdz"+string(rune('1'+i))+" "+strings.ReplaceAll(path, " ", `\040`)+" vfat rw 0 0")
+	}
+	mountsFile = filepath.Join(root, "mounts")
+	if err := os.WriteFile(mountsFile, []byte(strings.Join(lines, "\n")+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return paths
+}
+
+func TestEscape(t *testing.T) {
+	for _, test := range []struct {
+		s        string
+		expected string
+	}{
+		{"/media/user/BACKUP", "/media/user/BACKUP"},
+		{`/media/user/MY\040DRIVE`, "/media/user/MY DRIVE"},
+		{`/media/user/a\134b`, `/media/user/a\b`},
+		{`/media/user/a\04`, `/media/user/a\04`},
+		{`/media/user/a\999`, `/media/user/a\999`},
+	} {
+		if s := unescape(test.s); s != test.expected {
+			t.Errorf("unescape(%q) = %q, expected %q", test.s, s, test.expected)
+		}
+	}
+
+	for _, test := range []struct {
+		label    string
+		expected string
+	}{
+		{"BACKUP-A", "BACKUP-A"},
+		{"MY DRIVE", `MY\x20DRIVE`},
+		{`it's "mine"`, `it\x27s\x20\x22mine\x22`},
+	} {
+		if s := udevEscape(test.label); s != test.expected {
+			t.Errorf("udevEscape(%q) = %q, expected %q", test.label, s, test.expected)
+		}
+	}
+}
+
+func TestFindMount(t *testing.T) {
+	paths := fakeMounts(t, "BACKUP-A", "MY DRIVE", "sdz3")
+	mounts, err := readMounts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mounts) != 3 {
+		t.Fatalf("expected the 3 mounts below the media root, got %+v", mounts)
+	}
+
+	// udev links the label of the third drive to its device
+	device := filepath.Join(filepath.Dir(mountsFile), "sdz3")
+	if err := os.WriteFile(device, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	mounts[2].device = device
+	if err := os.MkdirAll(filepath.Join(diskDir, "by-label"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(device, filepath.Join(diskDir, "by-label", "OTHER")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		id       string
+		expected string
+	}{
+		{"BACKUP-A", paths["BACKUP-A"]},
+		{"MY DRIVE", paths["MY DRIVE"]},
+		{"OTHER", paths["sdz3"]},
+		{"BACKUP-B", ""},
+	} {
+		if path := findMount(test.id, mounts); path != test.expected {
+			t.Errorf("%s: got %q, expected %q", test.id, path, test.expected)
+		}
+	}
+}
+
+func TestPrune(t *testing.T) {
+	dir := t.TempDir()
+	names := []string{
+		"2024-01-01T10-00-00Z",
+		"2024-01-02T10-00-00Z",
+		"2024-01-03T10-00-00Z",
+		"2024-01-04T10-00-00Z",
+		"2024-01-05T10-00-00Z.part",
+		"photos",
+	}
+	for _, name := range names {
+		if err := os.Mkdir(filepath.Join(dir, name), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the current backup is kept even if it is older than the others
+	deleted, err := prune(&Config{Keep: 2}, dir, "2024-01-01T10-00-00Z")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(deleted)
+	expected := []string{"2024-01-02T10-00-00Z", "2024-01-03T10-00-00Z", "2024-01-05T10-00-00Z.part"}
+	if strings.Join(deleted, ",") != strings.Join(expected, ",") {
+		t.Errorf("deleted %v, expected %v", deleted, expected)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var left []string
+	for _, e := range entries {
+		left = append(left, e.Name())
+	}
+	expected = []string{"2024-01-01T10-00-00Z", "2024-01-04T10-00-00Z", "photos"}
+	if strings.Join(left, ",") != strings.Join(expected, ",") {
+		t.Errorf("left %v, expected %v", left, expected)
+	}
+}
+
+func TestStatusOrder(t *testing.T) {
+	fakeMounts(t, "A", "B", "C")
+	c := &Config{Drives: []string{"A", "B", "C", "D"}}
+	now := time.Now()
+	records := map[string][]Backup{
+		// an old archive that was copied just now is still old
+		"A": {{Name: "2024-01-01T10-00-00Z", Time: now}},
+		"B": {{Name: "2024-03-01T10-00-00Z", Time: now.Add(-time.Hour)}, {Name: "2024-02-01T10-00-00Z", Time: now.Add(-2 * time.Hour)}},
+		"C": {{Name: "2024-02-01T10-00-00Z", Time: now.Add(-3 * time.Hour)}},
+	}
+	mounts, err := c.Mounted()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range mounts {
+		if err := writeRecord(filepath.Join(m.Path, c.dir(), recordFile), Record{ID: m.ID, Backups: records[m.ID]}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	status, err := c.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, s := range status {
+		ids = append(ids, s.ID)
+	}
+	// D has no backups at all
+	if strings.Join(ids, ",") != "D,A,C,B" {
+		t.Errorf("got %v, expected D, A, C, B", ids)
+	}
+	if newest := status[3].Record.Newest(); newest == nil || newest.Name != "2024-03-01T10-00-00Z" {
+		t.Errorf("newest backup of B is %+v", newest)
+	}
+}
+
+func TestCopyFailingDrive(t *testing.T) {
+	paths := fakeMounts(t, "A", "B", "C")
+	// the directory cannot be created on B
+	if err := os.WriteFile(filepath.Join(paths["B"], DefaultDir), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	archive := filepath.Join(t.TempDir(), "backup.zip")
+	if err := os.WriteFile(archive, []byte("archive"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := Copy(&Config{Drives: []string{"A", "B", "C"}}, []string{archive}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("expected a result for every drive, got %+v", results)
+	}
+	for _, r := range results {
+		if failed := r.Drive.ID == "B"; failed != (r.Err != nil) {
+			t.Errorf("%s: unexpected error %v", r.Drive.ID, r.Err)
+			continue
+		}
+		if r.Err == nil {
+			if _, err := os.Stat(filepath.Join(r.Path, "backup.zip")); err != nil {
+				t.Errorf("%s: %v", r.Drive.ID, err)
+			}
+		}
+	}
+}
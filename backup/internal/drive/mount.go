@@ -0,0 +1,125 @@
+package drive
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var (
+	mountsFile = "/proc/self/mounts"
+	// links of udev from labels and UUIDs to devices
+	diskDir = "/dev/disk"
+	// where desktops and udisks mount removable drives
+	mediaRoots = []string{"/media", "/run/media"}
+)
+
+// A Mount is a configured drive that is mounted.
+type Mount struct {
+	// label or UUID from the config
+	ID   string
+	Path string
+}
+
+// Mounted returns the configured drives that are mounted, in the order of the config.
+func (c *Config) Mounted() ([]Mount, error) {
+	mounts, err := readMounts()
+	if err != nil {
+		return nil, err
+	}
+	var result []Mount
+	for _, id := range c.Drives {
+		if path := findMount(id, mounts); path != "" {
+			result = append(result, Mount{ID: id, Path: path})
+		}
+	}
+	return result, nil
+}
+
+type mount struct {
+	device string
+	path   string
+}
+
+// Returns the mounts under the media roots.
+func readMounts() ([]mount, error) {
+	f, err := os.Open(mountsFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var mounts []mount
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		m := mount{device: unescape(fields[0]), path: unescape(fields[1])}
+		for _, root := range mediaRoots {
+			if strings.HasPrefix(m.path, root+"/") {
+				mounts = append(mounts, m)
+				break
+			}
+		}
+	}
+	return mounts, scanner.Err()
+}
+
+// Finds the mount point of a drive by the device udev links to the label or UUID.
+// Without udev links the name of the mount point is used, udisks names it after the label or the UUID.
+func findMount(id string, mounts []mount) string {
+	for _, link := range []string{
+		filepath.Join(diskDir, "by-label", udevEscape(id)),
+		filepath.Join(diskDir, "by-uuid", id),
+	} {
+		device, err := filepath.EvalSymlinks(link)
+		if err != nil {
+			continue
+		}
+		for _, m := range mounts {
+			if d, err := filepath.EvalSymlinks(m.device); err == nil && d == device {
+				return m.path
+			}
+		}
+	}
+	for _, m := range mounts {
+		if filepath.Base(m.path) == id {
+			return m.path
+		}
+	}
+	return ""
+}
+
+// spaces and other special characters in mounts are octal escapes, e.g. \040
+func unescape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if n, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// udev escapes labels like "MY DRIVE" as MY\x20DRIVE
+func udevEscape(label string) string {
+	var b strings.Builder
+	for _, c := range []byte(label) {
+		if c == ' ' || c == '\\' || c == '"' || c == '\'' || c < 0x20 {
+			fmt.Fprintf(&b, `\x%02x`, c)
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
@@ -0,0 +1,172 @@
+package drive
+
+import (
+	"backup/internal/fs"
+	"encoding/json"
+	"errors"
+	"fmt"
+	iofs "io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// name of the record in the directory on the drive
+const recordFile = "backups.json"
+
+// Record lists the backups on a drive.
+type Record struct {
+	ID string `json:"id"`
+	// sorted by name
+	Backups []Backup `json:"backups"`
+}
+
+type Backup struct {
+	// folder in the directory on the drive
+	Name string `json:"name"`
+	// when the backup was copied to the drive
+	Time time.Time `json:"time"`
+	// the backup directory was copied instead of the archive
+	Snapshot bool  `json:"snapshot,omitempty"`
+	Files    int   `json:"files"`
+	Size     int64 `json:"size"`
+}
+
+// Newest returns the most recent backup by the time of the archive, nil if there is none. This is not always the one
+// that was copied last, e.g. an old archive can be copied again.
+func (r Record) Newest() *Backup {
+	var newest *Backup
+	for i, b := range r.Backups {
+		if newest == nil || b.Name > newest.Name {
+			newest = &r.Backups[i]
+		}
+	}
+	return newest
+}
+
+// Created returns the time of the archive from the name of the folder, or when the backup was copied if the name is not
+// a time.
+func (b Backup) Created() time.Time {
+	t, err := time.Parse(folderFormat, b.Name)
+	if err != nil {
+		return b.Time
+	}
+	return t
+}
+
+func readRecord(file string) (Record, error) {
+	var r Record
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return r, err
+	}
+	err = json.Unmarshal(data, &r)
+	return r, err
+}
+
+func writeRecord(file string, r Record) error {
+	sort.Slice(r.Backups, func(i, j int) bool {
+		return r.Backups[i].Name < r.Backups[j].Name
+	})
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := fs.CreateDir(filepath.Dir(file)); err != nil {
+		return err
+	}
+	tmp := file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+// the local copy of the record of a drive
+func localRecordFile(id string) (string, error) {
+	dir, err := fs.StateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "drives", id+".json"), nil
+}
+
+// Status is what is known about a configured drive.
+type Status struct {
+	ID string
+	// mount point, empty if the drive is not mounted
+	Path   string
+	Record Record
+}
+
+// Status returns the state of the configured drives, the most out of date drive first.
+// The records of mounted drives are read from the drives, the ones of the others from their local copies.
+func (c *Config) Status() ([]Status, error) {
+	mounts, err := c.Mounted()
+	if err != nil {
+		return nil, err
+	}
+	paths := map[string]string{}
+	for _, m := range mounts {
+		paths[m.ID] = m.Path
+	}
+
+	var result []Status
+	for _, id := range c.Drives {
+		s := Status{ID: id, Path: paths[id], Record: Record{ID: id}}
+		var file string
+		if s.Path != "" {
+			file = filepath.Join(s.Path, c.dir(), recordFile)
+		} else if file, err = localRecordFile(id); err != nil {
+			return nil, err
+		}
+		r, err := readRecord(file)
+		if err == nil {
+			s.Record = r
+		} else if !errors.Is(err, iofs.ErrNotExist) {
+			return nil, err
+		}
+		result = append(result, s)
+	}
+
+	// drives that never got a backup are the most out of date
+	sort.SliceStable(result, func(i, j int) bool {
+		a, b := result[i].Record.Newest(), result[j].Record.Newest()
+		if a == nil || b == nil {
+			return a == nil && b != nil
+		}
+		return a.Name < b.Name
+	})
+	return result, nil
+}
+
+// Summary describes the drive, e.g. "mounted, 2 backups, newest 2024-01-01 10:00 (3 days ago)".
+func (s Status) Summary() string {
+	var parts []string
+	if s.Path != "" {
+		parts = append(parts, "mounted")
+	}
+	newest := s.Record.Newest()
+	if newest == nil {
+		return strings.Join(append(parts, "no backups"), ", ")
+	}
+	parts = append(parts, fmt.Sprintf("%v backups", len(s.Record.Backups)))
+	created := newest.Created()
+	parts = append(parts, fmt.Sprintf("newest %s (%s)", created.Local().Format("2006-01-02 15:04"), Age(created)))
+	return strings.Join(parts, ", ")
+}
+
+// Age returns how long ago t was in days, e.g. "today" or "3 days ago".
+func Age(t time.Time) string {
+	days := int(time.Since(t).Hours() / 24)
+	switch days {
+	case 0:
+		return "today"
+	case 1:
+		return "1 day ago"
+	default:
+		return fmt.Sprintf("%v days ago", days)
+	}
+}
@@ -0,0 +1,186 @@
+package filebrowser
+
+import (
+	"fmt"
+	"io"
+	iofs "io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/list"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// An entry is a file or directory in the current directory.
+type entry struct {
+	name  string
+	path  string
+	isDir bool
+	// symlinks are not followed, they are backed up as links
+	isLink bool
+	size   int64
+}
+
+func (e entry) FilterValue() string {
+	return e.name
+}
+
+func (e entry) hidden() bool {
+	return strings.HasPrefix(e.name, ".")
+}
+
+// Reads the entries of a directory, directories first and both sorted by name.
+func readDir(dir string) ([]entry, error) {
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]entry, 0, len(dirEntries))
+	for _, d := range dirEntries {
+		e := entry{
+			name:   d.Name(),
+			path:   filepath.Join(dir, d.Name()),
+			isDir:  d.IsDir(),
+			isLink: d.Type()&iofs.ModeSymlink != 0,
+		}
+		if !e.isDir {
+			if info, err := d.Info(); err == nil {
+				e.size = info.Size()
+			}
+		}
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].isDir != entries[j].isDir {
+			return entries[i].isDir
+		}
+		return strings.ToLower(entries[i].name) < strings.ToLower(entries[j].name)
+	})
+	return entries, nil
+}
+
+// Returns the total size of all files in a directory, unreadable files and directories are skipped.
+func dirSize(dir string) int64 {
+	var size int64
+	filepath.WalkDir(dir, func(p string, d iofs.DirEntry, err error) error {
+		if err != nil {
+			if d != nil && d.IsDir() && p != dir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.Type().IsRegular() {
+			if info, err := d.Info(); err == nil {
+				size += info.Size()
+			}
+		}
+		return nil
+	})
+	return size
+}
+
+type entryItemDelegate struct {
+	itemStyle         lipgloss.Style
+	selectedItemStyle lipgloss.Style
+	infoStyle         lipgloss.Style
+
+	// by absolute path
+	marked    map[string]string
+	showSizes bool
+	// sizes of directories, a directory without an entry is still being calculated
+	dirSizes map[string]int64
+}
+
+func newEntryItemDelegate(marked map[string]string) *entryItemDelegate {
+	return &entryItemDelegate{
+		itemStyle:         lipgloss.NewStyle().PaddingLeft(4),
+		selectedItemStyle: lipgloss.NewStyle().PaddingLeft(2).Foreground(lipgloss.Color("170")),
+		infoStyle:         lipgloss.NewStyle().Foreground(lipgloss.AdaptiveColor{Light: "#A49FA5", Dark: "#777777"}),
+		marked:            marked,
+		dirSizes:          map[string]int64{},
+	}
+}
+
+func (d entryItemDelegate) Height() int {
+	return 1
+}
+
+func (d entryItemDelegate) Spacing() int {
+	return 0
+}
+
+func (d entryItemDelegate) Update(msg tea.Msg, m *list.Model) tea.Cmd {
+	return nil
+}
+
+// Returns the checkbox of an entry:
+// [x] if it is marked, [+] if a parent directory is marked and [~] if something inside the directory is marked.
+func (d entryItemDelegate) checkbox(e entry) string {
+	if _, ok := d.marked[e.path]; ok {
+		return "[x]"
+	}
+	for p := range d.marked {
+		if strings.HasPrefix(e.path, p+string(filepath.Separator)) {
+			return "[+]"
+		}
+	}
+	if e.isDir {
+		for p := range d.marked {
+			if strings.HasPrefix(p, e.path+string(filepath.Separator)) {
+				return "[~]"
+			}
+		}
+	}
+	return "[ ]"
+}
+
+func (d entryItemDelegate) Render(w io.Writer, m list.Model, index int, listItem list.Item) {
+	e, ok := listItem.(entry)
+	if !ok {
+		return
+	}
+
+	name := e.name
+	if e.isDir {
+		name += "/"
+	} else if e.isLink {
+		name += "@"
+	}
+	s := fmt.Sprintf("%s %s", d.checkbox(e), name)
+
+	if index == m.Index() {
+		s = d.selectedItemStyle.Render("> " + s)
+	} else {
+		s = d.itemStyle.Render(s)
+	}
+
+	if d.showSizes {
+		var size string
+		if !e.isDir {
+			size = fileSizeString(e.size)
+		} else if dirSize, ok := d.dirSizes[e.path]; ok {
+			size = fileSizeString(dirSize)
+		} else {
+			size = "..."
+		}
+		s = fmt.Sprintf("%s  %s", s, d.infoStyle.Render(size))
+	}
+
+	fmt.Fprint(w, s)
+}
+
+func fileSizeString(size int64) string {
+	if size >= 1024*1024*1024 {
+		return fmt.Sprintf("%.1fG", float64(size)/(1024*1024*1024))
+	} else if size >= 1024*1024 {
+		return fmt.Sprintf("%vM", size/(1024*1024))
+	} else if size >= 1024 {
+		return fmt.Sprintf("%vK", size/1024)
+	} else {
+		return fmt.Sprintf("%v", size)
+	}
+}
@@ -0,0 +1,455 @@
+package filebrowser
+
+import (
+	"backup/internal/config"
+	"backup/internal/fs"
+	"backup/internal/style"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/charmbracelet/bubbles/help"
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/list"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+type state int
+
+const (
+	stateBrowse state = iota
+	stateConfirmBack
+)
+
+type Model struct {
+	state state
+
+	configFile string
+	// paths from the config as they are written there
+	files []string
+
+	dir       string
+	entries   []entry
+	loadError error
+
+	showHidden bool
+	showSizes  bool
+
+	// marked paths by absolute path, the value is the path as it is stored in the config
+	marked    map[string]string
+	changed   bool
+	saved     bool
+	saveError error
+
+	list         list.Model
+	listDelegate *entryItemDelegate
+	helpView     help.Model
+	keyMap       keyMap
+
+	styles style.Styles
+
+	width, height int
+}
+
+func NewModel(configFile string, files []string, styles style.Styles) *Model {
+	helpView := help.New()
+	helpView.Styles = styles.HelpStyles
+
+	marked := map[string]string{}
+	for _, f := range files {
+		absPath, err := fs.AbsPath(f)
+		if err != nil {
+			continue
+		}
+		marked[absPath] = f
+	}
+
+	keyMap := defaultKeyMap()
+	listDelegate := newEntryItemDelegate(marked)
+	list := list.New(nil, listDelegate, 0, 0)
+	list.SetFilteringEnabled(false)
+	list.SetShowHelp(false)
+	list.DisableQuitKeybindings()
+	list.SetShowStatusBar(false)
+	list.SetShowPagination(true)
+	list.SetShowTitle(false)
+	list.KeyMap = keyMap.listKeyMap()
+
+	dir, err := os.UserHomeDir()
+	if err != nil {
+		dir = "/"
+	}
+
+	return &Model{
+		state:      stateBrowse,
+		configFile: configFile,
+		files:      files,
+		dir:        dir,
+		marked:     marked,
+
+		list:         list,
+		listDelegate: listDelegate,
+		helpView:     helpView,
+		keyMap:       keyMap,
+
+		styles: styles,
+	}
+}
+
+func (m *Model) Init() tea.Cmd {
+	return m.openDir(m.dir, "")
+}
+
+func (m *Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	var cmd tea.Cmd
+
+	// sizes are calculated in the background and might arrive in any state
+	if msg, ok := msg.(dirSizesResult); ok {
+		for p, size := range msg.sizes {
+			m.listDelegate.dirSizes[p] = size
+		}
+		return m, nil
+	}
+
+	switch m.state {
+
+	case stateBrowse:
+		switch msg := msg.(type) {
+		case tea.KeyMsg:
+			m.saved = false
+			switch {
+			case key.Matches(msg, m.keyMap.Open):
+				if e, ok := m.list.SelectedItem().(entry); ok && e.isDir {
+					cmd = m.openDir(e.path, "")
+				}
+			case key.Matches(msg, m.keyMap.Parent):
+				if parent := filepath.Dir(m.dir); parent != m.dir {
+					cmd = m.openDir(parent, m.dir)
+				}
+			case key.Matches(msg, m.keyMap.Home):
+				if home, err := os.UserHomeDir(); err == nil {
+					cmd = m.openDir(home, "")
+				}
+			case key.Matches(msg, m.keyMap.Mark):
+				if e, ok := m.list.SelectedItem().(entry); ok {
+					if _, marked := m.marked[e.path]; marked {
+						delete(m.marked, e.path)
+					} else {
+						m.marked[e.path] = fs.HomePath(e.path)
+					}
+					m.changed = true
+				}
+			case key.Matches(msg, m.keyMap.ToggleHidden):
+				m.showHidden = !m.showHidden
+				m.updateItems("")
+			case key.Matches(msg, m.keyMap.ToggleSizes):
+				m.showSizes = !m.showSizes
+				m.listDelegate.showSizes = m.showSizes
+				if m.showSizes {
+					cmd = m.dirSizesCmd()
+				}
+			case key.Matches(msg, m.keyMap.Save):
+				files := m.Files()
+				m.saveError = config.SaveFiles(m.configFile, files)
+				if m.saveError == nil {
+					m.files = files
+					m.changed = false
+					m.saved = true
+				}
+			case key.Matches(msg, m.keyMap.Back):
+				if m.changed {
+					m.state = stateConfirmBack
+				} else {
+					cmd = done(m.files)
+				}
+			default:
+				m.list, cmd = m.list.Update(msg)
+			}
+		default:
+			m.list, cmd = m.list.Update(msg)
+		}
+
+	case stateConfirmBack:
+		if msg, ok := msg.(tea.KeyMsg); ok {
+			switch {
+			case key.Matches(msg, m.keyMap.ConfirmBack):
+				cmd = done(m.files)
+			case key.Matches(msg, m.keyMap.CancelBack):
+				m.state = stateBrowse
+			}
+		}
+	}
+
+	return m, cmd
+}
+
+// Files returns the marked paths in the order they should be written to the config.
+// Paths that already were in the config keep their position and spelling, new ones are appended sorted.
+func (m *Model) Files() []string {
+	files := []string{}
+	seen := map[string]struct{}{}
+	for _, f := range m.files {
+		absPath, err := fs.AbsPath(f)
+		if err != nil {
+			continue
+		}
+		if _, ok := m.marked[absPath]; ok {
+			files = append(files, f)
+			seen[absPath] = struct{}{}
+		}
+	}
+
+	var added []string
+	for absPath := range m.marked {
+		if _, ok := seen[absPath]; !ok {
+			added = append(added, absPath)
+		}
+	}
+	sort.Strings(added)
+	for _, absPath := range added {
+		files = append(files, m.marked[absPath])
+	}
+	return files
+}
+
+// Changes to another directory, if selectPath is in that directory its entry gets selected.
+func (m *Model) openDir(dir string, selectPath string) tea.Cmd {
+	entries, err := readDir(dir)
+	if err != nil {
+		m.loadError = err
+		return nil
+	}
+	m.loadError = nil
+	m.dir = dir
+	m.entries = entries
+	m.updateItems(selectPath)
+	if m.showSizes {
+		return m.dirSizesCmd()
+	}
+	return nil
+}
+
+// Updates the list after the entries or the hidden files setting changed.
+// The current selection is kept if possible, otherwise selectPath is selected.
+func (m *Model) updateItems(selectPath string) {
+	if selectPath == "" {
+		if e, ok := m.list.SelectedItem().(entry); ok {
+			selectPath = e.path
+		}
+	}
+
+	var items []list.Item
+	selected := 0
+	for _, e := range m.entries {
+		if e.hidden() && !m.showHidden {
+			continue
+		}
+		if e.path == selectPath {
+			selected = len(items)
+		}
+		items = append(items, e)
+	}
+	m.list.SetItems(items)
+	m.list.Select(selected)
+}
+
+func (m *Model) dirSizesCmd() tea.Cmd {
+	var dirs []string
+	for _, e := range m.entries {
+		if _, ok := m.listDelegate.dirSizes[e.path]; e.isDir && !ok {
+			dirs = append(dirs, e.path)
+		}
+	}
+	if len(dirs) == 0 {
+		return nil
+	}
+
+	// one command per directory so that small directories show up right away
+	var cmds []tea.Cmd
+	for _, d := range dirs {
+		d := d
+		cmds = append(cmds, func() tea.Msg {
+			return dirSizesResult{sizes: map[string]int64{d: dirSize(d)}}
+		})
+	}
+	return tea.Batch(cmds...)
+}
+
+type dirSizesResult struct {
+	sizes map[string]int64
+}
+
+func (m *Model) SetSize(width, height int) {
+	m.width = width
+	m.height = height
+	m.helpView.Width = width
+	// 2 lines for the title, 3 lines for directory and status and 4 lines for help text
+	listHeight := height - 9
+	if listHeight < 2 {
+		listHeight = 2
+	}
+	m.list.SetSize(width, listHeight)
+}
+
+func (m *Model) View() string {
+	styles := m.styles
+
+	if m.state == stateConfirmBack {
+		return lipgloss.JoinVertical(
+			lipgloss.Left,
+			styles.TitleStyle.Render("Browse Files"),
+			"",
+			styles.NormalTextStyle.Render("There are unsaved changes. Do you really want to go back to the main menu?"),
+			"",
+			m.helpView.ShortHelpView(m.keyMap.confirmBackKeys()),
+		)
+	}
+
+	var status string
+	switch {
+	case m.loadError != nil:
+		status = styles.ErrorTextStyle.Render(fmt.Sprintf("error: %v", m.loadError))
+	case m.saveError != nil:
+		status = styles.ErrorTextStyle.Render(fmt.Sprintf("could not save config: %v", m.saveError))
+	case m.saved:
+		status = styles.NormalTextStyle.Render(fmt.Sprintf("Saved %v paths to %s", len(m.files), m.configFile))
+	case m.changed:
+		status = styles.NormalTextStyle.Render(fmt.Sprintf("%v paths marked, unsaved changes", len(m.marked)))
+	default:
+		status = styles.NormalTextStyle.Render(fmt.Sprintf("%v paths marked", len(m.marked)))
+	}
+
+	return lipgloss.JoinVertical(
+		lipgloss.Left,
+		styles.TitleStyle.Render("Browse Files"),
+		"",
+		styles.ListItemSelectedStyle.Render(fs.HomePath(m.dir)),
+		status,
+		"",
+		m.list.View(),
+		"",
+		m.helpView.FullHelpView(m.keyMap.browseKeys()),
+	)
+}
+
+// Done is sent when returning to the main menu, Files contains the paths that are saved in the config.
+type Done struct {
+	Files []string
+}
+
+func done(files []string) tea.Cmd {
+	return func() tea.Msg {
+		return Done{Files: files}
+	}
+}
+
+type keyMap struct {
+	CursorUp     key.Binding
+	CursorDown   key.Binding
+	PrevPage     key.Binding
+	NextPage     key.Binding
+	Open         key.Binding
+	Parent       key.Binding
+	Home         key.Binding
+	Mark         key.Binding
+	ToggleHidden key.Binding
+	ToggleSizes  key.Binding
+	Save         key.Binding
+	Back         key.Binding
+
+	ConfirmBack key.Binding
+	CancelBack  key.Binding
+}
+
+func defaultKeyMap() keyMap {
+	return keyMap{
+		CursorUp: key.NewBinding(
+			key.WithKeys("k", "up"),
+			key.WithHelp("k", "up"),
+		),
+		CursorDown: key.NewBinding(
+			key.WithKeys("j", "down"),
+			key.WithHelp("j", "down"),
+		),
+		PrevPage: key.NewBinding(
+			key.WithKeys("pgup"),
+			key.WithHelp("pgup", "prev page"),
+		),
+		NextPage: key.NewBinding(
+			key.WithKeys("pgdown"),
+			key.WithHelp("pgdn", "next page"),
+		),
+		Open: key.NewBinding(
+			key.WithKeys("l", "right", "enter"),
+			key.WithHelp("l", "open dir"),
+		),
+		Parent: key.NewBinding(
+			key.WithKeys("h", "left", "backspace"),
+			key.WithHelp("h", "parent dir"),
+		),
+		Home: key.NewBinding(
+			key.WithKeys("~"),
+			key.WithHelp("~", "home"),
+		),
+		Mark: key.NewBinding(
+			key.WithKeys(" "),
+			key.WithHelp("space", "(un)mark"),
+		),
+		ToggleHidden: key.NewBinding(
+			key.WithKeys("."),
+			key.WithHelp(".", "hidden files"),
+		),
+		ToggleSizes: key.NewBinding(
+			key.WithKeys("s"),
+			key.WithHelp("s", "sizes"),
+		),
+		Save: key.NewBinding(
+			key.WithKeys("w"),
+			key.WithHelp("w", "save"),
+		),
+		Back: key.NewBinding(
+			key.WithKeys("esc"),
+			key.WithHelp("esc", "back"),
+		),
+		ConfirmBack: key.NewBinding(
+			key.WithKeys("y"),
+			key.WithHelp("y", "yes"),
+		),
+		CancelBack: key.NewBinding(
+			key.WithKeys("n"),
+			key.WithHelp("n", "no"),
+		),
+	}
+}
+
+func (m keyMap) listKeyMap() list.KeyMap {
+	return list.KeyMap{
+		CursorUp:             m.CursorUp,
+		CursorDown:           m.CursorDown,
+		PrevPage:             m.PrevPage,
+		NextPage:             m.NextPage,
+		GoToStart:            key.NewBinding(key.WithDisabled()),
+		GoToEnd:              key.NewBinding(key.WithDisabled()),
+		Filter:               key.NewBinding(key.WithDisabled()),
+		ClearFilter:          key.NewBinding(key.WithDisabled()),
+		CancelWhileFiltering: key.NewBinding(key.WithDisabled()),
+		AcceptWhileFiltering: key.NewBinding(key.WithDisabled()),
+		ShowFullHelp:         key.NewBinding(key.WithDisabled()),
+		CloseFullHelp:        key.NewBinding(key.WithDisabled()),
+	}
+}
+
+func (m keyMap) browseKeys() [][]key.Binding {
+	return [][]key.Binding{
+		{m.CursorUp, m.CursorDown, m.PrevPage, m.NextPage},
+		{m.Open, m.Parent, m.Home},
+		{m.Mark, m.ToggleHidden, m.ToggleSizes},
+		{m.Save, m.Back},
+	}
+}
+
+func (m keyMap) confirmBackKeys() []key.Binding {
+	return []key.Binding{m.CancelBack, m.ConfirmBack}
+}
@@ -0,0 +1,275 @@
+package files
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	iofs "io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const DefaultWorkers = 4
+
+// how often progress is reported
+const progressInterval = 200 * time.Millisecond
+
+// A Job copies a file or directory to a target location.
+type Job struct {
+	Source string
+	Target string
+}
+
+type Options struct {
+	// number of files that are copied concurrently, DefaultWorkers if 0
+	Workers int
+	Exclude []string
+	// called regularly while copying and once more when done, never concurrently
+	OnProgress func(Progress)
+}
+
+type Progress struct {
+	FilesDone  int
+	FilesTotal int
+	BytesDone  int64
+	BytesTotal int64
+	// bytes per second since the start
+	Throughput float64
+	// estimated time remaining, 0 if unknown
+	ETA     time.Duration
+	Elapsed time.Duration
+	// a file that is currently being copied
+	Current string
+	Done    bool
+}
+
+type Result struct {
+	Job Job
+	// first error that occurred, nil if every file was copied
+	Err error
+	// number of files that could not be copied
+	Failed int
+	// number of files of the job including the ones that failed, and the size of its regular files
+	Files int
+	Bytes int64
+}
+
+type file struct {
+	job  int
+	path string
+	dest string
+	info iofs.FileInfo
+}
+
+type dir struct {
+	job  int
+	path string
+	info iofs.FileInfo
+}
+
+// CopyAll copies the given jobs using multiple workers, skipping everything that matches one of the exclude patterns.
+// A job does not stop at the first error, as many files as possible are copied. Paths that cannot be read or created,
+// e.g. an unreadable directory, count as failed.
+// Modes and modification times are preserved, symlinks are copied as symlinks.
+func CopyAll(jobs []Job, opts Options) []Result {
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = DefaultWorkers
+	}
+
+	results := make([]Result, len(jobs))
+	var mu sync.Mutex
+	fail := func(job int, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if results[job].Err == nil {
+			results[job].Err = err
+		}
+		results[job].Failed++
+	}
+
+	// collect everything first so that we know the total size, directories are created right away
+	var files []file
+	var dirs []dir
+	var bytesTotal int64
+	for i, job := range jobs {
+		results[i].Job = job
+		// errors are recorded per path, everything else of the job is still copied
+		walk(job.Source, opts.Exclude, func(p string, info iofs.FileInfo) error {
+			rel, err := filepath.Rel(job.Source, p)
+			if err != nil {
+				fail(i, fmt.Errorf("%s: %w", p, err))
+				return nil
+			}
+			dest := filepath.Join(job.Target, rel)
+			if info.IsDir() {
+				if err := os.MkdirAll(dest, 0775); err != nil {
+					fail(i, fmt.Errorf("%s: %w", p, err))
+					return filepath.SkipDir
+				}
+				dirs = append(dirs, dir{job: i, path: dest, info: info})
+				return nil
+			}
+			if !info.Mode().IsRegular() && info.Mode()&iofs.ModeSymlink == 0 {
+				// sockets, devices, named pipes etc.
+				return nil
+			}
+			files = append(files, file{job: i, path: p, dest: dest, info: info})
+			results[i].Files++
+			if info.Mode().IsRegular() {
+				bytesTotal += info.Size()
+				results[i].Bytes += info.Size()
+			}
+			return nil
+		}, nil, func(p string, err error) error {
+			fail(i, fmt.Errorf("%s: %w", p, err))
+			return nil
+		})
+	}
+
+	var filesDone atomic.Int64
+	var bytesDone atomic.Int64
+	var current atomic.Value
+	current.Store("")
+
+	start := time.Now()
+	progress := func(done bool) Progress {
+		p := Progress{
+			FilesDone:  int(filesDone.Load()),
+			FilesTotal: len(files),
+			BytesDone:  bytesDone.Load(),
+			BytesTotal: bytesTotal,
+			Elapsed:    time.Since(start),
+			Current:    current.Load().(string),
+			Done:       done,
+		}
+		if seconds := p.Elapsed.Seconds(); seconds > 0 {
+			p.Throughput = float64(p.BytesDone) / seconds
+		}
+		if p.Throughput > 0 {
+			p.ETA = time.Duration(float64(p.BytesTotal-p.BytesDone) / p.Throughput * float64(time.Second))
+		}
+		return p
+	}
+
+	stopProgress := make(chan struct{})
+	progressStopped := make(chan struct{})
+	go func() {
+		defer close(progressStopped)
+		if opts.OnProgress == nil {
+			return
+		}
+		ticker := time.NewTicker(progressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				opts.OnProgress(progress(false))
+			case <-stopProgress:
+				return
+			}
+		}
+	}()
+
+	queue := make(chan file)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for f := range queue {
+				current.Store(f.path)
+				if err := copyEntry(f, &bytesDone); err != nil {
+					fail(f.job, fmt.Errorf("%s: %w", f.path, err))
+				}
+				filesDone.Add(1)
+			}
+		}()
+	}
+	for _, f := range files {
+		queue <- f
+	}
+	close(queue)
+	wg.Wait()
+
+	// modes and modification times of directories have to be set after their contents were copied
+	for i := len(dirs) - 1; i >= 0; i-- {
+		d := dirs[i]
+		if err := os.Chmod(d.path, d.info.Mode().Perm()); err != nil {
+			fail(d.job, err)
+		} else if err := os.Chtimes(d.path, d.info.ModTime(), d.info.ModTime()); err != nil {
+			fail(d.job, err)
+		}
+	}
+
+	close(stopProgress)
+	<-progressStopped
+	if opts.OnProgress != nil {
+		opts.OnProgress(progress(true))
+	}
+
+	return results
+}
+
+func copyEntry(f file, bytesDone *atomic.Int64) error {
+	if f.info.Mode()&iofs.ModeSymlink != 0 {
+		link, err := os.Readlink(f.path)
+		if err != nil {
+			return err
+		}
+		if err := os.Remove(f.dest); err != nil && !errors.Is(err, iofs.ErrNotExist) {
+			return err
+		}
+		return os.Symlink(link, f.dest)
+	}
+	return copyFile(f.path, f.dest, f.info, bytesDone)
+}
+
+// CopyFile copies a regular file and preserves its mode and modification time.
+func CopyFile(source, target string, info iofs.FileInfo) error {
+	return copyFile(source, target, info, nil)
+}
+
+func copyFile(source, target string, info iofs.FileInfo, bytesDone *atomic.Int64) error {
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	var w io.Writer = out
+	if bytesDone != nil {
+		w = &countingWriter{w: out, n: bytesDone}
+	}
+	_, err = io.Copy(w, in)
+	if err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Chmod(target, info.Mode().Perm()); err != nil {
+		return err
+	}
+	return os.Chtimes(target, info.ModTime(), info.ModTime())
+}
+
+type countingWriter struct {
+	w io.Writer
+	n *atomic.Int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n.Add(int64(n))
+	return n, err
+}
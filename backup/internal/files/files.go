@@ -0,0 +1,143 @@
+package files
+
+import (
+	"backup/internal/fs"
+	"errors"
+	"fmt"
+	iofs "io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// A Source is one of the paths from the files section of the config.
+type Source struct {
+	// as given in the config
+	Path    string
+	AbsPath string
+	Exists  bool
+	IsDir   bool
+	// total size of all files that will be copied
+	Size int64
+	// number of files that will be copied
+	Files int
+	// number of files and directories that are skipped because they match an exclude pattern
+	Excluded int
+	Err      error
+}
+
+// Expand resolves the given paths and determines how many files and bytes will be copied with excludes applied.
+// Errors are recorded in the returned sources, e.g. if a path does not exist.
+func Expand(paths []string, exclude []string) []Source {
+	sources := make([]Source, len(paths))
+	for i, p := range paths {
+		sources[i] = expand(p, exclude)
+	}
+	return sources
+}
+
+func expand(path string, exclude []string) Source {
+	source := Source{Path: path}
+
+	absPath, err := ValidatePath(path)
+	if err != nil {
+		source.Err = err
+		return source
+	}
+	source.AbsPath = absPath
+
+	info, err := os.Lstat(absPath)
+	if err != nil {
+		if errors.Is(err, iofs.ErrNotExist) {
+			source.Err = errors.New("file or directory does not exist")
+		} else {
+			source.Err = err
+		}
+		return source
+	}
+	source.Exists = true
+	source.IsDir = info.IsDir()
+
+	source.Err = Walk(absPath, exclude, func(p string, info iofs.FileInfo) error {
+		if !info.IsDir() {
+			source.Files++
+			if info.Mode().IsRegular() {
+				source.Size += info.Size()
+			}
+		}
+		return nil
+	}, func(p string) {
+		source.Excluded++
+	})
+	return source
+}
+
+// ValidatePath returns the absolute path of a path from the config.
+func ValidatePath(path string) (string, error) {
+	absPath, err := fs.AbsPath(path)
+	if err != nil {
+		return "", fmt.Errorf("invalid path: %w", err)
+	}
+	if absPath == "/" {
+		return "", errors.New("copying / is a bad idea")
+	}
+	return absPath, nil
+}
+
+// Target returns the location of a file in the backup directory.
+// The full original path is retained e.g. /home/user/abc/def will be copied to backupDir/files/home/user/abc/def.
+func Target(backupDir string, absPath string) string {
+	return fs.JoinPath(fs.JoinPath(backupDir, "files"), absPath)
+}
+
+// Excluded returns true if the given path matches one of the exclude patterns.
+// Patterns containing a slash are matched against the full path, all others against the name of the file or directory,
+// e.g. "node_modules" or "*.tmp" match anywhere while "~/.cache/*" only matches the contents of a specific directory.
+func Excluded(path string, exclude []string) bool {
+	name := filepath.Base(path)
+	for _, pattern := range exclude {
+		var matched bool
+		if strings.Contains(pattern, "/") {
+			absPattern, err := fs.AbsPath(pattern)
+			if err != nil {
+				continue
+			}
+			matched, _ = filepath.Match(absPattern, path)
+		} else {
+			matched, _ = filepath.Match(pattern, name)
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// Walk calls fn for the given path and every file and directory below it that is not excluded.
+// Excluded directories are not descended into, onExcluded is called for every excluded path.
+// Symlinks are not followed.
+func Walk(path string, exclude []string, fn func(path string, info iofs.FileInfo) error, onExcluded func(path string)) error {
+	return walk(path, exclude, fn, onExcluded, func(p string, err error) error {
+		return err
+	})
+}
+
+// Same as Walk, but errors reading a path are passed to onError, returning nil continues with the next path.
+func walk(path string, exclude []string, fn func(path string, info iofs.FileInfo) error, onExcluded func(path string), onError func(path string, err error) error) error {
+	return filepath.Walk(path, func(p string, info iofs.FileInfo, err error) error {
+		if err != nil {
+			return onError(p, err)
+		}
+		// the path itself was explicitly configured and is never excluded
+		if p != path && Excluded(p, exclude) {
+			if onExcluded != nil {
+				onExcluded(p)
+			}
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		return fn(p, info)
+	})
+}
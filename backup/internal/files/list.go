@@ -0,0 +1,230 @@
+package files
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/list"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+func (s Source) FilterValue() string {
+	return s.Path
+}
+
+// Lists the paths from the config. Paths can be selected, after copying the result of every path is shown instead.
+type pathList struct {
+	sources []Source
+
+	list         list.Model
+	listDelegate *pathItemDelegate
+
+	keyMap keyMap
+}
+
+func newPathList(sources []Source, keyMap keyMap) *pathList {
+	items := make([]list.Item, len(sources))
+	for i, s := range sources {
+		items[i] = s
+	}
+	// initially select everything that can be copied
+	listDelegate := newPathItemDelegate()
+	for _, s := range sources {
+		if s.Exists {
+			listDelegate.selected[s.Path] = struct{}{}
+		}
+	}
+	list := list.New(items, listDelegate, 0, 0)
+	list.SetFilteringEnabled(false)
+	list.SetShowHelp(false)
+	list.DisableQuitKeybindings()
+	list.SetShowStatusBar(false)
+	list.SetShowPagination(true)
+	list.SetShowTitle(false)
+	list.KeyMap = keyMap.listKeyMap()
+
+	return &pathList{
+		sources:      sources,
+		list:         list,
+		listDelegate: listDelegate,
+		keyMap:       keyMap,
+	}
+}
+
+func (l *pathList) Update(msg tea.Msg) tea.Cmd {
+	var cmd tea.Cmd
+
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		switch {
+		case key.Matches(msg, l.keyMap.Select):
+			source, ok := l.list.SelectedItem().(Source)
+			if ok && source.Exists {
+				_, selected := l.listDelegate.selected[source.Path]
+				if selected {
+					delete(l.listDelegate.selected, source.Path)
+				} else {
+					l.listDelegate.selected[source.Path] = struct{}{}
+				}
+			}
+		case key.Matches(msg, l.keyMap.SelectAll):
+			if len(l.listDelegate.selected) > 0 {
+				// unselect all
+				l.listDelegate.selected = map[string]struct{}{}
+			} else {
+				// select all
+				for _, s := range l.sources {
+					if s.Exists {
+						l.listDelegate.selected[s.Path] = struct{}{}
+					}
+				}
+			}
+		default:
+			l.list, cmd = l.list.Update(msg)
+		}
+	default:
+		l.list, cmd = l.list.Update(msg)
+	}
+	return cmd
+}
+
+// ShowResults switches the list from selecting paths to showing the copy results.
+// Only selected paths are shown, a path without an entry in results is still being copied.
+func (l *pathList) ShowResults(results map[string]error) {
+	var items []list.Item
+	for _, s := range l.Selected() {
+		items = append(items, s)
+	}
+	l.list.SetItems(items)
+	l.list.ResetSelected()
+	l.listDelegate.results = results
+}
+
+func (l *pathList) SetSize(width, height int) {
+	l.list.SetSize(width, height)
+}
+
+func (l *pathList) View() string {
+	return l.list.View()
+}
+
+func (l *pathList) Selected() []Source {
+	var selected []Source
+	for _, s := range l.sources {
+		if _, ok := l.listDelegate.selected[s.Path]; ok {
+			selected = append(selected, s)
+		}
+	}
+	return selected
+}
+
+func (l *pathList) Current() (Source, bool) {
+	s, ok := l.list.SelectedItem().(Source)
+	return s, ok
+}
+
+type pathItemDelegate struct {
+	itemStyle         lipgloss.Style
+	selectedItemStyle lipgloss.Style
+	infoStyle         lipgloss.Style
+
+	selected map[string]struct{}
+	// nil while selecting
+	results map[string]error
+}
+
+func newPathItemDelegate() *pathItemDelegate {
+	return &pathItemDelegate{
+		itemStyle:         lipgloss.NewStyle().PaddingLeft(4),
+		selectedItemStyle: lipgloss.NewStyle().PaddingLeft(2).Foreground(lipgloss.Color("170")),
+		infoStyle:         lipgloss.NewStyle().Foreground(lipgloss.AdaptiveColor{Light: "#A49FA5", Dark: "#777777"}),
+		selected:          map[string]struct{}{},
+	}
+}
+
+func (d pathItemDelegate) Height() int {
+	return 1
+}
+
+func (d pathItemDelegate) Spacing() int {
+	return 0
+}
+
+func (d pathItemDelegate) Update(msg tea.Msg, m *list.Model) tea.Cmd {
+	return nil
+}
+
+var checkmark = lipgloss.NewStyle().Foreground(lipgloss.Color("#7ef542")).Render("✓")
+var cross = lipgloss.NewStyle().Foreground(lipgloss.Color("#de0d18")).Render("x")
+
+func (d pathItemDelegate) Render(w io.Writer, m list.Model, index int, listItem list.Item) {
+	source, ok := listItem.(Source)
+	if !ok {
+		return
+	}
+
+	var s string
+	if d.results != nil {
+		err, ok := d.results[source.Path]
+		if !ok {
+			s = fmt.Sprintf("%s  ?", source.Path)
+		} else if err == nil {
+			s = fmt.Sprintf("%s  %s", source.Path, checkmark)
+		} else {
+			s = fmt.Sprintf("%s  %s", source.Path, cross)
+		}
+	} else {
+		_, selected := d.selected[source.Path]
+		if !source.Exists {
+			s = fmt.Sprintf("[-] %s", source.Path)
+		} else if selected {
+			s = fmt.Sprintf("[x] %s", source.Path)
+		} else {
+			s = fmt.Sprintf("[ ] %s", source.Path)
+		}
+	}
+
+	if index == m.Index() {
+		s = d.selectedItemStyle.Render("> " + s)
+	} else {
+		s = d.itemStyle.Render(s)
+	}
+	if d.results == nil {
+		s = fmt.Sprintf("%s  %s", s, d.infoStyle.Render(sourceInfo(source)))
+	}
+
+	fmt.Fprint(w, s)
+}
+
+func sourceInfo(s Source) string {
+	if !s.Exists {
+		return s.Err.Error()
+	}
+	var info string
+	if s.IsDir {
+		info = fmt.Sprintf("dir, %v files, %s", s.Files, fileSizeString(s.Size))
+	} else {
+		info = fmt.Sprintf("file, %s", fileSizeString(s.Size))
+	}
+	if s.Excluded > 0 {
+		info = fmt.Sprintf("%s, %v excluded", info, s.Excluded)
+	}
+	if s.Err != nil {
+		info = fmt.Sprintf("%s, error: %v", info, s.Err)
+	}
+	return info
+}
+
+func fileSizeString(size int64) string {
+	if size >= 1024*1024*1024 {
+		return fmt.Sprintf("%.1fG", float64(size)/(1024*1024*1024))
+	} else if size >= 1024*1024 {
+		return fmt.Sprintf("%vM", size/(1024*1024))
+	} else if size >= 1024 {
+		return fmt.Sprintf("%vK", size/1024)
+	} else {
+		return fmt.Sprintf("%v", size)
+	}
+}
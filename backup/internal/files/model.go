@@ -0,0 +1,375 @@
+package files
+
+import (
+	"backup/internal/exec"
+	"backup/internal/fs"
+	"backup/internal/lock"
+	"backup/internal/style"
+	"errors"
+	"fmt"
+
+	"github.com/charmbracelet/bubbles/help"
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/progress"
+	"github.com/charmbracelet/bubbles/spinner"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+type Config struct {
+	Paths   []string
+	Exclude []string
+	Workers int
+}
+
+type state int
+
+const (
+	stateNoPaths state = iota
+	stateLoading
+	stateSelect
+	stateCopying
+	stateCopied
+	stateError
+)
+
+type Model struct {
+	state state
+
+	backupDir string
+	config    Config
+
+	sources []Source
+
+	// paths that are copied in the current run
+	toCopy []Source
+	// result of every path that was copied, nil if everything was copied
+	copyResult map[string]error
+	copyFailed int
+	progress   Progress
+	updates    chan tea.Msg
+	// run lock of the backup directory while copying
+	lock *lock.Lock
+
+	pathList        *pathList
+	validationError error
+	errorModel      *exec.ErrorModel
+
+	progressBar progress.Model
+	spinner     spinner.Model
+	helpView    help.Model
+	keyMap      keyMap
+
+	styles style.Styles
+
+	width, height int
+}
+
+func NewModel(backupDir string, config Config, styles style.Styles) *Model {
+	helpView := help.New()
+	helpView.Styles = styles.HelpStyles
+
+	state := stateLoading
+	if len(config.Paths) == 0 {
+		state = stateNoPaths
+	}
+
+	return &Model{
+		state:      state,
+		backupDir:  backupDir,
+		config:     config,
+		copyResult: map[string]error{},
+
+		progressBar: progress.New(progress.WithDefaultGradient()),
+		spinner: spinner.New(
+			spinner.WithSpinner(spinner.Dot),
+			spinner.WithStyle(styles.ListItemSelectedStyle),
+		),
+		helpView: helpView,
+		keyMap:   defaultKeyMap(),
+
+		styles: styles,
+	}
+}
+
+func (m *Model) Init() tea.Cmd {
+	if m.state == stateNoPaths {
+		return nil
+	}
+	return tea.Batch(expandCmd(m.config), m.spinner.Tick)
+}
+
+func (m *Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	var cmd tea.Cmd
+
+	switch m.state {
+
+	case stateNoPaths:
+		if msg, ok := msg.(tea.KeyMsg); ok && key.Matches(msg, m.keyMap.Return) {
+			cmd = done()
+		}
+
+	case stateLoading:
+		switch msg := msg.(type) {
+		case expandResult:
+			m.state = stateSelect
+			m.sources = msg.sources
+			m.pathList = newPathList(m.sources, m.keyMap)
+			m.setListSize()
+		case spinner.TickMsg:
+			m.spinner, cmd = m.spinner.Update(msg)
+		case tea.KeyMsg:
+			if key.Matches(msg, m.keyMap.Back) {
+				cmd = done()
+			}
+		}
+
+	case stateSelect:
+		switch msg := msg.(type) {
+		case tea.KeyMsg:
+			switch {
+			case key.Matches(msg, m.keyMap.Copy):
+				toCopy := m.pathList.Selected()
+				if len(toCopy) == 0 {
+					m.validationError = errors.New("no paths selected")
+				} else if err := m.acquireLock(); err != nil {
+					m.validationError = err
+				} else {
+					m.validationError = nil
+					m.toCopy = toCopy
+					m.copyResult = map[string]error{}
+					m.pathList.ShowResults(m.copyResult)
+					cmd = m.startCopy(m.toCopy)
+				}
+			case key.Matches(msg, m.keyMap.Back):
+				cmd = done()
+			default:
+				cmd = m.pathList.Update(msg)
+			}
+		default:
+			cmd = m.pathList.Update(msg)
+		}
+
+	case stateCopying:
+		switch msg := msg.(type) {
+		case progressMsg:
+			m.progress = Progress(msg)
+			cmd = waitForUpdate(m.updates)
+		case copyDone:
+			m.progress = msg.progress
+			for path, err := range msg.results {
+				m.copyResult[path] = err
+				if err != nil {
+					m.copyFailed++
+				}
+			}
+			m.ReleaseLock()
+			m.state = stateCopied
+			m.keyMap.Retry.SetEnabled(m.copyFailed > 0)
+			m.keyMap.Details.SetEnabled(m.copyFailed > 0)
+		case tea.KeyMsg:
+			// keys are ignored until copying is done, e.g. the selection must not change while it is copied
+		default:
+			cmd = m.pathList.Update(msg)
+		}
+
+	case stateCopied:
+		switch msg := msg.(type) {
+		case tea.KeyMsg:
+			switch {
+			case key.Matches(msg, m.keyMap.Retry):
+				if err := m.acquireLock(); err != nil {
+					m.validationError = err
+					break
+				}
+				m.validationError = nil
+				var failed []Source
+				for _, s := range m.toCopy {
+					if err := m.copyResult[s.Path]; err != nil {
+						delete(m.copyResult, s.Path)
+						failed = append(failed, s)
+					}
+				}
+				if len(failed) > 0 {
+					cmd = m.startCopy(failed)
+				} else {
+					m.ReleaseLock()
+				}
+			case key.Matches(msg, m.keyMap.Details):
+				source, ok := m.pathList.Current()
+				if ok {
+					if err := m.copyResult[source.Path]; err != nil {
+						m.state = stateError
+						m.errorModel = exec.NewErrorModel(exec.Result{
+							Cmd:      []string{"copy", source.AbsPath, Target(m.backupDir, source.AbsPath)},
+							ExitCode: -1,
+							Err:      err,
+						}, m.styles)
+						m.errorModel.SetSize(m.width, m.height)
+					}
+				}
+			case key.Matches(msg, m.keyMap.Return):
+				cmd = done()
+			default:
+				cmd = m.pathList.Update(msg)
+			}
+		default:
+			cmd = m.pathList.Update(msg)
+		}
+
+	case stateError:
+		switch msg := msg.(type) {
+		case exec.Done:
+			m.state = stateCopied
+			m.errorModel = nil
+		default:
+			cmd = m.errorModel.Update(msg)
+		}
+	}
+
+	return m, cmd
+}
+
+// Takes the run lock of the backup directory, it is released when copying is done.
+func (m *Model) acquireLock() error {
+	l, err := lock.Acquire(m.backupDir, "TUI Files")
+	m.lock = l
+	return err
+}
+
+// ReleaseLock releases the run lock if it is held, e.g. when the TUI quits while copying.
+func (m *Model) ReleaseLock() {
+	m.lock.Release()
+	m.lock = nil
+}
+
+func (m *Model) startCopy(sources []Source) tea.Cmd {
+	m.state = stateCopying
+	m.copyFailed = 0
+	m.progress = Progress{}
+
+	var jobs []Job
+	paths := map[int]string{}
+	invalid := map[string]error{}
+	for _, s := range sources {
+		job, err := newJob(m.backupDir, s.Path)
+		if err != nil {
+			invalid[s.Path] = err
+			continue
+		}
+		paths[len(jobs)] = s.Path
+		jobs = append(jobs, job)
+	}
+
+	m.updates = startCopy(jobs, paths, invalid, Options{Workers: m.config.Workers, Exclude: m.config.Exclude})
+	return waitForUpdate(m.updates)
+}
+
+func newJob(backupDir string, path string) (Job, error) {
+	absPath, err := ValidatePath(path)
+	if err != nil {
+		return Job{}, err
+	}
+	exists, err := fs.Exists(absPath)
+	if err != nil {
+		return Job{}, err
+	}
+	if !exists {
+		return Job{}, fmt.Errorf("file or directory does not exist")
+	}
+	target := Target(backupDir, absPath)
+	if err := fs.CreateDir(fs.ParentPath(target)); err != nil {
+		return Job{}, fmt.Errorf("could not create target directory: %w", err)
+	}
+	return Job{Source: absPath, Target: target}, nil
+}
+
+func (m *Model) SetSize(width, height int) {
+	m.width = width
+	m.height = height
+	m.helpView.Width = width
+	m.progressBar.Width = width
+	if m.progressBar.Width > 60 {
+		m.progressBar.Width = 60
+	}
+	m.setListSize()
+	if m.errorModel != nil {
+		m.errorModel.SetSize(width, height)
+	}
+}
+
+func (m *Model) setListSize() {
+	if m.pathList != nil {
+		// 2 lines for the title, 6 lines for the header or progress and 4 lines for help text
+		listHeight := m.height - 12
+		if listHeight < 2 {
+			listHeight = 2
+		}
+		m.pathList.SetSize(m.width, listHeight)
+	}
+}
+
+type expandResult struct {
+	sources []Source
+}
+
+func expandCmd(config Config) tea.Cmd {
+	return func() tea.Msg {
+		return expandResult{sources: Expand(config.Paths, config.Exclude)}
+	}
+}
+
+type progressMsg Progress
+
+type copyDone struct {
+	progress Progress
+	// by path from the config
+	results map[string]error
+}
+
+// Copies in the background, progress updates and the final result are sent on the returned channel.
+// paths maps the index of each job to its path from the config, invalid contains paths that could not be turned into a job.
+func startCopy(jobs []Job, paths map[int]string, invalid map[string]error, opts Options) chan tea.Msg {
+	// buffered so that the workers never have to wait for the UI, if an update is still pending the new one is dropped
+	updates := make(chan tea.Msg, 1)
+	go func() {
+		var last Progress
+		opts.OnProgress = func(p Progress) {
+			last = p
+			if p.Done {
+				return
+			}
+			select {
+			case updates <- progressMsg(p):
+			default:
+			}
+		}
+		results := map[string]error{}
+		for path, err := range invalid {
+			results[path] = err
+		}
+		for i, r := range CopyAll(jobs, opts) {
+			if r.Err != nil && r.Failed > 1 {
+				results[paths[i]] = fmt.Errorf("%v files could not be copied, first error: %w", r.Failed, r.Err)
+			} else {
+				results[paths[i]] = r.Err
+			}
+		}
+		// blocks while a progress update is still pending, unlike those the final message is never dropped
+		updates <- copyDone{progress: last, results: results}
+	}()
+	return updates
+}
+
+func waitForUpdate(updates chan tea.Msg) tea.Cmd {
+	return func() tea.Msg {
+		return <-updates
+	}
+}
+
+type Done struct{}
+
+func done() tea.Cmd {
+	return func() tea.Msg {
+		return Done{}
+	}
+}
@@ -0,0 +1,225 @@
+package files
+
+import (
+	"backup/internal/fs"
+	"fmt"
+	"time"
+
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/list"
+	"github.com/charmbracelet/lipgloss"
+)
+
+func (m *Model) View() string {
+	var content string
+
+	switch m.state {
+	case stateNoPaths:
+		content = m.viewNoPaths()
+	case stateLoading:
+		content = m.viewLoading()
+	case stateSelect:
+		content = m.viewSelect()
+	case stateCopying:
+		content = m.viewCopying()
+	case stateCopied:
+		content = m.viewCopied()
+	case stateError:
+		content = m.errorModel.View()
+	}
+
+	return content
+}
+
+func (m *Model) viewNoPaths() string {
+	return lipgloss.JoinVertical(
+		lipgloss.Left,
+		m.styles.TitleStyle.Render("Files"),
+		"",
+		m.styles.NormalTextStyle.Render("No files configured. Add paths to the files section of your config file and try again."),
+		"",
+		m.helpView.ShortHelpView(m.keyMap.noPathsKeys()),
+	)
+}
+
+func (m *Model) viewLoading() string {
+	return lipgloss.JoinVertical(
+		lipgloss.Left,
+		m.styles.TitleStyle.Render("Files"),
+		"",
+		fmt.Sprintf(
+			"%s %s",
+			m.styles.NormalTextStyle.UnsetWidth().Render("Scanning files"),
+			m.spinner.View(),
+		),
+	)
+}
+
+func (m *Model) viewSelect() string {
+	var size int64
+	for _, s := range m.pathList.Selected() {
+		size += s.Size
+	}
+
+	content := m.styles.NormalTextStyle.Render(fmt.Sprintf("Select paths to copy to %s (%s selected)", fs.JoinPath(m.backupDir, "files"), fileSizeString(size)))
+	if m.validationError != nil {
+		content = m.styles.ErrorTextStyle.Render(m.validationError.Error())
+	}
+
+	return lipgloss.JoinVertical(
+		lipgloss.Left,
+		m.styles.TitleStyle.Render("Files"),
+		"",
+		content,
+		"",
+		m.pathList.View(),
+		"",
+		m.helpView.FullHelpView(m.keyMap.selectKeys()),
+	)
+}
+
+func (m *Model) viewCopying() string {
+	p := m.progress
+
+	var percent float64
+	if p.BytesTotal > 0 {
+		percent = float64(p.BytesDone) / float64(p.BytesTotal)
+	}
+	eta := "unknown"
+	if p.ETA > 0 {
+		eta = p.ETA.Round(time.Second).String()
+	}
+
+	return lipgloss.JoinVertical(
+		lipgloss.Left,
+		m.styles.TitleStyle.Render("Files"),
+		"",
+		m.progressBar.ViewAs(percent),
+		m.styles.NormalTextStyle.Render(fmt.Sprintf(
+			"%v of %v files, %s of %s, %s/s, %s left",
+			p.FilesDone, p.FilesTotal, fileSizeString(p.BytesDone), fileSizeString(p.BytesTotal), fileSizeString(int64(p.Throughput)), eta,
+		)),
+		m.styles.ListItemDescriptionStyle.Render(p.Current),
+		"",
+		m.pathList.View(),
+	)
+}
+
+func (m *Model) viewCopied() string {
+	var content string
+	if m.copyFailed == 0 {
+		content = m.styles.NormalTextStyle.Render(fmt.Sprintf("All files copied successfully! Took %s.", m.progress.Elapsed.Round(time.Second)))
+	} else {
+		content = m.styles.ErrorTextStyle.Render("Some paths could not be copied, select one to see the details. Try again?")
+	}
+	if m.validationError != nil {
+		content = m.styles.ErrorTextStyle.Render(m.validationError.Error())
+	}
+
+	return lipgloss.JoinVertical(
+		lipgloss.Left,
+		m.styles.TitleStyle.Render("Files"),
+		"",
+		content,
+		"",
+		m.pathList.View(),
+		"",
+		m.helpView.ShortHelpView(m.keyMap.copiedKeys()),
+	)
+}
+
+type keyMap struct {
+	CursorUp   key.Binding
+	CursorDown key.Binding
+	PrevPage   key.Binding
+	NextPage   key.Binding
+	Select     key.Binding
+	SelectAll  key.Binding
+	Copy       key.Binding
+	Back       key.Binding
+
+	Details key.Binding
+	Retry   key.Binding
+	Return  key.Binding
+}
+
+func defaultKeyMap() keyMap {
+	return keyMap{
+		CursorUp: key.NewBinding(
+			key.WithKeys("k"),
+			key.WithHelp("k", "up"),
+		),
+		CursorDown: key.NewBinding(
+			key.WithKeys("j"),
+			key.WithHelp("j", "down"),
+		),
+		PrevPage: key.NewBinding(
+			key.WithKeys("h"),
+			key.WithHelp("h", "prev page"),
+		),
+		NextPage: key.NewBinding(
+			key.WithKeys("l"),
+			key.WithHelp("l", "next page"),
+		),
+		Select: key.NewBinding(
+			key.WithKeys(" "),
+			key.WithHelp("space", "(un)select"),
+		),
+		SelectAll: key.NewBinding(
+			key.WithKeys("a"),
+			key.WithHelp("a", "toggle all"),
+		),
+		Copy: key.NewBinding(
+			key.WithKeys("enter"),
+			key.WithHelp("enter", "copy"),
+		),
+		Back: key.NewBinding(
+			key.WithKeys("esc"),
+			key.WithHelp("esc", "back"),
+		),
+		Details: key.NewBinding(
+			key.WithKeys("d"),
+			key.WithHelp("d", "details"),
+		),
+		Retry: key.NewBinding(
+			key.WithKeys("r"),
+			key.WithHelp("r", "retry failed"),
+		),
+		Return: key.NewBinding(
+			key.WithKeys("enter"),
+			key.WithHelp("enter", "return"),
+		),
+	}
+}
+
+func (m keyMap) listKeyMap() list.KeyMap {
+	return list.KeyMap{
+		CursorUp:             m.CursorUp,
+		CursorDown:           m.CursorDown,
+		PrevPage:             m.PrevPage,
+		NextPage:             m.NextPage,
+		GoToStart:            key.NewBinding(key.WithDisabled()),
+		GoToEnd:              key.NewBinding(key.WithDisabled()),
+		Filter:               key.NewBinding(key.WithDisabled()),
+		ClearFilter:          key.NewBinding(key.WithDisabled()),
+		CancelWhileFiltering: key.NewBinding(key.WithDisabled()),
+		AcceptWhileFiltering: key.NewBinding(key.WithDisabled()),
+		ShowFullHelp:         key.NewBinding(key.WithDisabled()),
+		CloseFullHelp:        key.NewBinding(key.WithDisabled()),
+	}
+}
+
+func (m keyMap) noPathsKeys() []key.Binding {
+	return []key.Binding{m.Return}
+}
+
+func (m keyMap) selectKeys() [][]key.Binding {
+	return [][]key.Binding{
+		{m.CursorUp, m.CursorDown, m.PrevPage, m.NextPage},
+		{m.Select, m.SelectAll, m.Copy, m.Back},
+	}
+}
+
+func (m keyMap) copiedKeys() []key.Binding {
+	return []key.Binding{m.CursorUp, m.CursorDown, m.Details, m.Retry, m.Return}
+}
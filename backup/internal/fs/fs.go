@@ -7,6 +7,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"syscall"
 	"time"
 )
 
@@ -90,6 +91,22 @@ func AbsPath(path string) (string, error) {
 	}
 }
 
+// HomePath is the opposite of AbsPath, paths in the home directory are returned as "~/..." and all others unchanged.
+func HomePath(path string) string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	path = filepath.Clean(path)
+	if path == home {
+		return "~"
+	}
+	if rel, err := filepath.Rel(home, path); err == nil && rel != ".." && !strings.HasPrefix(rel, "../") {
+		return "~/" + rel
+	}
+	return path
+}
+
 func DefaultBackupDir() (string, error) {
 	home, err := os.UserHomeDir()
 	if err != nil {
@@ -100,6 +117,19 @@ func DefaultBackupDir() (string, error) {
 	return AbsPath(dir)
 }
 
+// StateDir returns the directory for state that is kept between runs, $XDG_STATE_HOME/backup or ~/.local/state/backup.
+// It is not created.
+func StateDir() (string, error) {
+	if dir := os.Getenv("XDG_STATE_HOME"); filepath.IsAbs(dir) {
+		return filepath.Join(dir, "backup"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".local", "state", "backup"), nil
+}
+
 func FileSize(file string) (int64, error) {
 	info, err := os.Stat(file)
 	if err != nil {
@@ -107,3 +137,66 @@ func FileSize(file string) (int64, error) {
 	}
 	return info.Size(), nil
 }
+
+// ExistingAncestor returns the path itself if it exists, otherwise the closest parent directory that exists.
+// Useful to determine the filesystem a file or directory will be created on.
+func ExistingAncestor(path string) (string, error) {
+	path = filepath.Clean(path)
+	for {
+		exists, err := Exists(path)
+		if err != nil {
+			return "", err
+		}
+		if exists {
+			return path, nil
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return "", errors.New("no existing parent directory")
+		}
+		path = parent
+	}
+}
+
+// FreeSpace returns the number of bytes available to unprivileged users on the filesystem the given path is or will be created on.
+func FreeSpace(path string) (int64, error) {
+	existing, err := ExistingAncestor(path)
+	if err != nil {
+		return 0, err
+	}
+	var stat syscall.Statfs_t
+	err = syscall.Statfs(existing, &stat)
+	if err != nil {
+		return 0, err
+	}
+	return int64(stat.Bavail) * int64(stat.Bsize), nil
+}
+
+// SameFilesystem returns true if the given paths are or will be created on the same filesystem.
+func SameFilesystem(a, b string) (bool, error) {
+	devA, err := device(a)
+	if err != nil {
+		return false, err
+	}
+	devB, err := device(b)
+	if err != nil {
+		return false, err
+	}
+	return devA == devB, nil
+}
+
+func device(path string) (uint64, error) {
+	existing, err := ExistingAncestor(path)
+	if err != nil {
+		return 0, err
+	}
+	info, err := os.Stat(existing)
+	if err != nil {
+		return 0, err
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, errors.New("could not determine device")
+	}
+	return uint64(stat.Dev), nil
+}
@@ -2,6 +2,7 @@ package github
 
 import (
 	"backup/internal/fs"
+	"backup/internal/lock"
 	"backup/internal/style"
 	"errors"
 
@@ -13,7 +14,7 @@ import (
 
 type Config struct {
 	// personal access token to authenticate API requests
-	Token string `json:"token"`
+	Token string `json:"token,omitempty"`
 }
 
 type state int
@@ -33,6 +34,10 @@ type Model struct {
 
 	backupDir string
 	config    Config
+	// the backup directory itself, backupDir is the github directory in it
+	lockDir string
+	// run lock of the backup directory while cloning
+	lock *lock.Lock
 
 	repos             []Repo
 	loadingReposError error
@@ -68,6 +73,7 @@ func NewModel(backupDir string, config Config, styles style.Styles) *Model {
 		confirmBack:       false,
 		backupDir:         fs.JoinPath(backupDir, "github"),
 		config:            config,
+		lockDir:           backupDir,
 		repos:             nil,
 		loadingReposError: nil,
 		reposToClone:      nil,
@@ -104,6 +110,7 @@ func (m *Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	if m.confirmBack {
 		if msg, ok := msg.(tea.KeyMsg); ok {
 			if key.Matches(msg, m.keyMap.ConfirmBack) {
+				m.ReleaseLock()
 				return m, done()
 			} else if key.Matches(msg, m.keyMap.CancelBack) {
 				m.confirmBack = false
@@ -164,6 +171,8 @@ func (m *Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				reposToClone := m.selectReposList.Selected()
 				if len(reposToClone) == 0 {
 					m.validationError = errors.New("no repos selected")
+				} else if err := m.acquireLock(); err != nil {
+					m.validationError = err
 				} else {
 					m.state = stateCloningRepos
 					m.reposToClone = reposToClone
@@ -196,6 +205,7 @@ func (m *Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			}
 
 			if len(m.cloneResult) == len(m.reposToClone) {
+				m.ReleaseLock()
 				m.state = stateReposCloned
 				if m.clonesFailed > 0 {
 					m.keyMap.CloneRetry.SetEnabled(true)
@@ -215,6 +225,11 @@ func (m *Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			switch {
 			case key.Matches(msg, m.keyMap.CloneRetry):
 				if m.clonesFailed > 0 {
+					if err := m.acquireLock(); err != nil {
+						m.validationError = err
+						break
+					}
+					m.validationError = nil
 					cmds := []tea.Cmd{m.spinner.Tick}
 					for _, r := range m.reposToClone {
 						if success, ok := m.cloneResult[r.Id]; ok && !success {
@@ -238,6 +253,19 @@ func (m *Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	return m, cmd
 }
 
+// Takes the run lock of the backup directory, it is released when all repos are cloned.
+func (m *Model) acquireLock() error {
+	l, err := lock.Acquire(m.lockDir, "TUI GitHub")
+	m.lock = l
+	return err
+}
+
+// ReleaseLock releases the run lock if it is held, e.g. when leaving while repos are cloned.
+func (m *Model) ReleaseLock() {
+	m.lock.Release()
+	m.lock = nil
+}
+
 func (m *Model) SetSize(width, height int) {
 	m.width = width
 	m.height = height
@@ -23,6 +23,8 @@ type Repo struct {
 	} `json:"owner"`
 	CloneUrl string `json:"clone_url"`
 	Private  bool   `json:"private"`
+	// size of the repo in kilobytes as reported by the API
+	Size int64 `json:"size"`
 }
 
 // implement the Item interface from the bubbles/list package
@@ -85,10 +87,10 @@ func LoadRepos(token string) ([]Repo, error) {
 
 func doRequest(client *http.Client, req *http.Request) ([]Repo, error, string) {
 	resp, err := client.Do(req)
-	defer resp.Body.Close()
 	if err != nil {
 		return nil, err, ""
 	}
+	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		return nil, fmt.Errorf("request failed: %v", resp.Status), ""
@@ -165,6 +167,13 @@ func CloneRepo(repo Repo, dir string, token string) exec.Result {
 	return exec.Background(cmd, opts...)
 }
 
+// BundleRepo packs all refs of a clone into a single git bundle file,
+// the repo can be restored from it with "git clone <file>".
+func BundleRepo(repoDir string, file string) exec.Result {
+	cmd := []string{"git", "-C", repoDir, "bundle", "create", file, "--all"}
+	return exec.Background(cmd, exec.WithTimeout(time.Second*120))
+}
+
 // func CloneRepo(repo Repo, dir string, token string) exec.Result {
 // 	// these commands should not get logged in your shell history
 // 	repoDir := fs.JoinPath(dir, repo.Name)
@@ -81,11 +81,16 @@ func (m *Model) viewLoadingReposError() string {
 }
 
 func (m *Model) viewReposLoaded() string {
+	content := m.styles.NormalTextStyle.Render("Select repos to backup")
+	if m.validationError != nil {
+		content = m.styles.ErrorTextStyle.Render(m.validationError.Error())
+	}
+
 	return lipgloss.JoinVertical(
 		lipgloss.Left,
 		m.styles.TitleStyle.Render("GitHub"),
 		"",
-		m.styles.NormalTextStyle.Render("Select repos to backup"),
+		content,
 		"",
 		m.selectReposList.View(),
 		"",
@@ -117,6 +122,9 @@ func (m *Model) viewReposCloned() string {
 	} else {
 		content = m.styles.ErrorTextStyle.Render("Some repos could not be cloned, check the logs for more information. Try again?")
 	}
+	if m.validationError != nil {
+		content = m.styles.ErrorTextStyle.Render(m.validationError.Error())
+	}
 
 	return lipgloss.JoinVertical(
 		lipgloss.Left,
@@ -0,0 +1,191 @@
+// Package lock keeps backups of the same backup directory from running at the same time, e.g. the TUI and a
+// scheduled run.
+//
+// The lock is an advisory flock on a file next to the backup directory, <dir>.lock, so that other users and hosts
+// that write to the same directory, e.g. over NFS, see it too. The file holds who has the lock, a run that crashed
+// leaves it behind but the flock is gone with the process, the next run reports it as stale and takes over.
+// On filesystems without flock the process of the holder is checked instead.
+package lock
+
+import (
+	"backup/internal/fs"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	iofs "io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// Holder is who has the lock.
+type Holder struct {
+	PID     int       `json:"pid"`
+	Host    string    `json:"host"`
+	Started time.Time `json:"started"`
+	// e.g. "backup" or "TUI Zip"
+	Owner string `json:"owner"`
+	Dir   string `json:"dir"`
+}
+
+// e.g. "TUI Zip (pid 1234 on laptop, since 2024-01-01 10:00:00)"
+func (h Holder) String() string {
+	if h.PID == 0 {
+		return h.Owner
+	}
+	return fmt.Sprintf("%s (pid %v on %s, since %s)", h.Owner, h.PID, h.Host, h.Started.Local().Format("2006-01-02 15:04:05"))
+}
+
+// LockedError is returned if someone else has the lock.
+type LockedError struct {
+	Holder Holder
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("backup directory %s is in use by %s", e.Holder.Dir, e.Holder)
+}
+
+type Lock struct {
+	f *os.File
+	// the lock file belongs to another user, who has the lock is not recorded
+	readOnly bool
+	// set if the previous holder did not release the lock, e.g. because it crashed
+	Stale *Holder
+}
+
+// Acquire takes the lock of the backup directory without waiting, a *LockedError is returned if it is taken.
+// owner describes what takes the lock. The parent of the backup directory is created if it does not exist.
+func Acquire(dir string, owner string) (*Lock, error) {
+	file, err := lockFile(dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(file, os.O_RDWR|os.O_CREATE, 0644)
+	readOnly := false
+	if errors.Is(err, iofs.ErrPermission) {
+		// created by another user, a flock works without write access
+		f, err = os.Open(file)
+		readOnly = true
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	noFlock := errors.Is(err, syscall.ENOLCK) || errors.Is(err, syscall.EOPNOTSUPP)
+	if err != nil && !noFlock && !errors.Is(err, syscall.EWOULDBLOCK) {
+		f.Close()
+		return nil, fmt.Errorf("could not lock %s: %w", file, err)
+	}
+	// read after locking, a holder that released the lock in between has emptied the file
+	previous, readErr := readHolder(f)
+	if readErr != nil {
+		f.Close()
+		return nil, fmt.Errorf("could not read lock file %s: %w", file, readErr)
+	}
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		f.Close()
+		// the holder might not have written the file yet
+		if previous == nil {
+			previous = &Holder{Owner: "another backup"}
+		}
+		previous.Dir = dir
+		return nil, &LockedError{Holder: *previous}
+	}
+	// no flock on this filesystem, e.g. some network filesystems
+	if noFlock && previous != nil && alive(*previous) {
+		f.Close()
+		return nil, &LockedError{Holder: *previous}
+	}
+
+	if readOnly {
+		return &Lock{f: f, readOnly: true, Stale: previous}, nil
+	}
+	holder := Holder{PID: os.Getpid(), Started: time.Now(), Owner: owner, Dir: dir}
+	holder.Host, _ = os.Hostname()
+	if err := writeHolder(f, &holder); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("could not write lock file %s: %w", file, err)
+	}
+	return &Lock{f: f, Stale: previous}, nil
+}
+
+// Release gives up the lock, it does nothing if l is nil.
+// The file is emptied but stays, removing it would let a waiting process lock a file that no longer exists.
+func (l *Lock) Release() error {
+	if l == nil || l.f == nil {
+		return nil
+	}
+	var err error
+	if !l.readOnly {
+		err = writeHolder(l.f, nil)
+	}
+	if closeErr := l.f.Close(); err == nil {
+		err = closeErr
+	}
+	l.f = nil
+	return err
+}
+
+// The file is next to the backup directory and not inside, it would end up in the backup and a directory that does
+// not exist yet can be locked too.
+func lockFile(dir string) (string, error) {
+	absDir, err := fs.AbsPath(dir)
+	if err != nil {
+		return "", err
+	}
+	if absDir == "/" {
+		return "", errors.New("cannot lock /")
+	}
+	return absDir + ".lock", nil
+}
+
+// Returns nil if the file is empty, i.e. the lock was released.
+func readHolder(f *os.File) (*Holder, error) {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(f)
+	if err != nil || len(data) == 0 {
+		return nil, err
+	}
+	var h Holder
+	if err := json.Unmarshal(data, &h); err != nil {
+		// half written by a run that crashed
+		return &Holder{Owner: "unknown"}, nil
+	}
+	return &h, nil
+}
+
+// Replaces the content of the file with the holder, empties it if holder is nil.
+func writeHolder(f *os.File, holder *Holder) error {
+	if err := f.Truncate(0); err != nil {
+		return err
+	}
+	if holder == nil {
+		return nil
+	}
+	data, err := json.Marshal(holder)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteAt(data, 0); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// Returns true if the process of the holder still runs, holders on other hosts are assumed to.
+func alive(h Holder) bool {
+	host, _ := os.Hostname()
+	if h.Host != host || h.PID <= 0 {
+		return true
+	}
+	err := syscall.Kill(h.PID, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
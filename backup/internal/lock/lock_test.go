@@ -0,0 +1,41 @@
+package lock
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAcquire(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "backup")
+
+	l, err := Acquire(dir, "first")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(dir + ".lock"); err != nil {
+		t.Errorf("lock file next to the backup directory: %v", err)
+	}
+
+	_, err = Acquire(dir, "second")
+	var locked *LockedError
+	if !errors.As(err, &locked) {
+		t.Fatalf("second lock returned %v, expected a LockedError", err)
+	}
+	if locked.Holder.Owner != "first" || locked.Holder.PID != os.Getpid() {
+		t.Errorf("holder is %+v", locked.Holder)
+	}
+
+	if err := l.Release(); err != nil {
+		t.Fatal(err)
+	}
+	l, err = Acquire(dir, "second")
+	if err != nil {
+		t.Fatalf("lock after release: %v", err)
+	}
+	if l.Stale != nil {
+		t.Errorf("released lock reported as stale: %v", l.Stale)
+	}
+	l.Release()
+}
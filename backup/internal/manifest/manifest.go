@@ -0,0 +1,300 @@
+package manifest
+
+import (
+	"backup/internal/exec"
+	"backup/internal/fs"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	iofs "io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Name of the manifest file, it is always stored in the root of the backup directory.
+const FileName = "manifest.json"
+
+const version = 1
+
+// A Manifest records the contents of a backup directory.
+// It is written after every backup run and is the basis for verifying, diffing and restoring backups.
+type Manifest struct {
+	Version int       `json:"version"`
+	Created time.Time `json:"created"`
+	// absolute path of the backup directory at the time the manifest was created
+	BackupDir string `json:"backupDir"`
+	Repos     []Repo `json:"repos,omitempty"`
+	Files     []File `json:"files"`
+}
+
+type Repo struct {
+	Name string `json:"name"`
+	// directory of the clone relative to the backup directory, or its bundle file if the backup was streamed
+	Path   string `json:"path"`
+	Remote string `json:"remote,omitempty"`
+	Commit string `json:"commit,omitempty"`
+}
+
+type File struct {
+	// relative to the backup directory, always uses forward slashes
+	Path    string        `json:"path"`
+	Size    int64         `json:"size"`
+	ModTime time.Time     `json:"mtime"`
+	Mode    iofs.FileMode `json:"mode"`
+	// empty for symlinks
+	SHA256 string `json:"sha256,omitempty"`
+	// target of a symlink
+	Link string `json:"link,omitempty"`
+	// original location of a local file
+	Source string `json:"source,omitempty"`
+	// name of the repo a file belongs to
+	Repo string `json:"repo,omitempty"`
+}
+
+func (f File) IsSymlink() bool {
+	return f.Mode&iofs.ModeSymlink != 0
+}
+
+// Create walks the given backup directory and hashes every file.
+// The layout of the backup directory determines where a file came from:
+// files in "github/<name>" belong to the repo with that name,
+// files in "files" keep their full original path e.g. files/home/user/abc is the backup of /home/user/abc.
+func Create(dir string) (Manifest, error) {
+	m := New(dir)
+
+	err := filepath.WalkDir(dir, func(p string, d iofs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if rel == FileName {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		file := NewFile(rel, info)
+
+		switch {
+		case info.Mode()&iofs.ModeSymlink != 0:
+			link, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+			file.Link = link
+		case info.Mode().IsRegular():
+			hash, err := HashFile(p)
+			if err != nil {
+				return err
+			}
+			file.SHA256 = hash
+		default:
+			// sockets, devices, named pipes etc. cannot be backed up in a meaningful way
+			return nil
+		}
+
+		m.Files = append(m.Files, file)
+		return nil
+	})
+	if err != nil {
+		return m, err
+	}
+
+	repos, err := findRepos(dir)
+	if err != nil {
+		return m, err
+	}
+	m.Repos = repos
+
+	return m, nil
+}
+
+// New returns an empty manifest for the given backup directory.
+func New(dir string) Manifest {
+	return Manifest{
+		Version:   version,
+		Created:   time.Now(),
+		BackupDir: dir,
+	}
+}
+
+// NewFile returns the entry of a file without its checksum or symlink target, rel is the path in the backup directory.
+func NewFile(rel string, info iofs.FileInfo) File {
+	file := File{
+		Path:    rel,
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+		Mode:    info.Mode(),
+	}
+	file.Source, file.Repo = origin(rel)
+	return file
+}
+
+// Returns the original location of a file or the name of the repo it belongs to.
+func origin(rel string) (string, string) {
+	parts := strings.SplitN(rel, "/", 3)
+	if len(parts) < 2 {
+		return "", ""
+	}
+	switch parts[0] {
+	case "files":
+		return "/" + strings.Join(parts[1:], "/"), ""
+	case "github":
+		if len(parts) == 3 {
+			return "", parts[1]
+		}
+	}
+	return "", ""
+}
+
+func findRepos(dir string) ([]Repo, error) {
+	githubDir := fs.JoinPath(dir, "github")
+	entries, err := os.ReadDir(githubDir)
+	if err != nil {
+		if errors.Is(err, iofs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var repos []Repo
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		repoDir := fs.JoinPath(githubDir, e.Name())
+		repos = append(repos, NewRepo(e.Name(), path.Join("github", e.Name()), repoDir))
+	}
+	return repos, nil
+}
+
+// NewRepo returns the entry of a repo, the commit and remote are read from the clone in repoDir.
+func NewRepo(name string, path string, repoDir string) Repo {
+	repo := Repo{Name: name, Path: path}
+	// the manifest is still useful without this information, e.g. if git is not installed
+	if r := exec.Background([]string{"git", "-C", repoDir, "rev-parse", "HEAD"}); r.Err == nil && r.ExitCode == 0 {
+		repo.Commit = strings.TrimSpace(r.Stdout)
+	}
+	if r := exec.Background([]string{"git", "-C", repoDir, "remote", "get-url", "origin"}); r.Err == nil && r.ExitCode == 0 {
+		repo.Remote = strings.TrimSpace(r.Stdout)
+	}
+	return repo
+}
+
+func HashFile(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return Hash(f)
+}
+
+func Hash(r io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Write stores the manifest in the root of the given backup directory.
+func Write(dir string, m Manifest) error {
+	data, err := Encode(m)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(fs.JoinPath(dir, FileName), data, 0664)
+}
+
+// Encode returns the manifest as it is stored in manifest.json.
+func Encode(m Manifest) ([]byte, error) {
+	return json.MarshalIndent(m, "", "  ")
+}
+
+// Load reads the manifest from the root of the given backup directory.
+func Load(dir string) (Manifest, error) {
+	f, err := os.Open(fs.JoinPath(dir, FileName))
+	if err != nil {
+		return Manifest{}, err
+	}
+	defer f.Close()
+	return Decode(f)
+}
+
+func Decode(r io.Reader) (Manifest, error) {
+	var m Manifest
+	err := json.NewDecoder(r).Decode(&m)
+	if err != nil {
+		return m, fmt.Errorf("could not decode manifest: %w", err)
+	}
+	if m.Version > version {
+		return m, fmt.Errorf("unsupported manifest version %v", m.Version)
+	}
+	return m, nil
+}
+
+// Returns the files of the manifest by path.
+func (m Manifest) FileMap() map[string]File {
+	files := make(map[string]File, len(m.Files))
+	for _, f := range m.Files {
+		files[f.Path] = f
+	}
+	return files
+}
+
+type Diff struct {
+	Added   []string
+	Removed []string
+	// files whose content, mode or symlink target changed
+	Changed []string
+}
+
+func (d Diff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// Compare returns the differences between two manifests, e.g. two backups taken at different times.
+// Modification times are ignored since they are not always preserved when copying files.
+func Compare(old, new Manifest) Diff {
+	var diff Diff
+	oldFiles := old.FileMap()
+	newFiles := new.FileMap()
+
+	for p, nf := range newFiles {
+		of, ok := oldFiles[p]
+		if !ok {
+			diff.Added = append(diff.Added, p)
+		} else if of.SHA256 != nf.SHA256 || of.Link != nf.Link || of.Mode != nf.Mode || of.Size != nf.Size {
+			diff.Changed = append(diff.Changed, p)
+		}
+	}
+	for p := range oldFiles {
+		if _, ok := newFiles[p]; !ok {
+			diff.Removed = append(diff.Removed, p)
+		}
+	}
+
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Removed)
+	sort.Strings(diff.Changed)
+	return diff
+}
@@ -0,0 +1,155 @@
+package manifest
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// sha256 of "hello\n"
+const helloHash = "5891b5b522d5df086d0ff0b110fbd9d21bb4fc7163af34d08286a2e846f6be03"
+
+// Creates a small backup directory with a local file, a symlink, a repo and an old manifest.
+func backupDir(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"files/home/user/notes.txt": "hello\n",
+		"files/home/user/todo.txt":  "buy milk\n",
+		"github/tools/README.md":    "# tools\n",
+		FileName:                    "{}",
+	} {
+		file := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("notes.txt", filepath.Join(dir, "files", "home", "user", "latest")); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestCreate(t *testing.T) {
+	dir := backupDir(t)
+	m, err := Create(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Version != version || m.BackupDir != dir {
+		t.Errorf("unexpected header %+v", m)
+	}
+
+	files := m.FileMap()
+	if len(files) != 4 {
+		t.Fatalf("expected 4 files without the manifest itself, got %+v", m.Files)
+	}
+	notes := files["files/home/user/notes.txt"]
+	if notes.SHA256 != helloHash || notes.Size != 6 || notes.Source != "/home/user/notes.txt" || notes.Repo != "" {
+		t.Errorf("unexpected entry %+v", notes)
+	}
+	latest := files["files/home/user/latest"]
+	if !latest.IsSymlink() || latest.Link != "notes.txt" || latest.SHA256 != "" {
+		t.Errorf("unexpected symlink entry %+v", latest)
+	}
+	readme := files["github/tools/README.md"]
+	if readme.Repo != "tools" || readme.Source != "" {
+		t.Errorf("unexpected repo file %+v", readme)
+	}
+	if len(m.Repos) != 1 || m.Repos[0].Name != "tools" || m.Repos[0].Path != "github/tools" {
+		t.Errorf("unexpected repos %+v", m.Repos)
+	}
+}
+
+func TestHash(t *testing.T) {
+	hash, err := Hash(strings.NewReader("hello\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash != helloHash {
+		t.Errorf("got %s, expected %s", hash, helloHash)
+	}
+}
+
+func TestWriteLoad(t *testing.T) {
+	dir := backupDir(t)
+	m, err := Create(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Write(dir, m); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := Encode(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := Encode(loaded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, expected) {
+		t.Errorf("got\n%s\nexpected\n%s", data, expected)
+	}
+	if !loaded.Created.Equal(m.Created) || loaded.Files[0].Mode != m.Files[0].Mode {
+		t.Errorf("got %+v, expected %+v", loaded, m)
+	}
+
+	if _, err := Decode(strings.NewReader(`{"version": 2, "files": []}`)); err == nil {
+		t.Error("no error for a newer version")
+	}
+	if _, err := Decode(strings.NewReader(`{"version": 1,`)); err == nil {
+		t.Error("no error for a broken manifest")
+	}
+}
+
+func TestCompare(t *testing.T) {
+	dir := backupDir(t)
+	old, err := Create(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := Compare(old, old); !diff.Empty() {
+		t.Errorf("expected no differences, got %+v", diff)
+	}
+
+	user := filepath.Join(dir, "files", "home", "user")
+	if err := os.WriteFile(filepath.Join(user, "todo.txt"), []byte("buy eggs\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(user, "notes.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(user, "new.txt"), []byte("new\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	m, err := Create(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	diff := Compare(old, m)
+	for _, test := range []struct {
+		name     string
+		got      []string
+		expected string
+	}{
+		{"added", diff.Added, "files/home/user/new.txt"},
+		{"removed", diff.Removed, "files/home/user/notes.txt"},
+		// same size, different content
+		{"changed", diff.Changed, "files/home/user/todo.txt"},
+	} {
+		if strings.Join(test.got, ",") != test.expected {
+			t.Errorf("%s: got %v, expected %s", test.name, test.got, test.expected)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package notify
+
+import (
+	"backup/internal/exec"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// DesktopConfig shows notifications on the desktop of the user through D-Bus, it needs busctl and a session bus,
+// e.g. a backup run by cron usually has none.
+type DesktopConfig struct {
+	On Trigger `json:"on,omitempty"`
+	// how long the notification is shown in seconds, 0 leaves it to the desktop, which usually keeps failures until they
+	// are closed
+	Timeout int `json:"timeout,omitempty"`
+}
+
+func (c *DesktopConfig) name() string {
+	return "desktop"
+}
+
+func (c *DesktopConfig) trigger() Trigger {
+	return c.On
+}
+
+func (c *DesktopConfig) validate() error {
+	if c.Timeout < 0 {
+		return errors.New("timeout cannot be negative")
+	}
+	return nil
+}
+
+func (c *DesktopConfig) send(n Notification) error {
+	if err := exec.CommandAvailable("busctl"); err != nil {
+		return fmt.Errorf("desktop notifications need busctl: %w", err)
+	}
+	if os.Getenv("DBUS_SESSION_BUS_ADDRESS") == "" && os.Getenv("XDG_RUNTIME_DIR") == "" {
+		return errors.New("no session bus, desktop notifications only work within a desktop session")
+	}
+
+	// urgency 2 is critical, notifications with it are not closed automatically
+	urgency := map[Status]string{StatusSuccess: "0", StatusWarnings: "1", StatusFailure: "2"}[n.Status]
+	expire := "-1"
+	if c.Timeout > 0 {
+		expire = strconv.Itoa(c.Timeout * 1000)
+	}
+	icon := map[Status]string{StatusSuccess: "dialog-information", StatusWarnings: "dialog-warning", StatusFailure: "dialog-error"}[n.Status]
+	// Notify(app_name, replaces_id, app_icon, summary, body, actions, hints, expire_timeout)
+	cmd := []string{
+		"busctl", "--user", "call",
+		"org.freedesktop.Notifications", "/org/freedesktop/Notifications", "org.freedesktop.Notifications", "Notify",
+		"susssasa{sv}i", "backup", "0", icon, n.Title, desktopBody(n.Text), "0", "1", "urgency", "y", urgency, expire,
+	}
+	result := exec.Background(cmd, exec.WithTimeout(timeout))
+	if result.Err != nil {
+		return result.Err
+	}
+	if result.ExitCode != 0 {
+		return fmt.Errorf("busctl exited with code %v: %s", result.ExitCode, strings.TrimSpace(result.Stderr))
+	}
+	return nil
+}
+
+// Notification popups are small, the first lines have to do.
+func desktopBody(text string) string {
+	lines := strings.Split(strings.TrimSpace(text), "\n")
+	if len(lines) > 12 {
+		lines = append(lines[:12], "...")
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,165 @@
+package notify
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type EmailConfig struct {
+	On Trigger `json:"on,omitempty"`
+	// SMTP server
+	Host string `json:"host,omitempty"`
+	// 587 if empty, 465 with tls
+	Port int `json:"port,omitempty"`
+	// TLS from the start, e.g. on port 465, otherwise STARTTLS is used if the server supports it.
+	// With a username STARTTLS is required unless the server is localhost.
+	TLS bool `json:"tls,omitempty"`
+	// no authentication if empty
+	Username string `json:"username,omitempty"`
+	// BACKUP_SMTP_PASSWORD is used if empty
+	Password string   `json:"password,omitempty"`
+	From     string   `json:"from,omitempty"`
+	To       []string `json:"to,omitempty"`
+}
+
+func (c *EmailConfig) name() string {
+	return "e-mail"
+}
+
+func (c *EmailConfig) trigger() Trigger {
+	return c.On
+}
+
+func (c *EmailConfig) validate() error {
+	if c.Host == "" {
+		return errors.New("no host specified")
+	}
+	if c.Port < 0 || c.Port > 65535 {
+		return fmt.Errorf("invalid port %v", c.Port)
+	}
+	if _, err := mail.ParseAddress(c.From); err != nil {
+		return fmt.Errorf("invalid from %q: %w", c.From, err)
+	}
+	if len(c.To) == 0 {
+		return errors.New("no recipients specified")
+	}
+	for _, to := range c.To {
+		if _, err := mail.ParseAddress(to); err != nil {
+			return fmt.Errorf("invalid recipient %q: %w", to, err)
+		}
+	}
+	return nil
+}
+
+func (c *EmailConfig) port() int {
+	if c.Port != 0 {
+		return c.Port
+	}
+	if c.TLS {
+		return 465
+	}
+	return 587
+}
+
+func (c *EmailConfig) password() string {
+	if c.Password != "" {
+		return c.Password
+	}
+	return os.Getenv("BACKUP_SMTP_PASSWORD")
+}
+
+func (c *EmailConfig) send(n Notification) error {
+	addr := net.JoinHostPort(c.Host, strconv.Itoa(c.port()))
+	dialer := &net.Dialer{Timeout: timeout}
+	var conn net.Conn
+	var err error
+	if c.TLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{ServerName: c.Host})
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	client, err := smtp.NewClient(conn, c.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if !c.TLS {
+		if ok, _ := client.Extension("STARTTLS"); ok {
+			if err := client.StartTLS(&tls.Config{ServerName: c.Host}); err != nil {
+				return err
+			}
+		} else if c.Username != "" && !localhost(c.Host) {
+			return errors.New("server does not support STARTTLS, the password would be sent unencrypted")
+		}
+	}
+	if c.Username != "" {
+		// PlainAuth refuses to send the password without TLS unless the server is localhost
+		if err := client.Auth(smtp.PlainAuth("", c.Username, c.password(), c.Host)); err != nil {
+			return fmt.Errorf("authentication failed: %w", err)
+		}
+	}
+
+	from, _ := mail.ParseAddress(c.From)
+	if err := client.Mail(from.Address); err != nil {
+		return err
+	}
+	for _, to := range c.To {
+		address, _ := mail.ParseAddress(to)
+		if err := client.Rcpt(address.Address); err != nil {
+			return fmt.Errorf("recipient %s: %w", to, err)
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(c.message(n)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// Same as the check of smtp.PlainAuth, a password can be sent to localhost without TLS.
+func localhost(host string) bool {
+	return host == "localhost" || host == "127.0.0.1" || host == "::1"
+}
+
+func (c *EmailConfig) message(n Notification) []byte {
+	var b strings.Builder
+	header := func(key, value string) {
+		b.WriteString(key + ": " + value + "\r\n")
+	}
+	header("From", c.From)
+	header("To", strings.Join(c.To, ", "))
+	header("Subject", mime.QEncoding.Encode("utf-8", n.Title))
+	header("Date", time.Now().Format(time.RFC1123Z))
+	header("MIME-Version", "1.0")
+	header("Content-Type", "text/plain; charset=utf-8")
+	header("Content-Transfer-Encoding", "8bit")
+	header("X-Backup-Status", n.Status.String())
+	b.WriteString("\r\n")
+	// lines of SMTP end with CRLF, leading dots are escaped by the writer of the client
+	for _, line := range strings.Split(strings.TrimRight(n.Text, "\n"), "\n") {
+		b.WriteString(strings.TrimSuffix(line, "\r") + "\r\n")
+	}
+	return []byte(b.String())
+}
@@ -0,0 +1,177 @@
+package notify
+
+import (
+	"bufio"
+	"encoding/base64"
+	"net"
+	"strings"
+	"testing"
+)
+
+// a minimal SMTP server that accepts a single message
+type smtpServer struct {
+	listener net.Listener
+	// commands the client sent, without the message
+	commands []string
+	message  string
+	done     chan struct{}
+}
+
+func startSMTPServer(t *testing.T, host string) *smtpServer {
+	l, err := net.Listen("tcp", net.JoinHostPort(host, "0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &smtpServer{listener: l, done: make(chan struct{})}
+	t.Cleanup(func() { l.Close() })
+	go s.serve()
+	return s
+}
+
+func (s *smtpServer) port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (s *smtpServer) serve() {
+	defer close(s.done)
+	conn, err := s.listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) {
+		conn.Write([]byte(line + "\r\n"))
+	}
+
+	reply("220 localhost ESMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		s.commands = append(s.commands, line)
+		cmd, _, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(cmd) {
+		case "EHLO":
+			reply("250-localhost")
+			reply("250 AUTH PLAIN")
+		case "AUTH":
+			reply("235 authenticated")
+		case "MAIL", "RCPT":
+			reply("250 ok")
+		case "DATA":
+			reply("354 go ahead")
+			var message strings.Builder
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				message.WriteString(line)
+			}
+			s.message = message.String()
+			reply("250 queued")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 not implemented")
+		}
+	}
+}
+
+func TestEmail(t *testing.T) {
+	server := startSMTPServer(t, "127.0.0.1")
+	c := &Config{Email: &EmailConfig{
+		Host:     "127.0.0.1",
+		Port:     server.port(),
+		Username: "me",
+		Password: "secret",
+		From:     "Backup <backup@example.com>",
+		To:       []string{"me@example.com", "you@example.com"},
+	}}
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	results := Send(c, Notification{Status: StatusFailure, Title: "Backup failed", Text: "files failed\n.hidden failed"})
+	if len(results) != 1 || results[0].Err != nil {
+		t.Fatalf("unexpected results %+v", results)
+	}
+	<-server.done
+
+	auth := "AUTH PLAIN " + base64.StdEncoding.EncodeToString([]byte("\x00me\x00secret"))
+	for _, want := range []string{auth, "MAIL FROM:<backup@example.com>", "RCPT TO:<me@example.com>", "RCPT TO:<you@example.com>"} {
+		found := false
+		for _, cmd := range server.commands {
+			if strings.HasPrefix(cmd, want) {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("command %q not sent, got %q", want, server.commands)
+		}
+	}
+	for _, want := range []string{"Subject: Backup failed\r\n", "To: me@example.com, you@example.com\r\n", "X-Backup-Status: failure\r\n", "\r\n\r\nfiles failed\r\n..hidden failed\r\n"} {
+		if !strings.Contains(server.message, want) {
+			t.Errorf("message does not contain %q:\n%s", want, server.message)
+		}
+	}
+}
+
+func TestEmailRequiresStartTLS(t *testing.T) {
+	// any address of 127.0.0.0/8 is the loopback interface, but only 127.0.0.1 counts as localhost
+	server := startSMTPServer(t, "127.0.0.2")
+	c := &Config{Email: &EmailConfig{
+		Host:     "127.0.0.2",
+		Port:     server.port(),
+		Username: "me",
+		Password: "secret",
+		From:     "backup@example.com",
+		To:       []string{"me@example.com"},
+	}}
+	results := Send(c, Notification{Status: StatusFailure})
+	if len(results) != 1 || results[0].Err == nil || !strings.Contains(results[0].Err.Error(), "STARTTLS") {
+		t.Fatalf("expected a STARTTLS error, got %+v", results)
+	}
+	for _, cmd := range server.commands {
+		if strings.HasPrefix(cmd, "AUTH") {
+			t.Errorf("password sent without TLS: %q", cmd)
+		}
+	}
+}
+
+func TestEmailTrigger(t *testing.T) {
+	// nothing listens on the port, sending would fail
+	c := &Config{Email: &EmailConfig{Host: "127.0.0.1", Port: 1, From: "backup@example.com", To: []string{"me@example.com"}}}
+	if results := Send(c, Notification{Status: StatusWarnings}); len(results) != 0 {
+		t.Fatalf("sent on warnings with the default trigger: %+v", results)
+	}
+	results := Send(c, Notification{Status: StatusFailure})
+	if len(results) != 1 || results[0].Err == nil {
+		t.Fatalf("expected an error, got %+v", results)
+	}
+}
+
+func TestEmailValidate(t *testing.T) {
+	for _, c := range []EmailConfig{
+		{From: "backup@example.com", To: []string{"me@example.com"}},
+		{Host: "localhost", From: "not an address", To: []string{"me@example.com"}},
+		{Host: "localhost", From: "backup@example.com"},
+		{Host: "localhost", Port: 70000, From: "backup@example.com", To: []string{"me@example.com"}},
+		{Host: "localhost", From: "backup@example.com", To: []string{"me@example.com"}, On: "sometimes"},
+	} {
+		c := c
+		if err := (&Config{Email: &c}).Validate(); err == nil {
+			t.Errorf("no error for %+v", c)
+		}
+	}
+	if port := (&EmailConfig{TLS: true}).port(); port != 465 {
+		t.Errorf("default port with tls is %v, expected 465", port)
+	}
+}
@@ -0,0 +1,141 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+type WebhookConfig struct {
+	On  Trigger `json:"on,omitempty"`
+	URL string  `json:"url,omitempty"`
+	// e.g. {"Authorization": "Bearer ..."}
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// payload of webhooks
+type webhookPayload struct {
+	Title   string `json:"title"`
+	Message string `json:"message"`
+	// success, warnings or failure
+	Status string `json:"status"`
+	Data   any    `json:"data,omitempty"`
+}
+
+func (c *WebhookConfig) name() string {
+	return "webhook"
+}
+
+func (c *WebhookConfig) trigger() Trigger {
+	return c.On
+}
+
+func (c *WebhookConfig) validate() error {
+	return validateURL(c.URL)
+}
+
+func (c *WebhookConfig) send(n Notification) error {
+	body, err := json.Marshal(webhookPayload{Title: n.Title, Message: n.Text, Status: n.Status.String(), Data: n.Data})
+	if err != nil {
+		return err
+	}
+	headers := map[string]string{"Content-Type": "application/json"}
+	for k, v := range c.Headers {
+		headers[k] = v
+	}
+	return post(c.URL, headers, body)
+}
+
+type PushConfig struct {
+	On Trigger `json:"on,omitempty"`
+	// ntfy or gotify
+	Service string `json:"service,omitempty"`
+	// ntfy: URL of the topic, e.g. https://ntfy.sh/my-backups
+	// gotify: URL of the server, e.g. https://gotify.example.com
+	URL string `json:"url,omitempty"`
+	// ntfy: access token, optional; gotify: token of the application
+	Token string `json:"token,omitempty"`
+}
+
+func (c *PushConfig) name() string {
+	return c.Service
+}
+
+func (c *PushConfig) trigger() Trigger {
+	return c.On
+}
+
+func (c *PushConfig) validate() error {
+	switch c.Service {
+	case "ntfy":
+	case "gotify":
+		if c.Token == "" {
+			return fmt.Errorf("gotify needs the token of an application")
+		}
+	default:
+		return fmt.Errorf("invalid service %q: must be ntfy or gotify", c.Service)
+	}
+	return validateURL(c.URL)
+}
+
+func (c *PushConfig) send(n Notification) error {
+	if c.Service == "gotify" {
+		// priorities of gotify go from 0 to 10, clients show 8 and above with sound
+		priority := map[Status]int{StatusSuccess: 2, StatusWarnings: 5, StatusFailure: 8}[n.Status]
+		body, err := json.Marshal(map[string]any{"title": n.Title, "message": n.Text, "priority": priority})
+		if err != nil {
+			return err
+		}
+		headers := map[string]string{"Content-Type": "application/json", "X-Gotify-Key": c.Token}
+		return post(strings.TrimSuffix(c.URL, "/")+"/message", headers, body)
+	}
+
+	headers := map[string]string{
+		"Title":    n.Title,
+		"Priority": map[Status]string{StatusSuccess: "default", StatusWarnings: "default", StatusFailure: "high"}[n.Status],
+		"Tags":     map[Status]string{StatusSuccess: "white_check_mark", StatusWarnings: "warning", StatusFailure: "x"}[n.Status],
+	}
+	if c.Token != "" {
+		headers["Authorization"] = "Bearer " + c.Token
+	}
+	return post(c.URL, headers, []byte(n.Text))
+}
+
+func validateURL(s string) error {
+	if s == "" {
+		return errNoURL
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return fmt.Errorf("invalid url: %w", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid url %s: must be http(s)://host/...", s)
+	}
+	return nil
+}
+
+func post(url string, headers map[string]string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s responded with %s: %s", url, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package notify
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type request struct {
+	path    string
+	headers http.Header
+	body    []byte
+}
+
+// starts a server that records the requests it gets and responds with status
+func startServer(t *testing.T, status int) (*httptest.Server, chan request) {
+	requests := make(chan request, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- request{path: r.URL.Path, headers: r.Header, body: body}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return server, requests
+}
+
+func TestWebhook(t *testing.T) {
+	server, requests := startServer(t, http.StatusOK)
+	c := &Config{Webhook: &WebhookConfig{
+		On:      TriggerAlways,
+		URL:     server.URL + "/hook",
+		Headers: map[string]string{"Authorization": "Bearer abc"},
+	}}
+
+	results := Send(c, Notification{Status: StatusSuccess, Title: "Backup finished", Text: "all good", Data: map[string]int{"exitCode": 0}})
+	if len(results) != 1 || results[0].Err != nil {
+		t.Fatalf("unexpected results %+v", results)
+	}
+	r := <-requests
+	if r.path != "/hook" || r.headers.Get("Authorization") != "Bearer abc" || r.headers.Get("Content-Type") != "application/json" {
+		t.Fatalf("unexpected request %+v", r)
+	}
+	var payload struct {
+		Title   string         `json:"title"`
+		Message string         `json:"message"`
+		Status  string         `json:"status"`
+		Data    map[string]int `json:"data"`
+	}
+	if err := json.Unmarshal(r.body, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.Title != "Backup finished" || payload.Message != "all good" || payload.Status != "success" {
+		t.Fatalf("unexpected payload %s", r.body)
+	}
+	if _, ok := payload.Data["exitCode"]; !ok {
+		t.Fatalf("data missing in payload %s", r.body)
+	}
+}
+
+func TestWebhookError(t *testing.T) {
+	server, _ := startServer(t, http.StatusInternalServerError)
+	c := &Config{Webhook: &WebhookConfig{URL: server.URL}}
+	results := Send(c, Notification{Status: StatusFailure})
+	if len(results) != 1 || results[0].Err == nil {
+		t.Fatalf("expected an error, got %+v", results)
+	}
+}
+
+func TestNtfy(t *testing.T) {
+	server, requests := startServer(t, http.StatusOK)
+	c := &Config{Push: &PushConfig{On: TriggerWarnings, Service: "ntfy", URL: server.URL + "/backups", Token: "tk"}}
+
+	if results := Send(c, Notification{Status: StatusSuccess}); len(results) != 0 {
+		t.Fatalf("sent a success with trigger warnings: %+v", results)
+	}
+	results := Send(c, Notification{Status: StatusFailure, Title: "Backup failed", Text: "zip failed"})
+	if len(results) != 1 || results[0].Err != nil {
+		t.Fatalf("unexpected results %+v", results)
+	}
+	r := <-requests
+	if r.path != "/backups" || string(r.body) != "zip failed" {
+		t.Fatalf("unexpected request %+v", r)
+	}
+	if r.headers.Get("Title") != "Backup failed" || r.headers.Get("Priority") != "high" || r.headers.Get("Authorization") != "Bearer tk" {
+		t.Fatalf("unexpected headers %v", r.headers)
+	}
+}
+
+func TestGotify(t *testing.T) {
+	server, requests := startServer(t, http.StatusOK)
+	c := &Config{Push: &PushConfig{Service: "gotify", URL: server.URL + "/", Token: "app"}}
+
+	results := Send(c, Notification{Status: StatusFailure, Title: "Backup failed", Text: "zip failed"})
+	if len(results) != 1 || results[0].Err != nil {
+		t.Fatalf("unexpected results %+v", results)
+	}
+	r := <-requests
+	if r.path != "/message" || r.headers.Get("X-Gotify-Key") != "app" {
+		t.Fatalf("unexpected request %+v", r)
+	}
+	var payload struct {
+		Title    string `json:"title"`
+		Message  string `json:"message"`
+		Priority int    `json:"priority"`
+	}
+	if err := json.Unmarshal(r.body, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.Title != "Backup failed" || payload.Message != "zip failed" || payload.Priority != 8 {
+		t.Fatalf("unexpected payload %s", r.body)
+	}
+}
+
+func TestTest(t *testing.T) {
+	server, requests := startServer(t, http.StatusOK)
+	// the test notification ignores the triggers
+	c := &Config{
+		Webhook: &WebhookConfig{URL: server.URL + "/hook"},
+		Push:    &PushConfig{Service: "ntfy", URL: server.URL + "/topic"},
+	}
+	results := Test(c)
+	if len(results) != 2 || results[0].Err != nil || results[1].Err != nil {
+		t.Fatalf("unexpected results %+v", results)
+	}
+	if r := <-requests; r.path != "/hook" {
+		t.Fatalf("unexpected request %+v", r)
+	}
+	if r := <-requests; r.path != "/topic" {
+		t.Fatalf("unexpected request %+v", r)
+	}
+}
+
+func TestValidatePush(t *testing.T) {
+	for _, c := range []PushConfig{
+		{Service: "pushover", URL: "https://example.com"},
+		{Service: "ntfy", URL: "ftp://example.com/topic"},
+		{Service: "ntfy"},
+		{Service: "gotify", URL: "https://example.com"},
+	} {
+		c := c
+		if err := (&Config{Push: &c}).Validate(); err == nil {
+			t.Errorf("no error for %+v", c)
+		}
+	}
+}
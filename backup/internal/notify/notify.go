@@ -0,0 +1,171 @@
+// Package notify tells about finished backups by e-mail, webhook, push notification or on the desktop.
+//
+// Every channel has its own trigger, e.g. e-mails only when a backup failed and a desktop notification after every
+// backup. A channel that cannot send does not keep the others from trying.
+package notify
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+type Config struct {
+	Email   *EmailConfig   `json:"email,omitempty"`
+	Webhook *WebhookConfig `json:"webhook,omitempty"`
+	// ntfy or Gotify
+	Push    *PushConfig    `json:"push,omitempty"`
+	Desktop *DesktopConfig `json:"desktop,omitempty"`
+}
+
+// how long sending to a single channel may take
+const timeout = 30 * time.Second
+
+type Status int
+
+const (
+	StatusSuccess Status = iota
+	// the backup was created, but something might need a look, e.g. a repo failed or old backups could not be deleted
+	StatusWarnings
+	// the backup could not be created
+	StatusFailure
+)
+
+func (s Status) String() string {
+	switch s {
+	case StatusSuccess:
+		return "success"
+	case StatusWarnings:
+		return "warnings"
+	default:
+		return "failure"
+	}
+}
+
+// Trigger says after which backups a channel sends, failure if empty.
+type Trigger string
+
+const (
+	TriggerAlways Trigger = "always"
+	// failed backups and backups with warnings
+	TriggerWarnings Trigger = "warnings"
+	TriggerFailure  Trigger = "failure"
+)
+
+func (t Trigger) validate() error {
+	switch t {
+	case "", TriggerAlways, TriggerWarnings, TriggerFailure:
+		return nil
+	}
+	return fmt.Errorf("invalid trigger %q: must be always, warnings or failure", t)
+}
+
+func (t Trigger) matches(s Status) bool {
+	switch t {
+	case TriggerAlways:
+		return true
+	case TriggerWarnings:
+		return s != StatusSuccess
+	default:
+		return s == StatusFailure
+	}
+}
+
+type Notification struct {
+	Status Status
+	// e.g. "Backup on laptop failed"
+	Title string
+	// readable summary of the run
+	Text string
+	// sent as JSON by webhooks, e.g. the summary of the run
+	Data any
+}
+
+type channel interface {
+	name() string
+	trigger() Trigger
+	validate() error
+	send(n Notification) error
+}
+
+func (c *Config) channels() []channel {
+	var channels []channel
+	if c == nil {
+		return channels
+	}
+	// the nil checks are needed, a nil pointer in an interface is not a nil interface
+	if c.Email != nil {
+		channels = append(channels, c.Email)
+	}
+	if c.Webhook != nil {
+		channels = append(channels, c.Webhook)
+	}
+	if c.Push != nil {
+		channels = append(channels, c.Push)
+	}
+	if c.Desktop != nil {
+		channels = append(channels, c.Desktop)
+	}
+	return channels
+}
+
+// Enabled returns true if any channel is configured.
+func (c *Config) Enabled() bool {
+	return len(c.channels()) > 0
+}
+
+// Validate checks the config without sending anything.
+func (c *Config) Validate() error {
+	for _, ch := range c.channels() {
+		if err := ch.trigger().validate(); err != nil {
+			return fmt.Errorf("%s: %w", ch.name(), err)
+		}
+		if err := ch.validate(); err != nil {
+			return fmt.Errorf("%s: %w", ch.name(), err)
+		}
+	}
+	return nil
+}
+
+// Result of sending to a channel.
+type Result struct {
+	// e.g. "e-mail"
+	Channel string
+	Err     error
+}
+
+// Send sends n to every channel whose trigger matches its status.
+func Send(c *Config, n Notification) []Result {
+	var results []Result
+	for _, ch := range c.channels() {
+		if ch.trigger().matches(n.Status) {
+			results = append(results, send(ch, n))
+		}
+	}
+	return results
+}
+
+// Test sends a test notification to every channel regardless of its trigger.
+func Test(c *Config) []Result {
+	n := Notification{
+		Status: StatusSuccess,
+		Title:  "Backup test notification",
+		Text:   "Notifications of your backups arrive here.",
+		Data:   map[string]string{"test": "true"},
+	}
+	var results []Result
+	for _, ch := range c.channels() {
+		results = append(results, send(ch, n))
+	}
+	return results
+}
+
+func send(ch channel, n Notification) Result {
+	err := ch.validate()
+	if err == nil {
+		err = ch.send(n)
+	}
+	return Result{Channel: ch.name(), Err: err}
+}
+
+var errNoURL = errors.New("no url specified")
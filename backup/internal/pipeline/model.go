@@ -0,0 +1,370 @@
+package pipeline
+
+import (
+	"backup/internal/config"
+	"backup/internal/exec"
+	"backup/internal/style"
+	"fmt"
+	"time"
+
+	"github.com/charmbracelet/bubbles/help"
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/spinner"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+type state int
+
+const (
+	stateRunning state = iota
+	// a phase failed, waiting for the user to retry or continue
+	stateFailed
+	stateFinished
+	stateError
+)
+
+type Model struct {
+	state state
+
+	config config.Config
+	run    *Run
+
+	results []Result
+	// progress and result of the running phase
+	updates chan tea.Msg
+	// phase that is running or failed
+	current int
+	started time.Time
+	// selected phase when finished
+	cursor int
+	// state to return to from the error screen
+	errorReturn state
+
+	errorModel *exec.ErrorModel
+	spinner    spinner.Model
+	helpView   help.Model
+	keyMap     keyMap
+
+	styles style.Styles
+
+	width, height int
+}
+
+func NewModel(config config.Config, styles style.Styles) *Model {
+	helpView := help.New()
+	helpView.Styles = styles.HelpStyles
+
+	return &Model{
+		state:   stateRunning,
+		config:  config,
+		run:     NewRun(config, "TUI full backup"),
+		results: make([]Result, len(Phases)),
+		spinner: spinner.New(
+			spinner.WithSpinner(spinner.Dot),
+			spinner.WithStyle(styles.ListItemSelectedStyle),
+		),
+		helpView: helpView,
+		keyMap:   defaultKeyMap(),
+		styles:   styles,
+	}
+}
+
+func (m *Model) Init() tea.Cmd {
+	return tea.Batch(m.runPhase(0), m.spinner.Tick)
+}
+
+func (m *Model) runPhase(i int) tea.Cmd {
+	m.state = stateRunning
+	m.current = i
+	m.started = time.Now()
+	m.results[i] = Result{Status: StatusRunning}
+
+	phase := Phases[i]
+	run := m.run
+	// buffered so that the phase never has to wait for the UI, if an update is still pending the new one is dropped
+	updates := make(chan tea.Msg, 1)
+	go func() {
+		result := phase.Run(run, func(detail string) {
+			select {
+			case updates <- phaseProgress{index: i, detail: detail}:
+			default:
+			}
+		})
+		// make sure the final message is not dropped
+		for len(updates) > 0 {
+			time.Sleep(10 * time.Millisecond)
+		}
+		updates <- phaseResult{index: i, result: result}
+	}()
+	m.updates = updates
+	return waitForUpdate(updates)
+}
+
+// Continues with the phase after the current one or finishes if there is none.
+func (m *Model) next() tea.Cmd {
+	if m.current+1 < len(Phases) {
+		return m.runPhase(m.current + 1)
+	}
+	m.state = stateFinished
+	m.cursor = 0
+	m.run.Release()
+	return nil
+}
+
+// ReleaseLock releases the run lock if it is held, e.g. when the TUI quits during a phase.
+func (m *Model) ReleaseLock() {
+	m.run.Release()
+}
+
+func (m *Model) showError(i int, returnTo state) {
+	failure := m.results[i].Failure
+	if failure == nil {
+		return
+	}
+	m.state = stateError
+	m.errorReturn = returnTo
+	m.errorModel = exec.NewErrorModel(*failure, m.styles)
+	m.errorModel.SetSize(m.width, m.height)
+}
+
+func (m *Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	var cmd tea.Cmd
+
+	switch m.state {
+	case stateRunning:
+		switch msg := msg.(type) {
+		case phaseProgress:
+			m.results[msg.index].Detail = msg.detail
+			cmd = waitForUpdate(m.updates)
+		case phaseResult:
+			m.results[msg.index] = msg.result
+			if msg.result.Status == StatusFailed {
+				m.state = stateFailed
+			} else {
+				cmd = m.next()
+			}
+		case spinner.TickMsg:
+			m.spinner, cmd = m.spinner.Update(msg)
+		}
+
+	case stateFailed:
+		switch msg := msg.(type) {
+		case tea.KeyMsg:
+			switch {
+			case key.Matches(msg, m.keyMap.Retry):
+				cmd = tea.Batch(m.runPhase(m.current), m.spinner.Tick)
+			case key.Matches(msg, m.keyMap.Continue):
+				cmd = tea.Batch(m.next(), m.spinner.Tick)
+			case key.Matches(msg, m.keyMap.Details):
+				m.showError(m.current, stateFailed)
+			case key.Matches(msg, m.keyMap.Abort):
+				// a streamed backup would otherwise leave the partial archive behind
+				m.run.Abort()
+				cmd = done()
+			}
+		}
+
+	case stateFinished:
+		switch msg := msg.(type) {
+		case tea.KeyMsg:
+			switch {
+			case key.Matches(msg, m.keyMap.CursorUp):
+				if m.cursor > 0 {
+					m.cursor--
+				}
+			case key.Matches(msg, m.keyMap.CursorDown):
+				if m.cursor < len(Phases)-1 {
+					m.cursor++
+				}
+			case key.Matches(msg, m.keyMap.Details):
+				m.showError(m.cursor, stateFinished)
+			case key.Matches(msg, m.keyMap.Return):
+				cmd = done()
+			}
+		}
+
+	case stateError:
+		switch msg := msg.(type) {
+		case exec.Done:
+			m.state = m.errorReturn
+			m.errorModel = nil
+		default:
+			cmd = m.errorModel.Update(msg)
+		}
+	}
+
+	return m, cmd
+}
+
+func (m *Model) SetSize(width, height int) {
+	m.width = width
+	m.height = height
+	m.helpView.Width = width
+	if m.errorModel != nil {
+		m.errorModel.SetSize(width, height)
+	}
+}
+
+var okStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("#7ef542"))
+var failedStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("#de0d18"))
+var nameStyle = lipgloss.NewStyle().Width(10)
+var timeStyle = lipgloss.NewStyle().Width(8).Align(lipgloss.Right)
+
+func (m *Model) View() string {
+	if m.state == stateError {
+		return m.errorModel.View()
+	}
+
+	styles := m.styles
+
+	var lines []string
+	for i, phase := range Phases {
+		r := m.results[i]
+
+		var status string
+		switch r.Status {
+		case StatusPending:
+			status = "·"
+		case StatusRunning:
+			status = m.spinner.View()
+		case StatusDone:
+			status = okStyle.Render("✓")
+		case StatusFailed:
+			status = failedStyle.Render("x")
+		case StatusSkipped:
+			status = "-"
+		}
+
+		var duration string
+		switch r.Status {
+		case StatusRunning:
+			duration = time.Since(m.started).Round(time.Second).String()
+		case StatusDone, StatusFailed:
+			duration = r.Time.Round(100 * time.Millisecond).String()
+		}
+
+		detail := r.Detail
+		if detail == "" {
+			detail = r.Status.String()
+		}
+
+		prefix := "  "
+		if m.state == stateFinished && i == m.cursor {
+			prefix = styles.ListItemSelectedStyle.Render("> ")
+		}
+		line := fmt.Sprintf("%s%s %s%s  %s", prefix, status, nameStyle.Render(phase.Name), timeStyle.Render(duration), styles.ListItemDescriptionStyle.Render(detail))
+		lines = append(lines, line)
+	}
+
+	var header string
+	var helpKeys []key.Binding
+	switch m.state {
+	case stateRunning:
+		header = styles.NormalTextStyle.Render(fmt.Sprintf("Running phase %v of %v", m.current+1, len(Phases)))
+	case stateFailed:
+		header = styles.ErrorTextStyle.Render(fmt.Sprintf("%s failed. Retry or continue with the next phase?", Phases[m.current].Name))
+		helpKeys = m.keyMap.failedKeys()
+	case stateFinished:
+		failures := 0
+		for _, r := range m.results {
+			if r.Status == StatusFailed {
+				failures++
+			}
+		}
+		if failures == 0 {
+			header = styles.NormalTextStyle.Render("Backup finished successfully!")
+		} else {
+			header = styles.ErrorTextStyle.Render(fmt.Sprintf("Backup finished, %v phases failed.", failures))
+		}
+		helpKeys = m.keyMap.finishedKeys()
+	}
+
+	parts := []string{
+		styles.TitleStyle.Render("Full Backup"),
+		"",
+		header,
+		"",
+		lipgloss.JoinVertical(lipgloss.Left, lines...),
+	}
+	if len(helpKeys) > 0 {
+		parts = append(parts, "", m.helpView.ShortHelpView(helpKeys))
+	}
+	return lipgloss.JoinVertical(lipgloss.Left, parts...)
+}
+
+type phaseResult struct {
+	index  int
+	result Result
+}
+
+// detail of a phase that is still running
+type phaseProgress struct {
+	index  int
+	detail string
+}
+
+func waitForUpdate(updates chan tea.Msg) tea.Cmd {
+	return func() tea.Msg {
+		return <-updates
+	}
+}
+
+type Done struct{}
+
+func done() tea.Cmd {
+	return func() tea.Msg {
+		return Done{}
+	}
+}
+
+type keyMap struct {
+	CursorUp   key.Binding
+	CursorDown key.Binding
+	Retry      key.Binding
+	Continue   key.Binding
+	Details    key.Binding
+	Abort      key.Binding
+	Return     key.Binding
+}
+
+func defaultKeyMap() keyMap {
+	return keyMap{
+		CursorUp: key.NewBinding(
+			key.WithKeys("k", "up"),
+			key.WithHelp("k", "up"),
+		),
+		CursorDown: key.NewBinding(
+			key.WithKeys("j", "down"),
+			key.WithHelp("j", "down"),
+		),
+		Retry: key.NewBinding(
+			key.WithKeys("r"),
+			key.WithHelp("r", "retry"),
+		),
+		Continue: key.NewBinding(
+			key.WithKeys("c"),
+			key.WithHelp("c", "continue"),
+		),
+		Details: key.NewBinding(
+			key.WithKeys("d"),
+			key.WithHelp("d", "details"),
+		),
+		Abort: key.NewBinding(
+			key.WithKeys("esc"),
+			key.WithHelp("esc", "abort"),
+		),
+		Return: key.NewBinding(
+			key.WithKeys("enter"),
+			key.WithHelp("enter", "return"),
+		),
+	}
+}
+
+func (m keyMap) failedKeys() []key.Binding {
+	return []key.Binding{m.Retry, m.Continue, m.Details, m.Abort}
+}
+
+func (m keyMap) finishedKeys() []key.Binding {
+	return []key.Binding{m.CursorUp, m.CursorDown, m.Details, m.Return}
+}
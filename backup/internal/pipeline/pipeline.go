@@ -0,0 +1,374 @@
+package pipeline
+
+import (
+	"backup/internal/archive"
+	"backup/internal/commands"
+	"backup/internal/config"
+	"backup/internal/exec"
+	"backup/internal/files"
+	"backup/internal/fs"
+	"backup/internal/github"
+	"backup/internal/lock"
+	"backup/internal/manifest"
+	"backup/internal/stream"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+type Status int
+
+const (
+	StatusPending Status = iota
+	StatusRunning
+	StatusDone
+	StatusFailed
+	// the phase is not configured, e.g. there is no zip file
+	StatusSkipped
+)
+
+func (s Status) String() string {
+	switch s {
+	case StatusPending:
+		return "pending"
+	case StatusRunning:
+		return "running"
+	case StatusDone:
+		return "done"
+	case StatusFailed:
+		return "failed"
+	default:
+		return "skipped"
+	}
+}
+
+// A Phase is one part of a full backup, phases run in the order of Phases.
+type Phase struct {
+	Name string
+	run  func(backupDir string, config config.Config) Result
+	// used instead of run when the backup is streamed into the archive
+	stream func(b *stream.Backup, config config.Config) Result
+	// used instead of run and stream by phases after the archive was created, report updates the detail while running
+	upload func(r *Run, report func(detail string)) Result
+}
+
+// Phases are the same as the ones of the non-interactive script.
+var Phases = []Phase{
+	{Name: "GitHub", run: runGithub, stream: streamGithub},
+	{Name: "Files", run: runFiles, stream: streamFiles},
+	{Name: "Commands", run: runCommands, stream: streamCommands},
+	{Name: "Manifest", run: runManifest, stream: streamManifest},
+	{Name: "Zip", run: runZip, stream: streamZip},
+	{Name: "S3", upload: uploadS3},
+	{Name: "SSH", upload: uploadSSH},
+	{Name: "Drives", upload: copyToDrives},
+}
+
+// A Run is one full backup, it holds what the phases share.
+type Run struct {
+	config config.Config
+	// archive of a streamed backup, created by the first phase
+	stream *stream.Backup
+	// what runs the backup, shown to others that find the backup directory locked
+	owner string
+	lock  *lock.Lock
+	// archive created by the zip phase of this run, empty until then
+	archive string
+	// where messages about the run lock go, e.g. a stale lock, the log package if nil
+	Logf func(format string, a ...any)
+}
+
+func NewRun(config config.Config, owner string) *Run {
+	return &Run{config: config, owner: owner}
+}
+
+func (r *Run) logf(format string, a ...any) {
+	if r.Logf != nil {
+		r.Logf(format, a...)
+	} else {
+		log.Printf(format, a...)
+	}
+}
+
+// Lock takes the run lock of the backup directory, it returns a *lock.LockedError if another backup holds it.
+// Phases take the lock themselves if it is not held yet, it is held until Release.
+func (r *Run) Lock() error {
+	if r.lock != nil {
+		return nil
+	}
+	l, err := lock.Acquire(r.config.BackupDir, r.owner)
+	if err != nil {
+		return err
+	}
+	if l.Stale != nil {
+		r.logf("previous backup did not release the lock: %v", l.Stale)
+	}
+	r.lock = l
+	return nil
+}
+
+// Release releases the run lock, e.g. after the last phase.
+func (r *Run) Release() {
+	if err := r.lock.Release(); err != nil {
+		r.logf("error releasing lock: %v", err)
+	}
+	r.lock = nil
+}
+
+// Streamed returns true if the backup is written straight into the archive instead of the backup directory.
+func (r *Run) Streamed() bool {
+	return r.config.Zip.Stream
+}
+
+// Abort removes the partial archive of a streamed backup and releases the run lock.
+func (r *Run) Abort() {
+	if r.stream != nil {
+		r.stream.Abort()
+	}
+	r.Release()
+}
+
+type Result struct {
+	Status Status
+	// short summary or reason why the phase was skipped
+	Detail string
+	// details of the first failure, set if the phase failed
+	Failure *exec.Result
+	Time    time.Duration
+	// set by the zip phase, the archive that the phases after it upload
+	archive string
+}
+
+// Run runs a single phase of a run. Phases of the same run must not run concurrently.
+// onProgress is called with the detail of phases that report their progress.
+func (p Phase) Run(r *Run, onProgress func(detail string)) Result {
+	start := time.Now()
+	if err := r.Lock(); err != nil {
+		return failed([]string{"lock"}, err)
+	}
+	var result Result
+	if p.upload != nil {
+		if onProgress == nil {
+			onProgress = func(string) {}
+		}
+		result = p.upload(r, onProgress)
+	} else if r.Streamed() {
+		if r.stream == nil {
+			b, err := stream.Start(r.config.BackupDir, r.config.Zip, nil)
+			if err != nil {
+				return failed([]string{"zip"}, err)
+			}
+			r.stream = b
+		}
+		result = p.stream(r.stream, r.config)
+	} else {
+		result = p.run(r.config.BackupDir, r.config)
+	}
+	if result.archive != "" {
+		r.archive = result.archive
+	}
+	result.Time = time.Since(start)
+	return result
+}
+
+func skipped(detail string) Result {
+	return Result{Status: StatusSkipped, Detail: detail}
+}
+
+func failed(cmd []string, err error) Result {
+	return Result{Status: StatusFailed, Detail: err.Error(), Failure: &exec.Result{Cmd: cmd, ExitCode: -1, Err: err}}
+}
+
+func runGithub(backupDir string, config config.Config) Result {
+	if config.Github.Token == "" {
+		return skipped("personal access token not provided")
+	}
+	if err := exec.CommandAvailable("gh"); err != nil {
+		return failed([]string{"gh"}, fmt.Errorf("no valid gh (github cli) executable found: %w", err))
+	}
+
+	repos, err := github.LoadRepos(config.Github.Token)
+	if err != nil {
+		return failed([]string{"load repos"}, err)
+	}
+
+	githubDir := fs.JoinPath(backupDir, "github")
+	var cloned, existing, failures int
+	var failure *exec.Result
+	for _, repo := range repos {
+		// same as the script, existing clones are not touched
+		empty, err := fs.IsDirEmpty(fs.JoinPath(githubDir, repo.Name))
+		if err != nil || !empty {
+			existing++
+			continue
+		}
+		r := github.CloneRepo(repo, githubDir, config.Github.Token)
+		if r.Err != nil || r.ExitCode != 0 {
+			failures++
+			if failure == nil {
+				failure = &r
+			}
+			continue
+		}
+		cloned++
+	}
+
+	detail := fmt.Sprintf("%v repos cloned", cloned)
+	if existing > 0 {
+		detail = fmt.Sprintf("%s, %v already cloned", detail, existing)
+	}
+	if failures > 0 {
+		return Result{Status: StatusFailed, Detail: fmt.Sprintf("%s, %v failed", detail, failures), Failure: failure}
+	}
+	return Result{Status: StatusDone, Detail: detail}
+}
+
+func runFiles(backupDir string, config config.Config) Result {
+	if len(config.Files) == 0 {
+		return skipped("no files configured")
+	}
+
+	var jobs []files.Job
+	var failures []string
+	var failure *exec.Result
+	for _, path := range config.Files {
+		absPath, err := files.ValidatePath(path)
+		if err == nil {
+			var exists bool
+			exists, err = fs.Exists(absPath)
+			if err == nil && !exists {
+				err = errors.New("file or directory does not exist")
+			}
+		}
+		if err == nil {
+			target := files.Target(backupDir, absPath)
+			err = fs.CreateDir(fs.ParentPath(target))
+			if err == nil {
+				jobs = append(jobs, files.Job{Source: absPath, Target: target})
+				continue
+			}
+		}
+		failures = append(failures, path)
+		if failure == nil {
+			failure = &exec.Result{Cmd: []string{"copy", path}, ExitCode: -1, Err: err}
+		}
+	}
+
+	var progress files.Progress
+	results := files.CopyAll(jobs, files.Options{
+		Workers: config.CopyWorkers,
+		Exclude: config.Exclude,
+		OnProgress: func(p files.Progress) {
+			progress = p
+		},
+	})
+	for _, r := range results {
+		if r.Err != nil {
+			failures = append(failures, r.Job.Source)
+			if failure == nil {
+				failure = &exec.Result{Cmd: []string{"copy", r.Job.Source, r.Job.Target}, ExitCode: -1, Err: r.Err}
+			}
+		}
+	}
+
+	detail := fmt.Sprintf("%v files, %s", progress.FilesDone, fileSizeString(progress.BytesDone))
+	if len(failures) > 0 {
+		return Result{Status: StatusFailed, Detail: fmt.Sprintf("%s, %v paths failed", detail, len(failures)), Failure: failure}
+	}
+	return Result{Status: StatusDone, Detail: detail}
+}
+
+func runCommands(backupDir string, config config.Config) Result {
+	if len(config.Commands) == 0 {
+		return skipped("no commands configured")
+	}
+
+	var failures int
+	var failure *exec.Result
+	for _, c := range config.Commands {
+		r, err := commands.Run(backupDir, c)
+		if err != nil && r.Err == nil {
+			r.Err = err
+			r.ExitCode = -1
+		}
+		if len(r.Cmd) == 0 {
+			r.Cmd = []string{"sh", "-c", c.Run}
+		}
+		if r.Err != nil || r.ExitCode != 0 {
+			failures++
+			if failure == nil {
+				failure = &r
+			}
+		}
+	}
+
+	detail := fmt.Sprintf("%v of %v commands succeeded", len(config.Commands)-failures, len(config.Commands))
+	if failures > 0 {
+		return Result{Status: StatusFailed, Detail: detail, Failure: failure}
+	}
+	return Result{Status: StatusDone, Detail: detail}
+}
+
+func runManifest(backupDir string, config config.Config) Result {
+	exists, err := fs.DirExists(backupDir)
+	if err != nil {
+		return failed([]string{"create manifest"}, err)
+	}
+	if !exists {
+		return skipped("nothing was backed up")
+	}
+
+	m, err := manifest.Create(backupDir)
+	if err != nil {
+		return failed([]string{"create manifest"}, err)
+	}
+	if err := manifest.Write(backupDir, m); err != nil {
+		return failed([]string{"write manifest"}, err)
+	}
+	return Result{Status: StatusDone, Detail: fmt.Sprintf("%v files from %v repos", len(m.Files), len(m.Repos))}
+}
+
+func runZip(backupDir string, config config.Config) Result {
+	if config.Zip.File == "" {
+		return skipped("no zip file specified")
+	}
+	file, err := config.Zip.TargetFile(config.Zip.File)
+	if err != nil {
+		return failed([]string{"zip"}, fmt.Errorf("invalid zip file: %w", err))
+	}
+
+	opts, err := config.Zip.ArchiveOptions()
+	if err != nil {
+		return failed([]string{"zip"}, fmt.Errorf("invalid zip config: %w", err))
+	}
+
+	r, err := archive.Create(backupDir, file, opts)
+	if err != nil {
+		return failed([]string{"zip", backupDir, file}, err)
+	}
+	return Result{Status: StatusDone, Detail: zipDetail(r), archive: r.File}
+}
+
+func zipDetail(r archive.Result) string {
+	detail := fmt.Sprintf("%s (%s)", r.File, fileSizeString(r.ArchiveSize))
+	if r.Size > 0 {
+		detail = fmt.Sprintf("%s (%s, %.0f%%)", r.File, fileSizeString(r.ArchiveSize), float64(r.ArchiveSize)/float64(r.Size)*100)
+	}
+	if len(r.Volumes) > 1 {
+		detail = fmt.Sprintf("%s in %v volumes", detail, len(r.Volumes))
+	}
+	return detail
+}
+
+func fileSizeString(size int64) string {
+	if size >= 1024*1024*1024 {
+		return fmt.Sprintf("%.1fG", float64(size)/(1024*1024*1024))
+	} else if size >= 1024*1024 {
+		return fmt.Sprintf("%vM", size/(1024*1024))
+	} else if size >= 1024 {
+		return fmt.Sprintf("%vK", size/1024)
+	} else {
+		return fmt.Sprintf("%v", size)
+	}
+}
@@ -0,0 +1,149 @@
+package pipeline
+
+import (
+	"backup/internal/config"
+	"backup/internal/exec"
+	"backup/internal/files"
+	"backup/internal/fs"
+	"backup/internal/github"
+	"backup/internal/stream"
+	"errors"
+	"fmt"
+)
+
+// the phases of a streamed backup, everything is added to the archive instead of the backup directory
+
+func streamGithub(b *stream.Backup, config config.Config) Result {
+	if config.Github.Token == "" {
+		return skipped("personal access token not provided")
+	}
+	if err := exec.CommandAvailable("gh"); err != nil {
+		return failed([]string{"gh"}, fmt.Errorf("no valid gh (github cli) executable found: %w", err))
+	}
+
+	repos, err := github.LoadRepos(config.Github.Token)
+	if err != nil {
+		return failed([]string{"load repos"}, err)
+	}
+
+	var bundled, failures int
+	var failure *exec.Result
+	for _, repo := range repos {
+		r, err := b.Repo(repo, config.Github.Token)
+		if b.Err() != nil {
+			return failed([]string{"zip"}, b.Err())
+		}
+		if err != nil && r.Err == nil {
+			r.Err = err
+			r.ExitCode = -1
+		}
+		if r.Err != nil || r.ExitCode != 0 {
+			failures++
+			if failure == nil {
+				failure = &r
+			}
+			continue
+		}
+		bundled++
+	}
+
+	detail := fmt.Sprintf("%v repos bundled", bundled)
+	if failures > 0 {
+		return Result{Status: StatusFailed, Detail: fmt.Sprintf("%s, %v failed", detail, failures), Failure: failure}
+	}
+	return Result{Status: StatusDone, Detail: detail}
+}
+
+func streamFiles(b *stream.Backup, config config.Config) Result {
+	if len(config.Files) == 0 {
+		return skipped("no files configured")
+	}
+
+	added := len(b.Manifest().Files)
+	var failures []string
+	var failure *exec.Result
+	for _, path := range config.Files {
+		absPath, err := files.ValidatePath(path)
+		if err == nil {
+			var exists bool
+			exists, err = fs.Exists(absPath)
+			if err == nil && !exists {
+				err = errors.New("file or directory does not exist")
+			}
+		}
+		if err == nil {
+			r := b.Files(absPath, config.Exclude)
+			if b.Err() != nil {
+				return failed([]string{"zip"}, b.Err())
+			}
+			err = r.Err
+		}
+		if err != nil {
+			failures = append(failures, path)
+			if failure == nil {
+				failure = &exec.Result{Cmd: []string{"add", path}, ExitCode: -1, Err: err}
+			}
+		}
+	}
+
+	var size int64
+	newFiles := b.Manifest().Files[added:]
+	for _, f := range newFiles {
+		size += f.Size
+	}
+	detail := fmt.Sprintf("%v files, %s", len(newFiles), fileSizeString(size))
+	if len(failures) > 0 {
+		return Result{Status: StatusFailed, Detail: fmt.Sprintf("%s, %v paths failed", detail, len(failures)), Failure: failure}
+	}
+	return Result{Status: StatusDone, Detail: detail}
+}
+
+func streamCommands(b *stream.Backup, config config.Config) Result {
+	if len(config.Commands) == 0 {
+		return skipped("no commands configured")
+	}
+
+	var failures int
+	var failure *exec.Result
+	for _, c := range config.Commands {
+		r, err := b.Command(c)
+		if b.Err() != nil {
+			return failed([]string{"zip"}, b.Err())
+		}
+		if err != nil && r.Err == nil {
+			r.Err = err
+			r.ExitCode = -1
+		}
+		if len(r.Cmd) == 0 {
+			r.Cmd = []string{"sh", "-c", c.Run}
+		}
+		if r.Err != nil || r.ExitCode != 0 {
+			failures++
+			if failure == nil {
+				failure = &r
+			}
+		}
+	}
+
+	detail := fmt.Sprintf("%v of %v commands succeeded", len(config.Commands)-failures, len(config.Commands))
+	if failures > 0 {
+		return Result{Status: StatusFailed, Detail: detail, Failure: failure}
+	}
+	return Result{Status: StatusDone, Detail: detail}
+}
+
+func streamManifest(b *stream.Backup, config config.Config) Result {
+	m, err := b.WriteManifest()
+	if err != nil {
+		return failed([]string{"write manifest"}, err)
+	}
+	return Result{Status: StatusDone, Detail: fmt.Sprintf("%v files from %v repos", len(m.Files), len(m.Repos))}
+}
+
+func streamZip(b *stream.Backup, config config.Config) Result {
+	r, err := b.Close()
+	if err != nil {
+		return failed([]string{"zip", b.File()}, err)
+	}
+	return Result{Status: StatusDone, Detail: zipDetail(r), archive: r.File}
+}
@@ -0,0 +1,179 @@
+package pipeline
+
+import (
+	"backup/internal/archive"
+	"backup/internal/drive"
+	"backup/internal/exec"
+	"backup/internal/s3"
+	"backup/internal/ssh"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Returns the files of the archive from the zip phase of the run, or the result of the phase if there is nothing to
+// upload. An archive of an earlier run is never uploaded, e.g. if the zip phase failed and left the old file in place.
+func archiveFiles(r *Run, name string) ([]string, *Result) {
+	if r.config.Zip.File == "" {
+		result := skipped("no zip file specified")
+		return nil, &result
+	}
+	if r.archive == "" {
+		// the zip phase failed, like the script the phases after it are skipped
+		result := skipped("zip phase did not produce an archive")
+		return nil, &result
+	}
+	files, err := archive.Files(r.archive)
+	if err != nil {
+		result := failed([]string{name, r.archive}, err)
+		return nil, &result
+	}
+	return files, nil
+}
+
+func uploadS3(r *Run, report func(detail string)) Result {
+	config := r.config
+	if !config.S3.Enabled() {
+		return skipped("not configured")
+	}
+	files, result := archiveFiles(r, "upload")
+	if result != nil {
+		return *result
+	}
+
+	u, err := s3.Upload(config.S3, files, func(p s3.Progress) {
+		report(fmt.Sprintf("%s/%s %s", fileSizeString(p.BytesDone), fileSizeString(p.BytesTotal), p.Current))
+	})
+	if err != nil {
+		return failed([]string{"upload", files[0], config.S3.URL()}, err)
+	}
+	var size int64
+	for _, o := range u.Objects {
+		size += o.Size
+	}
+	detail := fmt.Sprintf("%v files (%s) to %s", len(u.Objects), fileSizeString(size), u.URL)
+	if len(u.Deleted) > 0 {
+		detail = fmt.Sprintf("%s, %v old objects deleted", detail, len(u.Deleted))
+	}
+	if u.DeleteErr != nil {
+		detail = fmt.Sprintf("%s, deleting old backups failed: %v", detail, u.DeleteErr)
+	}
+	return Result{Status: StatusDone, Detail: detail}
+}
+
+func uploadSSH(r *Run, report func(detail string)) Result {
+	config := r.config
+	if !config.SSH.Enabled() {
+		return skipped("not configured")
+	}
+	onProgress := func(p ssh.Progress) {
+		detail := fmt.Sprintf("%s/%s", fileSizeString(p.BytesDone), fileSizeString(p.BytesTotal))
+		if p.Current != "" {
+			detail += " " + p.Current
+		}
+		report(detail)
+	}
+
+	if config.SSH.SyncDir {
+		if r.Streamed() {
+			return failed([]string{"rsync"}, errors.New("cannot sync the backup directory, the backup was streamed into the archive"))
+		}
+		u, err := ssh.Sync(config.SSH, config.BackupDir, onProgress)
+		if err != nil {
+			return failed([]string{"rsync", config.BackupDir, config.SSH.URL()}, err)
+		}
+		return Result{Status: StatusDone, Detail: fmt.Sprintf("synced %s to %s", fileSizeString(u.Size), u.Target)}
+	}
+
+	files, result := archiveFiles(r, "upload")
+	if result != nil {
+		return *result
+	}
+	u, err := ssh.Upload(config.SSH, files, onProgress)
+	if err != nil {
+		return failed([]string{"upload", files[0], config.SSH.URL()}, err)
+	}
+	var size int64
+	for _, f := range u.Files {
+		size += f.Size
+	}
+	return Result{Status: StatusDone, Detail: fmt.Sprintf("%v files (%s) to %s", len(u.Files), fileSizeString(size), u.Target)}
+}
+
+// A drive that is not plugged in skips the phase, the detail shows which drive is the most out of date.
+func copyToDrives(r *Run, report func(detail string)) Result {
+	config := r.config
+	c := config.Removable
+	if !c.Enabled() {
+		return skipped("not configured")
+	}
+	mounts, err := c.Mounted()
+	if err != nil {
+		return failed([]string{"find drives"}, err)
+	}
+	if len(mounts) == 0 {
+		return skipped("none of the drives is mounted" + oldestDrive(c, nil))
+	}
+
+	onProgress := func(p drive.Progress) {
+		report(fmt.Sprintf("%s: %s/%s %s", p.Drive, fileSizeString(p.BytesDone), fileSizeString(p.BytesTotal), p.Current))
+	}
+	var results []drive.Result
+	if c.Snapshot {
+		if r.Streamed() {
+			return failed([]string{"copy"}, errors.New("cannot copy the backup directory, the backup was streamed into the archive"))
+		}
+		results, err = drive.CopyDir(c, config.BackupDir, onProgress)
+	} else {
+		files, result := archiveFiles(r, "copy")
+		if result != nil {
+			return *result
+		}
+		results, err = drive.Copy(c, files, onProgress)
+	}
+	if err != nil {
+		return failed([]string{"copy", c.Names()}, err)
+	}
+
+	var ids []string
+	var size int64
+	var failure *exec.Result
+	var failures int
+	for _, d := range results {
+		if d.Err != nil {
+			failures++
+			if failure == nil {
+				failure = &exec.Result{Cmd: []string{"copy", d.Drive.ID}, ExitCode: -1, Err: d.Err}
+			}
+			continue
+		}
+		ids = append(ids, d.Drive.ID)
+		size = d.Backup.Size
+	}
+	detail := fmt.Sprintf("%s to %s", fileSizeString(size), strings.Join(ids, ", "))
+	if len(ids) == 0 {
+		detail = "nothing copied"
+	}
+	if failures > 0 {
+		return Result{Status: StatusFailed, Detail: fmt.Sprintf("%s, %v drives failed", detail, failures), Failure: failure}
+	}
+	return Result{Status: StatusDone, Detail: detail + oldestDrive(c, ids)}
+}
+
+// e.g. ", BACKUP-B is the most out of date (14 days ago)", empty if it is one of the drives that were just written
+func oldestDrive(c *drive.Config, written []string) string {
+	status, err := c.Status()
+	if err != nil || len(status) < 2 {
+		return ""
+	}
+	for _, id := range written {
+		if status[0].ID == id {
+			return ""
+		}
+	}
+	newest := status[0].Record.Newest()
+	if newest == nil {
+		return fmt.Sprintf(", %s has no backups", status[0].ID)
+	}
+	return fmt.Sprintf(", %s is the most out of date (%s)", status[0].ID, drive.Age(newest.Created()))
+}
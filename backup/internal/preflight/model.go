@@ -0,0 +1,270 @@
+package preflight
+
+import (
+	"backup/internal/config"
+	"backup/internal/style"
+	"fmt"
+
+	"github.com/charmbracelet/bubbles/help"
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/spinner"
+	"github.com/charmbracelet/bubbles/viewport"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+type state int
+
+const (
+	stateLoading state = iota
+	stateReport
+)
+
+type Model struct {
+	state  state
+	config config.Config
+	report Report
+
+	viewport viewport.Model
+	spinner  spinner.Model
+	helpView help.Model
+	keyMap   keyMap
+
+	styles style.Styles
+
+	width  int
+	height int
+}
+
+func NewModel(config config.Config, styles style.Styles) *Model {
+	helpView := help.New()
+	helpView.Styles = styles.HelpStyles
+
+	keyMap := defaultKeyMap()
+
+	vp := viewport.New(0, 0)
+	vp.KeyMap = keyMap.viewportKeyMap()
+
+	return &Model{
+		state:    stateLoading,
+		config:   config,
+		viewport: vp,
+		spinner: spinner.New(
+			spinner.WithSpinner(spinner.Dot),
+			spinner.WithStyle(styles.ListItemSelectedStyle),
+		),
+		helpView: helpView,
+		keyMap:   keyMap,
+		styles:   styles,
+	}
+}
+
+func (m *Model) Init() tea.Cmd {
+	return tea.Batch(runCmd(m.config), m.spinner.Tick)
+}
+
+func (m *Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	var cmd tea.Cmd
+
+	switch m.state {
+	case stateLoading:
+		switch msg := msg.(type) {
+		case runResult:
+			m.state = stateReport
+			m.report = msg.report
+			m.viewport.SetContent(m.reportView())
+			m.viewport.GotoTop()
+		case spinner.TickMsg:
+			m.spinner, cmd = m.spinner.Update(msg)
+		case tea.KeyMsg:
+			if key.Matches(msg, m.keyMap.Return) {
+				cmd = done()
+			}
+		}
+	case stateReport:
+		switch msg := msg.(type) {
+		case tea.KeyMsg:
+			switch {
+			case key.Matches(msg, m.keyMap.Return):
+				cmd = done()
+			case key.Matches(msg, m.keyMap.Refresh):
+				m.state = stateLoading
+				cmd = tea.Batch(runCmd(m.config), m.spinner.Tick)
+			default:
+				m.viewport, cmd = m.viewport.Update(msg)
+			}
+		default:
+			m.viewport, cmd = m.viewport.Update(msg)
+		}
+	}
+
+	return m, cmd
+}
+
+func (m *Model) SetSize(width, height int) {
+	m.width = width
+	m.height = height
+	m.helpView.Width = width
+	// 2 lines for title, 2 lines for help
+	vpHeight := height - 4
+	if vpHeight < 2 {
+		vpHeight = 2
+	}
+	m.viewport.Width = width
+	m.viewport.Height = vpHeight
+}
+
+func (m *Model) View() string {
+	styles := m.styles
+
+	switch m.state {
+	case stateLoading:
+		return lipgloss.JoinVertical(
+			lipgloss.Left,
+			styles.TitleStyle.Render("Pre-flight Check"),
+			"",
+			fmt.Sprintf(
+				"%s %s",
+				styles.NormalTextStyle.UnsetWidth().Render("Estimating backup size"),
+				m.spinner.View(),
+			),
+		)
+	default:
+		return lipgloss.JoinVertical(
+			lipgloss.Left,
+			styles.TitleStyle.Render("Pre-flight Check"),
+			"",
+			m.viewport.View(),
+			m.helpView.ShortHelpView(m.keyMap.reportKeys()),
+		)
+	}
+}
+
+var sizeStyle = lipgloss.NewStyle().Width(8).Align(lipgloss.Right)
+var okStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("#7ef542"))
+var warningStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("#f5a442"))
+
+func (m *Model) reportView() string {
+	styles := m.styles
+	r := m.report
+
+	var status string
+	switch r.Status() {
+	case StatusOk:
+		status = okStyle.Render("There is enough free space for the backup.")
+	case StatusLowSpace:
+		status = warningStyle.Render("There is enough free space, but not much more.")
+	case StatusInsufficient:
+		status = styles.ErrorTextStyle.Render("There is not enough free space, the backup will likely fail.")
+	default:
+		status = warningStyle.Render("Free space could not be determined.")
+	}
+
+	lines := []string{status, ""}
+
+	for _, t := range r.Targets {
+		if t.Err != nil {
+			lines = append(lines, styles.ErrorTextStyle.Render(fmt.Sprintf("%s %s: %v", t.Name, t.Path, t.Err)))
+			continue
+		}
+		line := fmt.Sprintf("%s %s: needs %s, %s free (%s)", t.Name, t.Path, fileSizeString(t.Needed), fileSizeString(t.Free), t.Status)
+		switch t.Status {
+		case StatusOk:
+			lines = append(lines, line)
+		case StatusLowSpace:
+			lines = append(lines, warningStyle.Render(line))
+		default:
+			lines = append(lines, styles.ErrorTextStyle.UnsetWidth().Render(line))
+		}
+	}
+
+	lines = append(lines, "", styles.ListItemSelectedStyle.Render(fmt.Sprintf("%s total", fileSizeString(r.Total))))
+	if r.Existing > 0 {
+		lines = append(lines, styles.ListItemDescriptionStyle.Render(fmt.Sprintf("%s already in backup directory", fileSizeString(r.Existing))))
+	}
+	if r.GithubSkipped != "" {
+		lines = append(lines, styles.ListItemDescriptionStyle.Render(fmt.Sprintf("repos not included: %s", r.GithubSkipped)))
+	}
+	for _, s := range r.Sources {
+		if s.Err != nil {
+			lines = append(lines, styles.ErrorTextStyle.UnsetWidth().Render(fmt.Sprintf("%s %-6s %s: %v", sizeStyle.Render("-"), s.Kind, s.Name, s.Err)))
+		} else {
+			lines = append(lines, fmt.Sprintf("%s %-6s %s", sizeStyle.Render(fileSizeString(s.Size)), s.Kind, s.Name))
+		}
+	}
+
+	return lipgloss.JoinVertical(lipgloss.Left, lines...)
+}
+
+func fileSizeString(size int64) string {
+	if size >= 1024*1024*1024 {
+		return fmt.Sprintf("%.1fG", float64(size)/(1024*1024*1024))
+	} else if size >= 1024*1024 {
+		return fmt.Sprintf("%vM", size/(1024*1024))
+	} else if size >= 1024 {
+		return fmt.Sprintf("%vK", size/1024)
+	} else {
+		return fmt.Sprintf("%v", size)
+	}
+}
+
+type runResult struct {
+	report Report
+}
+
+func runCmd(config config.Config) tea.Cmd {
+	return func() tea.Msg {
+		return runResult{report: Run(config)}
+	}
+}
+
+type Done struct{}
+
+func done() tea.Cmd {
+	return func() tea.Msg {
+		return Done{}
+	}
+}
+
+type keyMap struct {
+	Up      key.Binding
+	Down    key.Binding
+	Refresh key.Binding
+	Return  key.Binding
+}
+
+func defaultKeyMap() keyMap {
+	return keyMap{
+		Up: key.NewBinding(
+			key.WithKeys("k"),
+			key.WithHelp("k", "up"),
+		),
+		Down: key.NewBinding(
+			key.WithKeys("j"),
+			key.WithHelp("j", "down"),
+		),
+		Refresh: key.NewBinding(
+			key.WithKeys("r"),
+			key.WithHelp("r", "refresh"),
+		),
+		Return: key.NewBinding(
+			key.WithKeys("enter", "esc"),
+			key.WithHelp("enter", "return"),
+		),
+	}
+}
+
+func (m keyMap) viewportKeyMap() viewport.KeyMap {
+	return viewport.KeyMap{
+		Up:           m.Up,
+		Down:         m.Down,
+		PageUp:       key.NewBinding(key.WithDisabled()),
+		PageDown:     key.NewBinding(key.WithDisabled()),
+		HalfPageUp:   key.NewBinding(key.WithDisabled()),
+		HalfPageDown: key.NewBinding(key.WithDisabled()),
+	}
+}
+
+func (m keyMap) reportKeys() []key.Binding {
+	return []key.Binding{m.Up, m.Down, m.Refresh, m.Return}
+}
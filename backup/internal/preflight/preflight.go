@@ -0,0 +1,210 @@
+package preflight
+
+import (
+	"backup/internal/config"
+	"backup/internal/files"
+	"backup/internal/fs"
+	"backup/internal/github"
+	"errors"
+	iofs "io/fs"
+	"path/filepath"
+)
+
+type Status int
+
+const (
+	StatusOk Status = iota
+	// there is enough space, but not much more
+	StatusLowSpace
+	StatusInsufficient
+	// free space could not be determined
+	StatusUnknown
+)
+
+func (s Status) String() string {
+	switch s {
+	case StatusOk:
+		return "ok"
+	case StatusLowSpace:
+		return "low space"
+	case StatusInsufficient:
+		return "insufficient space"
+	default:
+		return "unknown"
+	}
+}
+
+// A Source is something that will be written to the backup directory, e.g. a path from the config or a repo.
+type Source struct {
+	Kind string
+	Name string
+	Size int64
+	Err  error
+}
+
+// A Target is a location the backup is written to, e.g. the backup directory or the zip file.
+type Target struct {
+	Name string
+	Path string
+	Free int64
+	// includes other targets on the same filesystem
+	Needed int64
+	Status Status
+	Err    error
+}
+
+type Report struct {
+	Sources []Source
+	// reason why repos are not part of the estimate
+	GithubSkipped string
+	// size of the files that already are in the backup directory, they will end up in the zip file too
+	Existing int64
+	// size of all new files
+	Total   int64
+	Targets []Target
+}
+
+// how bad a status is, a target that is known to be too small is worse than one whose free space is unknown
+func (s Status) severity() int {
+	switch s {
+	case StatusInsufficient:
+		return 3
+	case StatusLowSpace:
+		return 2
+	case StatusUnknown:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// Status returns the worst status of all targets.
+func (r Report) Status() Status {
+	status := StatusOk
+	for _, t := range r.Targets {
+		if t.Status.severity() > status.severity() {
+			status = t.Status
+		}
+	}
+	return status
+}
+
+// if less than this fraction of the free space would remain, a target has low space
+const lowSpaceThreshold = 0.1
+
+// Run estimates the size of a backup and compares it against the free space on the filesystems of the backup directory and zip file.
+// Repo sizes are taken from the GitHub API and are only a rough estimate since they do not include the working tree.
+func Run(config config.Config) Report {
+	var report Report
+
+	backupDir := config.BackupDir
+
+	for _, source := range files.Expand(config.Files, config.Exclude) {
+		report.Sources = append(report.Sources, Source{
+			Kind: "files",
+			Name: source.Path,
+			Size: source.Size,
+			Err:  source.Err,
+		})
+		report.Total += source.Size
+	}
+
+	if config.Github.Token == "" {
+		report.GithubSkipped = "personal access token not provided"
+	} else {
+		repos, err := github.LoadRepos(config.Github.Token)
+		if err != nil {
+			report.GithubSkipped = "could not load repos: " + err.Error()
+		}
+		githubDir := fs.JoinPath(backupDir, "github")
+		for _, repo := range repos {
+			// existing clones are skipped when backing up, see script.backupGithub, streamed backups bundle every repo
+			empty, err := fs.IsDirEmpty(fs.JoinPath(githubDir, repo.Name))
+			if !config.Zip.Stream && (err != nil || !empty) {
+				continue
+			}
+			size := repo.Size * 1024
+			report.Sources = append(report.Sources, Source{Kind: "github", Name: repo.FullName, Size: size})
+			report.Total += size
+		}
+	}
+
+	// a streamed backup only writes the archive, the backup directory is not used at all
+	if config.Zip.Stream {
+		if config.Zip.File != "" {
+			target := Target{Name: "zip file", Path: config.Zip.File, Needed: report.Total}
+			target.Path, target.Err = fs.AbsPath(config.Zip.File)
+			report.Targets = append(report.Targets, checkTarget(target))
+		}
+		return report
+	}
+
+	existing, err := dirSize(backupDir)
+	if err == nil {
+		report.Existing = existing
+	}
+
+	backupTarget := Target{Name: "backup directory", Path: backupDir, Needed: report.Total}
+	var zipTarget *Target
+	if config.Zip.File != "" {
+		file, err := fs.AbsPath(config.Zip.File)
+		if err != nil {
+			zipTarget = &Target{Name: "zip file", Path: config.Zip.File, Err: err}
+		} else {
+			// upper bound, the zip file will be smaller because of compression
+			zipTarget = &Target{Name: "zip file", Path: file, Needed: report.Total + report.Existing}
+			same, err := fs.SameFilesystem(backupDir, file)
+			if err == nil && same {
+				backupTarget.Needed += zipTarget.Needed
+				zipTarget.Needed = backupTarget.Needed
+			}
+		}
+	}
+
+	report.Targets = append(report.Targets, checkTarget(backupTarget))
+	if zipTarget != nil {
+		report.Targets = append(report.Targets, checkTarget(*zipTarget))
+	}
+
+	return report
+}
+
+func checkTarget(t Target) Target {
+	if t.Err != nil {
+		t.Status = StatusUnknown
+		return t
+	}
+	free, err := fs.FreeSpace(t.Path)
+	if err != nil {
+		t.Err = err
+		t.Status = StatusUnknown
+		return t
+	}
+	t.Free = free
+	switch {
+	case t.Needed > free:
+		t.Status = StatusInsufficient
+	case float64(free-t.Needed) < float64(free)*lowSpaceThreshold:
+		t.Status = StatusLowSpace
+	default:
+		t.Status = StatusOk
+	}
+	return t
+}
+
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.Walk(dir, func(p string, info iofs.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	if errors.Is(err, iofs.ErrNotExist) {
+		return 0, nil
+	}
+	return size, err
+}
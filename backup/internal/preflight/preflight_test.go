@@ -0,0 +1,24 @@
+package preflight
+
+import "testing"
+
+func TestReportStatus(t *testing.T) {
+	for _, test := range []struct {
+		targets  []Status
+		expected Status
+	}{
+		{nil, StatusOk},
+		{[]Status{StatusOk, StatusUnknown}, StatusUnknown},
+		{[]Status{StatusUnknown, StatusLowSpace}, StatusLowSpace},
+		{[]Status{StatusInsufficient, StatusUnknown}, StatusInsufficient},
+		{[]Status{StatusUnknown, StatusInsufficient, StatusLowSpace}, StatusInsufficient},
+	} {
+		var report Report
+		for _, s := range test.targets {
+			report.Targets = append(report.Targets, Target{Status: s})
+		}
+		if status := report.Status(); status != test.expected {
+			t.Errorf("status of %v is %v, expected %v", test.targets, status, test.expected)
+		}
+	}
+}
@@ -0,0 +1,668 @@
+package restore
+
+import (
+	"backup/internal/fs"
+	"backup/internal/style"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/help"
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/list"
+	"github.com/charmbracelet/bubbles/spinner"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+type state int
+
+const (
+	stateInput state = iota
+	stateLoading
+	stateSelect
+	statePlanning
+	stateReview
+	stateRestoring
+	stateDone
+)
+
+type Model struct {
+	state      state
+	inputError error
+
+	backup *Backup
+	policy Policy
+	target string
+	items  []Item
+
+	backupInput textinput.Model
+	targetInput textinput.Model
+
+	filesList     list.Model
+	filesDelegate *fileItemDelegate
+	itemsList     list.Model
+
+	spinner  spinner.Model
+	helpView help.Model
+	keyMap   keyMap
+
+	styles style.Styles
+
+	width  int
+	height int
+}
+
+// The given path is used as the initial value of the backup input, e.g. the zip file from the config.
+func NewModel(path string, styles style.Styles) *Model {
+	bi := textinput.New()
+	bi.CharLimit = 250
+	bi.Width = 40
+	bi.SetValue(path)
+	bi.Focus()
+
+	ti := textinput.New()
+	ti.CharLimit = 250
+	ti.Width = 40
+	ti.Placeholder = "empty to restore to original locations"
+
+	helpView := help.New()
+	helpView.Styles = styles.HelpStyles
+
+	keyMap := defaultKeyMap()
+
+	filesDelegate := &fileItemDelegate{selected: map[string]struct{}{}}
+	filesList := newList(filesDelegate, keyMap)
+	itemsList := newList(planItemDelegate{}, keyMap)
+
+	return &Model{
+		state:         stateInput,
+		policy:        PolicySkip,
+		backupInput:   bi,
+		targetInput:   ti,
+		filesList:     filesList,
+		filesDelegate: filesDelegate,
+		itemsList:     itemsList,
+		spinner: spinner.New(
+			spinner.WithSpinner(spinner.Dot),
+			spinner.WithStyle(styles.ListItemSelectedStyle),
+		),
+		helpView: helpView,
+		keyMap:   keyMap,
+		styles:   styles,
+	}
+}
+
+func newList(delegate list.ItemDelegate, keyMap keyMap) list.Model {
+	l := list.New(nil, delegate, 0, 0)
+	l.SetShowHelp(false)
+	l.DisableQuitKeybindings()
+	l.SetShowStatusBar(false)
+	l.SetShowPagination(true)
+	l.SetShowTitle(false)
+	l.KeyMap = keyMap.listKeyMap()
+	return l
+}
+
+func (m *Model) Init() tea.Cmd {
+	return textinput.Blink
+}
+
+func (m *Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	var cmd tea.Cmd
+
+	switch m.state {
+	case stateInput:
+		cmd = m.updateInput(msg)
+	case stateLoading:
+		switch msg := msg.(type) {
+		case openResult:
+			if msg.err != nil {
+				m.state = stateInput
+				m.inputError = msg.err
+			} else {
+				m.state = stateSelect
+				m.backup = msg.backup
+				files := msg.backup.Files()
+				items := make([]list.Item, len(files))
+				// initially select all files
+				m.filesDelegate.selected = map[string]struct{}{}
+				for i, f := range files {
+					items[i] = f
+					m.filesDelegate.selected[f.Entry.Path] = struct{}{}
+				}
+				m.filesList.ResetFilter()
+				cmd = m.filesList.SetItems(items)
+			}
+		case spinner.TickMsg:
+			m.spinner, cmd = m.spinner.Update(msg)
+		}
+	case stateSelect:
+		cmd = m.updateSelect(msg)
+	case statePlanning:
+		switch msg := msg.(type) {
+		case planResult:
+			if msg.err != nil {
+				m.state = stateSelect
+				m.inputError = msg.err
+			} else {
+				m.state = stateReview
+				m.items = msg.items
+				cmd = m.setPlanItems()
+			}
+		case spinner.TickMsg:
+			m.spinner, cmd = m.spinner.Update(msg)
+		}
+	case stateReview:
+		switch msg := msg.(type) {
+		case tea.KeyMsg:
+			switch {
+			case key.Matches(msg, m.keyMap.ReviewRestore):
+				m.state = stateRestoring
+				cmd = tea.Batch(restoreCmd(m.backup, m.items), m.spinner.Tick)
+			case key.Matches(msg, m.keyMap.Back):
+				m.state = stateSelect
+			default:
+				m.itemsList, cmd = m.itemsList.Update(msg)
+			}
+		default:
+			m.itemsList, cmd = m.itemsList.Update(msg)
+		}
+	case stateRestoring:
+		switch msg := msg.(type) {
+		case restoreResult:
+			m.state = stateDone
+			m.items = msg.items
+			cmd = m.setPlanItems()
+		case spinner.TickMsg:
+			m.spinner, cmd = m.spinner.Update(msg)
+		}
+	case stateDone:
+		switch msg := msg.(type) {
+		case tea.KeyMsg:
+			if key.Matches(msg, m.keyMap.DoneReturn) {
+				m.backup.Close()
+				cmd = done()
+			} else {
+				m.itemsList, cmd = m.itemsList.Update(msg)
+			}
+		default:
+			m.itemsList, cmd = m.itemsList.Update(msg)
+		}
+	}
+
+	return m, cmd
+}
+
+func (m *Model) updateInput(msg tea.Msg) tea.Cmd {
+	var cmd tea.Cmd
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		switch {
+		case key.Matches(msg, m.keyMap.InputConfirm):
+			path := strings.TrimSpace(m.backupInput.Value())
+			if path == "" {
+				m.inputError = errors.New("backup path cannot be empty")
+				return nil
+			}
+			absPath, err := fs.AbsPath(path)
+			if err != nil {
+				m.inputError = err
+				return nil
+			}
+			m.target = strings.TrimSpace(m.targetInput.Value())
+			if m.target != "" {
+				absTarget, err := fs.AbsPath(m.target)
+				if err != nil {
+					m.inputError = err
+					return nil
+				}
+				m.target = absTarget
+			}
+			m.inputError = nil
+			m.state = stateLoading
+			return tea.Batch(openCmd(absPath), m.spinner.Tick)
+		case key.Matches(msg, m.keyMap.InputSwitch):
+			if m.backupInput.Focused() {
+				m.backupInput.Blur()
+				cmd = m.targetInput.Focus()
+			} else {
+				m.targetInput.Blur()
+				cmd = m.backupInput.Focus()
+			}
+			return cmd
+		case key.Matches(msg, m.keyMap.InputBack):
+			return done()
+		}
+	}
+
+	if m.backupInput.Focused() {
+		m.backupInput, cmd = m.backupInput.Update(msg)
+	} else {
+		m.targetInput, cmd = m.targetInput.Update(msg)
+	}
+	return cmd
+}
+
+func (m *Model) updateSelect(msg tea.Msg) tea.Cmd {
+	var cmd tea.Cmd
+
+	keyMsg, ok := msg.(tea.KeyMsg)
+	// while filtering every key has to go to the list
+	if !ok || m.filesList.FilterState() == list.Filtering {
+		m.filesList, cmd = m.filesList.Update(msg)
+		return cmd
+	}
+
+	switch {
+	case key.Matches(keyMsg, m.keyMap.Select):
+		if f, ok := m.filesList.SelectedItem().(File); ok {
+			if _, selected := m.filesDelegate.selected[f.Entry.Path]; selected {
+				delete(m.filesDelegate.selected, f.Entry.Path)
+			} else {
+				m.filesDelegate.selected[f.Entry.Path] = struct{}{}
+			}
+		}
+	case key.Matches(keyMsg, m.keyMap.SelectAll):
+		// only applies to the files that are currently visible, i.e. that match the filter
+		visible := m.filesList.VisibleItems()
+		allSelected := true
+		for _, item := range visible {
+			if _, ok := m.filesDelegate.selected[item.(File).Entry.Path]; !ok {
+				allSelected = false
+				break
+			}
+		}
+		for _, item := range visible {
+			p := item.(File).Entry.Path
+			if allSelected {
+				delete(m.filesDelegate.selected, p)
+			} else {
+				m.filesDelegate.selected[p] = struct{}{}
+			}
+		}
+	case key.Matches(keyMsg, m.keyMap.Policy):
+		m.policy = m.policy.Next()
+	case key.Matches(keyMsg, m.keyMap.Continue):
+		var paths []string
+		for _, f := range m.backup.Files() {
+			if _, ok := m.filesDelegate.selected[f.Entry.Path]; ok {
+				paths = append(paths, f.Original)
+			}
+		}
+		if len(paths) == 0 {
+			m.inputError = errors.New("no files selected")
+			return nil
+		}
+		m.inputError = nil
+		m.state = statePlanning
+		opts := Options{Target: m.target, Paths: paths, Policy: m.policy}
+		cmd = tea.Batch(planCmd(m.backup, opts), m.spinner.Tick)
+	case key.Matches(keyMsg, m.keyMap.Back):
+		if m.filesList.FilterState() == list.FilterApplied {
+			m.filesList.ResetFilter()
+			return nil
+		}
+		m.backup.Close()
+		m.backup = nil
+		m.state = stateInput
+	default:
+		m.filesList, cmd = m.filesList.Update(msg)
+	}
+	return cmd
+}
+
+func (m *Model) setPlanItems() tea.Cmd {
+	items := make([]list.Item, len(m.items))
+	for i, item := range m.items {
+		items[i] = item
+	}
+	return m.itemsList.SetItems(items)
+}
+
+func (m *Model) SetSize(width, height int) {
+	m.width = width
+	m.height = height
+	m.helpView.Width = width
+
+	// 2 lines for title, 3 lines for header, 4 lines for help, plus 2 empty lines
+	listHeight := height - 11
+	if listHeight < 2 {
+		listHeight = 2
+	}
+	m.filesList.SetSize(width, listHeight)
+	m.itemsList.SetSize(width, listHeight)
+}
+
+func (m *Model) View() string {
+	styles := m.styles
+	title := styles.TitleStyle.Render("Restore")
+
+	var parts []string
+	switch m.state {
+	case stateInput:
+		parts = []string{
+			title,
+			"",
+			styles.NormalTextStyle.Render("Backup directory or archive"),
+			m.backupInput.View(),
+			"",
+			styles.NormalTextStyle.Render("Restore below directory"),
+			m.targetInput.View(),
+			"",
+		}
+		if m.inputError != nil {
+			parts = append(parts, styles.ErrorTextStyle.Render(m.inputError.Error()), "")
+		}
+		parts = append(parts, m.helpView.ShortHelpView(m.keyMap.inputKeys()))
+	case stateLoading, statePlanning, stateRestoring:
+		text := "Opening backup"
+		if m.state == statePlanning {
+			text = "Checking existing files"
+		} else if m.state == stateRestoring {
+			text = "Restoring"
+		}
+		parts = []string{
+			title,
+			"",
+			fmt.Sprintf("%s %s", styles.NormalTextStyle.UnsetWidth().Render(text), m.spinner.View()),
+		}
+	case stateSelect:
+		target := "original locations"
+		if m.target != "" {
+			target = m.target
+		}
+		parts = []string{
+			title,
+			"",
+			styles.NormalTextStyle.Render(fmt.Sprintf("Select files to restore to %s", target)),
+			styles.NormalTextStyle.Render(fmt.Sprintf("%v of %v selected, existing files: %s", len(m.filesDelegate.selected), len(m.backup.Files()), m.policy)),
+			"",
+			m.filesList.View(),
+			"",
+		}
+		if m.inputError != nil {
+			parts = append(parts, styles.ErrorTextStyle.Render(m.inputError.Error()), "")
+		}
+		parts = append(parts, m.helpView.FullHelpView(m.keyMap.selectKeys()))
+	case stateReview:
+		parts = []string{
+			title,
+			"",
+			styles.NormalTextStyle.Render(fmt.Sprintf(
+				"%v to create, %v to overwrite, %v to keep both, %v skipped, %v unchanged",
+				Count(m.items, ActionCreate),
+				Count(m.items, ActionOverwrite),
+				Count(m.items, ActionKeepBoth),
+				Count(m.items, ActionSkip),
+				Count(m.items, ActionUnchanged),
+			)),
+			"",
+			m.itemsList.View(),
+			"",
+			m.helpView.ShortHelpView(m.keyMap.reviewKeys()),
+		}
+	case stateDone:
+		failed := Failed(m.items)
+		var text string
+		if failed == 0 {
+			text = styles.NormalTextStyle.Render("Restore finished successfully!")
+		} else {
+			text = styles.ErrorTextStyle.Render(fmt.Sprintf("%v files could not be restored.", failed))
+		}
+		parts = []string{
+			title,
+			"",
+			text,
+			"",
+			m.itemsList.View(),
+			"",
+			m.helpView.ShortHelpView(m.keyMap.doneKeys()),
+		}
+	}
+
+	return lipgloss.JoinVertical(lipgloss.Left, parts...)
+}
+
+type openResult struct {
+	backup *Backup
+	err    error
+}
+
+func openCmd(path string) tea.Cmd {
+	return func() tea.Msg {
+		backup, err := Open(path)
+		return openResult{backup: backup, err: err}
+	}
+}
+
+type planResult struct {
+	items []Item
+	err   error
+}
+
+func planCmd(backup *Backup, opts Options) tea.Cmd {
+	return func() tea.Msg {
+		items, err := backup.Plan(opts)
+		return planResult{items: items, err: err}
+	}
+}
+
+type restoreResult struct {
+	items []Item
+}
+
+func restoreCmd(backup *Backup, items []Item) tea.Cmd {
+	return func() tea.Msg {
+		return restoreResult{items: backup.Restore(items)}
+	}
+}
+
+var itemStyle = lipgloss.NewStyle().PaddingLeft(4)
+var selectedItemStyle = lipgloss.NewStyle().PaddingLeft(2).Foreground(lipgloss.Color("170"))
+var actionStyle = lipgloss.NewStyle().Width(10)
+var errorStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("#de0d18"))
+
+type fileItemDelegate struct {
+	selected map[string]struct{}
+}
+
+func (d fileItemDelegate) Height() int {
+	return 1
+}
+
+func (d fileItemDelegate) Spacing() int {
+	return 0
+}
+
+func (d fileItemDelegate) Update(msg tea.Msg, m *list.Model) tea.Cmd {
+	return nil
+}
+
+func (d fileItemDelegate) Render(w io.Writer, m list.Model, index int, listItem list.Item) {
+	f, ok := listItem.(File)
+	if !ok {
+		return
+	}
+
+	var s string
+	if _, selected := d.selected[f.Entry.Path]; selected {
+		s = fmt.Sprintf("[x] %s", f.Original)
+	} else {
+		s = fmt.Sprintf("[ ] %s", f.Original)
+	}
+
+	if index == m.Index() {
+		s = selectedItemStyle.Render("> " + s)
+	} else {
+		s = itemStyle.Render(s)
+	}
+	fmt.Fprint(w, s)
+}
+
+type planItemDelegate struct{}
+
+func (d planItemDelegate) Height() int {
+	return 1
+}
+
+func (d planItemDelegate) Spacing() int {
+	return 0
+}
+
+func (d planItemDelegate) Update(msg tea.Msg, m *list.Model) tea.Cmd {
+	return nil
+}
+
+func (d planItemDelegate) Render(w io.Writer, m list.Model, index int, listItem list.Item) {
+	item, ok := listItem.(Item)
+	if !ok {
+		return
+	}
+
+	var s string
+	if item.Err != nil {
+		s = fmt.Sprintf("%s %s %s", actionStyle.Render("error"), item.Dest, errorStyle.Render(item.Err.Error()))
+	} else {
+		s = fmt.Sprintf("%s %s", actionStyle.Render(item.Action.String()), item.Dest)
+	}
+
+	if index == m.Index() {
+		s = selectedItemStyle.Render("> " + s)
+	} else {
+		s = itemStyle.Render(s)
+	}
+	fmt.Fprint(w, s)
+}
+
+type Done struct{}
+
+func done() tea.Cmd {
+	return func() tea.Msg {
+		return Done{}
+	}
+}
+
+type keyMap struct {
+	InputConfirm key.Binding
+	InputSwitch  key.Binding
+	InputBack    key.Binding
+
+	CursorUp   key.Binding
+	CursorDown key.Binding
+	PrevPage   key.Binding
+	NextPage   key.Binding
+	Filter     key.Binding
+	Select     key.Binding
+	SelectAll  key.Binding
+	Policy     key.Binding
+	Continue   key.Binding
+	Back       key.Binding
+
+	ReviewRestore key.Binding
+
+	DoneReturn key.Binding
+}
+
+func defaultKeyMap() keyMap {
+	return keyMap{
+		InputConfirm: key.NewBinding(
+			key.WithKeys("enter"),
+			key.WithHelp("enter", "open"),
+		),
+		InputSwitch: key.NewBinding(
+			key.WithKeys("tab"),
+			key.WithHelp("tab", "switch input"),
+		),
+		InputBack: key.NewBinding(
+			key.WithKeys("esc"),
+			key.WithHelp("esc", "back"),
+		),
+		CursorUp: key.NewBinding(
+			key.WithKeys("k"),
+			key.WithHelp("k", "up"),
+		),
+		CursorDown: key.NewBinding(
+			key.WithKeys("j"),
+			key.WithHelp("j", "down"),
+		),
+		PrevPage: key.NewBinding(
+			key.WithKeys("h"),
+			key.WithHelp("h", "prev page"),
+		),
+		NextPage: key.NewBinding(
+			key.WithKeys("l"),
+			key.WithHelp("l", "next page"),
+		),
+		Filter: key.NewBinding(
+			key.WithKeys("/"),
+			key.WithHelp("/", "search"),
+		),
+		Select: key.NewBinding(
+			key.WithKeys(" "),
+			key.WithHelp("space", "(un)select"),
+		),
+		SelectAll: key.NewBinding(
+			key.WithKeys("a"),
+			key.WithHelp("a", "toggle all"),
+		),
+		Policy: key.NewBinding(
+			key.WithKeys("c"),
+			key.WithHelp("c", "change conflict policy"),
+		),
+		Continue: key.NewBinding(
+			key.WithKeys("enter"),
+			key.WithHelp("enter", "continue"),
+		),
+		Back: key.NewBinding(
+			key.WithKeys("esc"),
+			key.WithHelp("esc", "back"),
+		),
+		ReviewRestore: key.NewBinding(
+			key.WithKeys("enter"),
+			key.WithHelp("enter", "restore"),
+		),
+		DoneReturn: key.NewBinding(
+			key.WithKeys("enter"),
+			key.WithHelp("enter", "return"),
+		),
+	}
+}
+
+func (m keyMap) listKeyMap() list.KeyMap {
+	return list.KeyMap{
+		CursorUp:             m.CursorUp,
+		CursorDown:           m.CursorDown,
+		PrevPage:             m.PrevPage,
+		NextPage:             m.NextPage,
+		GoToStart:            key.NewBinding(key.WithDisabled()),
+		GoToEnd:              key.NewBinding(key.WithDisabled()),
+		Filter:               m.Filter,
+		ClearFilter:          key.NewBinding(key.WithDisabled()),
+		CancelWhileFiltering: key.NewBinding(key.WithKeys("esc")),
+		AcceptWhileFiltering: key.NewBinding(key.WithKeys("enter")),
+		ShowFullHelp:         key.NewBinding(key.WithDisabled()),
+		CloseFullHelp:        key.NewBinding(key.WithDisabled()),
+	}
+}
+
+func (m keyMap) inputKeys() []key.Binding {
+	return []key.Binding{m.InputBack, m.InputSwitch, m.InputConfirm}
+}
+
+func (m keyMap) selectKeys() [][]key.Binding {
+	return [][]key.Binding{
+		{m.CursorUp, m.CursorDown, m.PrevPage, m.NextPage, m.Filter},
+		{m.Select, m.SelectAll, m.Policy, m.Continue, m.Back},
+	}
+}
+
+func (m keyMap) reviewKeys() []key.Binding {
+	return []key.Binding{m.PrevPage, m.NextPage, m.Back, m.ReviewRestore}
+}
+
+func (m keyMap) doneKeys() []key.Binding {
+	return []key.Binding{m.PrevPage, m.NextPage, m.DoneReturn}
+}
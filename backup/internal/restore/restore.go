@@ -0,0 +1,396 @@
+package restore
+
+import (
+	"backup/internal/archive"
+	"backup/internal/fs"
+	"backup/internal/manifest"
+	"errors"
+	"fmt"
+	iofs "io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// What to do if a file that should be restored already exists.
+type Policy int
+
+const (
+	PolicySkip Policy = iota
+	PolicyOverwrite
+	// restore next to the existing file with a ".restored" suffix
+	PolicyKeepBoth
+	// overwrite only if the file in the backup has a more recent modification time
+	PolicyNewerWins
+)
+
+var policies = []Policy{PolicySkip, PolicyOverwrite, PolicyKeepBoth, PolicyNewerWins}
+
+func (p Policy) String() string {
+	switch p {
+	case PolicySkip:
+		return "skip"
+	case PolicyOverwrite:
+		return "overwrite"
+	case PolicyKeepBoth:
+		return "keep-both"
+	case PolicyNewerWins:
+		return "newer-wins"
+	default:
+		return "unknown"
+	}
+}
+
+// Next returns the policy after p, used to cycle through policies in the TUI.
+func (p Policy) Next() Policy {
+	return policies[(int(p)+1)%len(policies)]
+}
+
+func ParsePolicy(s string) (Policy, error) {
+	for _, p := range policies {
+		if p.String() == s {
+			return p, nil
+		}
+	}
+	return PolicySkip, fmt.Errorf("invalid conflict policy %q, must be one of skip, overwrite, keep-both, newer-wins", s)
+}
+
+type Action int
+
+const (
+	ActionCreate Action = iota
+	ActionOverwrite
+	ActionKeepBoth
+	ActionSkip
+	// target exists and has the same content
+	ActionUnchanged
+)
+
+func (a Action) String() string {
+	switch a {
+	case ActionCreate:
+		return "create"
+	case ActionOverwrite:
+		return "overwrite"
+	case ActionKeepBoth:
+		return "keep both"
+	case ActionSkip:
+		return "skip"
+	case ActionUnchanged:
+		return "unchanged"
+	default:
+		return "unknown"
+	}
+}
+
+// A File is a file of a backup that can be restored.
+type File struct {
+	Entry archive.Entry
+	// location the file was backed up from
+	Original string
+	// checksum from the manifest, empty if there is none
+	SHA256 string
+}
+
+// implement the Item interface from the bubbles/list package
+func (f File) FilterValue() string {
+	return f.Original
+}
+
+// A Backup is an opened backup directory or archive.
+type Backup struct {
+	reader      archive.Reader
+	hasManifest bool
+	files       []File
+}
+
+// Open opens a backup directory or archive for restoring.
+// Only local files can be restored, repos are not part of the result since they can just be cloned again.
+func Open(path string) (*Backup, error) {
+	r, err := archive.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &Backup{reader: r}
+
+	var manifestFiles map[string]manifest.File
+	if _, ok := archive.Find(r, manifest.FileName); ok {
+		m, err := loadManifest(r)
+		if err != nil {
+			r.Close()
+			return nil, err
+		}
+		b.hasManifest = true
+		manifestFiles = m.FileMap()
+	}
+
+	for _, e := range r.Entries() {
+		if !strings.HasPrefix(e.Path, "files/") {
+			continue
+		}
+		f := File{
+			Entry:    e,
+			Original: strings.TrimPrefix(e.Path, "files"),
+		}
+		// metadata from the manifest takes precedence, it was recorded before the backup was e.g. zipped.
+		// The original path always comes from the entry, the source in the manifest is derived from it and a
+		// modified manifest could otherwise send the file anywhere.
+		if mf, ok := manifestFiles[e.Path]; ok {
+			f.SHA256 = mf.SHA256
+			f.Entry.Mode = mf.Mode
+			f.Entry.ModTime = mf.ModTime
+		}
+		b.files = append(b.files, f)
+	}
+
+	return b, nil
+}
+
+func loadManifest(r archive.Reader) (manifest.Manifest, error) {
+	rc, err := r.Open(manifest.FileName)
+	if err != nil {
+		return manifest.Manifest{}, err
+	}
+	defer rc.Close()
+	return manifest.Decode(rc)
+}
+
+func (b *Backup) Files() []File {
+	return b.files
+}
+
+func (b *Backup) HasManifest() bool {
+	return b.hasManifest
+}
+
+func (b *Backup) Close() error {
+	return b.reader.Close()
+}
+
+type Options struct {
+	// if not empty, files are restored below this directory instead of their original location
+	// e.g. /home/user/abc will be restored to <Target>/home/user/abc
+	Target string
+	// original paths of files or directories to restore, everything is restored if empty
+	Paths  []string
+	Policy Policy
+}
+
+type Item struct {
+	File File
+	// where the file will be restored to
+	Dest   string
+	Action Action
+	Err    error
+	// the target of the plan, empty if files are restored to their original location
+	target string
+}
+
+// implement the Item interface from the bubbles/list package
+func (i Item) FilterValue() string {
+	return i.File.Original
+}
+
+// Plan determines what would happen to every selected file without changing anything.
+func (b *Backup) Plan(opts Options) ([]Item, error) {
+	var target string
+	if opts.Target != "" {
+		absTarget, err := fs.AbsPath(opts.Target)
+		if err != nil {
+			return nil, fmt.Errorf("invalid target: %w", err)
+		}
+		target = absTarget
+	}
+
+	paths := map[string]struct{}{}
+	for _, p := range opts.Paths {
+		absPath, err := fs.AbsPath(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid path: %w", err)
+		}
+		paths[absPath] = struct{}{}
+	}
+
+	var items []Item
+	for _, f := range b.files {
+		if !selected(f.Original, paths) {
+			continue
+		}
+		dest, err := destination(target, f.Original)
+		if err != nil {
+			items = append(items, Item{File: f, Dest: dest, Action: ActionSkip, Err: err})
+			continue
+		}
+		item := Item{File: f, Dest: dest, target: target}
+		item.Action, item.Err = action(f, dest, opts.Policy)
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// Returns where a file is restored to. Paths of a broken or malicious backup could point anywhere,
+// e.g. files/../../.bashrc, like archive.Extract they have to stay below the target.
+func destination(target string, original string) (string, error) {
+	if target == "" {
+		if !filepath.IsAbs(original) || filepath.Clean(original) != original {
+			return original, errors.New("original path is not a clean absolute path")
+		}
+		return original, nil
+	}
+	dest := filepath.Join(target, original)
+	rel, err := filepath.Rel(target, dest)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return dest, errors.New("path is outside of the target directory")
+	}
+	return dest, nil
+}
+
+// A file is selected if its path or one of its parent directories was given.
+func selected(path string, paths map[string]struct{}) bool {
+	if len(paths) == 0 {
+		return true
+	}
+	for {
+		if _, ok := paths[path]; ok {
+			return true
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return false
+		}
+		path = parent
+	}
+}
+
+func action(f File, dest string, policy Policy) (Action, error) {
+	info, err := os.Lstat(dest)
+	if err != nil {
+		if errors.Is(err, iofs.ErrNotExist) {
+			return ActionCreate, nil
+		}
+		return ActionSkip, err
+	}
+
+	if unchanged(f, dest, info) {
+		return ActionUnchanged, nil
+	}
+
+	switch policy {
+	case PolicyOverwrite:
+		return ActionOverwrite, nil
+	case PolicyKeepBoth:
+		return ActionKeepBoth, nil
+	case PolicyNewerWins:
+		if f.Entry.ModTime.After(info.ModTime()) {
+			return ActionOverwrite, nil
+		}
+		return ActionSkip, nil
+	default:
+		return ActionSkip, nil
+	}
+}
+
+// Without a checksum from the manifest we cannot tell if the content is the same.
+func unchanged(f File, dest string, info iofs.FileInfo) bool {
+	if f.Entry.IsSymlink() {
+		link, err := os.Readlink(dest)
+		return err == nil && link == f.Entry.Link
+	}
+	if f.SHA256 == "" || !info.Mode().IsRegular() || info.Size() != f.Entry.Size {
+		return false
+	}
+	hash, err := manifest.HashFile(dest)
+	return err == nil && hash == f.SHA256
+}
+
+// Restore executes a plan created with Plan, the error of every item that could not be restored is set.
+// Modes and modification times are restored where they were preserved.
+func (b *Backup) Restore(items []Item) []Item {
+	result := make([]Item, len(items))
+	// symlinks restored so far, files below them are not restored
+	links := map[string]struct{}{}
+	for i, item := range items {
+		if item.Err == nil {
+			switch item.Action {
+			case ActionCreate, ActionOverwrite:
+				item.Err = b.restoreFile(item, item.Dest, links)
+			case ActionKeepBoth:
+				dest, err := keepBothPath(item.Dest)
+				if err == nil {
+					item.Dest = dest
+					err = b.restoreFile(item, dest, links)
+				}
+				item.Err = err
+			}
+		}
+		result[i] = item
+	}
+	return result
+}
+
+func (b *Backup) restoreFile(item Item, dest string, links map[string]struct{}) error {
+	if err := checkParents(item.target, dest, links); err != nil {
+		return err
+	}
+	if err := archive.ExtractFile(b.reader, item.File.Entry, dest); err != nil {
+		return err
+	}
+	if item.File.Entry.IsSymlink() {
+		links[dest] = struct{}{}
+	}
+	return nil
+}
+
+// Returns an error if the file would be written through a symlink, e.g. a backup with files/x -> / followed by
+// files/x/etc/passwd. Below a target no symlink is followed, in the original location only the ones that were just
+// restored are refused since the parents of the original paths might be symlinks on purpose.
+func checkParents(target string, dest string, links map[string]struct{}) error {
+	if target != "" {
+		return archive.CheckParents(target, dest)
+	}
+	for dir := filepath.Dir(dest); ; dir = filepath.Dir(dir) {
+		if _, ok := links[dir]; ok {
+			return fmt.Errorf("%s is a symlink", dir)
+		}
+		if filepath.Dir(dir) == dir {
+			return nil
+		}
+	}
+}
+
+func keepBothPath(dest string) (string, error) {
+	candidate := dest + ".restored"
+	for i := 1; ; i++ {
+		exists, err := fs.Exists(candidate)
+		if err != nil {
+			return "", err
+		}
+		if !exists {
+			return candidate, nil
+		}
+		candidate = fmt.Sprintf("%s.restored.%v", dest, i)
+	}
+}
+
+// Count returns the number of items with the given action.
+func Count(items []Item, action Action) int {
+	n := 0
+	for _, item := range items {
+		if item.Action == action {
+			n++
+		}
+	}
+	return n
+}
+
+// Failed returns the number of items that have an error.
+func Failed(items []Item) int {
+	n := 0
+	for _, item := range items {
+		if item.Err != nil {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,154 @@
+package restore
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"backup/internal/manifest"
+)
+
+// writes a tar file with the given files and a manifest with their sources
+func writeArchive(t *testing.T, files map[string]string) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "backup.tar")
+	f, err := os.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	w := tar.NewWriter(f)
+
+	m := manifest.Manifest{Version: 1, Created: time.Now()}
+	for name, source := range files {
+		m.Files = append(m.Files, manifest.File{Path: name, Mode: 0o644, Source: source})
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	add := func(name string, content []byte) {
+		if err := w.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(content)), ModTime: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	add(manifest.FileName, data)
+	for name := range files {
+		add(name, []byte("content"))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestPlanOutsideTarget(t *testing.T) {
+	file := writeArchive(t, map[string]string{
+		"files/home/user/ok.txt":    "",
+		"files/../../.bashrc":       "",
+		"files/home/user/other.txt": "/home/user/../../../etc/passwd",
+	})
+	b, err := Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	target := t.TempDir()
+	for _, opts := range []Options{{}, {Target: target}} {
+		items, err := b.Plan(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(items) != 3 {
+			t.Fatalf("expected 3 items, got %+v", items)
+		}
+		for _, item := range items {
+			// the source from the manifest is ignored, the path comes from the entry
+			if item.File.Entry.Path == "files/home/user/other.txt" && item.File.Original != "/home/user/other.txt" {
+				t.Errorf("original of %s is %s", item.File.Entry.Path, item.File.Original)
+			}
+			ok := item.File.Entry.Path != "files/../../.bashrc"
+			if ok && item.Err != nil {
+				t.Errorf("unexpected error for %s: %v", item.File.Original, item.Err)
+			}
+			if !ok && item.Err == nil {
+				t.Errorf("no error for %s restored to %s", item.File.Original, item.Dest)
+			}
+		}
+	}
+
+	// the items with errors are left alone
+	items, err := b.Plan(Options{Target: target})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, item := range b.Restore(items) {
+		if item.Err == nil && item.Dest != filepath.Join(target, item.File.Original) {
+			t.Errorf("restored %s to %s", item.File.Original, item.Dest)
+		}
+	}
+	if _, err := os.Lstat(filepath.Join(target, "home/user/ok.txt")); err != nil {
+		t.Error(err)
+	}
+	if _, err := os.Lstat(filepath.Join(filepath.Dir(filepath.Dir(target)), ".bashrc")); err == nil {
+		t.Error(".bashrc was written outside of the target")
+	}
+}
+
+func TestRestoreThroughSymlink(t *testing.T) {
+	outside := t.TempDir()
+	file := filepath.Join(t.TempDir(), "backup.tar")
+	f, err := os.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := tar.NewWriter(f)
+	// the top level directory is the backup directory like in a created archive
+	if err := w.WriteHeader(&tar.Header{Name: "backup/files/x", Typeflag: tar.TypeSymlink, Linkname: outside, Mode: 0o777}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteHeader(&tar.Header{Name: "backup/files/x/passwd", Mode: 0o644, Size: 4}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("root")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	b, err := Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	items, err := b.Plan(Options{Target: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	items = b.Restore(items)
+	if len(items) != 2 || items[0].Err != nil || items[1].Err == nil {
+		t.Errorf("expected the symlink to be restored and the file below it to fail, got %+v", items)
+	}
+	if _, err := os.Lstat(filepath.Join(outside, "passwd")); err == nil {
+		t.Error("passwd was written through the symlink")
+	}
+
+	// without a target only the symlinks that were restored before are refused
+	links := map[string]struct{}{"/x": {}}
+	if err := checkParents("", "/x/etc/passwd", links); err == nil {
+		t.Error("no error for a file below a restored symlink")
+	}
+	if err := checkParents("", "/y/etc/passwd", links); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,416 @@
+package s3
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A Client sends signed requests to the bucket of a config.
+type Client struct {
+	endpoint  *url.URL
+	region    string
+	bucket    string
+	pathStyle bool
+	creds     credentials
+	http      *http.Client
+	// replaced in tests
+	now func() time.Time
+}
+
+func NewClient(c *Config) (*Client, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	endpoint, _ := c.endpoint()
+	creds, _ := c.credentials()
+	return &Client{
+		endpoint: endpoint,
+		region:   c.region(),
+		bucket:   c.Bucket,
+		// virtual hosted buckets need DNS entries, only AWS has them
+		pathStyle: c.PathStyle || !strings.HasSuffix(endpoint.Hostname(), ".amazonaws.com"),
+		creds:     creds,
+		http:      &http.Client{Timeout: 15 * time.Minute},
+		now:       time.Now,
+	}, nil
+}
+
+// Error is an error response of the server.
+type Error struct {
+	StatusCode int
+	Code       string `xml:"Code"`
+	Message    string `xml:"Message"`
+}
+
+func (e *Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("s3: %s", http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("s3: %s: %s", e.Code, e.Message)
+}
+
+// IsNotFound returns true if the object, upload or bucket does not exist.
+func IsNotFound(err error) bool {
+	var e *Error
+	return errors.As(err, &e) && e.StatusCode == http.StatusNotFound
+}
+
+// how often a request is sent at most, failed requests are retried after 1s, 2s, 4s...
+const attempts = 4
+
+// Sends a request for an object of the bucket, key "" is the bucket itself.
+// Server errors and network problems are retried, other error responses are returned as *Error.
+func (c *Client) do(method string, key string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	var err error
+	for i := 0; i < attempts; i++ {
+		if i > 0 {
+			time.Sleep(time.Second << (i - 1))
+		}
+		var resp *http.Response
+		resp, err = c.send(method, key, query, header, body)
+		if err != nil {
+			continue
+		}
+		if resp.StatusCode < 300 {
+			return resp, nil
+		}
+		err = readError(resp)
+		if resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+			return nil, err
+		}
+	}
+	return nil, err
+}
+
+func (c *Client) send(method string, key string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	u := *c.endpoint
+	p := "/" + key
+	if c.pathStyle {
+		p = "/" + c.bucket + p
+	} else {
+		u.Host = c.bucket + "." + u.Host
+	}
+	u.Path = strings.TrimSuffix(c.endpoint.Path, "/") + p
+	u.RawPath = escapePath(u.Path)
+	u.RawQuery = canonicalQuery(query)
+
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, values := range header {
+		req.Header[k] = values
+	}
+	sum := sha256.Sum256(body)
+	c.sign(req, hex.EncodeToString(sum[:]))
+	return c.http.Do(req)
+}
+
+func readError(resp *http.Response) error {
+	defer resp.Body.Close()
+	e := &Error{StatusCode: resp.StatusCode}
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	// HEAD responses have no body
+	xml.Unmarshal(b, e)
+	return e
+}
+
+// sign adds an AWS signature version 4 to the request, every header that is set is signed.
+func (c *Client) sign(req *http.Request, payloadHash string) {
+	now := c.now().UTC()
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", now.Format("20060102T150405Z"))
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if c.creds.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", c.creds.sessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := date + "/" + c.region + "/s3/aws4_request"
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + now.Format("20060102T150405Z") + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	key := hmacSHA256([]byte("AWS4"+c.creds.secretKey), date)
+	key = hmacSHA256(key, c.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", c.creds.accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// URI encoding of signature version 4, only unreserved characters are kept
+func escape(s string, keepSlash bool) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.' || c == '~' || (keepSlash && c == '/') {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func escapePath(p string) string {
+	return escape(p, true)
+}
+
+func canonicalQuery(query url.Values) string {
+	var params []string
+	for k, values := range query {
+		for _, v := range values {
+			params = append(params, escape(k, false)+"="+escape(v, false))
+		}
+	}
+	sort.Strings(params)
+	return strings.Join(params, "&")
+}
+
+// ObjectInfo is the metadata of an object.
+type ObjectInfo struct {
+	Key          string    `xml:"Key"`
+	Size         int64     `xml:"Size"`
+	ETag         string    `xml:"ETag"`
+	LastModified time.Time `xml:"LastModified"`
+}
+
+func (c *Client) Head(key string) (ObjectInfo, error) {
+	resp, err := c.do(http.MethodHead, key, nil, nil, nil)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	resp.Body.Close()
+	info := ObjectInfo{Key: key, Size: resp.ContentLength, ETag: resp.Header.Get("ETag")}
+	info.LastModified, _ = http.ParseTime(resp.Header.Get("Last-Modified"))
+	return info, nil
+}
+
+// Put uploads a small object in a single request.
+func (c *Client) Put(key string, data []byte, header http.Header) (string, error) {
+	header = header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	sum := md5.Sum(data)
+	header.Set("Content-Md5", base64.StdEncoding.EncodeToString(sum[:]))
+	resp, err := c.do(http.MethodPut, key, nil, header, data)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	return resp.Header.Get("ETag"), nil
+}
+
+func (c *Client) Delete(key string) error {
+	resp, err := c.do(http.MethodDelete, key, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// List returns all objects whose key starts with prefix.
+func (c *Client) List(prefix string) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		var result struct {
+			Contents              []ObjectInfo `xml:"Contents"`
+			IsTruncated           bool         `xml:"IsTruncated"`
+			NextContinuationToken string       `xml:"NextContinuationToken"`
+		}
+		if err := c.getXML("", query, &result); err != nil {
+			return nil, err
+		}
+		objects = append(objects, result.Contents...)
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return objects, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+func (c *Client) getXML(key string, query url.Values, v any) error {
+	resp, err := c.do(http.MethodGet, key, query, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return xml.NewDecoder(resp.Body).Decode(v)
+}
+
+func (c *Client) postXML(key string, query url.Values, header http.Header, body []byte, v any) error {
+	resp, err := c.do(http.MethodPost, key, query, header, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	// complete multipart upload can fail after the status 200 was sent, the error is in the body then
+	if bytes.Contains(b, []byte("<Error>")) {
+		e := &Error{StatusCode: resp.StatusCode}
+		xml.Unmarshal(b, e)
+		return e
+	}
+	if v == nil {
+		return nil
+	}
+	return xml.Unmarshal(b, v)
+}
+
+// CreateUpload starts a multipart upload and returns its id.
+func (c *Client) CreateUpload(key string, header http.Header) (string, error) {
+	var result struct {
+		UploadID string `xml:"UploadId"`
+	}
+	err := c.postXML(key, url.Values{"uploads": {""}}, header, nil, &result)
+	return result.UploadID, err
+}
+
+// FindUpload returns the id of the latest unfinished multipart upload of key, "" if there is none.
+func (c *Client) FindUpload(key string) (string, error) {
+	var result struct {
+		Uploads []struct {
+			Key       string    `xml:"Key"`
+			UploadID  string    `xml:"UploadId"`
+			Initiated time.Time `xml:"Initiated"`
+		} `xml:"Upload"`
+	}
+	if err := c.getXML("", url.Values{"uploads": {""}, "prefix": {key}}, &result); err != nil {
+		return "", err
+	}
+	id := ""
+	var latest time.Time
+	for _, u := range result.Uploads {
+		if u.Key == key && !u.Initiated.Before(latest) {
+			id = u.UploadID
+			latest = u.Initiated
+		}
+	}
+	return id, nil
+}
+
+type Part struct {
+	Number int    `xml:"PartNumber"`
+	ETag   string `xml:"ETag"`
+	Size   int64  `xml:"Size"`
+}
+
+// Parts returns the parts that were uploaded so far.
+func (c *Client) Parts(key string, uploadID string) ([]Part, error) {
+	var parts []Part
+	marker := ""
+	for {
+		query := url.Values{"uploadId": {uploadID}}
+		if marker != "" {
+			query.Set("part-number-marker", marker)
+		}
+		var result struct {
+			Parts                []Part `xml:"Part"`
+			IsTruncated          bool   `xml:"IsTruncated"`
+			NextPartNumberMarker string `xml:"NextPartNumberMarker"`
+		}
+		if err := c.getXML(key, query, &result); err != nil {
+			return nil, err
+		}
+		parts = append(parts, result.Parts...)
+		if !result.IsTruncated || result.NextPartNumberMarker == "" {
+			return parts, nil
+		}
+		marker = result.NextPartNumberMarker
+	}
+}
+
+// UploadPart uploads part n of a multipart upload, parts are numbered from 1.
+func (c *Client) UploadPart(key string, uploadID string, n int, data []byte) (string, error) {
+	sum := md5.Sum(data)
+	header := http.Header{"Content-Md5": {base64.StdEncoding.EncodeToString(sum[:])}}
+	query := url.Values{"uploadId": {uploadID}, "partNumber": {strconv.Itoa(n)}}
+	resp, err := c.do(http.MethodPut, key, query, header, data)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	return resp.Header.Get("ETag"), nil
+}
+
+// CompleteUpload joins the parts to the object.
+func (c *Client) CompleteUpload(key string, uploadID string, parts []Part) error {
+	type part struct {
+		Number int    `xml:"PartNumber"`
+		ETag   string `xml:"ETag"`
+	}
+	request := struct {
+		XMLName xml.Name `xml:"CompleteMultipartUpload"`
+		Parts   []part   `xml:"Part"`
+	}{}
+	for _, p := range parts {
+		request.Parts = append(request.Parts, part{Number: p.Number, ETag: p.ETag})
+	}
+	body, err := xml.Marshal(request)
+	if err != nil {
+		return err
+	}
+	return c.postXML(key, url.Values{"uploadId": {uploadID}}, nil, body, nil)
+}
+
+// AbortUpload removes a multipart upload and its parts.
+func (c *Client) AbortUpload(key string, uploadID string) error {
+	resp, err := c.do(http.MethodDelete, key, url.Values{"uploadId": {uploadID}}, nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
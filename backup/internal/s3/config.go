@@ -0,0 +1,156 @@
+// Package s3 uploads finished archives to S3 compatible object storage, e.g. AWS S3 or a MinIO server.
+//
+// Every upload goes to its own folder <prefix>/<time of the archive>/ so that older backups can be deleted by
+// retention rules. Large files are uploaded in parts, an interrupted upload continues with the missing parts when
+// the same archive is uploaded again.
+package s3
+
+import (
+	"backup/internal/volume"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+)
+
+type Config struct {
+	// e.g. http://localhost:9000 for MinIO, AWS_ENDPOINT_URL or AWS S3 of the region if empty
+	Endpoint string `json:"endpoint,omitempty"`
+	// AWS_REGION or us-east-1 if empty
+	Region string `json:"region,omitempty"`
+	Bucket string `json:"bucket,omitempty"`
+	// folder in the bucket, e.g. laptop/backups
+	Prefix string `json:"prefix,omitempty"`
+	// AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY are used if empty
+	AccessKey string `json:"accessKey,omitempty"`
+	SecretKey string `json:"secretKey,omitempty"`
+	// bucket in the path instead of the host name, always used for endpoints other than AWS
+	PathStyle bool `json:"pathStyle,omitempty"`
+	// size of the parts of large files, e.g. 64M, defaults to 16M
+	PartSize string `json:"partSize,omitempty"`
+	// server-side encryption, AES256 or aws:kms
+	SSE string `json:"sse,omitempty"`
+	// key for aws:kms, the default key of the bucket if empty
+	KMSKeyID string `json:"kmsKeyId,omitempty"`
+	// number of backups to keep, older ones are deleted after an upload, 0 keeps all
+	Keep int `json:"keep,omitempty"`
+	// backups older than this many days are deleted after an upload, the newest one is always kept, 0 keeps all
+	KeepDays int `json:"keepDays,omitempty"`
+}
+
+const (
+	DefaultRegion   = "us-east-1"
+	DefaultPartSize = 16 * 1024 * 1024
+	// limits of S3
+	minPartSize = 5 * 1024 * 1024
+	maxParts    = 10000
+)
+
+// Enabled returns true if archives should be uploaded.
+func (c *Config) Enabled() bool {
+	return c != nil && c.Bucket != ""
+}
+
+type credentials struct {
+	accessKey    string
+	secretKey    string
+	sessionToken string
+}
+
+// credentials from the config take precedence over the environment
+func (c *Config) credentials() (credentials, error) {
+	creds := credentials{accessKey: c.AccessKey, secretKey: c.SecretKey}
+	if creds.accessKey == "" && creds.secretKey == "" {
+		creds = credentials{
+			accessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+			secretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		}
+	}
+	if creds.accessKey == "" || creds.secretKey == "" {
+		return creds, errors.New("no credentials, set accessKey and secretKey or AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+	return creds, nil
+}
+
+func (c *Config) region() string {
+	for _, r := range []string{c.Region, os.Getenv("AWS_REGION"), os.Getenv("AWS_DEFAULT_REGION")} {
+		if r != "" {
+			return r
+		}
+	}
+	return DefaultRegion
+}
+
+func (c *Config) endpoint() (*url.URL, error) {
+	endpoint := c.Endpoint
+	if endpoint == "" {
+		endpoint = os.Getenv("AWS_ENDPOINT_URL")
+	}
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", c.region())
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid endpoint: %w", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid endpoint %s: must be http(s)://host[:port]", endpoint)
+	}
+	return u, nil
+}
+
+func (c *Config) partSize() (int64, error) {
+	if c.PartSize == "" {
+		return DefaultPartSize, nil
+	}
+	size, err := volume.ParseSize(c.PartSize)
+	if err != nil {
+		return 0, fmt.Errorf("invalid part size: %w", err)
+	}
+	if size < minPartSize {
+		return 0, errors.New("invalid part size: must be at least 5M")
+	}
+	return size, nil
+}
+
+// Validate checks the config without connecting to the server.
+func (c *Config) Validate() error {
+	if !c.Enabled() {
+		return errors.New("no bucket specified")
+	}
+	if _, err := c.endpoint(); err != nil {
+		return err
+	}
+	if _, err := c.partSize(); err != nil {
+		return err
+	}
+	switch c.SSE {
+	case "", "AES256", "aws:kms":
+	default:
+		return fmt.Errorf("invalid sse %q: must be AES256 or aws:kms", c.SSE)
+	}
+	if c.KMSKeyID != "" && c.SSE != "aws:kms" {
+		return errors.New("kmsKeyId needs sse aws:kms")
+	}
+	if c.Keep < 0 || c.KeepDays < 0 {
+		return errors.New("keep and keepDays cannot be negative")
+	}
+	_, err := c.credentials()
+	return err
+}
+
+// URL returns where backups are uploaded to, e.g. s3://bucket/prefix/.
+func (c *Config) URL() string {
+	return fmt.Sprintf("s3://%s/%s", c.Bucket, c.prefix())
+}
+
+// the prefix with a trailing slash, empty for the root of the bucket
+func (c *Config) prefix() string {
+	p := strings.Trim(c.Prefix, "/")
+	if p == "" {
+		return ""
+	}
+	return p + "/"
+}
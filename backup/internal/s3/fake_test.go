@@ -0,0 +1,235 @@
+package s3
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeS3 is an in-memory S3 server for a single bucket with path style requests. It knows the requests of the
+// client: objects, listing and multipart uploads, signatures are not checked.
+type fakeS3 struct {
+	bucket  string
+	mu      sync.Mutex
+	objects map[string]fakeObject
+	uploads map[string]*fakeUpload
+	next    int
+}
+
+type fakeObject struct {
+	data     []byte
+	etag     string
+	modified time.Time
+}
+
+type fakeUpload struct {
+	key       string
+	initiated time.Time
+	parts     map[int][]byte
+}
+
+// starts a fake server and returns a config for it
+func startFakeS3(t *testing.T) *Config {
+	f := &fakeS3{bucket: "backup-test", objects: map[string]fakeObject{}, uploads: map[string]*fakeUpload{}}
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+	return &Config{Endpoint: server.URL, Bucket: f.bucket, AccessKey: "test", SecretKey: "test"}
+}
+
+func etag(data []byte) string {
+	sum := md5.Sum(data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+func writeXML(w http.ResponseWriter, v any) {
+	b, err := xml.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.Write(b)
+}
+
+func writeError(w http.ResponseWriter, status int, code string, message string) {
+	w.WriteHeader(status)
+	writeXML(w, struct {
+		XMLName xml.Name `xml:"Error"`
+		Code    string   `xml:"Code"`
+		Message string   `xml:"Message"`
+	}{Code: code, Message: message})
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket != f.bucket {
+		writeError(w, http.StatusNotFound, "NoSuchBucket", bucket)
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "IncompleteBody", err.Error())
+		return
+	}
+	if md5sum := r.Header.Get("Content-Md5"); md5sum != "" {
+		sum := md5.Sum(body)
+		if md5sum != base64.StdEncoding.EncodeToString(sum[:]) {
+			writeError(w, http.StatusBadRequest, "BadDigest", key)
+			return
+		}
+	}
+	query := r.URL.Query()
+
+	switch {
+	case key == "" && r.Method == http.MethodGet && query.Has("uploads"):
+		f.listUploads(w, query.Get("prefix"))
+	case key == "" && r.Method == http.MethodGet:
+		f.list(w, query.Get("prefix"))
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		f.next++
+		id := strconv.Itoa(f.next)
+		f.uploads[id] = &fakeUpload{key: key, initiated: time.Now(), parts: map[int][]byte{}}
+		writeXML(w, struct {
+			XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+			UploadID string   `xml:"UploadId"`
+		}{UploadID: id})
+	case query.Has("uploadId"):
+		u, ok := f.uploads[query.Get("uploadId")]
+		if !ok || u.key != key {
+			writeError(w, http.StatusNotFound, "NoSuchUpload", key)
+			return
+		}
+		f.multipart(w, r.Method, query, u, body)
+	case r.Method == http.MethodHead:
+		o, ok := f.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(o.data)))
+		w.Header().Set("ETag", o.etag)
+		w.Header().Set("Last-Modified", o.modified.UTC().Format(http.TimeFormat))
+	case r.Method == http.MethodPut:
+		f.objects[key] = fakeObject{data: body, etag: etag(body), modified: time.Now()}
+		w.Header().Set("ETag", etag(body))
+	case r.Method == http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "MethodNotAllowed", r.Method+" "+r.URL.String())
+	}
+}
+
+func (f *fakeS3) list(w http.ResponseWriter, prefix string) {
+	type content struct {
+		Key          string    `xml:"Key"`
+		Size         int       `xml:"Size"`
+		ETag         string    `xml:"ETag"`
+		LastModified time.Time `xml:"LastModified"`
+	}
+	result := struct {
+		XMLName     xml.Name  `xml:"ListBucketResult"`
+		Contents    []content `xml:"Contents"`
+		IsTruncated bool      `xml:"IsTruncated"`
+	}{}
+	for key, o := range f.objects {
+		if strings.HasPrefix(key, prefix) {
+			result.Contents = append(result.Contents, content{Key: key, Size: len(o.data), ETag: o.etag, LastModified: o.modified})
+		}
+	}
+	sort.Slice(result.Contents, func(i, j int) bool { return result.Contents[i].Key < result.Contents[j].Key })
+	writeXML(w, result)
+}
+
+func (f *fakeS3) listUploads(w http.ResponseWriter, prefix string) {
+	type upload struct {
+		Key       string    `xml:"Key"`
+		UploadID  string    `xml:"UploadId"`
+		Initiated time.Time `xml:"Initiated"`
+	}
+	result := struct {
+		XMLName xml.Name `xml:"ListMultipartUploadsResult"`
+		Uploads []upload `xml:"Upload"`
+	}{}
+	for id, u := range f.uploads {
+		if strings.HasPrefix(u.key, prefix) {
+			result.Uploads = append(result.Uploads, upload{Key: u.key, UploadID: id, Initiated: u.initiated})
+		}
+	}
+	writeXML(w, result)
+}
+
+// requests of a multipart upload: uploading, listing and joining the parts and aborting it
+func (f *fakeS3) multipart(w http.ResponseWriter, method string, query url.Values, u *fakeUpload, body []byte) {
+	id := query.Get("uploadId")
+	switch method {
+	case http.MethodPut:
+		n, err := strconv.Atoi(query.Get("partNumber"))
+		if err != nil || n < 1 || n > maxParts {
+			writeError(w, http.StatusBadRequest, "InvalidArgument", "invalid part number")
+			return
+		}
+		u.parts[n] = body
+		w.Header().Set("ETag", etag(body))
+	case http.MethodGet:
+		result := struct {
+			XMLName xml.Name `xml:"ListPartsResult"`
+			Parts   []Part   `xml:"Part"`
+		}{}
+		for n, data := range u.parts {
+			result.Parts = append(result.Parts, Part{Number: n, ETag: etag(data), Size: int64(len(data))})
+		}
+		sort.Slice(result.Parts, func(i, j int) bool { return result.Parts[i].Number < result.Parts[j].Number })
+		writeXML(w, result)
+	case http.MethodPost:
+		var request struct {
+			Parts []Part `xml:"Part"`
+		}
+		if err := xml.Unmarshal(body, &request); err != nil {
+			writeError(w, http.StatusBadRequest, "MalformedXML", err.Error())
+			return
+		}
+		var data, sums []byte
+		for i, p := range request.Parts {
+			part, ok := u.parts[p.Number]
+			if !ok || p.ETag != etag(part) {
+				writeError(w, http.StatusBadRequest, "InvalidPart", strconv.Itoa(p.Number))
+				return
+			}
+			if i < len(request.Parts)-1 && len(part) < minPartSize {
+				writeError(w, http.StatusBadRequest, "EntityTooSmall", strconv.Itoa(p.Number))
+				return
+			}
+			data = append(data, part...)
+			sum := md5.Sum(part)
+			sums = append(sums, sum[:]...)
+		}
+		sum := md5.Sum(sums)
+		f.objects[u.key] = fakeObject{data: data, etag: fmt.Sprintf(`"%s-%v"`, hex.EncodeToString(sum[:]), len(request.Parts)), modified: time.Now()}
+		delete(f.uploads, id)
+		writeXML(w, struct {
+			XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+			Key     string   `xml:"Key"`
+		}{Key: u.key})
+	case http.MethodDelete:
+		delete(f.uploads, id)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "MethodNotAllowed", method)
+	}
+}
@@ -0,0 +1,339 @@
+package s3
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// format of the folders of the uploads, sorting them by name sorts them by time
+const folderFormat = "2006-01-02T15-04-05Z"
+
+type Progress struct {
+	BytesDone  int64
+	BytesTotal int64
+	// file that is currently uploaded
+	Current string
+}
+
+// Object is the result of a single file.
+type Object struct {
+	File string
+	Key  string
+	Size int64
+	// the object already existed with the same content
+	Existed bool
+	// parts of an interrupted upload that did not have to be uploaded again
+	ResumedParts int
+}
+
+type Result struct {
+	// folder of the upload, e.g. s3://bucket/prefix/2024-01-01T10-00-00Z/
+	URL     string
+	Objects []Object
+	// keys that were deleted by the retention rules
+	Deleted []string
+	// deleting old backups failed, the upload itself succeeded
+	DeleteErr error
+	Time      time.Duration
+}
+
+// Upload uploads the files of an archive into a new folder of the prefix and deletes old backups afterwards.
+// The folder is named after the modification time of the first file, uploading the same archive again continues
+// an interrupted upload and skips files that are already complete. Every object is checked against its local file
+// after it was uploaded.
+func Upload(c *Config, files []string, onProgress func(Progress)) (Result, error) {
+	start := time.Now()
+	var result Result
+	if len(files) == 0 {
+		return result, errors.New("nothing to upload")
+	}
+	client, err := NewClient(c)
+	if err != nil {
+		return result, err
+	}
+	partSize, _ := c.partSize()
+
+	info, err := os.Stat(files[0])
+	if err != nil {
+		return result, err
+	}
+	folder := c.prefix() + info.ModTime().UTC().Format(folderFormat) + "/"
+	result.URL = fmt.Sprintf("s3://%s/%s", c.Bucket, folder)
+
+	u := uploader{client: client, config: c, partSize: partSize, onProgress: onProgress}
+	for _, f := range files {
+		info, err := os.Stat(f)
+		if err != nil {
+			return result, err
+		}
+		u.progress.BytesTotal += info.Size()
+	}
+
+	for _, f := range files {
+		object, err := u.upload(f, folder+filepath.Base(f))
+		if err != nil {
+			return result, fmt.Errorf("%s: %w", filepath.Base(f), err)
+		}
+		result.Objects = append(result.Objects, object)
+	}
+
+	result.Deleted, result.DeleteErr = prune(client, c, folder)
+	result.Time = time.Since(start)
+	return result, nil
+}
+
+type uploader struct {
+	client     *Client
+	config     *Config
+	partSize   int64
+	progress   Progress
+	onProgress func(Progress)
+}
+
+func (u *uploader) report(n int64) {
+	u.progress.BytesDone += n
+	if u.onProgress != nil {
+		u.onProgress(u.progress)
+	}
+}
+
+// headers of new objects
+func (u *uploader) header() http.Header {
+	header := http.Header{}
+	if u.config.SSE != "" {
+		header.Set("X-Amz-Server-Side-Encryption", u.config.SSE)
+	}
+	if u.config.KMSKeyID != "" {
+		header.Set("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id", u.config.KMSKeyID)
+	}
+	return header
+}
+
+func (u *uploader) upload(file string, key string) (Object, error) {
+	object := Object{File: file, Key: key}
+	f, err := os.Open(file)
+	if err != nil {
+		return object, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return object, err
+	}
+	object.Size = info.Size()
+	u.progress.Current = filepath.Base(file)
+
+	// S3 allows at most 10000 parts, the part size grows for very large files
+	partSize := u.partSize
+	for object.Size > partSize*maxParts {
+		partSize *= 2
+	}
+
+	// an upload that was interrupted before can be continued
+	uploadID, err := u.client.FindUpload(key)
+	if err != nil {
+		return object, err
+	}
+	if uploadID == "" {
+		if existing, err := u.client.Head(key); err == nil && existing.Size == object.Size && u.verifiable() {
+			expected, err := expectedETag(f, object.Size, partSize)
+			if err != nil {
+				return object, err
+			}
+			if existing.ETag == expected {
+				object.Existed = true
+				u.report(object.Size)
+				return object, nil
+			}
+		} else if err != nil && !IsNotFound(err) {
+			return object, err
+		}
+	}
+
+	var expected string
+	if object.Size <= partSize && uploadID == "" {
+		data, err := io.ReadAll(f)
+		if err != nil {
+			return object, err
+		}
+		if _, err := u.client.Put(key, data, u.header()); err != nil {
+			return object, err
+		}
+		sum := md5.Sum(data)
+		expected = `"` + hex.EncodeToString(sum[:]) + `"`
+		u.report(object.Size)
+	} else {
+		expected, object.ResumedParts, err = u.multipart(f, key, object.Size, partSize, uploadID)
+		if err != nil {
+			return object, err
+		}
+	}
+
+	return object, u.verify(key, object.Size, expected)
+}
+
+// Uploads a file in parts, parts of an unfinished upload with the same content are kept.
+// Returns the expected ETag of the object and the number of parts that were kept.
+func (u *uploader) multipart(f *os.File, key string, size int64, partSize int64, uploadID string) (string, int, error) {
+	existing := map[int]Part{}
+	if uploadID != "" {
+		parts, err := u.client.Parts(key, uploadID)
+		if err != nil {
+			return "", 0, err
+		}
+		for _, p := range parts {
+			existing[p.Number] = p
+		}
+	} else {
+		var err error
+		uploadID, err = u.client.CreateUpload(key, u.header())
+		if err != nil {
+			return "", 0, err
+		}
+	}
+
+	var parts []Part
+	var sums []byte
+	resumed := 0
+	buf := make([]byte, partSize)
+	for n := 1; int64(n-1)*partSize < size; n++ {
+		length, err := io.ReadFull(f, buf)
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return "", resumed, err
+		}
+		data := buf[:length]
+		sum := md5.Sum(data)
+		sums = append(sums, sum[:]...)
+		etag := `"` + hex.EncodeToString(sum[:]) + `"`
+
+		if p, ok := existing[n]; ok && p.ETag == etag && p.Size == int64(length) {
+			resumed++
+		} else {
+			etag, err = u.client.UploadPart(key, uploadID, n, data)
+			if err != nil {
+				// the upload is kept, the next attempt continues with this part
+				return "", resumed, fmt.Errorf("part %v: %w", n, err)
+			}
+		}
+		parts = append(parts, Part{Number: n, ETag: etag})
+		u.report(int64(length))
+	}
+
+	if err := u.client.CompleteUpload(key, uploadID, parts); err != nil {
+		return "", resumed, err
+	}
+	sum := md5.Sum(sums)
+	return fmt.Sprintf(`"%s-%v"`, hex.EncodeToString(sum[:]), len(parts)), resumed, nil
+}
+
+// ETags are MD5 hashes unless the object is encrypted with KMS
+func (u *uploader) verifiable() bool {
+	return u.config.SSE != "aws:kms"
+}
+
+// compares the uploaded object with the local file
+func (u *uploader) verify(key string, size int64, expected string) error {
+	info, err := u.client.Head(key)
+	if err != nil {
+		return fmt.Errorf("could not check upload: %w", err)
+	}
+	if info.Size != size {
+		return fmt.Errorf("upload is broken: size is %v instead of %v", info.Size, size)
+	}
+	if u.verifiable() && info.ETag != expected {
+		return fmt.Errorf("upload is broken: checksum %s does not match %s", info.ETag, expected)
+	}
+	return nil
+}
+
+// Returns the ETag an object has if the file is uploaded with the given part size.
+func expectedETag(f *os.File, size int64, partSize int64) (string, error) {
+	defer f.Seek(0, io.SeekStart)
+	if size <= partSize {
+		h := md5.New()
+		if _, err := io.Copy(h, f); err != nil {
+			return "", err
+		}
+		return `"` + hex.EncodeToString(h.Sum(nil)) + `"`, nil
+	}
+	var sums []byte
+	parts := 0
+	for {
+		h := md5.New()
+		n, err := io.CopyN(h, f, partSize)
+		if n > 0 {
+			sums = append(sums, h.Sum(nil)...)
+			parts++
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	sum := md5.Sum(sums)
+	return fmt.Sprintf(`"%s-%v"`, hex.EncodeToString(sum[:]), parts), nil
+}
+
+// Deletes the backups that are beyond the retention rules, current is the folder that was just uploaded and is
+// always kept. Only folders named like uploads are touched, anything else in the prefix is left alone.
+func prune(client *Client, c *Config, current string) ([]string, error) {
+	if c.Keep == 0 && c.KeepDays == 0 {
+		return nil, nil
+	}
+	objects, err := client.List(c.prefix())
+	if err != nil {
+		return nil, fmt.Errorf("could not list old backups: %w", err)
+	}
+
+	folders := map[string][]string{}
+	for _, o := range objects {
+		name, _, ok := strings.Cut(strings.TrimPrefix(o.Key, c.prefix()), "/")
+		if !ok {
+			continue
+		}
+		if _, err := time.Parse(folderFormat, name); err != nil {
+			continue
+		}
+		folder := c.prefix() + name + "/"
+		folders[folder] = append(folders[folder], o.Key)
+	}
+	names := make([]string, 0, len(folders))
+	for f := range folders {
+		names = append(names, f)
+	}
+	// newest first
+	sort.Sort(sort.Reverse(sort.StringSlice(names)))
+
+	var deleted []string
+	for i, folder := range names {
+		if folder == current {
+			continue
+		}
+		t, _ := time.Parse(folderFormat, strings.TrimSuffix(path.Base(folder), "/"))
+		tooMany := c.Keep > 0 && i >= c.Keep
+		tooOld := c.KeepDays > 0 && time.Since(t) > time.Duration(c.KeepDays)*24*time.Hour
+		if !tooMany && !tooOld {
+			continue
+		}
+		for _, key := range folders[folder] {
+			if err := client.Delete(key); err != nil {
+				// objects with a retention period of object lock cannot be deleted yet
+				return deleted, fmt.Errorf("could not delete %s: %w", key, err)
+			}
+			deleted = append(deleted, key)
+		}
+	}
+	return deleted, nil
+}
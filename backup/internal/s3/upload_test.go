@@ -0,0 +1,171 @@
+package s3
+
+import (
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// The tests below run against a fake server unless a real one is given, e.g. a local MinIO:
+//
+//	docker run -p 9000:9000 minio/minio server /data
+//	mc mb local/backup-test
+//	BACKUP_S3_TEST_ENDPOINT=http://localhost:9000 BACKUP_S3_TEST_BUCKET=backup-test \
+//	AWS_ACCESS_KEY_ID=minioadmin AWS_SECRET_ACCESS_KEY=minioadmin go test ./internal/s3/
+func testConfig(t *testing.T) *Config {
+	endpoint := os.Getenv("BACKUP_S3_TEST_ENDPOINT")
+	bucket := os.Getenv("BACKUP_S3_TEST_BUCKET")
+	c := &Config{Endpoint: endpoint, Bucket: bucket}
+	if endpoint == "" || bucket == "" {
+		c = startFakeS3(t)
+	}
+	c.Prefix = "test-" + time.Now().UTC().Format("20060102150405.000000000")
+	c.PartSize = "5M"
+	return c
+}
+
+func writeFile(t *testing.T, name string, size int, modTime time.Time) string {
+	data := make([]byte, size)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(file, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(file, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func cleanup(t *testing.T, c *Config) {
+	t.Cleanup(func() {
+		client, err := NewClient(c)
+		if err != nil {
+			return
+		}
+		objects, _ := client.List(c.prefix())
+		for _, o := range objects {
+			client.Delete(o.Key)
+		}
+	})
+}
+
+func TestUpload(t *testing.T) {
+	c := testConfig(t)
+	cleanup(t, c)
+
+	now := time.Now()
+	small := writeFile(t, "backup.zip", 1000, now)
+	large := writeFile(t, "backup.tar.zst", 12*1024*1024, now)
+
+	result, err := Upload(c, []string{small, large}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Objects) != 2 || result.Objects[0].Existed || result.Objects[1].Existed {
+		t.Fatalf("unexpected result %+v", result)
+	}
+
+	// the same archive again is not uploaded a second time
+	result, err = Upload(c, []string{small, large}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Objects[0].Existed || !result.Objects[1].Existed {
+		t.Errorf("objects should already exist: %+v", result.Objects)
+	}
+
+	// a different file of the same size and time is uploaded again
+	changed := writeFile(t, "backup.zip", 1000, now)
+	result, err = Upload(c, []string{changed}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Objects[0].Existed {
+		t.Errorf("changed object should be uploaded: %+v", result.Objects)
+	}
+}
+
+func TestResume(t *testing.T) {
+	c := testConfig(t)
+	cleanup(t, c)
+
+	file := writeFile(t, "backup.zip", 11*1024*1024, time.Now())
+	info, _ := os.Stat(file)
+	key := c.prefix() + info.ModTime().UTC().Format(folderFormat) + "/backup.zip"
+
+	// an upload that was interrupted after the first part
+	client, err := NewClient(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := client.CreateUpload(key, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(file)
+	if _, err := client.UploadPart(key, id, 1, data[:5*1024*1024]); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := Upload(c, []string{file}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Objects[0].ResumedParts != 1 {
+		t.Errorf("got %v resumed parts, want 1", result.Objects[0].ResumedParts)
+	}
+	if id, _ := client.FindUpload(key); id != "" {
+		t.Error("upload should be completed")
+	}
+}
+
+func TestRetention(t *testing.T) {
+	c := testConfig(t)
+	c.Keep = 2
+	cleanup(t, c)
+
+	now := time.Now()
+	var results []Result
+	for i := 3; i >= 0; i-- {
+		file := writeFile(t, "backup.zip", 100, now.Add(-time.Duration(i)*time.Hour))
+		result, err := Upload(c, []string{file}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.DeleteErr != nil {
+			t.Fatal(result.DeleteErr)
+		}
+		results = append(results, result)
+	}
+
+	client, _ := NewClient(c)
+	objects, err := client.List(c.prefix())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, o := range objects {
+		keys = append(keys, o.Key)
+	}
+	want := []string{results[2].Objects[0].Key, results[3].Objects[0].Key}
+	if len(keys) != 2 || keys[0] != want[0] || keys[1] != want[1] {
+		t.Errorf("got %v, want %v", keys, want)
+	}
+
+	// objects outside of backup folders are never deleted
+	if _, err := client.Put(c.prefix()+"notes.txt", []byte("keep me"), nil); err != nil {
+		t.Fatal(err)
+	}
+	file := writeFile(t, "backup.zip", 100, now.Add(time.Hour))
+	if _, err := Upload(c, []string{file}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Head(c.prefix() + "notes.txt"); err != nil {
+		t.Error(err)
+	}
+}
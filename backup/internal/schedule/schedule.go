@@ -0,0 +1,296 @@
+// Package schedule parses the times at which the daemon runs backups.
+//
+// A time is either a cron expression with five fields (minute, hour, day of month, month, day of week), e.g.
+// "30 2 * * 1-5", or one of the shorthands "hourly", "daily at 02:00" and "weekly on sunday at 03:30".
+package schedule
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Config struct {
+	// e.g. ["daily at 02:00", "0 */6 * * *"], a backup runs at every time of every schedule
+	Times []string `json:"times,omitempty"`
+	// run even if the computer runs on battery
+	OnBattery bool `json:"onBattery,omitempty"`
+	// run even if the network connection is metered
+	OnMetered bool `json:"onMetered,omitempty"`
+	// Unix socket of the status API, defaults to $XDG_RUNTIME_DIR/backup/daemon.sock
+	Socket string `json:"socket,omitempty"`
+}
+
+// Enabled returns true if backups should run on a schedule.
+func (c *Config) Enabled() bool {
+	return c != nil && len(c.Times) > 0
+}
+
+// Validate checks that every time can be parsed.
+func (c *Config) Validate() error {
+	if !c.Enabled() {
+		return errors.New("no times specified")
+	}
+	_, err := c.Schedules()
+	return err
+}
+
+// Schedules parses the times of the config.
+func (c *Config) Schedules() ([]Schedule, error) {
+	var result []Schedule
+	for _, t := range c.Times {
+		s, err := Parse(t)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, s)
+	}
+	return result, nil
+}
+
+// Next returns the earliest time after t at which any of the schedules runs, zero if none ever runs.
+func Next(schedules []Schedule, t time.Time) time.Time {
+	var next time.Time
+	for _, s := range schedules {
+		n := s.Next(t)
+		if !n.IsZero() && (next.IsZero() || n.Before(next)) {
+			next = n
+		}
+	}
+	return next
+}
+
+// A Schedule is a parsed cron expression, every field is a set of the values it matches.
+type Schedule struct {
+	expr   string
+	minute bits
+	hour   bits
+	dom    bits
+	month  bits
+	dow    bits
+	// a field that is "*" does not restrict the day, see dayMatches
+	domAny bool
+	dowAny bool
+}
+
+type bits uint64
+
+func (b bits) has(i int) bool {
+	return b&(1<<uint(i)) != 0
+}
+
+type field struct {
+	name     string
+	min, max int
+	names    []string
+}
+
+var fields = []field{
+	{name: "minute", min: 0, max: 59},
+	{name: "hour", min: 0, max: 23},
+	{name: "day of month", min: 1, max: 31},
+	{name: "month", min: 1, max: 12, names: []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}},
+	// 7 is sunday as well
+	{name: "day of week", min: 0, max: 7, names: []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}},
+}
+
+var weekdays = map[string]string{
+	"sunday": "0", "monday": "1", "tuesday": "2", "wednesday": "3", "thursday": "4", "friday": "5", "saturday": "6",
+}
+
+// Parse parses a cron expression or one of the shorthands.
+func Parse(expr string) (Schedule, error) {
+	cron, err := toCron(expr)
+	if err != nil {
+		return Schedule{}, fmt.Errorf("invalid schedule %q: %w", expr, err)
+	}
+	parts := strings.Fields(cron)
+	if len(parts) != len(fields) {
+		return Schedule{}, fmt.Errorf("invalid schedule %q: expected 5 fields or e.g. \"daily at 02:00\"", expr)
+	}
+	var sets [5]bits
+	for i, f := range fields {
+		sets[i], err = parseField(strings.ToLower(parts[i]), f)
+		if err != nil {
+			return Schedule{}, fmt.Errorf("invalid schedule %q: %s: %w", expr, f.name, err)
+		}
+	}
+	dow := sets[4]
+	if dow.has(7) {
+		dow |= 1
+	}
+	return Schedule{
+		expr:   expr,
+		minute: sets[0],
+		hour:   sets[1],
+		dom:    sets[2],
+		month:  sets[3],
+		dow:    dow,
+		domAny: parts[2] == "*",
+		dowAny: parts[4] == "*",
+	}, nil
+}
+
+func (s Schedule) String() string {
+	return s.expr
+}
+
+// Converts the shorthands to cron expressions, cron expressions are returned as they are.
+func toCron(expr string) (string, error) {
+	words := strings.Fields(strings.ToLower(expr))
+	if len(words) == 0 {
+		return "", errors.New("empty")
+	}
+	switch words[0] {
+	case "hourly", "@hourly":
+		if len(words) != 1 {
+			return "", errors.New("hourly takes no time")
+		}
+		return "0 * * * *", nil
+	case "daily", "@daily":
+		hour, minute, err := parseAt(words[1:])
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%v %v * * *", minute, hour), nil
+	case "weekly", "@weekly":
+		day := "0"
+		rest := words[1:]
+		if len(rest) >= 2 && rest[0] == "on" {
+			var ok bool
+			if day, ok = weekdays[rest[1]]; !ok {
+				return "", fmt.Errorf("unknown day %q", rest[1])
+			}
+			rest = rest[2:]
+		}
+		hour, minute, err := parseAt(rest)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%v %v * * %v", minute, hour, day), nil
+	}
+	return expr, nil
+}
+
+// parses an optional "at 02:00", midnight if it is missing
+func parseAt(words []string) (int, int, error) {
+	if len(words) == 0 {
+		return 0, 0, nil
+	}
+	if len(words) != 2 || words[0] != "at" {
+		return 0, 0, fmt.Errorf("unexpected %q, expected e.g. \"at 02:00\"", strings.Join(words, " "))
+	}
+	t, err := time.Parse("15:04", words[1])
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid time %q, expected e.g. 02:00", words[1])
+	}
+	return t.Hour(), t.Minute(), nil
+}
+
+// parses e.g. "*", "5", "1-5", "*/15", "1-10/2" and lists of them like "1,15,30"
+func parseField(s string, f field) (bits, error) {
+	var result bits
+	for _, part := range strings.Split(s, ",") {
+		rng, stepStr, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			step, err = strconv.Atoi(stepStr)
+			if err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid step %q", stepStr)
+			}
+		}
+
+		var lo, hi int
+		if rng == "*" {
+			lo, hi = f.min, f.max
+		} else {
+			loStr, hiStr, isRange := strings.Cut(rng, "-")
+			var err error
+			if lo, err = parseValue(loStr, f); err != nil {
+				return 0, err
+			}
+			hi = lo
+			if isRange {
+				if hi, err = parseValue(hiStr, f); err != nil {
+					return 0, err
+				}
+			} else if hasStep {
+				// "5/15" means from 5 to the end
+				hi = f.max
+			}
+			if hi < lo {
+				return 0, fmt.Errorf("invalid range %q", rng)
+			}
+		}
+		for i := lo; i <= hi; i += step {
+			result |= 1 << uint(i)
+		}
+	}
+	return result, nil
+}
+
+func parseValue(s string, f field) (int, error) {
+	for i, name := range f.names {
+		if s == name {
+			if f.min == 1 {
+				return i + 1, nil
+			}
+			return i, nil
+		}
+	}
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", s)
+	}
+	if i < f.min || i > f.max {
+		return 0, fmt.Errorf("%v out of range %v-%v", i, f.min, f.max)
+	}
+	return i, nil
+}
+
+// Next returns the earliest time after t that matches the schedule, zero if there is none within the next 5 years,
+// e.g. for the 31st of February.
+func (s Schedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		var next time.Time
+		switch {
+		case !s.month.has(int(t.Month())):
+			next = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !s.dayMatches(t):
+			next = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case !s.hour.has(t.Hour()):
+			next = t.Add(time.Duration(60-t.Minute()) * time.Minute)
+		case !s.minute.has(t.Minute()):
+			next = t.Add(time.Minute)
+		default:
+			return t
+		}
+		// daylight saving time can turn midnight into an earlier time
+		if !next.After(t) {
+			next = t.Add(time.Minute)
+		}
+		t = next
+	}
+	return time.Time{}
+}
+
+// Like cron, if both day of month and day of week are restricted a day matches if either of them does.
+func (s Schedule) dayMatches(t time.Time) bool {
+	dom := s.dom.has(t.Day())
+	dow := s.dow.has(int(t.Weekday()))
+	switch {
+	case s.domAny && s.dowAny:
+		return true
+	case s.domAny:
+		return dow
+	case s.dowAny:
+		return dom
+	default:
+		return dom || dow
+	}
+}
@@ -0,0 +1,81 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNext(t *testing.T) {
+	// a wednesday
+	from := time.Date(2024, 1, 10, 14, 30, 0, 0, time.UTC)
+	tests := []struct {
+		expr string
+		want time.Time
+	}{
+		{"daily at 02:00", time.Date(2024, 1, 11, 2, 0, 0, 0, time.UTC)},
+		{"Daily at 23:15", time.Date(2024, 1, 10, 23, 15, 0, 0, time.UTC)},
+		{"daily", time.Date(2024, 1, 11, 0, 0, 0, 0, time.UTC)},
+		{"hourly", time.Date(2024, 1, 10, 15, 0, 0, 0, time.UTC)},
+		{"weekly on sunday at 03:30", time.Date(2024, 1, 14, 3, 30, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2024, 1, 10, 14, 45, 0, 0, time.UTC)},
+		{"30 14 * * *", time.Date(2024, 1, 11, 14, 30, 0, 0, time.UTC)},
+		{"0 9 * * mon-fri", time.Date(2024, 1, 11, 9, 0, 0, 0, time.UTC)},
+		{"0 0 1 * *", time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 feb *", time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 12 * * 7", time.Date(2024, 1, 14, 12, 0, 0, 0, time.UTC)},
+		// either the 1st or a friday
+		{"0 0 1 * 5", time.Date(2024, 1, 12, 0, 0, 0, 0, time.UTC)},
+		{"5,50 1-3/2 * * *", time.Date(2024, 1, 11, 1, 5, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		s, err := Parse(tt.expr)
+		if err != nil {
+			t.Errorf("%s: %v", tt.expr, err)
+			continue
+		}
+		if got := s.Next(from); !got.Equal(tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestNextNever(t *testing.T) {
+	s, err := Parse("0 0 31 2 *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := s.Next(time.Now()); !got.IsZero() {
+		t.Errorf("got %v, want zero", got)
+	}
+}
+
+// a missed run is caught up by asking for the next run after the last one
+func TestNextAfterLastRun(t *testing.T) {
+	schedules, err := (&Config{Times: []string{"daily at 02:00", "daily at 14:00"}}).Schedules()
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := time.Date(2024, 1, 10, 2, 0, 5, 0, time.UTC)
+	if got, want := Next(schedules, last), time.Date(2024, 1, 10, 14, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* * 0 * *",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"daily at 25:00",
+		"daily 02:00",
+		"weekly on someday",
+		"hourly at 02:00",
+	} {
+		if _, err := Parse(expr); err == nil {
+			t.Errorf("%q: expected error", expr)
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package script
+
+import (
+	"backup/internal/config"
+	"backup/internal/daemon"
+	"time"
+)
+
+// Daemon runs backups on the schedules from the config until it is stopped.
+func Daemon(configFile string) bool {
+	config, err := config.LoadConfig(configFile)
+	if err != nil {
+		out.Println("error:", err)
+		return false
+	}
+	if !config.Schedule.Enabled() {
+		out.Println("error: no schedule configured")
+		return false
+	}
+	err = daemon.Run(configFile, config.Schedule, func(format string, a ...any) {
+		out.Printf(time.Now().Format("2006-01-02 15:04:05")+" "+format+"\n", a...)
+	})
+	if err != nil {
+		out.Println("error:", err)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,180 @@
+package script
+
+import (
+	"backup/internal/config"
+	"backup/internal/crypt"
+	"backup/internal/fs"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/x/term"
+)
+
+type DecryptArgs struct {
+	Config string
+	// encrypted archive
+	File string
+	// defaults to File without .enc
+	Output string
+	// secret key file, defaults to the identity file from the config
+	Identity string
+	// defaults to the passphrase file from the config
+	PassphraseFile string
+}
+
+// Decrypt decrypts an encrypted archive. Keys that are not given as arguments are taken from the config,
+// if there are none either the passphrase is asked for.
+// Returns false if the archive could not be decrypted.
+func Decrypt(args DecryptArgs) bool {
+	file, err := fs.AbsPath(args.File)
+	if err != nil {
+		out.Println("error: invalid file:", err)
+		return false
+	}
+
+	output := args.Output
+	if output == "" {
+		output = strings.TrimSuffix(file, crypt.Extension)
+		if output == file {
+			output = file + ".decrypted"
+		}
+	}
+	output, err = fs.AbsPath(output)
+	if err != nil {
+		out.Println("error: invalid output file:", err)
+		return false
+	}
+	exists, err := fs.Exists(output)
+	if err != nil {
+		out.Println("error:", err)
+		return false
+	}
+	if exists {
+		out.Println("error:", output, "already exists")
+		return false
+	}
+
+	ids, err := loadIdentities(args)
+	if err != nil {
+		out.Println("error:", err)
+		return false
+	}
+
+	out.Println("decrypting", file)
+	start := time.Now()
+	size, err := decryptFile(file, output, ids)
+	if err != nil {
+		out.Println("error:", err)
+		return false
+	}
+	out.Printf("created %s %s in %s\n", output, fileSizeString(size), time.Since(start).Round(time.Second))
+	return true
+}
+
+func loadIdentities(args DecryptArgs) (crypt.Identities, error) {
+	var ids crypt.Identities
+
+	identity, passphraseFile := args.Identity, args.PassphraseFile
+	if identity == "" && passphraseFile == "" && args.Config != "" {
+		c, err := config.LoadConfig(args.Config)
+		if err != nil {
+			return ids, err
+		}
+		if c.Zip.Encryption != nil {
+			identity = c.Zip.Encryption.IdentityFile
+			passphraseFile = c.Zip.Encryption.PassphraseFile
+		}
+	}
+
+	if identity != "" {
+		keys, err := crypt.ReadIdentityFile(identity)
+		if err != nil {
+			return ids, err
+		}
+		ids.Keys = keys
+	}
+	if passphraseFile != "" {
+		passphrase, err := crypt.ReadPassphraseFile(passphraseFile)
+		if err != nil {
+			return ids, err
+		}
+		ids.Passphrase = passphrase
+	}
+
+	if len(ids.Keys) == 0 && len(ids.Passphrase) == 0 {
+		if !term.IsTerminal(os.Stdin.Fd()) {
+			return ids, errors.New("no identity or passphrase file given")
+		}
+		out.Printf("passphrase: ")
+		passphrase, err := term.ReadPassword(os.Stdin.Fd())
+		out.Println()
+		if err != nil {
+			return ids, fmt.Errorf("could not read passphrase: %w", err)
+		}
+		if len(passphrase) == 0 {
+			return ids, errors.New("no passphrase given")
+		}
+		ids.Passphrase = passphrase
+	}
+	return ids, nil
+}
+
+// The output is written to a temporary file first, a wrong key or a corrupt archive does not leave a partial file behind.
+func decryptFile(file string, output string, ids crypt.Identities) (int64, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	r, err := crypt.Decrypt(f, ids)
+	if err != nil {
+		return 0, err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(output), filepath.Base(output)+".*.tmp")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+
+	size, err := io.Copy(tmp, r)
+	if err != nil {
+		tmp.Close()
+		return 0, err
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, err
+	}
+	if err := os.Chmod(tmp.Name(), 0600); err != nil {
+		return 0, err
+	}
+	return size, os.Rename(tmp.Name(), output)
+}
+
+// Keygen creates a new key pair, the secret key is written to file and the public key is printed.
+// Returns false if the key could not be written.
+func Keygen(file string) bool {
+	secret, public, err := crypt.GenerateKey()
+	if err != nil {
+		out.Println("error:", err)
+		return false
+	}
+	if file == "" {
+		out.Println("# public key:", public)
+		out.Println(secret)
+		return true
+	}
+	if err := crypt.WriteIdentityFile(file, secret, public); err != nil {
+		out.Println("error: could not write secret key:", err)
+		return false
+	}
+	out.Println("secret key written to", file)
+	out.Println("public key:", public)
+	return true
+}
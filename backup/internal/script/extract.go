@@ -0,0 +1,77 @@
+package script
+
+import (
+	"backup/internal/archive"
+	"backup/internal/fs"
+	"fmt"
+)
+
+type ExtractArgs struct {
+	// backup directory or archive
+	Archive string
+	// paths of the backup as listed by ls, everything if empty
+	Paths []string
+	// defaults to the current directory
+	Output string
+	// existing files are skipped unless set
+	Overwrite bool
+}
+
+// Extract copies files out of a backup into a directory. Unlike restore, files are not put back to their original
+// locations, a selected file or directory is extracted with its name into the output directory.
+// Returns false if anything could not be extracted.
+func Extract(args ExtractArgs) bool {
+	absPath, err := fs.AbsPath(args.Archive)
+	if err != nil {
+		out.Println("error: invalid path:", err)
+		return false
+	}
+	output := args.Output
+	if output == "" {
+		output = "."
+	}
+	output, err = fs.AbsPath(output)
+	if err != nil {
+		out.Println("error: invalid output directory:", err)
+		return false
+	}
+
+	r, err := archive.Open(absPath)
+	if err != nil {
+		out.Println("error:", err)
+		return false
+	}
+	defer r.Close()
+
+	out.Println("extracting to", output)
+	progress := &progressLine{}
+	results, err := archive.Extract(r, args.Paths, output, archive.ExtractOptions{
+		Overwrite: args.Overwrite,
+		OnProgress: func(p archive.Progress) {
+			progress.Print(fmt.Sprintf("%v/%v files, %s/%s", p.FilesDone, p.FilesTotal, fileSizeString(p.BytesDone), fileSizeString(p.BytesTotal)))
+		},
+	})
+	progress.End()
+	if err != nil {
+		out.Println("error:", err)
+		return false
+	}
+
+	var extracted, skipped, failed int
+	var size int64
+	for _, x := range results {
+		switch {
+		case x.Err != nil:
+			failed++
+			out.Printf("error: %s: %v\n", x.Entry.Path, x.Err)
+		case x.Skipped:
+			skipped++
+			out.Println("skipped:", x.Dest, "already exists")
+		default:
+			extracted++
+			size += x.Entry.Size
+		}
+	}
+	out.Printf("%v files extracted (%s), %v skipped, %v failed\n", extracted, fileSizeString(size), skipped, failed)
+	return failed == 0
+}
@@ -2,8 +2,10 @@ package script
 
 import (
 	"bufio"
+	"encoding/json"
 	"fmt"
 	"os"
+	"sync"
 )
 
 // Why an interface?
@@ -14,6 +16,8 @@ import (
 type output interface {
 	Printf(format string, a ...any) (int, error)
 	Println(a ...any) (int, error)
+	// Event reports an Event or the Summary of a backup run, outputs for humans ignore it since the text says the same.
+	Event(v any)
 }
 
 type fmtOutput struct{}
@@ -26,6 +30,32 @@ func (f fmtOutput) Println(a ...any) (int, error) {
 	return fmt.Println(a...)
 }
 
+func (f fmtOutput) Event(v any) {}
+
+// jsonOutput writes events to stdout as JSON, one per line, the text goes to stderr so that it is still in the logs.
+type jsonOutput struct {
+	mu      sync.Mutex
+	encoder *json.Encoder
+}
+
+func newJSONOutput() *jsonOutput {
+	return &jsonOutput{encoder: json.NewEncoder(os.Stdout)}
+}
+
+func (j *jsonOutput) Printf(format string, a ...any) (int, error) {
+	return fmt.Fprintf(os.Stderr, format, a...)
+}
+
+func (j *jsonOutput) Println(a ...any) (int, error) {
+	return fmt.Fprintln(os.Stderr, a...)
+}
+
+func (j *jsonOutput) Event(v any) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.encoder.Encode(v)
+}
+
 var out output = fmtOutput{}
 
 type input interface {
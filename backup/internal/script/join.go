@@ -0,0 +1,95 @@
+package script
+
+import (
+	"backup/internal/fs"
+	"backup/internal/volume"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type JoinArgs struct {
+	// index, any volume or the name of the archive
+	Path string
+	// defaults to the name of the archive next to the volumes
+	Output string
+	// only verify the volumes, nothing is written
+	Check bool
+}
+
+// Join verifies the volumes of a split archive against their index and joins them into a single archive.
+// Returns false if a volume is missing or corrupt, the archive is not written then.
+func Join(args JoinArgs) bool {
+	path, err := fs.AbsPath(args.Path)
+	if err != nil {
+		out.Println("error: invalid path:", err)
+		return false
+	}
+	indexFile, ok := volume.FindIndex(path)
+	if !ok {
+		out.Println("error: no index found for", path)
+		return false
+	}
+	index, err := volume.ReadIndex(indexFile)
+	if err != nil {
+		out.Println("error:", err)
+		return false
+	}
+
+	var output string
+	if !args.Check {
+		output = args.Output
+		if output == "" {
+			output = filepath.Join(filepath.Dir(indexFile), index.File)
+		}
+		output, err = fs.AbsPath(output)
+		if err != nil {
+			out.Println("error: invalid output file:", err)
+			return false
+		}
+		exists, err := fs.Exists(output)
+		if err != nil {
+			out.Println("error:", err)
+			return false
+		}
+		if exists {
+			out.Println("error:", output, "already exists")
+			return false
+		}
+	}
+
+	out.Printf("checking %v volumes of %s\n", len(index.Volumes), index.File)
+	start := time.Now()
+	lastLength := 0
+	problems, err := volume.Verify(indexFile, output, func(p volume.Progress) {
+		line := fmt.Sprintf("%s/%s %s", fileSizeString(p.BytesDone), fileSizeString(p.BytesTotal), p.Current)
+		padding := ""
+		if len(line) < lastLength {
+			padding = strings.Repeat(" ", lastLength-len(line))
+		}
+		lastLength = len(line)
+		out.Printf("\r%s%s", line, padding)
+	})
+	if lastLength > 0 {
+		out.Println()
+	}
+	if err != nil {
+		out.Println("error:", err)
+		return false
+	}
+	for _, p := range problems {
+		out.Printf("error: %s: %s\n", p.Volume, p.Detail)
+	}
+	if len(problems) > 0 {
+		out.Printf("%v of %v volumes have problems\n", len(problems), len(index.Volumes))
+		return false
+	}
+
+	if args.Check {
+		out.Printf("all %v volumes ok\n", len(index.Volumes))
+	} else {
+		out.Printf("all %v volumes ok, created %s %s in %s\n", len(index.Volumes), output, fileSizeString(index.Size), time.Since(start).Round(time.Second))
+	}
+	return true
+}
@@ -0,0 +1,65 @@
+package script
+
+import (
+	"backup/internal/archive"
+	"backup/internal/fs"
+	"path"
+	"strings"
+)
+
+type LsArgs struct {
+	// backup directory or archive
+	Archive string
+	// only list files below this path of the backup
+	Path string
+	// only list files whose name contains this, ignoring case
+	Find string
+}
+
+// Ls lists the files of a backup with mode, size and modification time.
+// Returns false if the backup could not be read or nothing matched.
+func Ls(args LsArgs) bool {
+	absPath, err := fs.AbsPath(args.Archive)
+	if err != nil {
+		out.Println("error: invalid path:", err)
+		return false
+	}
+	r, err := archive.Open(absPath)
+	if err != nil {
+		out.Println("error:", err)
+		return false
+	}
+	defer r.Close()
+
+	dir := archive.CleanPath(args.Path)
+	find := strings.ToLower(args.Find)
+	var files int
+	var size int64
+	for _, e := range r.Entries() {
+		if !e.Below(dir) || !strings.Contains(strings.ToLower(path.Base(e.Path)), find) {
+			continue
+		}
+		var date string
+		if !e.ModTime.IsZero() {
+			date = e.ModTime.Local().Format("2006-01-02 15:04")
+		}
+		name := e.Path
+		if e.IsSymlink() {
+			name += " -> " + e.Link
+		}
+		out.Printf("%s %7s %-16s %s\n", e.Mode, fileSizeString(e.Size), date, name)
+		files++
+		size += e.Size
+	}
+
+	if files == 0 {
+		if args.Find != "" {
+			out.Println("no files matching", args.Find)
+		} else {
+			out.Printf("error: %s: no such file or directory in backup\n", args.Path)
+		}
+		return false
+	}
+	out.Printf("%v files, %s\n", files, fileSizeString(size))
+	return true
+}
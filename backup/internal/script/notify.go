@@ -0,0 +1,133 @@
+package script
+
+import (
+	"backup/internal/config"
+	"backup/internal/notify"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Sends the notifications of a finished backup run, channels that fail are reported as warnings.
+func sendNotifications(c *notify.Config, s Summary) {
+	if !c.Enabled() {
+		return
+	}
+	results := notify.Send(c, runNotification(s))
+	if len(results) == 0 {
+		return
+	}
+	out.Println()
+	out.Println("sending notifications")
+	printNotifyResults(results)
+}
+
+// Returns false if any channel failed.
+func printNotifyResults(results []notify.Result) bool {
+	ok := true
+	for _, r := range results {
+		if r.Err != nil {
+			printWarning(r.Channel, "could not send %s notification: %v", r.Channel, r.Err)
+			ok = false
+		} else {
+			out.Printf("sent %s notification\n", r.Channel)
+		}
+	}
+	return ok
+}
+
+func runNotification(s Summary) notify.Notification {
+	host, _ := os.Hostname()
+	status := notify.StatusSuccess
+	title := "finished"
+	switch {
+	case s.Result == ResultFatal.String():
+		status, title = notify.StatusFailure, "failed"
+	case s.Result == ResultPartial.String():
+		// the backup was created
+		status, title = notify.StatusWarnings, "finished with errors"
+	case len(s.Warnings) > 0:
+		status, title = notify.StatusWarnings, "finished with warnings"
+	}
+
+	var lines []string
+	lines = append(lines, fmt.Sprintf("Started %s, took %s.", s.Start.Local().Format("2006-01-02 15:04"), seconds(s.Seconds)), "")
+	for _, p := range s.Phases {
+		line := fmt.Sprintf("%-10s %-8s %s", p.Name, p.Status, seconds(p.Seconds))
+		if p.Detail != "" {
+			line += ", " + p.Detail
+		}
+		lines = append(lines, line)
+	}
+	if len(s.Phases) > 0 {
+		lines = append(lines, "")
+	}
+	if s.Repos.Cloned > 0 || s.Repos.Failed > 0 {
+		lines = append(lines, fmt.Sprintf("Repos: %v cloned, %v failed", s.Repos.Cloned, s.Repos.Failed))
+	}
+	if s.Files.Paths > 0 {
+		lines = append(lines, fmt.Sprintf("Files: %v files (%s), %v failed", s.Files.Files, fileSizeString(s.Files.Bytes), s.Files.Failed))
+	}
+	if a := s.Archive; a != nil {
+		lines = append(lines, fmt.Sprintf("Archive: %s (%s, %v volumes)", a.File, fileSizeString(a.Size), a.Volumes))
+	}
+	for _, list := range []struct {
+		title    string
+		problems []Problem
+	}{{"Errors", s.Errors}, {"Warnings", s.Warnings}} {
+		if len(list.problems) == 0 {
+			continue
+		}
+		lines = append(lines, "", list.title+":")
+		for _, p := range list.problems {
+			lines = append(lines, "- "+problemString(p))
+		}
+	}
+
+	return notify.Notification{
+		Status: status,
+		Title:  fmt.Sprintf("Backup on %s %s", host, title),
+		Text:   strings.Join(lines, "\n"),
+		Data:   s,
+	}
+}
+
+// e.g. "commands: dump: command exited with code 1 (connection refused)"
+func problemString(p Problem) string {
+	s := p.Message
+	// messages about paths name them already
+	if p.Item != "" && !strings.Contains(s, p.Item) {
+		s = p.Item + ": " + s
+	}
+	if p.Phase != "" {
+		s = p.Phase + ": " + s
+	}
+	if p.Detail != "" {
+		s += " (" + strings.ReplaceAll(strings.TrimSpace(p.Detail), "\n", " ") + ")"
+	}
+	return s
+}
+
+func seconds(s float64) string {
+	return (time.Duration(s * float64(time.Second))).Round(time.Second).String()
+}
+
+// TestNotify sends a test notification to every channel of the config regardless of its trigger.
+// Returns false if no channel is configured or any failed.
+func TestNotify(configFile string) bool {
+	config, err := config.LoadConfig(configFile)
+	if err != nil {
+		out.Println("error:", err)
+		return false
+	}
+	if !config.Notify.Enabled() {
+		out.Println("error: no notifications configured")
+		return false
+	}
+	if err := config.Notify.Validate(); err != nil {
+		out.Println("error:", err)
+		return false
+	}
+	return printNotifyResults(notify.Test(config.Notify))
+}
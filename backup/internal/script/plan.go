@@ -0,0 +1,427 @@
+package script
+
+import (
+	"backup/internal/commands"
+	"backup/internal/config"
+	"backup/internal/exec"
+	"backup/internal/files"
+	"backup/internal/fs"
+	"backup/internal/github"
+	"encoding/json"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// A plan describes what a backup run would do, it is created without changing anything on disk.
+type plan struct {
+	BackupDir string `json:"backupDir"`
+	// if true, files in the backup directory might get overwritten
+	BackupDirNotEmpty bool `json:"backupDirNotEmpty"`
+	// the backup is written straight into the archive, targets are paths in the archive then
+	Stream   bool         `json:"stream"`
+	Github   ghPlan       `json:"github"`
+	Files    filesPlan    `json:"files"`
+	Commands []cmdPlan    `json:"commands"`
+	Archive  *archivePlan `json:"archive"`
+	Uploads  []uploadPlan `json:"uploads"`
+	Warnings []string     `json:"warnings"`
+}
+
+type ghPlan struct {
+	// reason why no repos will be cloned, e.g. because there is no token
+	Skipped string     `json:"skipped,omitempty"`
+	Repos   []repoPlan `json:"repos"`
+}
+
+type repoPlan struct {
+	Name string `json:"name"`
+	Dir  string `json:"dir"`
+	// clone, bundle or skip
+	Action string `json:"action"`
+	// in bytes, as reported by the GitHub API
+	Size int64 `json:"size"`
+}
+
+type filesPlan struct {
+	Exclude []string   `json:"exclude"`
+	Paths   []pathPlan `json:"paths"`
+	Size    int64      `json:"size"`
+}
+
+type pathPlan struct {
+	Path     string `json:"path"`
+	Target   string `json:"target,omitempty"`
+	IsDir    bool   `json:"isDir"`
+	Files    int    `json:"files"`
+	Excluded int    `json:"excluded"`
+	Size     int64  `json:"size"`
+	Error    string `json:"error,omitempty"`
+}
+
+type cmdPlan struct {
+	Name   string `json:"name"`
+	Run    string `json:"run"`
+	Target string `json:"target"`
+	Error  string `json:"error,omitempty"`
+}
+
+type archivePlan struct {
+	File   string `json:"file"`
+	Format string `json:"format"`
+	// encrypted with a passphrase or public keys
+	Encrypted bool `json:"encrypted"`
+	// maximum size of a volume, 0 if the archive is not split
+	SplitSize int64 `json:"splitSize"`
+	// upper bound, the size of all files before compression
+	EstimatedSize int64 `json:"estimatedSize"`
+}
+
+type uploadPlan struct {
+	// s3, ssh or drive
+	Type string `json:"type"`
+	// e.g. s3://bucket/prefix/, user@host:/path or the labels of the drives
+	Target string `json:"target"`
+	// the backup directory is synced or copied instead of the archive
+	Sync bool `json:"sync,omitempty"`
+	// drives that are plugged in, the others are skipped
+	Mounted []string `json:"mounted,omitempty"`
+	// retention of old backups, empty if all are kept
+	Retention string `json:"retention,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// DryRun loads the config and prints what a backup run would do without creating, copying or zipping anything.
+// The plan is printed either as readable text or as JSON if format is "json".
+// Returns false if the plan could not be created.
+func DryRun(configFile string, format string) bool {
+	if format != "text" && format != "json" {
+		out.Println("error: invalid output format", format)
+		return false
+	}
+
+	config, err := config.LoadConfig(configFile)
+	if err != nil {
+		out.Println("error: could not load config:", err)
+		return false
+	}
+
+	p, err := createPlan(config)
+	if err != nil {
+		out.Println("error:", err)
+		return false
+	}
+
+	if format == "json" {
+		data, err := json.MarshalIndent(p, "", "  ")
+		if err != nil {
+			out.Println("error:", err)
+			return false
+		}
+		out.Println(string(data))
+	} else {
+		printPlan(p)
+	}
+	return true
+}
+
+func createPlan(config config.Config) (plan, error) {
+	p := plan{Warnings: []string{}}
+
+	backupDir, err := fs.AbsPath(config.BackupDir)
+	if err != nil {
+		return p, fmt.Errorf("invalid backup directory: %w", err)
+	}
+	p.BackupDir = backupDir
+	p.Stream = config.Zip.Stream
+
+	if p.Stream {
+		if config.Zip.File == "" {
+			p.Warnings = append(p.Warnings, "streaming is enabled but no zip file is specified, the backup will fail")
+		}
+	} else {
+		empty, err := fs.IsDirEmpty(backupDir)
+		if err != nil {
+			return p, err
+		}
+		p.BackupDirNotEmpty = !empty
+
+		exists, err := fs.DirExists(fs.ParentPath(backupDir))
+		if err != nil {
+			return p, err
+		}
+		if !exists {
+			p.Warnings = append(p.Warnings, "parent of backup directory does not exist, might be a typo")
+		}
+	}
+
+	p.Github = planGithub(backupDir, config.Github, p.Stream)
+
+	p.Files = filesPlan{Exclude: config.Exclude, Paths: []pathPlan{}}
+	if p.Files.Exclude == nil {
+		p.Files.Exclude = []string{}
+	}
+	for _, source := range files.Expand(config.Files, config.Exclude) {
+		pp := pathPlan{
+			Path:     source.Path,
+			IsDir:    source.IsDir,
+			Files:    source.Files,
+			Excluded: source.Excluded,
+			Size:     source.Size,
+		}
+		if source.AbsPath != "" && p.Stream {
+			pp.Target = path.Join("files", filepath.ToSlash(source.AbsPath))
+		} else if source.AbsPath != "" {
+			pp.Target = files.Target(backupDir, source.AbsPath)
+		}
+		if source.Err != nil {
+			pp.Error = source.Err.Error()
+		}
+		p.Files.Size += source.Size
+		p.Files.Paths = append(p.Files.Paths, pp)
+	}
+
+	p.Commands = []cmdPlan{}
+	for _, c := range config.Commands {
+		cp := cmdPlan{Name: c.Name, Run: c.Run, Target: fs.JoinPath(commands.Dir(backupDir), c.Name)}
+		if p.Stream {
+			cp.Target = path.Join("commands", c.Name)
+		}
+		if err := c.Validate(); err != nil {
+			cp.Error = err.Error()
+		}
+		p.Commands = append(p.Commands, cp)
+	}
+
+	if config.Zip.File != "" {
+		file, err := config.Zip.TargetFile(config.Zip.File)
+		if err != nil {
+			return p, fmt.Errorf("invalid zip file: %w", err)
+		}
+		size := p.Files.Size
+		for _, r := range p.Github.Repos {
+			if r.Action != "skip" {
+				size += r.Size
+			}
+		}
+		opts, err := config.Zip.ArchiveOptions()
+		if err != nil {
+			return p, fmt.Errorf("invalid zip config: %w", err)
+		}
+		p.Archive = &archivePlan{File: file, Format: string(opts.Format), Encrypted: opts.Encrypt != nil, SplitSize: opts.SplitSize, EstimatedSize: size}
+	}
+
+	p.Uploads = []uploadPlan{}
+	if config.S3.Enabled() {
+		up := uploadPlan{Type: "s3", Target: config.S3.URL(), Retention: retentionString(config.S3.Keep, config.S3.KeepDays)}
+		if err := config.S3.Validate(); err != nil {
+			up.Error = err.Error()
+		}
+		p.Uploads = append(p.Uploads, up)
+	}
+	if config.SSH.Enabled() {
+		up := uploadPlan{Type: "ssh", Target: config.SSH.URL(), Sync: config.SSH.SyncDir}
+		if err := config.SSH.Validate(); err != nil {
+			up.Error = err.Error()
+		}
+		p.Uploads = append(p.Uploads, up)
+		if up.Sync && p.Stream {
+			p.Warnings = append(p.Warnings, "the backup directory cannot be synced when the backup is streamed into the archive")
+		}
+	}
+	if config.Removable.Enabled() {
+		up := uploadPlan{Type: "drive", Target: config.Removable.Names(), Sync: config.Removable.Snapshot, Retention: retentionString(config.Removable.Keep, 0)}
+		if err := config.Removable.Validate(); err != nil {
+			up.Error = err.Error()
+		} else if mounts, err := config.Removable.Mounted(); err != nil {
+			up.Error = err.Error()
+		} else {
+			for _, m := range mounts {
+				up.Mounted = append(up.Mounted, m.ID)
+			}
+		}
+		p.Uploads = append(p.Uploads, up)
+		if up.Sync && p.Stream {
+			p.Warnings = append(p.Warnings, "the backup directory cannot be copied to drives when the backup is streamed into the archive")
+		}
+	}
+	for _, u := range p.Uploads {
+		if !u.Sync && p.Archive == nil {
+			p.Warnings = append(p.Warnings, "an upload is configured but no zip file is specified, nothing will be uploaded")
+			break
+		}
+	}
+
+	return p, nil
+}
+
+// e.g. "keep 7 backups, at most 30 days"
+func retentionString(keep int, keepDays int) string {
+	var parts []string
+	if keep > 0 {
+		parts = append(parts, fmt.Sprintf("keep %v backups", keep))
+	}
+	if keepDays > 0 {
+		parts = append(parts, fmt.Sprintf("delete backups older than %v days", keepDays))
+	}
+	return strings.Join(parts, ", ")
+}
+
+func planGithub(backupDir string, config github.Config, stream bool) ghPlan {
+	p := ghPlan{Repos: []repoPlan{}}
+
+	if config.Token == "" {
+		p.Skipped = "personal access token not provided"
+		return p
+	}
+	if err := exec.CommandAvailable("gh"); err != nil {
+		p.Skipped = "no valid gh (github cli) executable found"
+		return p
+	}
+
+	repos, err := github.LoadRepos(config.Token)
+	if err != nil {
+		p.Skipped = fmt.Sprintf("could not load repos: %v", err)
+		return p
+	}
+
+	githubDir := fs.JoinPath(backupDir, "github")
+	for _, repo := range repos {
+		if stream {
+			p.Repos = append(p.Repos, repoPlan{
+				Name:   repo.FullName,
+				Dir:    path.Join("github", repo.Name+".bundle"),
+				Action: "bundle",
+				Size:   repo.Size * 1024,
+			})
+			continue
+		}
+		rp := repoPlan{
+			Name:   repo.FullName,
+			Dir:    fs.JoinPath(githubDir, repo.Name),
+			Action: "clone",
+			Size:   repo.Size * 1024,
+		}
+		// same rule as in backupGithub, existing clones are not touched
+		empty, err := fs.IsDirEmpty(rp.Dir)
+		if err != nil || !empty {
+			rp.Action = "skip"
+		}
+		p.Repos = append(p.Repos, rp)
+	}
+	return p
+}
+
+func printPlan(p plan) {
+	out.Println("backup directory:", p.BackupDir)
+	if p.Stream {
+		out.Println("  not used, the backup is streamed into the archive")
+	} else if p.BackupDirNotEmpty {
+		out.Println("  not empty, files might get overwritten")
+	}
+
+	out.Println()
+	out.Println("github:")
+	if p.Github.Skipped != "" {
+		out.Println("  skipped,", p.Github.Skipped)
+	} else if len(p.Github.Repos) == 0 {
+		out.Println("  no repos to clone")
+	}
+	for _, r := range p.Github.Repos {
+		if r.Action == "skip" {
+			out.Printf("  skip  %s (%s is not empty)\n", r.Name, r.Dir)
+		} else if r.Action == "bundle" {
+			out.Printf("  bundle %s (%s) to %s\n", r.Name, fileSizeString(r.Size), r.Dir)
+		} else {
+			out.Printf("  clone %s (%s) to %s\n", r.Name, fileSizeString(r.Size), r.Dir)
+		}
+	}
+
+	out.Println()
+	out.Println("files:")
+	if len(p.Files.Exclude) > 0 {
+		out.Println("  excluding", p.Files.Exclude)
+	}
+	if len(p.Files.Paths) == 0 {
+		out.Println("  no files to copy")
+	}
+	for _, pp := range p.Files.Paths {
+		if pp.Error != "" {
+			out.Printf("  error %s: %s\n", pp.Path, pp.Error)
+			continue
+		}
+		excluded := ""
+		if pp.Excluded > 0 {
+			excluded = fmt.Sprintf(", %v excluded", pp.Excluded)
+		}
+		out.Printf("  copy  %s (%v files, %s%s) to %s\n", pp.Path, pp.Files, fileSizeString(pp.Size), excluded, pp.Target)
+	}
+
+	out.Println()
+	out.Println("commands:")
+	if len(p.Commands) == 0 {
+		out.Println("  no commands to run")
+	}
+	for _, c := range p.Commands {
+		if c.Error != "" {
+			out.Printf("  error %s: %s\n", c.Name, c.Error)
+			continue
+		}
+		out.Printf("  run   %s and write output to %s\n", c.Run, c.Target)
+	}
+
+	out.Println()
+	out.Println("zip:")
+	if p.Archive == nil {
+		out.Println("  skipped, no zip file specified")
+	} else {
+		format := p.Archive.Format
+		if p.Archive.Encrypted {
+			format += ", encrypted"
+		}
+		if p.Stream {
+			format += ", streamed"
+		}
+		if p.Archive.SplitSize > 0 {
+			format += fmt.Sprintf(", split into volumes of %s", fileSizeString(p.Archive.SplitSize))
+		}
+		out.Printf("  create %s as %s (at most %s)\n", p.Archive.File, format, fileSizeString(p.Archive.EstimatedSize))
+	}
+
+	if len(p.Uploads) > 0 {
+		out.Println()
+		out.Println("upload:")
+		for _, u := range p.Uploads {
+			if u.Error != "" {
+				out.Printf("  error %s: %s\n", u.Target, u.Error)
+				continue
+			}
+			line := "  upload to " + u.Target
+			if u.Type == "drive" {
+				what := "the archive"
+				if u.Sync {
+					what = p.BackupDir
+				}
+				mounted := "none is mounted, skipped"
+				if len(u.Mounted) > 0 {
+					mounted = strings.Join(u.Mounted, ", ") + " mounted"
+				}
+				line = fmt.Sprintf("  copy  %s to drives %s (%s)", what, u.Target, mounted)
+			} else if u.Sync {
+				line = fmt.Sprintf("  sync  %s to %s", p.BackupDir, u.Target)
+			}
+			if u.Retention != "" {
+				line += ", " + u.Retention
+			}
+			out.Println(line)
+		}
+	}
+
+	if len(p.Warnings) > 0 {
+		out.Println()
+		for _, w := range p.Warnings {
+			out.Println("warning:", w)
+		}
+	}
+}
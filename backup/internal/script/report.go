@@ -0,0 +1,317 @@
+package script
+
+import (
+	"backup/internal/archive"
+	"backup/internal/files"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+type EventType string
+
+const (
+	EventPhaseStarted  EventType = "phase_started"
+	EventPhaseFinished EventType = "phase_finished"
+	EventRepoCloned    EventType = "repo_cloned"
+	// a path from the config was copied, Failed is set if some of its files were not
+	EventFileCopied EventType = "file_copied"
+	EventWarning    EventType = "warning"
+	EventError      EventType = "error"
+	EventSummary    EventType = "summary"
+)
+
+// Event is something that happened during a backup run, written as a line of JSON with --output json.
+type Event struct {
+	Type  EventType `json:"type"`
+	Time  time.Time `json:"time"`
+	Phase string    `json:"phase,omitempty"`
+	// repo, path or command the event is about
+	Item    string `json:"item,omitempty"`
+	Message string `json:"message,omitempty"`
+	// output of the command that failed
+	Detail string `json:"detail,omitempty"`
+	// done, failed or skipped for finished phases
+	Status  string  `json:"status,omitempty"`
+	Seconds float64 `json:"seconds,omitempty"`
+	Files   int     `json:"files,omitempty"`
+	Failed  int     `json:"failed,omitempty"`
+	Bytes   int64   `json:"bytes,omitempty"`
+}
+
+// Summary is the last event of a backup run.
+type Summary struct {
+	Type EventType `json:"type"`
+	// success, partial or fatal
+	Result   string         `json:"result"`
+	ExitCode int            `json:"exitCode"`
+	Start    time.Time      `json:"start"`
+	End      time.Time      `json:"end"`
+	Seconds  float64        `json:"seconds"`
+	Phases   []PhaseSummary `json:"phases"`
+	Repos    RepoSummary    `json:"repos"`
+	Files    FileSummary    `json:"files"`
+	// nil if no archive was created
+	Archive  *ArchiveSummary `json:"archive,omitempty"`
+	Errors   []Problem       `json:"errors"`
+	Warnings []Problem       `json:"warnings"`
+}
+
+type PhaseSummary struct {
+	Name    string  `json:"name"`
+	Status  string  `json:"status"`
+	Seconds float64 `json:"seconds"`
+	// why the phase was skipped
+	Detail string `json:"detail,omitempty"`
+}
+
+type RepoSummary struct {
+	Cloned int `json:"cloned"`
+	Failed int `json:"failed"`
+}
+
+type FileSummary struct {
+	// paths from the config that were copied
+	Paths int   `json:"paths"`
+	Files int   `json:"files"`
+	Bytes int64 `json:"bytes"`
+	// files that could not be copied
+	Failed int `json:"failed"`
+}
+
+type ArchiveSummary struct {
+	File    string `json:"file"`
+	Size    int64  `json:"size"`
+	Files   int    `json:"files"`
+	Bytes   int64  `json:"bytes"`
+	Volumes int    `json:"volumes"`
+}
+
+// An error or warning, Phase and Item are empty if it is not about one.
+type Problem struct {
+	Phase   string `json:"phase,omitempty"`
+	Item    string `json:"item,omitempty"`
+	Message string `json:"message"`
+	Detail  string `json:"detail,omitempty"`
+}
+
+// recorder collects the events of a backup run for the summary and writes them to the output, outputs for humans
+// ignore them since the text says the same. The steps report phases, items and problems themselves,
+// the methods do nothing on a nil recorder, e.g. outside of a backup.
+type recorder struct {
+	output output
+
+	// guards everything below, progress is reported from other goroutines
+	mu    sync.Mutex
+	start time.Time
+	// current phase, empty between phases
+	phase      string
+	phaseStart time.Time
+	skipped    string
+
+	summary Summary
+	// final state of every repo, a failed clone can succeed when it is tried again
+	repos map[string]bool
+}
+
+// the recorder of the current backup run
+var report *recorder
+
+func newRecorder(o output) *recorder {
+	return &recorder{
+		output: o,
+		start:  time.Now(),
+		summary: Summary{
+			Type:     EventSummary,
+			Phases:   []PhaseSummary{},
+			Errors:   []Problem{},
+			Warnings: []Problem{},
+		},
+		repos: map[string]bool{},
+	}
+}
+
+// printError prints an error of a step and reports it, item is the repo, path or command it is about, empty if none.
+func printError(item string, format string, a ...any) {
+	message := fmt.Sprintf(format, a...)
+	out.Println("error:", message)
+	report.problem(EventError, item, message, "")
+}
+
+// printCommandError is printError for a command that failed, its output is printed after the error and becomes
+// the detail of the event.
+func printCommandError(item string, message string, stdout string, stderr string) {
+	out.Println("error:", message)
+	var detail []string
+	if len(stdout) > 0 {
+		out.Println("stdout:")
+		out.Println(stdout)
+		detail = append(detail, strings.TrimSpace(stdout))
+	}
+	if len(stderr) > 0 {
+		out.Println("stderr:")
+		out.Println(stderr)
+		detail = append(detail, strings.TrimSpace(stderr))
+	}
+	report.problem(EventError, item, message, strings.Join(detail, "\n"))
+}
+
+func printWarning(item string, format string, a ...any) {
+	message := fmt.Sprintf(format, a...)
+	out.Println("warning:", message)
+	report.problem(EventWarning, item, message, "")
+}
+
+// printSkipped prints why a step has nothing to do, its phase counts as skipped.
+func printSkipped(reason string) {
+	out.Println("skipping,", reason)
+	report.skip(reason)
+}
+
+func (r *recorder) problem(t EventType, item string, message string, detail string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	p := Problem{Phase: r.phase, Item: item, Message: message, Detail: detail}
+	if t == EventError {
+		r.summary.Errors = append(r.summary.Errors, p)
+		if r.phase == "github" && item != "" {
+			r.repos[item] = false
+		}
+	} else {
+		r.summary.Warnings = append(r.summary.Warnings, p)
+	}
+	r.emit(Event{Type: t, Phase: p.Phase, Item: item, Message: message, Detail: detail})
+}
+
+func (r *recorder) emit(e Event) {
+	e.Time = time.Now()
+	r.output.Event(e)
+}
+
+func (r *recorder) startPhase(name string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.phase = name
+	r.phaseStart = time.Now()
+	r.skipped = ""
+	r.emit(Event{Type: EventPhaseStarted, Phase: name})
+}
+
+func (r *recorder) endPhase(ok bool) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	status := "done"
+	if !ok {
+		status = "failed"
+	} else if r.skipped != "" {
+		status = "skipped"
+	}
+	seconds := time.Since(r.phaseStart).Seconds()
+	r.emit(Event{Type: EventPhaseFinished, Phase: r.phase, Status: status, Message: r.skipped, Seconds: seconds})
+	r.summary.Phases = append(r.summary.Phases, PhaseSummary{Name: r.phase, Status: status, Seconds: seconds, Detail: r.skipped})
+	r.phase = ""
+}
+
+// skip marks the current phase as skipped, for steps that have nothing to do and print nothing.
+func (r *recorder) skip(reason string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.skipped = reason
+}
+
+func (r *recorder) repoCloned(name string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.repos[name] = true
+	r.emit(Event{Type: EventRepoCloned, Phase: r.phase, Item: name})
+}
+
+func (r *recorder) filesCopied(result files.Result) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	f := &r.summary.Files
+	f.Paths++
+	f.Files += result.Files - result.Failed
+	f.Bytes += result.Bytes
+	f.Failed += result.Failed
+	r.emit(Event{
+		Type:   EventFileCopied,
+		Phase:  r.phase,
+		Item:   result.Job.Source,
+		Files:  result.Files - result.Failed,
+		Failed: result.Failed,
+		Bytes:  result.Bytes,
+	})
+}
+
+func (r *recorder) archiveCreated(result archive.Result) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.summary.Archive = &ArchiveSummary{
+		File:    result.File,
+		Size:    result.ArchiveSize,
+		Files:   result.Files,
+		Bytes:   result.Size,
+		Volumes: len(result.Volumes),
+	}
+}
+
+// summarize returns the summary of the run so far.
+func (r *recorder) summarize(result Result) Summary {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := r.summary
+	s.Phases = append([]PhaseSummary{}, s.Phases...)
+	s.Errors = append([]Problem{}, s.Errors...)
+	s.Warnings = append([]Problem{}, s.Warnings...)
+	s.Result = result.String()
+	s.ExitCode = int(result)
+	s.Start = r.start
+	s.End = time.Now()
+	s.Seconds = s.End.Sub(s.Start).Seconds()
+	for _, cloned := range r.repos {
+		if cloned {
+			s.Repos.Cloned++
+		} else {
+			s.Repos.Failed++
+		}
+	}
+	return s
+}
+
+// finish writes the summary of the run, it is the last event.
+func (r *recorder) finish(result Result) Summary {
+	s := r.summarize(result)
+	r.output.Event(s)
+	return s
+}
+
+// runPhase runs a step of the backup as a phase of the report, step returns false if it failed.
+func runPhase(name string, step func() bool) bool {
+	report.startPhase(name)
+	ok := step()
+	report.endPhase(ok)
+	return ok
+}
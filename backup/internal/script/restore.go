@@ -0,0 +1,101 @@
+package script
+
+import (
+	"backup/internal/fs"
+	"backup/internal/restore"
+)
+
+type RestoreArgs struct {
+	// backup directory or archive
+	Backup string
+	// original paths to restore, restore everything if empty
+	Paths []string
+	// restore below this directory instead of the original locations
+	Target string
+	Policy string
+	DryRun bool
+}
+
+// Restore restores files from a backup directory or archive and prints what is done for every file.
+// With DryRun only the plan is printed.
+// Returns false if something went wrong.
+func Restore(args RestoreArgs) bool {
+	policy, err := restore.ParsePolicy(args.Policy)
+	if err != nil {
+		out.Println("error:", err)
+		return false
+	}
+
+	path, err := fs.AbsPath(args.Backup)
+	if err != nil {
+		out.Println("error: invalid backup path:", err)
+		return false
+	}
+
+	out.Println("opening", path)
+	backup, err := restore.Open(path)
+	if err != nil {
+		out.Println("error:", err)
+		return false
+	}
+	defer backup.Close()
+
+	if !backup.HasManifest() {
+		out.Println("warning: no manifest found, permissions and timestamps are taken from the backup itself")
+	}
+
+	items, err := backup.Plan(restore.Options{
+		Target: args.Target,
+		Paths:  args.Paths,
+		Policy: policy,
+	})
+	if err != nil {
+		out.Println("error:", err)
+		return false
+	}
+
+	if len(items) == 0 {
+		out.Println("nothing to restore")
+		return true
+	}
+
+	if args.DryRun {
+		for _, item := range items {
+			printRestoreItem(item)
+		}
+		out.Printf(
+			"%v to create, %v to overwrite, %v to keep both, %v skipped, %v unchanged\n",
+			restore.Count(items, restore.ActionCreate),
+			restore.Count(items, restore.ActionOverwrite),
+			restore.Count(items, restore.ActionKeepBoth),
+			restore.Count(items, restore.ActionSkip),
+			restore.Count(items, restore.ActionUnchanged),
+		)
+		return restore.Failed(items) == 0
+	}
+
+	items = backup.Restore(items)
+	for _, item := range items {
+		printRestoreItem(item)
+	}
+
+	failed := restore.Failed(items)
+	restored := restore.Count(items, restore.ActionCreate) + restore.Count(items, restore.ActionOverwrite) + restore.Count(items, restore.ActionKeepBoth)
+	out.Printf("restored %v files, %v failed\n", restored-failed, failed)
+	return failed == 0
+}
+
+func printRestoreItem(item restore.Item) {
+	if item.Err != nil {
+		out.Printf("error: %s: %v\n", item.Dest, item.Err)
+		return
+	}
+	switch item.Action {
+	case restore.ActionKeepBoth:
+		out.Printf("%s: %s (existing file is kept)\n", item.Action, item.Dest)
+	case restore.ActionSkip:
+		out.Printf("%s: %s (already exists)\n", item.Action, item.Dest)
+	default:
+		out.Printf("%s: %s\n", item.Action, item.Dest)
+	}
+}
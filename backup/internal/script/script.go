@@ -1,65 +1,207 @@
 package script
 
 import (
+	"backup/internal/archive"
+	"backup/internal/commands"
 	"backup/internal/config"
 	"backup/internal/exec"
+	"backup/internal/files"
 	"backup/internal/fs"
 	"backup/internal/github"
+	"backup/internal/lock"
+	"backup/internal/manifest"
+	"backup/internal/preflight"
+	"backup/internal/volume"
 	"backup/internal/zip"
+	"errors"
 	"fmt"
 	"strings"
+	"time"
 )
 
-func Backup(configFile string) {
+// Result of a backup run, used as the exit code of the process.
+type Result int
+
+const (
+	ResultSuccess Result = 0
+	// the backup could not be created or was aborted
+	ResultFatal Result = 1
+	// the backup was created, but parts of it failed, e.g. a repo could not be cloned
+	ResultPartial Result = 2
+)
+
+// Policy answers the questions of a backup run without asking, e.g. when it runs from cron or systemd.
+type Policy struct {
+	// never ask, questions without a policy are answered with no
+	NonInteractive bool
+	// continue if the backup directory already exists, files in it might get overwritten
+	ExistingDir bool
+	// continue if the parent of the backup directory does not exist
+	MissingParent bool
+	// continue if there is not enough free space
+	LowSpace bool
+	// how often failed operations like loading and cloning repos are tried again when not asking
+	Retries int
+}
+
+// YesPolicy answers every question with yes, failed operations are tried again retries times.
+func YesPolicy(retries int) Policy {
+	return Policy{NonInteractive: true, ExistingDir: true, MissingParent: true, LowSpace: true, Retries: retries}
+}
+
+func (r Result) String() string {
+	switch r {
+	case ResultSuccess:
+		return "success"
+	case ResultPartial:
+		return "partial"
+	default:
+		return "fatal"
+	}
+}
+
+var policy Policy
+
+// Backup runs a full backup with the config, questions are answered by p if it says so.
+// With format "json" the output is a line of JSON for every Event and the Summary at the end, the text goes to stderr.
+func Backup(configFile string, p Policy, format string) Result {
+	if format != "text" && format != "json" {
+		out.Println("error: invalid output format", format)
+		return ResultFatal
+	}
+	policy = p
+
+	previous := out
+	if format == "json" {
+		out = newJSONOutput()
+	}
+	report = newRecorder(out)
+	defer func() {
+		out = previous
+		report = nil
+	}()
+
 	out.Println("loading config")
 	config, err := config.LoadConfig(configFile)
 	if err != nil {
-		out.Println("error:", err)
-		return
+		printError("", "%v", err)
+		report.finish(ResultFatal)
+		return ResultFatal
+	}
+
+	result := backup(config)
+	// failed notifications are warnings of the run, the summary has to come after them
+	sendNotifications(config.Notify, report.summarize(result))
+	report.finish(result)
+	return result
+}
+
+func backup(config config.Config) Result {
+	// validated before locking, the lock file next to the backup directory would create a missing parent
+	var backupDir string
+	if !config.Zip.Stream {
+		var ok bool
+		backupDir, ok = validateBackupDir(config.BackupDir)
+		if !ok {
+			return ResultFatal
+		}
 	}
 
-	backupDir, ok := validateBackupDir(config.BackupDir)
+	l, ok := lockBackupDir(config.BackupDir)
 	if !ok {
-		return
+		return ResultFatal
 	}
+	defer l.Release()
+
+	if config.Zip.Stream {
+		return finish(streamBackup(config))
+	}
+
+	if !runPhase("preflight", func() bool { return checkFreeSpace(config) }) {
+		return ResultFatal
+	}
+
+	// every step runs even if the ones before failed, as much as possible should be backed up
+	ok = runPhase("github", func() bool { return backupGithub(backupDir, config.Github) })
+
+	ok = runPhase("files", func() bool {
+		return backupFiles(backupDir, config.Files, config.Exclude, config.CopyWorkers)
+	}) && ok
+
+	ok = runPhase("commands", func() bool { return runCommands(backupDir, config.Commands) }) && ok
 
-	backupGithub(backupDir, config.Github)
+	ok = runPhase("manifest", func() bool { return writeManifest(backupDir) }) && ok
 
-	backupFiles(backupDir, config.Files)
+	var file string
+	ok = runPhase("zip", func() bool {
+		var zipped bool
+		file, zipped = zipDir(backupDir, config.Zip)
+		return zipped
+	}) && ok
+	ok = uploadBackup(config, backupDir, file) && ok
 
-	zipDir(backupDir, config.Zip)
+	if !ok {
+		return finish(ResultPartial)
+	}
+	return finish(ResultSuccess)
+}
+
+// Takes the run lock of the backup directory so that no other backup, e.g. in the TUI, writes to it at the same time.
+func lockBackupDir(dir string) (*lock.Lock, bool) {
+	l, err := lock.Acquire(dir, "backup")
+	var locked *lock.LockedError
+	if errors.As(err, &locked) {
+		printError("", "%v", err)
+		out.Println("try again when it is finished")
+		return nil, false
+	} else if err != nil {
+		printError("", "could not lock backup directory: %v", err)
+		return nil, false
+	}
+	if l.Stale != nil {
+		printWarning("", "the backup by %v did not finish, e.g. because it crashed, files in the backup directory might be incomplete", l.Stale)
+	}
+	return l, true
+}
+
+func finish(r Result) Result {
+	if r == ResultPartial {
+		out.Println()
+		out.Println("backup finished with errors")
+	}
+	return r
 }
 
 func validateBackupDir(backupDir string) (string, bool) {
 	out.Println("validating backup directory:", backupDir)
 	absPath, err := fs.AbsPath(backupDir)
 	if err != nil {
-		out.Println("error:", err)
+		printError("", "%v", err)
 		return "", false
 	}
 
 	exists, err := fs.DirExists(absPath)
 	if err != nil {
-		out.Println("error:", err)
+		printError("", "%v", err)
 		return "", false
 	}
 
 	if exists {
-		out.Println("warning: backup directory is not empty, files might get overwritten")
-		if !confirmPrompt("continue?") {
+		printWarning("", "backup directory is not empty, files might get overwritten")
+		if !confirmPrompt("continue?", policy.ExistingDir) {
 			return "", false
 		}
 	}
 
 	exists, err = fs.DirExists(fs.ParentPath(absPath))
 	if err != nil {
-		out.Println("error:", err)
+		printError("", "%v", err)
 		return "", false
 	}
 
 	if !exists {
-		out.Println("warning: parent directory does not exist, might be a typo")
-		if !confirmPrompt("continue?") {
+		printWarning("", "parent directory does not exist, might be a typo")
+		if !confirmPrompt("continue?", policy.MissingParent) {
 			return "", false
 		}
 	}
@@ -67,46 +209,82 @@ func validateBackupDir(backupDir string) (string, bool) {
 	return absPath, true
 }
 
-func backupGithub(backupDir string, config github.Config) {
+// Estimates the size of the backup and compares it against the free space of the backup directory and zip file.
+// Returns false if the backup should not be started.
+func checkFreeSpace(config config.Config) bool {
 	out.Println()
-	out.Println("backing up github repos")
+	out.Println("estimating backup size")
+	report := preflight.Run(config)
 
-	err := exec.CommandAvailable("gh")
-	if err != nil {
-		out.Println("error: no valid gh (github cli) executable found:", err)
-		return
+	for _, s := range report.Sources {
+		if s.Err == nil {
+			out.Printf("  %8s  %-6s %s\n", fileSizeString(s.Size), s.Kind, s.Name)
+		}
+	}
+	if report.GithubSkipped != "" {
+		out.Println("  repos not included:", report.GithubSkipped)
 	}
+	out.Printf("  %8s  total\n", fileSizeString(report.Total))
 
-	backupDir = fs.JoinPath(backupDir, "github")
+	for _, t := range report.Targets {
+		if t.Err != nil {
+			printWarning("", "could not determine free space of %s %s: %v", t.Name, t.Path, t.Err)
+			continue
+		}
+		out.Printf("%s %s: needs %s, %s free\n", t.Name, t.Path, fileSizeString(t.Needed), fileSizeString(t.Free))
+	}
+
+	switch report.Status() {
+	case preflight.StatusInsufficient:
+		printError("", "not enough free space, the backup will likely fail")
+		return confirmPrompt("continue anyway?", policy.LowSpace)
+	case preflight.StatusLowSpace:
+		printWarning("", "there is enough free space, but not much more")
+	}
+	return true
+}
+
+// Returns false if any repo could not be cloned.
+func backupGithub(backupDir string, config github.Config) bool {
+	out.Println()
+	out.Println("backing up github repos")
 
 	if config.Token == "" {
 		out.Println("personal access token not provided, update your config and try again")
-		return
+		report.skip("personal access token not provided")
+		return true
 	}
 
+	err := exec.CommandAvailable("gh")
+	if err != nil {
+		printError("", "no valid gh (github cli) executable found: %v", err)
+		return false
+	}
+
+	backupDir = fs.JoinPath(backupDir, "github")
+
 	out.Println("loading repos")
 	var repos []github.Repo
-	for {
+	for attempt := 1; ; attempt++ {
 		repos, err = github.LoadRepos(config.Token)
-		if err != nil {
-			out.Println("error:", err)
-			if !confirmPrompt("try again?") {
-				break
-			}
-		} else {
+		if err == nil {
 			break
 		}
+		printError("", "%v", err)
+		if !retryPrompt(attempt) {
+			return false
+		}
 	}
 
 	if len(repos) == 0 {
 		out.Println("no repos to clone")
-		return
+		return true
 	}
 
 	out.Println("found", len(repos), "repos to clone")
 
 	reposToClone := repos
-	for {
+	for attempt := 1; ; attempt++ {
 		var failed []github.Repo
 		for i, repo := range reposToClone {
 			out.Printf("cloning repo %s (%v/%v)\n", repo.FullName, i+1, len(reposToClone))
@@ -114,7 +292,7 @@ func backupGithub(backupDir string, config github.Config) {
 			empty, err := fs.IsDirEmpty(cloneDir)
 			if err != nil {
 				failed = append(failed, repo)
-				out.Println("error: could not check if clone directory exists:", err)
+				printError(repo.FullName, "could not check if clone directory exists: %v", err)
 			}
 			if !empty {
 				out.Println("skipping, target directory", cloneDir, "is not empty")
@@ -124,46 +302,46 @@ func backupGithub(backupDir string, config github.Config) {
 			result := github.CloneRepo(repo, backupDir, config.Token)
 			if result.Err != nil {
 				failed = append(failed, repo)
-				out.Println("error:", result.Err)
+				printError(repo.FullName, "%v", result.Err)
 			} else if result.ExitCode != 0 {
 				failed = append(failed, repo)
-				out.Println("error: gh exited with code", result.ExitCode)
-				if len(result.Stdout) > 0 {
-					out.Println("stdout:")
-					out.Println(result.Stdout)
-				}
-				if len(result.Stderr) > 0 {
-					out.Println("stderr:")
-					out.Println(result.Stderr)
-				}
+				printCommandError(repo.FullName, fmt.Sprint("gh exited with code ", result.ExitCode), result.Stdout, result.Stderr)
+			} else {
+				report.repoCloned(repo.FullName)
 			}
 		}
 
-		if len(failed) > 0 {
-			if len(failed) == 1 {
-				out.Println("1 repo failed to clone")
-			} else {
-				out.Println(len(failed), "repos failed to clone")
-			}
-			if confirmPrompt("try again?") {
-				reposToClone = failed
-			} else {
-				break
-			}
+		if len(failed) == 0 {
+			return true
+		}
+		if len(failed) == 1 {
+			out.Println("1 repo failed to clone")
 		} else {
-			break
+			out.Println(len(failed), "repos failed to clone")
+		}
+		if !retryPrompt(attempt) {
+			return false
 		}
+		reposToClone = failed
 	}
 }
 
-func confirmPrompt(text string) bool {
+// Asks a yes/no question. answer is the answer of the policy, it is used without asking if it is yes or if
+// questions should not be asked at all. Without any input, e.g. under cron, the question is answered with no.
+func confirmPrompt(text string, answer bool) bool {
+	if policy.NonInteractive || answer {
+		out.Printf("%s (y/n): %s\n", text, yesNo(answer))
+		return answer
+	}
 	for {
 		out.Printf("%s (y/n): ", text)
 		response, err := in.ReadLine()
-		if err != nil {
-			panic(err)
-		}
 		response = strings.TrimSpace(response)
+		if err != nil && response == "" {
+			out.Println()
+			out.Println("no input, answering no, use --yes or --non-interactive to run without a terminal")
+			return false
+		}
 
 		if response == "y" {
 			return true
@@ -175,128 +353,267 @@ func confirmPrompt(text string) bool {
 	}
 }
 
+// Asks whether a failed operation should be tried again, without asking it is tried again policy.Retries times.
+// attempt is the number of attempts so far.
+func retryPrompt(attempt int) bool {
+	if !policy.NonInteractive {
+		return confirmPrompt("try again?", false)
+	}
+	if attempt > policy.Retries {
+		return false
+	}
+	out.Printf("trying again (%v/%v)\n", attempt, policy.Retries)
+	return true
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "y"
+	}
+	return "n"
+}
+
 // no retries here, in most cases if it didn't work the first time is likely won't on further attempts
-func backupFiles(backupDir string, paths []string) {
+// Returns false if any file could not be copied.
+func backupFiles(backupDir string, paths []string, exclude []string, workers int) bool {
 	if len(paths) == 0 {
-		return
+		report.skip("no files configured")
+		return true
 	}
 
 	out.Println()
 	out.Println("backing up local files")
 
-	backupDir = fs.JoinPath(backupDir, "files")
-
-	for i, path := range paths {
-		out.Printf("copying %s (%v/%v)\n", path, i+1, len(paths))
-		absPath, err := fs.AbsPath(path)
+	ok := true
+	var jobs []files.Job
+	for _, path := range paths {
+		absPath, err := files.ValidatePath(path)
 		if err != nil {
-			out.Println("error: invalid path:", err)
+			printError(path, "%s: %v", path, err)
+			ok = false
 			continue
 		}
 
 		exists, err := fs.Exists(absPath)
 		if err != nil {
-			out.Println("error:", err)
+			printError(path, "%s: %v", path, err)
+			ok = false
 			continue
 		}
 
 		if !exists {
-			out.Println("error: file or directory does not exist")
+			printError(path, "%s: file or directory does not exist", path)
+			ok = false
 			continue
 		}
 
-		if absPath == "/" {
-			out.Println("error: copying / is a bad idea")
+		target := files.Target(backupDir, absPath)
+		err = fs.CreateDir(fs.ParentPath(target))
+		if err != nil {
+			printError(path, "%s: could not create target directory: %v", path, err)
+			ok = false
 			continue
 		}
 
-		// the full original path will be retained
-		// e.g. /home/user/abc/def will be copied to backupDir/local/home/user/abc/def
-		parentPath := fs.ParentPath(absPath)
-		targetPath := fs.JoinPath(backupDir, parentPath)
+		jobs = append(jobs, files.Job{Source: absPath, Target: target})
+	}
 
-		err = fs.CreateDir(targetPath)
-		if err != nil {
-			out.Println("error: could not create target directory:", err)
-			continue
-		}
+	if len(jobs) == 0 {
+		return ok
+	}
 
-		target := fs.JoinPath(backupDir, absPath)
-		result := copyFiles(absPath, target)
-		if result.Err != nil {
-			out.Println("error: could not copy files:", result.Err)
-		} else if result.ExitCode != 0 {
-			out.Println("error: cp exited with code", result.ExitCode)
-			if len(result.Stdout) > 0 {
-				out.Println("stdout:")
-				out.Println(result.Stdout)
+	out.Printf("copying %v paths\n", len(jobs))
+	// the progress line is overwritten on every update, padding makes sure that no characters of a longer previous line remain
+	lastLength := 0
+	results := files.CopyAll(jobs, files.Options{
+		Workers: workers,
+		Exclude: exclude,
+		OnProgress: func(p files.Progress) {
+			line := progressString(p)
+			padding := ""
+			if len(line) < lastLength {
+				padding = strings.Repeat(" ", lastLength-len(line))
 			}
-			if len(result.Stderr) > 0 {
-				out.Println("stderr:")
-				out.Println(result.Stderr)
+			lastLength = len(line)
+			out.Printf("\r%s%s", line, padding)
+			if p.Done {
+				out.Println()
 			}
+		},
+	})
+
+	for _, r := range results {
+		report.filesCopied(r)
+		if r.Err == nil {
+			continue
+		}
+		ok = false
+		if r.Failed > 1 {
+			printError(r.Job.Source, "%v files of %s could not be copied, first error: %v", r.Failed, r.Job.Source, r.Err)
+		} else {
+			printError(r.Job.Source, "could not copy %s: %v", r.Job.Source, r.Err)
 		}
 	}
+	return ok
 }
 
-func copyFiles(source, target string) exec.Result {
-	return exec.Background(
-		[]string{"cp", "-r", source, target},
-		exec.WithTimeout(0),
+func progressString(p files.Progress) string {
+	s := fmt.Sprintf(
+		"%v/%v files, %s/%s, %s/s",
+		p.FilesDone,
+		p.FilesTotal,
+		fileSizeString(p.BytesDone),
+		fileSizeString(p.BytesTotal),
+		fileSizeString(int64(p.Throughput)),
 	)
+	if p.Done {
+		return fmt.Sprintf("%s, took %s", s, p.Elapsed.Round(time.Second))
+	}
+	if p.ETA > 0 {
+		s = fmt.Sprintf("%s, %s left", s, p.ETA.Round(time.Second))
+	}
+	if p.Current != "" {
+		// long paths would wrap and break overwriting the line
+		current := p.Current
+		if len(current) > 40 {
+			current = "..." + current[len(current)-37:]
+		}
+		s = fmt.Sprintf("%s, %s", s, current)
+	}
+	return s
 }
 
-func zipDir(dir string, config zip.Config) {
+// Returns false if any command failed.
+func runCommands(backupDir string, cmds []commands.Command) bool {
+	if len(cmds) == 0 {
+		report.skip("no commands configured")
+		return true
+	}
+
 	out.Println()
-	out.Println("zipping")
-	err := exec.CommandAvailable("zip")
+	out.Println("running commands")
+
+	ok := true
+	for i, c := range cmds {
+		out.Printf("running %s (%v/%v)\n", c.Name, i+1, len(cmds))
+		result, err := commands.Run(backupDir, c)
+		ok = printCommandResult(c.Name, result, err) && ok
+	}
+	return ok
+}
+
+// prints why a command failed, returns false if it did
+func printCommandResult(name string, result exec.Result, err error) bool {
+	if err != nil {
+		printError(name, "%v", err)
+	} else if result.Err != nil {
+		printError(name, "%v", result.Err)
+	} else if result.ExitCode != 0 {
+		// the output of the command is the backup, only stderr is of interest
+		printCommandError(name, fmt.Sprint("command exited with code ", result.ExitCode), "", result.Stderr)
+	} else {
+		return true
+	}
+	return false
+}
+
+func writeManifest(backupDir string) bool {
+	out.Println()
+	out.Println("creating manifest")
+
+	exists, err := fs.DirExists(backupDir)
 	if err != nil {
-		out.Println("error: no valid zip executable found:", err)
-		return
+		printError("", "%v", err)
+		return false
+	}
+	if !exists {
+		printSkipped("nothing was backed up")
+		return true
 	}
 
+	m, err := manifest.Create(backupDir)
+	if err != nil {
+		printError("", "could not create manifest: %v", err)
+		return false
+	}
+	err = manifest.Write(backupDir, m)
+	if err != nil {
+		printError("", "could not write manifest: %v", err)
+		return false
+	}
+
+	var size int64
+	for _, f := range m.Files {
+		size += f.Size
+	}
+	out.Printf("%v files (%s) from %v repos\n", len(m.Files), fileSizeString(size), len(m.Repos))
+	return true
+}
+
+// Returns the archive that was created, "" if there is none, and false if zipping failed.
+func zipDir(dir string, config zip.Config) (string, bool) {
+	out.Println()
+	out.Println("zipping")
+
 	if config.File == "" {
-		out.Println("skipping, no zip file specified")
-		return
+		printSkipped("no zip file specified")
+		return "", true
 	}
 
-	filePath, err := fs.AbsPath(config.File)
+	filePath, err := config.TargetFile(config.File)
 	if err != nil {
-		out.Println("error: invalid zip file:", err)
+		printError("", "invalid zip file: %v", err)
+		return "", false
 	}
 
-	// copied from package zip
-	base := fs.BasePath(dir)
-	parent := fs.ParentPath(dir)
-	// why change directory? because otherwise the zip file will contain all the parent directories of files
-	// e.g. when you unzip you will get home/username/backup/somefile instead of just backup/somefile
-	// sh starts a new shell, so we do not have to worry about changing directory back
-	cmd := []string{"sh", "-c", fmt.Sprintf("cd %s && zip -r %s %s", parent, filePath, base)}
-	// no timeout, zip might take a while
-	result := exec.Background(cmd, exec.WithTimeout(0))
+	opts, err := config.ArchiveOptions()
+	if err != nil {
+		printError("", "invalid zip config: %v", err)
+		return "", false
+	}
 
-	if result.Err != nil {
-		out.Println("error: zip failed:", err)
-	} else if result.ExitCode != 0 {
-		out.Println("error: zip failed with exit code", result.ExitCode)
-		if len(result.Stdout) > 0 {
-			out.Println("stdout:")
-			out.Println(result.Stdout)
+	lastLength := 0
+	opts.OnProgress = func(p archive.Progress) {
+		line := fmt.Sprintf("%v/%v files, %s/%s", p.FilesDone, p.FilesTotal, fileSizeString(p.BytesDone), fileSizeString(p.BytesTotal))
+		padding := ""
+		if len(line) < lastLength {
+			padding = strings.Repeat(" ", lastLength-len(line))
+		}
+		lastLength = len(line)
+		out.Printf("\r%s%s", line, padding)
+		if p.Done {
+			out.Println()
 		}
-		if len(result.Stderr) > 0 {
-			out.Println("stderr:")
-			out.Println(result.Stderr)
+	}
+	result, err := archive.Create(dir, filePath, opts)
+	if err != nil {
+		if lastLength > 0 {
+			out.Println()
 		}
+		printError("", "zip failed: %v", err)
+		return "", false
+	}
+
+	out.Printf("created %s %s (%s) in %s\n", result.File, fileSizeString(result.ArchiveSize), ratioString(result), result.Time.Round(time.Second))
+	if len(result.Volumes) > 1 {
+		out.Printf("split into %v volumes, index %s\n", len(result.Volumes), volume.IndexFile(result.File))
 	}
+	report.archiveCreated(result)
+	return result.File, true
+}
 
-	size, err := fs.FileSize(filePath)
-	if err == nil {
-		out.Println("created", filePath, fileSizeString(size))
+// size of the archive compared to the size of the files in it
+func ratioString(r archive.Result) string {
+	if r.Size == 0 {
+		return "ratio -"
 	}
+	return fmt.Sprintf("%.0f%% of %s", float64(r.ArchiveSize)/float64(r.Size)*100, fileSizeString(r.Size))
 }
 
 func fileSizeString(size int64) string {
-	if size >= 1024*1024 {
+	if size >= 1024*1024*1024 {
+		return fmt.Sprintf("%.1fG", float64(size)/(1024*1024*1024))
+	} else if size >= 1024*1024 {
 		return fmt.Sprintf("%vM", size/(1024*1024))
 	} else if size >= 1024 {
 		return fmt.Sprintf("%vK", size/1024)
@@ -0,0 +1,272 @@
+package script
+
+import (
+	"backup/internal/archive"
+	"backup/internal/commands"
+	"backup/internal/config"
+	"backup/internal/exec"
+	"backup/internal/files"
+	"backup/internal/fs"
+	"backup/internal/github"
+	"backup/internal/stream"
+	"backup/internal/volume"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// streamBackup writes the backup straight into the archive, the backup directory is only used for the name of the
+// top level directory in the archive.
+func streamBackup(config config.Config) Result {
+	if !runPhase("preflight", func() bool { return checkFreeSpace(config) }) {
+		return ResultFatal
+	}
+
+	// the archive reports progress for every file, it is only shown while local files are added
+	var progress *progressLine
+	b, err := stream.Start(config.BackupDir, config.Zip, func(p archive.Progress) {
+		if progress != nil {
+			progress.Print(fmt.Sprintf("%v files, %s", p.FilesDone, fileSizeString(p.BytesDone)))
+		}
+	})
+	if err != nil {
+		printError("", "%v", err)
+		return ResultFatal
+	}
+	out.Println()
+	out.Println("streaming backup into", b.File())
+
+	ok := runPhase("github", func() bool { return streamGithub(b, config.Github) })
+	if b.Err() == nil {
+		added := len(b.Manifest().Files)
+		progress = &progressLine{}
+		report.startPhase("files")
+		filesOK := streamFiles(b, config.Files, config.Exclude, progress)
+		// progress is only reported every now and then, the last report is not the total
+		if b.Err() == nil && len(config.Files) > 0 {
+			var size int64
+			newFiles := b.Manifest().Files[added:]
+			for _, f := range newFiles {
+				size += f.Size
+			}
+			progress.Print(fmt.Sprintf("%v files, %s", len(newFiles), fileSizeString(size)))
+		}
+		progress.End()
+		progress = nil
+		report.endPhase(filesOK)
+		ok = filesOK && ok
+	}
+	if b.Err() == nil {
+		ok = runPhase("commands", func() bool { return streamCommands(b, config.Commands) }) && ok
+	}
+	if b.Err() != nil {
+		printError("", "%v", b.Err())
+		b.Abort()
+		return ResultFatal
+	}
+
+	report.startPhase("manifest")
+	out.Println()
+	out.Println("creating manifest")
+	m, err := b.WriteManifest()
+	if err != nil {
+		printError("", "could not write manifest: %v", err)
+		report.endPhase(false)
+		b.Abort()
+		return ResultFatal
+	}
+	var size int64
+	for _, f := range m.Files {
+		size += f.Size
+	}
+	out.Printf("%v files (%s) from %v repos\n", len(m.Files), fileSizeString(size), len(m.Repos))
+	report.endPhase(true)
+
+	report.startPhase("zip")
+	result, err := b.Close()
+	if err != nil {
+		printError("", "zip failed: %v", err)
+		report.endPhase(false)
+		return ResultFatal
+	}
+	out.Printf("created %s %s (%s) in %s\n", result.File, fileSizeString(result.ArchiveSize), ratioString(result), result.Time.Round(time.Second))
+	if len(result.Volumes) > 1 {
+		out.Printf("split into %v volumes, index %s\n", len(result.Volumes), volume.IndexFile(result.File))
+	}
+	report.archiveCreated(result)
+	report.endPhase(true)
+
+	if !uploadBackup(config, "", result.File) || !ok {
+		return ResultPartial
+	}
+	return ResultSuccess
+}
+
+// Returns false if any repo could not be bundled.
+func streamGithub(b *stream.Backup, config github.Config) bool {
+	out.Println()
+	out.Println("backing up github repos")
+
+	if config.Token == "" {
+		out.Println("personal access token not provided, update your config and try again")
+		report.skip("personal access token not provided")
+		return true
+	}
+
+	err := exec.CommandAvailable("gh")
+	if err != nil {
+		printError("", "no valid gh (github cli) executable found: %v", err)
+		return false
+	}
+
+	out.Println("loading repos")
+	var repos []github.Repo
+	for attempt := 1; ; attempt++ {
+		repos, err = github.LoadRepos(config.Token)
+		if err == nil {
+			break
+		}
+		printError("", "%v", err)
+		if !retryPrompt(attempt) {
+			return false
+		}
+	}
+
+	if len(repos) == 0 {
+		out.Println("no repos to clone")
+		return true
+	}
+
+	out.Println("found", len(repos), "repos to bundle")
+
+	reposToClone := repos
+	for attempt := 1; ; attempt++ {
+		var failed []github.Repo
+		for i, repo := range reposToClone {
+			out.Printf("bundling repo %s (%v/%v)\n", repo.FullName, i+1, len(reposToClone))
+			result, err := b.Repo(repo, config.Token)
+			if b.Err() != nil {
+				return false
+			}
+			if err != nil {
+				failed = append(failed, repo)
+				printError(repo.FullName, "%v", err)
+			} else if result.Err != nil {
+				failed = append(failed, repo)
+				printError(repo.FullName, "%v", result.Err)
+			} else if result.ExitCode != 0 {
+				failed = append(failed, repo)
+				printCommandError(repo.FullName, fmt.Sprintf("%s exited with code %v", result.Cmd[0], result.ExitCode), "", result.Stderr)
+			} else {
+				report.repoCloned(repo.FullName)
+			}
+		}
+
+		if len(failed) == 0 {
+			return true
+		}
+		if len(failed) == 1 {
+			out.Println("1 repo failed to bundle")
+		} else {
+			out.Println(len(failed), "repos failed to bundle")
+		}
+		if !retryPrompt(attempt) {
+			return false
+		}
+		reposToClone = failed
+	}
+}
+
+// Returns false if any file could not be added.
+func streamFiles(b *stream.Backup, paths []string, exclude []string, progress *progressLine) bool {
+	if len(paths) == 0 {
+		report.skip("no files configured")
+		return true
+	}
+
+	out.Println()
+	out.Println("backing up local files")
+
+	ok := true
+	for _, path := range paths {
+		absPath, err := files.ValidatePath(path)
+		if err != nil {
+			printError(path, "%s: %v", path, err)
+			ok = false
+			continue
+		}
+
+		exists, err := fs.Exists(absPath)
+		if err != nil {
+			printError(path, "%s: %v", path, err)
+			ok = false
+			continue
+		}
+		if !exists {
+			printError(path, "%s: file or directory does not exist", path)
+			ok = false
+			continue
+		}
+
+		r := b.Files(absPath, exclude)
+		if b.Err() != nil {
+			return false
+		}
+		report.filesCopied(r)
+		if r.Err != nil {
+			progress.End()
+			ok = false
+		}
+		if r.Failed > 1 {
+			printError(path, "%v files of %s could not be added, first error: %v", r.Failed, absPath, r.Err)
+		} else if r.Err != nil {
+			printError(path, "could not add %s: %v", absPath, r.Err)
+		}
+	}
+	return ok
+}
+
+// Returns false if any command failed.
+func streamCommands(b *stream.Backup, cmds []commands.Command) bool {
+	if len(cmds) == 0 {
+		report.skip("no commands configured")
+		return true
+	}
+
+	out.Println()
+	out.Println("running commands")
+
+	ok := true
+	for i, c := range cmds {
+		out.Printf("running %s (%v/%v)\n", c.Name, i+1, len(cmds))
+		result, err := b.Command(c)
+		if b.Err() != nil {
+			return false
+		}
+		ok = printCommandResult(c.Name, result, err) && ok
+	}
+	return ok
+}
+
+// a line that is overwritten by the next one, e.g. to show progress
+type progressLine struct {
+	length int
+}
+
+func (l *progressLine) Print(line string) {
+	// padding makes sure that no characters of a longer previous line remain
+	padding := ""
+	if len(line) < l.length {
+		padding = strings.Repeat(" ", l.length-len(line))
+	}
+	l.length = len(line)
+	out.Printf("\r%s%s", line, padding)
+}
+
+// End moves to the next line if anything was printed, the next Print starts a new line.
+func (l *progressLine) End() {
+	if l.length > 0 {
+		out.Println()
+	}
+	l.length = 0
+}
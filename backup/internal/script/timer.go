@@ -0,0 +1,149 @@
+package script
+
+import (
+	"backup/internal/fs"
+	"backup/internal/timer"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+type TimerArgs struct {
+	// name of the units, see timer.DefaultName
+	Name string
+	// systemd calendar expression
+	Schedule   string
+	ConfigFile string
+	// answers of the questions of the scheduled runs, always non-interactive
+	Policy Policy
+}
+
+// InstallTimer installs a systemd user timer that runs this binary with the config on the schedule.
+func InstallTimer(args TimerArgs) bool {
+	exe, err := os.Executable()
+	if err == nil {
+		exe, err = filepath.EvalSymlinks(exe)
+	}
+	if err != nil {
+		out.Println("error: could not find the path of the backup binary:", err)
+		return false
+	}
+	if strings.HasPrefix(exe, os.TempDir()+"/") {
+		out.Println("warning:", exe, "is a temporary file, e.g. of go run, install the binary and run install-timer again")
+	}
+
+	cmd := []string{exe}
+	if args.ConfigFile != "" {
+		configFile, err := fs.AbsPath(args.ConfigFile)
+		if err != nil {
+			out.Println("error: invalid config file:", err)
+			return false
+		}
+		cmd = append(cmd, "--config", configFile)
+	} else {
+		out.Println("warning: no config file given, the scheduled backups use the defaults")
+	}
+	args.Policy.NonInteractive = true
+	cmd = append(cmd, args.Policy.flags()...)
+
+	o := timer.Options{Name: args.Name, Schedule: args.Schedule, Command: cmd}
+	if err := timer.Install(o); err != nil {
+		out.Println("error:", err)
+		return false
+	}
+	service, t, _ := timer.Files(args.Name)
+	out.Println("installed", service)
+	out.Println("installed", t)
+	out.Println()
+	return printTimerStatus(args.Name)
+}
+
+// UninstallTimer stops the timer and removes its units.
+func UninstallTimer(name string) bool {
+	removed, err := timer.Uninstall(name)
+	for _, f := range removed {
+		out.Println("removed", f)
+	}
+	if err != nil {
+		out.Println("error:", err)
+		return false
+	}
+	return true
+}
+
+// TimerStatus shows whether the timer is installed, when it runs next and how the last run went.
+func TimerStatus(name string) bool {
+	return printTimerStatus(name)
+}
+
+func printTimerStatus(name string) bool {
+	s, err := timer.GetStatus(name)
+	if !s.Installed && err == nil {
+		out.Println("not installed, see install-timer")
+		return false
+	}
+	out.Println("timer:", s.Timer)
+	out.Println("service:", s.Service)
+	out.Println("schedule:", s.Schedule)
+	if err != nil {
+		out.Println("error:", err)
+		return false
+	}
+	out.Printf("state: %s, %s\n", s.Active, s.Enabled)
+	out.Println("next run:", orNone(s.NextRun))
+	switch {
+	case s.Running:
+		out.Println("last run:", s.LastRun, "(running)")
+	case s.Result == "":
+		out.Println("last run:", orNone(s.LastRun))
+	case s.Result == "success":
+		out.Println("last run:", s.LastRun, "(succeeded)")
+	default:
+		out.Printf("last run: %s (%s)\n", s.LastRun, exitCodeString(s.ExitCode))
+	}
+	out.Printf("logs: journalctl --user -u %s.service\n", name)
+	return true
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "none"
+	}
+	return s
+}
+
+func exitCodeString(code int) string {
+	switch Result(code) {
+	case ResultFatal:
+		return "failed, exit code 1"
+	case ResultPartial:
+		return "parts of the backup failed, exit code 2"
+	default:
+		return fmt.Sprintf("failed, exit code %v", code)
+	}
+}
+
+// the flags of the backup command that give the same policy
+func (p Policy) flags() []string {
+	var flags []string
+	if p.NonInteractive {
+		flags = append(flags, "--non-interactive")
+	}
+	for _, f := range []struct {
+		name   string
+		answer bool
+	}{
+		{"--on-existing-dir", p.ExistingDir},
+		{"--on-missing-parent", p.MissingParent},
+		{"--on-low-space", p.LowSpace},
+	} {
+		value := "abort"
+		if f.answer {
+			value = "continue"
+		}
+		flags = append(flags, f.name, value)
+	}
+	return append(flags, "--retries", strconv.Itoa(p.Retries))
+}
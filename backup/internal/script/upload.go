@@ -0,0 +1,271 @@
+package script
+
+import (
+	"backup/internal/archive"
+	"backup/internal/config"
+	"backup/internal/drive"
+	"backup/internal/fs"
+	"backup/internal/s3"
+	"backup/internal/ssh"
+	"fmt"
+	"time"
+)
+
+// Upload uploads an archive to the destinations from the config, the zip file from the config if file is empty.
+// Destinations that sync the backup directory sync the one from the config instead.
+// Uploading the same archive again continues an interrupted upload.
+// Returns false if anything could not be uploaded.
+func Upload(configFile string, file string) bool {
+	out.Println("loading config")
+	config, err := config.LoadConfig(configFile)
+	if err != nil {
+		out.Println("error:", err)
+		return false
+	}
+	if !config.S3.Enabled() && !config.SSH.Enabled() && !config.Removable.Enabled() {
+		out.Println("error: no upload destination configured")
+		return false
+	}
+
+	if config.S3.Enabled() || (config.SSH.Enabled() && !config.SSH.SyncDir) || (config.Removable.Enabled() && !config.Removable.Snapshot) {
+		if file == "" {
+			if config.Zip.File == "" {
+				out.Println("error: no archive given and no zip file specified")
+				return false
+			}
+			file, err = config.Zip.TargetFile(config.Zip.File)
+		} else {
+			file, err = fs.AbsPath(file)
+		}
+		if err != nil {
+			out.Println("error: invalid archive:", err)
+			return false
+		}
+	}
+	return uploadBackup(config, config.BackupDir, file)
+}
+
+// Uploads the archive or backup directory to every configured destination, returns false if any upload failed.
+// file is empty if no archive was created, backupDir if the backup was streamed.
+func uploadBackup(config config.Config, backupDir string, file string) bool {
+	ok := true
+	if config.S3.Enabled() {
+		ok = runPhase("s3", func() bool { return uploadS3(config, file) }) && ok
+	}
+	if config.SSH.Enabled() {
+		ok = runPhase("ssh", func() bool { return uploadSSH(config, backupDir, file) }) && ok
+	}
+	if config.Removable.Enabled() {
+		ok = runPhase("drives", func() bool { return copyToDrives(config, backupDir, file) }) && ok
+	}
+	return ok
+}
+
+func uploadS3(config config.Config, file string) bool {
+	out.Println()
+	out.Println("uploading to", config.S3.URL())
+	if file == "" {
+		printSkipped("no archive was created")
+		return true
+	}
+	files, err := archive.Files(file)
+	if err != nil {
+		printError("", "%v", err)
+		return false
+	}
+
+	progress := &progressLine{}
+	result, err := s3.Upload(config.S3, files, func(p s3.Progress) {
+		progress.Print(fmt.Sprintf("%s/%s %s", fileSizeString(p.BytesDone), fileSizeString(p.BytesTotal), p.Current))
+	})
+	progress.End()
+	if err != nil {
+		printError("", "upload failed: %v", err)
+		out.Println("run the upload again to continue where it stopped")
+		return false
+	}
+
+	var size int64
+	existed, resumed := 0, 0
+	for _, o := range result.Objects {
+		size += o.Size
+		if o.Existed {
+			existed++
+		}
+		resumed += o.ResumedParts
+	}
+	out.Printf("uploaded %v files (%s) to %s in %s\n", len(result.Objects), fileSizeString(size), result.URL, result.Time.Round(time.Second))
+	if existed > 0 {
+		out.Printf("%v files were already uploaded\n", existed)
+	}
+	if resumed > 0 {
+		out.Printf("continued an interrupted upload, %v parts were already uploaded\n", resumed)
+	}
+	if len(result.Deleted) > 0 {
+		out.Printf("deleted %v objects of old backups\n", len(result.Deleted))
+	}
+	if result.DeleteErr != nil {
+		printWarning("", "could not delete old backups: %v", result.DeleteErr)
+	}
+	return true
+}
+
+func uploadSSH(config config.Config, backupDir string, file string) bool {
+	out.Println()
+	out.Println("copying to", config.SSH.URL())
+
+	progress := &progressLine{}
+	onProgress := func(p ssh.Progress) {
+		line := fmt.Sprintf("%s/%s", fileSizeString(p.BytesDone), fileSizeString(p.BytesTotal))
+		if p.Current != "" {
+			line += " " + p.Current
+		}
+		progress.Print(line)
+	}
+
+	if config.SSH.SyncDir {
+		if backupDir == "" {
+			printError("", "cannot sync the backup directory, the backup was streamed into the archive")
+			return false
+		}
+		result, err := ssh.Sync(config.SSH, backupDir, onProgress)
+		progress.End()
+		if err != nil {
+			printError("", "sync failed: %v", err)
+			return false
+		}
+		out.Printf("synced %s (%s) to %s in %s\n", backupDir, fileSizeString(result.Size), result.Target, result.Time.Round(time.Second))
+		return true
+	}
+
+	if file == "" {
+		printSkipped("no archive was created")
+		return true
+	}
+	files, err := archive.Files(file)
+	if err != nil {
+		printError("", "%v", err)
+		return false
+	}
+	result, err := ssh.Upload(config.SSH, files, onProgress)
+	progress.End()
+	if err != nil {
+		printError("", "upload failed: %v", err)
+		out.Println("run the upload again to continue where it stopped")
+		return false
+	}
+
+	var size, resumed int64
+	existed := 0
+	for _, f := range result.Files {
+		size += f.Size
+		resumed += f.Resumed
+		if f.Existed {
+			existed++
+		}
+	}
+	out.Printf("copied %v files (%s) to %s in %s\n", len(result.Files), fileSizeString(size), result.Target, result.Time.Round(time.Second))
+	if existed > 0 {
+		out.Printf("%v files were already on the server\n", existed)
+	}
+	if resumed > 0 {
+		out.Printf("continued an interrupted upload, %s were already uploaded\n", fileSizeString(resumed))
+	}
+	return true
+}
+
+// A drive that is not plugged in is not an error, the status shows which drive should be swapped in next.
+func copyToDrives(config config.Config, backupDir string, file string) bool {
+	c := config.Removable
+	out.Println()
+	out.Println("copying to drives", c.Names())
+
+	mounts, err := c.Mounted()
+	if err != nil {
+		printError("", "could not find drives: %v", err)
+		return false
+	}
+	if len(mounts) == 0 {
+		printSkipped("none of the drives is mounted")
+		printDriveStatus(c)
+		return true
+	}
+
+	progress := &progressLine{}
+	onProgress := func(p drive.Progress) {
+		progress.Print(fmt.Sprintf("%s: %s/%s %s", p.Drive, fileSizeString(p.BytesDone), fileSizeString(p.BytesTotal), p.Current))
+	}
+	var results []drive.Result
+	if c.Snapshot {
+		if backupDir == "" {
+			printError("", "cannot copy the backup directory, the backup was streamed into the archive")
+			return false
+		}
+		results, err = drive.CopyDir(c, backupDir, onProgress)
+	} else {
+		if file == "" {
+			printSkipped("no archive was created")
+			return true
+		}
+		var files []string
+		files, err = archive.Files(file)
+		if err == nil {
+			results, err = drive.Copy(c, files, onProgress)
+		}
+	}
+	progress.End()
+
+	ok := true
+	for _, r := range results {
+		if r.Err != nil {
+			printError(r.Drive.ID, "copy failed: %v", r.Err)
+			ok = false
+			continue
+		}
+		out.Printf("copied %v files (%s) to %s in %s\n", r.Backup.Files, fileSizeString(r.Backup.Size), r.Path, r.Time.Round(time.Second))
+		if len(r.Deleted) > 0 {
+			out.Printf("deleted %v old backups from %s\n", len(r.Deleted), r.Drive.ID)
+		}
+		if r.DeleteErr != nil {
+			printWarning(r.Drive.ID, "could not delete old backups from %s: %v", r.Drive.ID, r.DeleteErr)
+		}
+	}
+	if err != nil {
+		printError("", "copy failed: %v", err)
+		return false
+	}
+	printDriveStatus(c)
+	return ok
+}
+
+func printDriveStatus(c *drive.Config) {
+	status, err := c.Status()
+	if err != nil {
+		printWarning("", "could not read records of drives: %v", err)
+		return
+	}
+	out.Println("drives, most out of date first:")
+	for _, s := range status {
+		out.Printf("  %s: %s\n", s.ID, s.Summary())
+	}
+}
+
+// Drives prints which backups the configured drives hold, the most out of date drive first.
+// Returns false if no drives are configured.
+func Drives(configFile string) bool {
+	config, err := config.LoadConfig(configFile)
+	if err != nil {
+		out.Println("error:", err)
+		return false
+	}
+	if !config.Removable.Enabled() {
+		out.Println("error: no drives configured")
+		return false
+	}
+	if err := config.Removable.Validate(); err != nil {
+		out.Println("error:", err)
+		return false
+	}
+	printDriveStatus(config.Removable)
+	return true
+}
@@ -0,0 +1,52 @@
+package script
+
+import (
+	"backup/internal/config"
+	"backup/internal/fs"
+	"backup/internal/verify"
+)
+
+// Verify checks a backup directory or archive and prints every problem that was found.
+// If no path is given, the zip file from the config is verified or the backup directory if there is none or it is encrypted.
+// Returns false if the backup could not be verified or has problems.
+func Verify(configFile string, path string) bool {
+	if path == "" {
+		out.Println("loading config")
+		config, err := config.LoadConfig(configFile)
+		if err != nil {
+			out.Println("error:", err)
+			return false
+		}
+		path = config.BackupDir
+		if config.Zip.File != "" && !config.Zip.Encryption.Enabled() {
+			path = config.Zip.File
+		}
+	}
+
+	absPath, err := fs.AbsPath(path)
+	if err != nil {
+		out.Println("error: invalid path:", err)
+		return false
+	}
+
+	out.Println("verifying", absPath)
+	result, err := verify.Verify(absPath)
+	if err != nil {
+		out.Println("error:", err)
+		return false
+	}
+
+	if !result.HasManifest {
+		out.Println("warning: no manifest found, only checked that files can be read")
+	}
+	for _, p := range result.Problems {
+		if p.Detail != "" {
+			out.Printf("%s: %s (%s)\n", p.Status, p.Path, p.Detail)
+		} else {
+			out.Printf("%s: %s\n", p.Status, p.Path)
+		}
+	}
+	out.Println(result.Summary())
+
+	return result.Ok()
+}
@@ -0,0 +1,612 @@
+package settings
+
+import (
+	"backup/internal/archive"
+	"backup/internal/config"
+	"backup/internal/crypt"
+	"backup/internal/drive"
+	"backup/internal/files"
+	"backup/internal/fs"
+	"backup/internal/s3"
+	"backup/internal/ssh"
+	"backup/internal/volume"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+type kind int
+
+const (
+	kindText kind = iota
+	// masked when shown and edited
+	kindSecret
+	// comma separated when edited
+	kindList
+	kindNumber
+)
+
+// A field is a single setting of the config. New settings only need a new entry in fields.
+type field struct {
+	section string
+	name    string
+	// shown when the value is empty
+	placeholder string
+	kind        kind
+	get         func(c *config.Config) string
+	// validates the value and updates the config
+	set func(c *config.Config, value string) error
+}
+
+func (f field) FilterValue() string {
+	return f.name
+}
+
+var fields = []field{
+	{
+		section:     "General",
+		name:        "Backup Directory",
+		placeholder: "~/backup-<date>",
+		kind:        kindText,
+		get:         func(c *config.Config) string { return c.BackupDir },
+		set: func(c *config.Config, value string) error {
+			if value != "" {
+				absPath, err := fs.AbsPath(value)
+				if err != nil {
+					return err
+				}
+				if absPath == "/" {
+					return errors.New("cannot use the root directory")
+				}
+			}
+			c.BackupDir = value
+			return nil
+		},
+	},
+	{
+		section:     "GitHub",
+		name:        "Personal Access Token",
+		placeholder: "not set, repos are skipped",
+		kind:        kindSecret,
+		get:         func(c *config.Config) string { return c.Github.Token },
+		set: func(c *config.Config, value string) error {
+			if strings.ContainsAny(value, " \t") {
+				return errors.New("token cannot contain whitespace")
+			}
+			c.Github.Token = value
+			return nil
+		},
+	},
+	{
+		section:     "Zip",
+		name:        "Zip File",
+		placeholder: "not set, nothing is zipped",
+		kind:        kindText,
+		get:         func(c *config.Config) string { return c.Zip.File },
+		set: func(c *config.Config, value string) error {
+			if value != "" {
+				absPath, err := fs.AbsPath(value)
+				if err != nil {
+					return err
+				}
+				exists, err := fs.DirExists(absPath)
+				if err != nil {
+					return err
+				}
+				if exists {
+					return errors.New("is a directory")
+				}
+			}
+			c.Zip.File = value
+			return nil
+		},
+	},
+	{
+		section:     "Zip",
+		name:        "Archive Format",
+		placeholder: "zip",
+		kind:        kindText,
+		get:         func(c *config.Config) string { return c.Zip.Format },
+		set: func(c *config.Config, value string) error {
+			format, err := archive.ParseFormat(value)
+			if err != nil {
+				return err
+			}
+			if err := archive.ValidateLevel(format, c.Zip.Level); err != nil {
+				return fmt.Errorf("%w, change the compression level first", err)
+			}
+			if value != "" {
+				value = string(format)
+			}
+			c.Zip.Format = value
+			return nil
+		},
+	},
+	{
+		section:     "Zip",
+		name:        "Compression Level",
+		placeholder: "default of the format",
+		kind:        kindNumber,
+		get: func(c *config.Config) string {
+			if c.Zip.Level == 0 {
+				return ""
+			}
+			return strconv.Itoa(c.Zip.Level)
+		},
+		set: func(c *config.Config, value string) error {
+			if value == "" {
+				c.Zip.Level = 0
+				return nil
+			}
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return errors.New("must be a number")
+			}
+			format, err := archive.ParseFormat(c.Zip.Format)
+			if err != nil {
+				return err
+			}
+			if err := archive.ValidateLevel(format, n); err != nil {
+				return err
+			}
+			c.Zip.Level = n
+			return nil
+		},
+	},
+	{
+		section:     "Zip",
+		name:        "Split Size",
+		placeholder: "not split",
+		kind:        kindText,
+		get:         func(c *config.Config) string { return c.Zip.SplitSize },
+		set: func(c *config.Config, value string) error {
+			if value != "" {
+				if _, err := volume.ParseSize(value); err != nil {
+					return err
+				}
+			}
+			c.Zip.SplitSize = value
+			return nil
+		},
+	},
+	{
+		section:     "Zip",
+		name:        "Stream",
+		placeholder: "no, archive the backup directory",
+		kind:        kindText,
+		get: func(c *config.Config) string {
+			if c.Zip.Stream {
+				return "yes"
+			}
+			return ""
+		},
+		set: func(c *config.Config, value string) error {
+			switch strings.ToLower(value) {
+			case "", "no", "false":
+				c.Zip.Stream = false
+			case "yes", "true":
+				c.Zip.Stream = true
+			default:
+				return errors.New("must be yes or no")
+			}
+			return nil
+		},
+	},
+	{
+		section:     "Encryption",
+		name:        "Recipients",
+		placeholder: "no public keys",
+		kind:        kindList,
+		get: func(c *config.Config) string {
+			if c.Zip.Encryption == nil {
+				return ""
+			}
+			return joinList(c.Zip.Encryption.Recipients)
+		},
+		set: func(c *config.Config, value string) error {
+			recipients := splitList(value)
+			for _, r := range recipients {
+				if _, err := crypt.ParsePublicKey(r); err != nil {
+					return err
+				}
+			}
+			encryption(c).Recipients = recipients
+			cleanEncryption(c)
+			return nil
+		},
+	},
+	{
+		section:     "Encryption",
+		name:        "Passphrase File",
+		placeholder: "not set",
+		kind:        kindText,
+		get: func(c *config.Config) string {
+			if c.Zip.Encryption == nil {
+				return ""
+			}
+			return c.Zip.Encryption.PassphraseFile
+		},
+		set: func(c *config.Config, value string) error {
+			if value != "" {
+				if _, err := crypt.ReadPassphraseFile(value); err != nil {
+					return err
+				}
+			}
+			encryption(c).PassphraseFile = value
+			cleanEncryption(c)
+			return nil
+		},
+	},
+	{
+		section:     "Encryption",
+		name:        "Identity File",
+		placeholder: "not set, only needed to decrypt",
+		kind:        kindText,
+		get: func(c *config.Config) string {
+			if c.Zip.Encryption == nil {
+				return ""
+			}
+			return c.Zip.Encryption.IdentityFile
+		},
+		set: func(c *config.Config, value string) error {
+			if value != "" {
+				if _, err := crypt.ReadIdentityFile(value); err != nil {
+					return err
+				}
+			}
+			encryption(c).IdentityFile = value
+			cleanEncryption(c)
+			return nil
+		},
+	},
+	{
+		section:     "S3 Upload",
+		name:        "Bucket",
+		placeholder: "not set, nothing is uploaded",
+		kind:        kindText,
+		get: func(c *config.Config) string {
+			if c.S3 == nil {
+				return ""
+			}
+			return c.S3.Bucket
+		},
+		set: func(c *config.Config, value string) error {
+			if strings.ContainsAny(value, " /\t") {
+				return errors.New("bucket cannot contain whitespace or slashes")
+			}
+			s3Config(c).Bucket = value
+			cleanS3(c)
+			return nil
+		},
+	},
+	{
+		section:     "S3 Upload",
+		name:        "Endpoint",
+		placeholder: "AWS S3",
+		kind:        kindText,
+		get: func(c *config.Config) string {
+			if c.S3 == nil {
+				return ""
+			}
+			return c.S3.Endpoint
+		},
+		set: func(c *config.Config, value string) error {
+			if value != "" && !strings.HasPrefix(value, "http://") && !strings.HasPrefix(value, "https://") {
+				return errors.New("must start with http:// or https://")
+			}
+			s3Config(c).Endpoint = value
+			cleanS3(c)
+			return nil
+		},
+	},
+	{
+		section:     "S3 Upload",
+		name:        "Prefix",
+		placeholder: "root of the bucket",
+		kind:        kindText,
+		get: func(c *config.Config) string {
+			if c.S3 == nil {
+				return ""
+			}
+			return c.S3.Prefix
+		},
+		set: func(c *config.Config, value string) error {
+			s3Config(c).Prefix = value
+			cleanS3(c)
+			return nil
+		},
+	},
+	{
+		section:     "S3 Upload",
+		name:        "Keep Backups",
+		placeholder: "all",
+		kind:        kindNumber,
+		get: func(c *config.Config) string {
+			if c.S3 == nil || c.S3.Keep == 0 {
+				return ""
+			}
+			return strconv.Itoa(c.S3.Keep)
+		},
+		set: func(c *config.Config, value string) error {
+			n := 0
+			if value != "" {
+				var err error
+				n, err = strconv.Atoi(value)
+				if err != nil || n < 1 {
+					return errors.New("must be a number of at least 1")
+				}
+			}
+			s3Config(c).Keep = n
+			cleanS3(c)
+			return nil
+		},
+	},
+	{
+		section:     "SSH Upload",
+		name:        "Target",
+		placeholder: "not set, e.g. user@host:/path",
+		kind:        kindText,
+		get: func(c *config.Config) string {
+			if c.SSH == nil {
+				return ""
+			}
+			return c.SSH.Target
+		},
+		set: func(c *config.Config, value string) error {
+			if value != "" {
+				if err := (&ssh.Config{Target: value}).Validate(); err != nil {
+					return err
+				}
+			}
+			sshConfig(c).Target = value
+			cleanSSH(c)
+			return nil
+		},
+	},
+	{
+		section:     "SSH Upload",
+		name:        "Identity File",
+		placeholder: "ssh agent and default keys",
+		kind:        kindText,
+		get: func(c *config.Config) string {
+			if c.SSH == nil {
+				return ""
+			}
+			return c.SSH.IdentityFile
+		},
+		set: func(c *config.Config, value string) error {
+			if value != "" {
+				path, err := fs.AbsPath(value)
+				if err != nil {
+					return err
+				}
+				exists, err := fs.Exists(path)
+				if err != nil {
+					return err
+				}
+				if !exists {
+					return errors.New("file does not exist")
+				}
+			}
+			sshConfig(c).IdentityFile = value
+			cleanSSH(c)
+			return nil
+		},
+	},
+	{
+		section:     "SSH Upload",
+		name:        "Sync Backup Directory",
+		placeholder: "no, upload the archive",
+		kind:        kindText,
+		get: func(c *config.Config) string {
+			if c.SSH != nil && c.SSH.SyncDir {
+				return "yes"
+			}
+			return ""
+		},
+		set: func(c *config.Config, value string) error {
+			switch strings.ToLower(value) {
+			case "", "no", "false":
+				sshConfig(c).SyncDir = false
+			case "yes", "true":
+				sshConfig(c).SyncDir = true
+			default:
+				return errors.New("must be yes or no")
+			}
+			cleanSSH(c)
+			return nil
+		},
+	},
+	{
+		section:     "Removable Drives",
+		name:        "Drives",
+		placeholder: "none, labels or UUIDs",
+		kind:        kindList,
+		get: func(c *config.Config) string {
+			if c.Removable == nil {
+				return ""
+			}
+			return joinList(c.Removable.Drives)
+		},
+		set: func(c *config.Config, value string) error {
+			drives := splitList(value)
+			if len(drives) > 0 {
+				if err := (&drive.Config{Drives: drives}).Validate(); err != nil {
+					return err
+				}
+			}
+			removable(c).Drives = drives
+			cleanRemovable(c)
+			return nil
+		},
+	},
+	{
+		section:     "Removable Drives",
+		name:        "Keep Backups",
+		placeholder: "all",
+		kind:        kindNumber,
+		get: func(c *config.Config) string {
+			if c.Removable == nil || c.Removable.Keep == 0 {
+				return ""
+			}
+			return strconv.Itoa(c.Removable.Keep)
+		},
+		set: func(c *config.Config, value string) error {
+			n := 0
+			if value != "" {
+				var err error
+				n, err = strconv.Atoi(value)
+				if err != nil || n < 1 {
+					return errors.New("must be a number of at least 1")
+				}
+			}
+			removable(c).Keep = n
+			cleanRemovable(c)
+			return nil
+		},
+	},
+	{
+		section:     "Files",
+		name:        "Files",
+		placeholder: "no files",
+		kind:        kindList,
+		get:         func(c *config.Config) string { return joinList(c.Files) },
+		set: func(c *config.Config, value string) error {
+			paths := splitList(value)
+			for _, p := range paths {
+				if _, err := files.ValidatePath(p); err != nil {
+					return fmt.Errorf("%s: %w", p, err)
+				}
+			}
+			c.Files = paths
+			return nil
+		},
+	},
+	{
+		section:     "Files",
+		name:        "Exclude",
+		placeholder: "nothing excluded",
+		kind:        kindList,
+		get:         func(c *config.Config) string { return joinList(c.Exclude) },
+		set: func(c *config.Config, value string) error {
+			patterns := splitList(value)
+			for _, p := range patterns {
+				if _, err := filepath.Match(p, ""); err != nil {
+					return fmt.Errorf("%s: %w", p, err)
+				}
+			}
+			c.Exclude = patterns
+			return nil
+		},
+	},
+	{
+		section:     "Files",
+		name:        "Copy Workers",
+		placeholder: strconv.Itoa(files.DefaultWorkers),
+		kind:        kindNumber,
+		get: func(c *config.Config) string {
+			if c.CopyWorkers == 0 {
+				return ""
+			}
+			return strconv.Itoa(c.CopyWorkers)
+		},
+		set: func(c *config.Config, value string) error {
+			if value == "" {
+				c.CopyWorkers = 0
+				return nil
+			}
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 || n > 64 {
+				return errors.New("must be a number between 1 and 64")
+			}
+			c.CopyWorkers = n
+			return nil
+		},
+	},
+}
+
+// the encryption config is only written to the config file if something is set
+func encryption(c *config.Config) *crypt.Config {
+	if c.Zip.Encryption == nil {
+		c.Zip.Encryption = &crypt.Config{}
+	} else {
+		// the config that is edited is a copy, the original must not change
+		e := *c.Zip.Encryption
+		c.Zip.Encryption = &e
+	}
+	return c.Zip.Encryption
+}
+
+func cleanEncryption(c *config.Config) {
+	e := c.Zip.Encryption
+	if e != nil && len(e.Recipients) == 0 && len(e.RecipientFiles) == 0 && e.PassphraseFile == "" && e.IdentityFile == "" {
+		c.Zip.Encryption = nil
+	}
+}
+
+// same as encryption, the upload config is only written if something is set
+func s3Config(c *config.Config) *s3.Config {
+	if c.S3 == nil {
+		c.S3 = &s3.Config{}
+	} else {
+		s := *c.S3
+		c.S3 = &s
+	}
+	return c.S3
+}
+
+func cleanS3(c *config.Config) {
+	if c.S3 != nil && *c.S3 == (s3.Config{}) {
+		c.S3 = nil
+	}
+}
+
+func sshConfig(c *config.Config) *ssh.Config {
+	if c.SSH == nil {
+		c.SSH = &ssh.Config{}
+	} else {
+		s := *c.SSH
+		c.SSH = &s
+	}
+	return c.SSH
+}
+
+func cleanSSH(c *config.Config) {
+	if c.SSH != nil && *c.SSH == (ssh.Config{}) {
+		c.SSH = nil
+	}
+}
+
+func removable(c *config.Config) *drive.Config {
+	if c.Removable == nil {
+		c.Removable = &drive.Config{}
+	} else {
+		r := *c.Removable
+		c.Removable = &r
+	}
+	return c.Removable
+}
+
+func cleanRemovable(c *config.Config) {
+	r := c.Removable
+	if r != nil && len(r.Drives) == 0 && r.Dir == "" && r.Keep == 0 && !r.Snapshot {
+		c.Removable = nil
+	}
+}
+
+func joinList(values []string) string {
+	return strings.Join(values, ", ")
+}
+
+func splitList(value string) []string {
+	var values []string
+	for _, v := range strings.Split(value, ",") {
+		v = strings.TrimSpace(v)
+		if v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
@@ -0,0 +1,434 @@
+package settings
+
+import (
+	"backup/internal/config"
+	"backup/internal/fs"
+	"backup/internal/style"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/help"
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/list"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+type state int
+
+const (
+	stateNoConfigFile state = iota
+	stateLoadError
+	stateSelect
+	stateEdit
+	stateConfirmBack
+)
+
+type Model struct {
+	state state
+
+	configFile string
+	config     config.Config
+	loadError  error
+
+	// field that is currently edited
+	field     field
+	editError error
+
+	changed bool
+	// true right after saving, only used for the status line
+	saved bool
+	// true if the config file was written at least once
+	written   bool
+	saveError error
+
+	list      list.Model
+	textInput textinput.Model
+	helpView  help.Model
+	keyMap    keyMap
+
+	styles style.Styles
+
+	width, height int
+}
+
+func NewModel(configFile string, styles style.Styles) *Model {
+	helpView := help.New()
+	helpView.Styles = styles.HelpStyles
+
+	keyMap := defaultKeyMap()
+
+	state := stateSelect
+	var c config.Config
+	var loadError error
+	if configFile == "" {
+		state = stateNoConfigFile
+	} else {
+		// the file is read as it is, LoadConfig would replace e.g. an empty backup directory with the default
+		c, loadError = config.ReadConfig(configFile)
+		if loadError != nil {
+			state = stateLoadError
+		}
+	}
+
+	textInput := textinput.New()
+	textInput.CharLimit = 1000
+	textInput.Width = 60
+
+	m := &Model{
+		state:      state,
+		configFile: configFile,
+		config:     c,
+		loadError:  loadError,
+
+		textInput: textInput,
+		helpView:  helpView,
+		keyMap:    keyMap,
+
+		styles: styles,
+	}
+
+	items := make([]list.Item, len(fields))
+	for i, f := range fields {
+		items[i] = f
+	}
+	// the delegate renders the current values of the config that is edited
+	m.list = list.New(items, &fieldItemDelegate{config: &m.config, styles: styles}, 0, 0)
+	m.list.SetFilteringEnabled(false)
+	m.list.SetShowHelp(false)
+	m.list.DisableQuitKeybindings()
+	m.list.SetShowStatusBar(false)
+	m.list.SetShowPagination(true)
+	m.list.SetShowTitle(false)
+	m.list.KeyMap = keyMap.listKeyMap()
+
+	return m
+}
+
+func (m *Model) Init() tea.Cmd {
+	return nil
+}
+
+func (m *Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	var cmd tea.Cmd
+
+	switch m.state {
+
+	case stateNoConfigFile, stateLoadError:
+		if msg, ok := msg.(tea.KeyMsg); ok && key.Matches(msg, m.keyMap.Return) {
+			cmd = done(false)
+		}
+
+	case stateSelect:
+		switch msg := msg.(type) {
+		case tea.KeyMsg:
+			m.saved = false
+			switch {
+			case key.Matches(msg, m.keyMap.Edit):
+				if f, ok := m.list.SelectedItem().(field); ok {
+					m.state = stateEdit
+					m.field = f
+					m.editError = nil
+					m.textInput.SetValue(f.get(&m.config))
+					m.textInput.Placeholder = f.placeholder
+					m.textInput.EchoMode = textinput.EchoNormal
+					if f.kind == kindSecret {
+						m.textInput.EchoMode = textinput.EchoPassword
+					}
+					m.textInput.CursorEnd()
+					cmd = m.textInput.Focus()
+				}
+			case key.Matches(msg, m.keyMap.Save):
+				m.saveError = config.Save(m.configFile, m.config)
+				if m.saveError == nil {
+					m.changed = false
+					m.saved = true
+					m.written = true
+				}
+			case key.Matches(msg, m.keyMap.Back):
+				if m.changed {
+					m.state = stateConfirmBack
+				} else {
+					cmd = done(m.written)
+				}
+			default:
+				m.list, cmd = m.list.Update(msg)
+			}
+		default:
+			m.list, cmd = m.list.Update(msg)
+		}
+
+	case stateEdit:
+		switch msg := msg.(type) {
+		case tea.KeyMsg:
+			switch {
+			case key.Matches(msg, m.keyMap.Confirm):
+				value := strings.TrimSpace(m.textInput.Value())
+				// validate on a copy so that an invalid value does not change the config
+				c := m.config
+				if err := m.field.set(&c, value); err != nil {
+					m.editError = err
+				} else {
+					if m.field.get(&c) != m.field.get(&m.config) {
+						m.changed = true
+					}
+					m.config = c
+					m.state = stateSelect
+					m.textInput.Blur()
+				}
+			case key.Matches(msg, m.keyMap.Cancel):
+				m.state = stateSelect
+				m.textInput.Blur()
+			default:
+				m.textInput, cmd = m.textInput.Update(msg)
+			}
+		default:
+			m.textInput, cmd = m.textInput.Update(msg)
+		}
+
+	case stateConfirmBack:
+		if msg, ok := msg.(tea.KeyMsg); ok {
+			switch {
+			case key.Matches(msg, m.keyMap.ConfirmBack):
+				cmd = done(m.written)
+			case key.Matches(msg, m.keyMap.CancelBack):
+				m.state = stateSelect
+			}
+		}
+	}
+
+	return m, cmd
+}
+
+func (m *Model) SetSize(width, height int) {
+	m.width = width
+	m.height = height
+	m.helpView.Width = width
+	// 2 lines for the title, 2 lines for the status, 4 lines for editing and 2 lines for help text
+	listHeight := height - 10
+	if listHeight < 3 {
+		listHeight = 3
+	}
+	m.list.SetSize(width, listHeight)
+	m.textInput.Width = width - 4
+}
+
+func (m *Model) View() string {
+	styles := m.styles
+
+	switch m.state {
+	case stateNoConfigFile:
+		return lipgloss.JoinVertical(
+			lipgloss.Left,
+			styles.TitleStyle.Render("Settings"),
+			"",
+			styles.NormalTextStyle.Render("No config file given. Start with --config to edit the settings."),
+			"",
+			m.helpView.ShortHelpView(m.keyMap.errorKeys()),
+		)
+	case stateLoadError:
+		return lipgloss.JoinVertical(
+			lipgloss.Left,
+			styles.TitleStyle.Render("Settings"),
+			"",
+			styles.ErrorTextStyle.Render(fmt.Sprintf("Could not load %s: %v", m.configFile, m.loadError)),
+			"",
+			m.helpView.ShortHelpView(m.keyMap.errorKeys()),
+		)
+	case stateConfirmBack:
+		return lipgloss.JoinVertical(
+			lipgloss.Left,
+			styles.TitleStyle.Render("Settings"),
+			"",
+			styles.NormalTextStyle.Render("There are unsaved changes. Do you really want to go back to the main menu?"),
+			"",
+			m.helpView.ShortHelpView(m.keyMap.confirmBackKeys()),
+		)
+	}
+
+	var status string
+	switch {
+	case m.saveError != nil:
+		status = styles.ErrorTextStyle.Render(fmt.Sprintf("could not save config: %v", m.saveError))
+	case m.saved:
+		status = styles.NormalTextStyle.Render(fmt.Sprintf("Saved, the previous version was kept as %s.bak", fs.BasePath(m.configFile)))
+	case m.changed:
+		status = styles.NormalTextStyle.Render("Unsaved changes")
+	default:
+		status = styles.NormalTextStyle.Render(m.configFile)
+	}
+
+	parts := []string{
+		styles.TitleStyle.Render("Settings"),
+		"",
+		status,
+		"",
+		m.list.View(),
+	}
+
+	if m.state == stateEdit {
+		label := m.field.name
+		if m.field.kind == kindList {
+			label += " (comma separated)"
+		}
+		parts = append(parts, "", styles.ListItemSelectedStyle.Render(label), m.textInput.View())
+		if m.editError != nil {
+			parts = append(parts, styles.ErrorTextStyle.Render(m.editError.Error()))
+		}
+		parts = append(parts, "", m.helpView.ShortHelpView(m.keyMap.editKeys()))
+	} else {
+		parts = append(parts, "", m.helpView.ShortHelpView(m.keyMap.selectKeys()))
+	}
+
+	return lipgloss.JoinVertical(lipgloss.Left, parts...)
+}
+
+type fieldItemDelegate struct {
+	config *config.Config
+	styles style.Styles
+}
+
+func (d fieldItemDelegate) Height() int {
+	return 2
+}
+
+func (d fieldItemDelegate) Spacing() int {
+	return 1
+}
+
+func (d fieldItemDelegate) Update(msg tea.Msg, m *list.Model) tea.Cmd {
+	return nil
+}
+
+func (d fieldItemDelegate) Render(w io.Writer, m list.Model, index int, listItem list.Item) {
+	f, ok := listItem.(field)
+	if !ok {
+		return
+	}
+
+	value := f.get(d.config)
+	valueStyle := d.styles.ListItemDescriptionStyle
+	switch {
+	case value == "":
+		value = f.placeholder
+		valueStyle = valueStyle.Italic(true)
+	case f.kind == kindSecret:
+		value = strings.Repeat("*", 8)
+	}
+
+	title := fmt.Sprintf("%s › %s", f.section, f.name)
+	if index == m.Index() {
+		title = d.styles.ListItemSelectedStyle.Render(title)
+	} else {
+		title = d.styles.ListItemTitleStyle.Render(title)
+	}
+
+	fmt.Fprint(w, lipgloss.JoinVertical(lipgloss.Left, title, valueStyle.Render(value)))
+}
+
+// Done is sent when returning to the main menu, Saved is true if the config file was changed.
+type Done struct {
+	Saved bool
+}
+
+func done(saved bool) tea.Cmd {
+	return func() tea.Msg {
+		return Done{Saved: saved}
+	}
+}
+
+type keyMap struct {
+	CursorUp   key.Binding
+	CursorDown key.Binding
+	Edit       key.Binding
+	Save       key.Binding
+	Back       key.Binding
+
+	Confirm key.Binding
+	Cancel  key.Binding
+
+	Return key.Binding
+
+	ConfirmBack key.Binding
+	CancelBack  key.Binding
+}
+
+func defaultKeyMap() keyMap {
+	return keyMap{
+		CursorUp: key.NewBinding(
+			key.WithKeys("k", "up"),
+			key.WithHelp("k", "up"),
+		),
+		CursorDown: key.NewBinding(
+			key.WithKeys("j", "down"),
+			key.WithHelp("j", "down"),
+		),
+		Edit: key.NewBinding(
+			key.WithKeys("enter"),
+			key.WithHelp("enter", "edit"),
+		),
+		Save: key.NewBinding(
+			key.WithKeys("w"),
+			key.WithHelp("w", "save"),
+		),
+		Back: key.NewBinding(
+			key.WithKeys("esc"),
+			key.WithHelp("esc", "back"),
+		),
+		Confirm: key.NewBinding(
+			key.WithKeys("enter"),
+			key.WithHelp("enter", "confirm"),
+		),
+		Cancel: key.NewBinding(
+			key.WithKeys("esc"),
+			key.WithHelp("esc", "cancel"),
+		),
+		Return: key.NewBinding(
+			key.WithKeys("enter"),
+			key.WithHelp("enter", "return"),
+		),
+		ConfirmBack: key.NewBinding(
+			key.WithKeys("y"),
+			key.WithHelp("y", "yes"),
+		),
+		CancelBack: key.NewBinding(
+			key.WithKeys("n"),
+			key.WithHelp("n", "no"),
+		),
+	}
+}
+
+func (m keyMap) listKeyMap() list.KeyMap {
+	return list.KeyMap{
+		CursorUp:             m.CursorUp,
+		CursorDown:           m.CursorDown,
+		PrevPage:             key.NewBinding(key.WithDisabled()),
+		NextPage:             key.NewBinding(key.WithDisabled()),
+		GoToStart:            key.NewBinding(key.WithDisabled()),
+		GoToEnd:              key.NewBinding(key.WithDisabled()),
+		Filter:               key.NewBinding(key.WithDisabled()),
+		ClearFilter:          key.NewBinding(key.WithDisabled()),
+		CancelWhileFiltering: key.NewBinding(key.WithDisabled()),
+		AcceptWhileFiltering: key.NewBinding(key.WithDisabled()),
+		ShowFullHelp:         key.NewBinding(key.WithDisabled()),
+		CloseFullHelp:        key.NewBinding(key.WithDisabled()),
+	}
+}
+
+func (m keyMap) selectKeys() []key.Binding {
+	return []key.Binding{m.CursorUp, m.CursorDown, m.Edit, m.Save, m.Back}
+}
+
+func (m keyMap) editKeys() []key.Binding {
+	return []key.Binding{m.Confirm, m.Cancel}
+}
+
+func (m keyMap) errorKeys() []key.Binding {
+	return []key.Binding{m.Return}
+}
+
+func (m keyMap) confirmBackKeys() []key.Binding {
+	return []key.Binding{m.CancelBack, m.ConfirmBack}
+}
@@ -0,0 +1,154 @@
+// Package ssh copies finished backups to a server over SSH, either the archive with rsync or sftp or the whole
+// backup directory with rsync.
+//
+// It runs the ssh, rsync and sftp commands of the system, so the ssh agent and ~/.ssh/config work as usual.
+// Only key authentication is used and hosts are only accepted if their key is in known_hosts.
+package ssh
+
+import (
+	"backup/internal/exec"
+	"backup/internal/fs"
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+)
+
+type Config struct {
+	// user@host:/path, a relative path or ~/path is in the home directory of the user
+	Target string `json:"target,omitempty"`
+	// 22 or the port from ~/.ssh/config if empty
+	Port int `json:"port,omitempty"`
+	// private key, the ssh agent and the default keys are used if empty
+	IdentityFile string `json:"identityFile,omitempty"`
+	// ~/.ssh/known_hosts if empty, hosts that are not in it are rejected
+	KnownHostsFile string `json:"knownHostsFile,omitempty"`
+	// rsync or sftp, rsync if it is installed
+	Method string `json:"method,omitempty"`
+	// sync the backup directory to the path instead of uploading the archive, needs rsync
+	SyncDir bool `json:"syncDir,omitempty"`
+}
+
+const (
+	MethodRsync = "rsync"
+	MethodSftp  = "sftp"
+)
+
+// Enabled returns true if backups should be copied to a server.
+func (c *Config) Enabled() bool {
+	return c != nil && c.Target != ""
+}
+
+// Validate checks the config without connecting to the server.
+func (c *Config) Validate() error {
+	if !c.Enabled() {
+		return errors.New("no target specified")
+	}
+	if _, _, err := c.parseTarget(); err != nil {
+		return err
+	}
+	if c.Port < 0 || c.Port > 65535 {
+		return fmt.Errorf("invalid port %v", c.Port)
+	}
+	switch c.Method {
+	case "", MethodRsync, MethodSftp:
+	default:
+		return fmt.Errorf("invalid method %q: must be rsync or sftp", c.Method)
+	}
+	if c.SyncDir && c.Method == MethodSftp {
+		return errors.New("syncing the backup directory needs rsync")
+	}
+	if c.SyncDir {
+		// files that are not in the backup are deleted from the directory
+		_, dir, _ := c.parseTarget()
+		if dir = path.Clean(dir); dir == "." || dir == "/" {
+			return fmt.Errorf("invalid target %q: syncing the backup directory needs a directory of its own, not the home or root directory", c.Target)
+		}
+	}
+	for _, f := range []string{c.IdentityFile, c.KnownHostsFile} {
+		if f == "" {
+			continue
+		}
+		path, err := fs.AbsPath(f)
+		if err != nil {
+			return err
+		}
+		exists, err := fs.Exists(path)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return fmt.Errorf("%s does not exist", f)
+		}
+	}
+	return nil
+}
+
+// URL returns where backups are copied to, e.g. user@host:/path.
+func (c *Config) URL() string {
+	return c.Target
+}
+
+// splits the target into the host for ssh and the path on the server
+func (c *Config) parseTarget() (string, string, error) {
+	host, path, ok := strings.Cut(c.Target, ":")
+	if !ok || host == "" || path == "" {
+		return "", "", fmt.Errorf("invalid target %q: must be user@host:/path", c.Target)
+	}
+	if strings.HasPrefix(host, "-") || strings.ContainsAny(host, " \t/") {
+		return "", "", fmt.Errorf("invalid host %q", host)
+	}
+	// paths are quoted on the server, the home directory is where relative paths start anyway
+	path = strings.TrimPrefix(path, "~/")
+	if path == "~" || path == "" {
+		path = "."
+	}
+	return host, path, nil
+}
+
+// the method that is used if none is configured
+func (c *Config) method() string {
+	if c.Method != "" {
+		return c.Method
+	}
+	if exec.CommandAvailable("rsync") == nil {
+		return MethodRsync
+	}
+	return MethodSftp
+}
+
+// Options for ssh and sftp, without the host.
+// BatchMode makes ssh fail instead of asking for passwords or unknown host keys.
+func (c *Config) sshOptions() []string {
+	opts := []string{
+		"-o", "BatchMode=yes",
+		"-o", "StrictHostKeyChecking=yes",
+		"-o", "ConnectTimeout=30",
+		"-o", "ServerAliveInterval=30",
+	}
+	if c.Port != 0 {
+		opts = append(opts, "-o", "Port="+strconv.Itoa(c.Port))
+	}
+	if c.KnownHostsFile != "" {
+		path, _ := fs.AbsPath(c.KnownHostsFile)
+		opts = append(opts, "-o", fmt.Sprintf("UserKnownHostsFile=%q", path))
+	}
+	if c.IdentityFile != "" {
+		path, _ := fs.AbsPath(c.IdentityFile)
+		opts = append(opts, "-i", path, "-o", "IdentitiesOnly=yes")
+	}
+	return opts
+}
+
+// The remote shell for rsync -e, rsync splits it at spaces but keeps quoted parts together.
+func (c *Config) rsyncShell() string {
+	parts := []string{"ssh"}
+	for _, o := range c.sshOptions() {
+		if strings.ContainsAny(o, " '\"") {
+			o = "'" + o + "'"
+		}
+		parts = append(parts, o)
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,131 @@
+package ssh
+
+import (
+	"backup/internal/exec"
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	osexec "os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Runs a shell command on the server and returns its output.
+func (c *Config) remote(script string, timeout time.Duration) (string, error) {
+	host, _, err := c.parseTarget()
+	if err != nil {
+		return "", err
+	}
+	cmd := append([]string{"ssh"}, c.sshOptions()...)
+	cmd = append(cmd, "--", host, script)
+	r := exec.Background(cmd, exec.WithTimeout(timeout))
+	if r.Err != nil {
+		return "", r.Err
+	}
+	if r.ExitCode != 0 {
+		return "", commandError("ssh", r.ExitCode, r.Stderr)
+	}
+	return r.Stdout, nil
+}
+
+// Returns the size of a file on the server, -1 if it does not exist.
+func (c *Config) remoteSize(path string) (int64, error) {
+	out, err := c.remote(fmt.Sprintf("if [ -f %[1]s ]; then wc -c < %[1]s; else echo -1; fi", quote(path)), 2*time.Minute)
+	if err != nil {
+		return 0, err
+	}
+	size, err := strconv.ParseInt(strings.TrimSpace(out), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("unexpected size %q of %s", strings.TrimSpace(out), path)
+	}
+	return size, nil
+}
+
+// Returns the SHA-256 of a file on the server, linux has sha256sum and macOS and BSD shasum.
+func (c *Config) remoteHash(path string) (string, error) {
+	out, err := c.remote(fmt.Sprintf("{ sha256sum || shasum -a 256; } < %s", quote(path)), time.Hour)
+	if err != nil {
+		return "", fmt.Errorf("could not hash %s: %w", path, err)
+	}
+	hash, _, _ := strings.Cut(strings.TrimSpace(out), " ")
+	if len(hash) != 64 {
+		return "", fmt.Errorf("unexpected hash %q of %s", hash, path)
+	}
+	return hash, nil
+}
+
+// Runs a command and calls onLine for every line of its output, progress lines of rsync end with \r.
+// Returns the last lines of stderr in the error if the command fails.
+func stream(cmd []string, onLine func(line string)) error {
+	c := osexec.Command(cmd[0], cmd[1:]...)
+	var stderr bytes.Buffer
+	c.Stderr = &stderr
+	stdout, err := c.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := c.Start(); err != nil {
+		return err
+	}
+
+	scanner := bufio.NewScanner(stdout)
+	scanner.Split(scanLines)
+	for scanner.Scan() {
+		if onLine != nil {
+			onLine(scanner.Text())
+		}
+	}
+	// the rest of the output is not needed, but the command must not block on a full pipe
+	io.Copy(io.Discard, stdout)
+
+	err = c.Wait()
+	var exitErr *osexec.ExitError
+	if errors.As(err, &exitErr) && exitErr.Exited() {
+		return commandError(cmd[0], exitErr.ExitCode(), stderr.String())
+	}
+	return err
+}
+
+// like bufio.ScanLines, but \r ends a line as well
+func scanLines(data []byte, atEOF bool) (int, []byte, error) {
+	if i := bytes.IndexAny(data, "\r\n"); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+func commandError(name string, exitCode int, stderr string) error {
+	var lines []string
+	for _, l := range strings.Split(stderr, "\n") {
+		if l = strings.TrimSpace(l); l != "" {
+			lines = append(lines, l)
+		}
+	}
+	msg := fmt.Sprintf("%s exited with %v", name, exitCode)
+	if len(lines) > 3 {
+		lines = lines[len(lines)-3:]
+	}
+	if len(lines) > 0 {
+		msg = fmt.Sprintf("%s: %s", msg, strings.Join(lines, "; "))
+	}
+	if strings.Contains(stderr, "Host key verification failed") {
+		msg += " (the host is not in known_hosts, check its fingerprint and connect once with ssh to add it)"
+	}
+	return errors.New(msg)
+}
+
+// quotes a string for the shell on the server
+func quote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// quotes a path for sftp batch commands
+func sftpQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
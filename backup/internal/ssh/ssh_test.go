@@ -0,0 +1,160 @@
+package ssh
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+)
+
+func TestParseTarget(t *testing.T) {
+	for _, test := range []struct {
+		target string
+		host   string
+		path   string
+	}{
+		{"me@example.com:/srv/backups", "me@example.com", "/srv/backups"},
+		{"example.com:backups", "example.com", "backups"},
+		{"me@example.com:~/backups", "me@example.com", "backups"},
+		{"me@example.com:~", "me@example.com", "."},
+		{"me@example.com:~/", "me@example.com", "."},
+		{"server:/", "server", "/"},
+	} {
+		host, path, err := (&Config{Target: test.target}).parseTarget()
+		if err != nil {
+			t.Errorf("%s: %v", test.target, err)
+			continue
+		}
+		if host != test.host || path != test.path {
+			t.Errorf("%s: got %q %q, expected %q %q", test.target, host, path, test.host, test.path)
+		}
+	}
+
+	for _, target := range []string{"example.com", "example.com:", ":/srv", "-oProxyCommand=x:/srv", "a b:/srv", "a/b:/srv"} {
+		if _, _, err := (&Config{Target: target}).parseTarget(); err == nil {
+			t.Errorf("no error for %q", target)
+		}
+	}
+}
+
+func TestValidateSyncDir(t *testing.T) {
+	for _, test := range []struct {
+		target string
+		ok     bool
+	}{
+		{"me@example.com:/srv/backups", true},
+		{"me@example.com:backups", true},
+		{"me@example.com:~/backups/", true},
+		{"me@example.com:~", false},
+		{"me@example.com:~/", false},
+		{"me@example.com:.", false},
+		{"me@example.com:./", false},
+		{"me@example.com:/", false},
+		{"me@example.com:/srv/..", false},
+	} {
+		err := (&Config{Target: test.target, SyncDir: true}).Validate()
+		if test.ok && err != nil {
+			t.Errorf("%s: %v", test.target, err)
+		}
+		if !test.ok && err == nil {
+			t.Errorf("%s: no error", test.target)
+		}
+	}
+	// the archive can be uploaded to the home directory
+	if err := (&Config{Target: "me@example.com:~"}).Validate(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestQuote(t *testing.T) {
+	for _, test := range []struct {
+		s     string
+		shell string
+		sftp  string
+	}{
+		{"backup.zip", `'backup.zip'`, `"backup.zip"`},
+		{"my backup.zip", `'my backup.zip'`, `"my backup.zip"`},
+		{"it's.zip", `'it'\''s.zip'`, `"it's.zip"`},
+		{`a "b" \c`, `'a "b" \c'`, `"a \"b\" \\c"`},
+		{"$(rm -rf ~)", `'$(rm -rf ~)'`, `"$(rm -rf ~)"`},
+	} {
+		if q := quote(test.s); q != test.shell {
+			t.Errorf("quote(%q) = %s, expected %s", test.s, q, test.shell)
+		}
+		if q := sftpQuote(test.s); q != test.sftp {
+			t.Errorf("sftpQuote(%q) = %s, expected %s", test.s, q, test.sftp)
+		}
+	}
+}
+
+func TestScanLines(t *testing.T) {
+	scanner := bufio.NewScanner(strings.NewReader("sending\n  1,024  10%\r  10,240 100%\r\nlast"))
+	scanner.Split(scanLines)
+	var lines []string
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	expected := []string{"sending", "  1,024  10%", "  10,240 100%", "", "last"}
+	if strings.Join(lines, "|") != strings.Join(expected, "|") {
+		t.Errorf("got %q, expected %q", lines, expected)
+	}
+}
+
+func TestParsePercent(t *testing.T) {
+	for _, test := range []struct {
+		line    string
+		percent int64
+		ok      bool
+	}{
+		{"  1,048,576  50%  1.00MB/s  0:00:01", 50, true},
+		{"      2,097,152 100%    2.00MB/s    0:00:01 (xfr#1, to-chk=0/1)", 100, true},
+		{"sending incremental file list", 0, false},
+		{"backup.zip", 0, false},
+		{"  1,024  150%", 0, false},
+		{"  1,024  -1%", 0, false},
+		{"", 0, false},
+	} {
+		percent, ok := parsePercent(test.line)
+		if percent != test.percent || ok != test.ok {
+			t.Errorf("%q: got %v %v, expected %v %v", test.line, percent, ok, test.percent, test.ok)
+		}
+	}
+}
+
+func TestCommandError(t *testing.T) {
+	for _, test := range []struct {
+		stderr   string
+		expected string
+	}{
+		{"", "rsync exited with 12"},
+		{"one\n\n  two  \n", "rsync exited with 12: one; two"},
+		{"1\n2\n3\n4\n5\n", "rsync exited with 12: 3; 4; 5"},
+		{"Host key verification failed.\n", "rsync exited with 12: Host key verification failed. (the host is not in known_hosts, check its fingerprint and connect once with ssh to add it)"},
+	} {
+		if err := commandError("rsync", 12, test.stderr); err.Error() != test.expected {
+			t.Errorf("%q: got %q, expected %q", test.stderr, err, test.expected)
+		}
+	}
+}
+
+func TestPartial(t *testing.T) {
+	for _, test := range []struct {
+		remoteSize int64
+		size       int64
+		remove     bool
+		resumed    int64
+	}{
+		// nothing on the server
+		{-1, 100, false, 0},
+		{0, 100, false, 0},
+		// an interrupted upload
+		{40, 100, false, 40},
+		// same size but a different hash, or a larger file with the same name
+		{100, 100, true, 0},
+		{200, 100, true, 0},
+	} {
+		remove, resumed := partial(test.remoteSize, test.size)
+		if remove != test.remove || resumed != test.resumed {
+			t.Errorf("%v of %v bytes: got %v %v, expected %v %v", test.remoteSize, test.size, remove, resumed, test.remove, test.resumed)
+		}
+	}
+}
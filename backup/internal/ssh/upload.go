@@ -0,0 +1,347 @@
+package ssh
+
+import (
+	"backup/internal/exec"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	iofs "io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Progress struct {
+	BytesDone  int64
+	BytesTotal int64
+	// file that is currently uploaded, empty when syncing a directory
+	Current string
+}
+
+// File is the result of a single uploaded file.
+type File struct {
+	File string
+	// path on the server
+	Path string
+	Size int64
+	// the file already was on the server with the same content
+	Existed bool
+	// bytes of an interrupted upload that did not have to be uploaded again
+	Resumed int64
+}
+
+type Result struct {
+	// e.g. user@host:/path
+	Target string
+	// uploaded files, empty if a directory was synced
+	Files []File
+	// size of the synced directory
+	Size int64
+	Time time.Duration
+}
+
+// Upload copies the files of an archive into the directory of the target. Files that are already on the server
+// are skipped, an interrupted upload is continued. Every file is compared with its local file afterwards.
+func Upload(c *Config, files []string, onProgress func(Progress)) (Result, error) {
+	start := time.Now()
+	result := Result{Target: c.URL()}
+	if len(files) == 0 {
+		return result, errors.New("nothing to upload")
+	}
+	if err := c.Validate(); err != nil {
+		return result, err
+	}
+	host, dir, _ := c.parseTarget()
+
+	if _, err := c.remote("mkdir -p -- "+quote(dir), 2*time.Minute); err != nil {
+		return result, fmt.Errorf("could not create %s: %w", dir, err)
+	}
+
+	u := uploader{config: c, host: host, method: c.method(), onProgress: onProgress}
+	for _, f := range files {
+		info, err := os.Stat(f)
+		if err != nil {
+			return result, err
+		}
+		u.progress.BytesTotal += info.Size()
+	}
+
+	for _, f := range files {
+		file, err := u.upload(f, path.Join(dir, filepath.Base(f)))
+		if err != nil {
+			return result, fmt.Errorf("%s: %w", filepath.Base(f), err)
+		}
+		result.Files = append(result.Files, file)
+	}
+	result.Time = time.Since(start)
+	return result, nil
+}
+
+type uploader struct {
+	config *Config
+	host   string
+	method string
+	// bytes of the files that are done
+	finished   int64
+	progress   Progress
+	onProgress func(Progress)
+}
+
+// n is the number of bytes of the current file that are uploaded
+func (u *uploader) report(n int64) {
+	u.progress.BytesDone = u.finished + n
+	if u.onProgress != nil {
+		u.onProgress(u.progress)
+	}
+}
+
+func (u *uploader) upload(file string, dest string) (File, error) {
+	result := File{File: file, Path: dest}
+	info, err := os.Stat(file)
+	if err != nil {
+		return result, err
+	}
+	result.Size = info.Size()
+	u.progress.Current = filepath.Base(file)
+	u.report(0)
+	defer func() {
+		u.finished += result.Size
+	}()
+
+	hash, err := hashFile(file)
+	if err != nil {
+		return result, err
+	}
+	size, err := u.config.remoteSize(dest)
+	if err != nil {
+		return result, err
+	}
+	if size == result.Size {
+		remoteHash, err := u.config.remoteHash(dest)
+		if err != nil {
+			return result, err
+		}
+		if remoteHash == hash {
+			result.Existed = true
+			u.report(result.Size)
+			return result, nil
+		}
+	}
+	remove, resumed := partial(size, result.Size)
+	if remove {
+		if err := u.remove(dest); err != nil {
+			return result, err
+		}
+	}
+	result.Resumed = resumed
+
+	if err := u.copy(file, dest, result.Size, result.Resumed > 0); err != nil {
+		return result, err
+	}
+	err = u.verify(dest, result.Size, hash)
+	if err != nil && result.Resumed > 0 {
+		// the part that was on the server might not belong to this file, upload it again from the start
+		if err := u.remove(dest); err != nil {
+			return result, err
+		}
+		result.Resumed = 0
+		if err := u.copy(file, dest, result.Size, false); err != nil {
+			return result, err
+		}
+		err = u.verify(dest, result.Size, hash)
+	}
+	return result, err
+}
+
+// Decides what happens to a file of remoteSize bytes on the server, -1 if there is none, that is not the same as the
+// local file of size bytes. Returns the number of bytes that do not have to be uploaded again.
+func partial(remoteSize int64, size int64) (remove bool, resumed int64) {
+	if remoteSize >= size {
+		// not a partial upload of this file, e.g. an older archive with the same name
+		return true, 0
+	}
+	if remoteSize > 0 {
+		return false, remoteSize
+	}
+	return false, 0
+}
+
+// Uploads a file, resume appends to the partial file on the server.
+func (u *uploader) copy(file string, dest string, size int64, resume bool) error {
+	if u.method == MethodSftp {
+		command := "put"
+		if resume {
+			command = "reput"
+		}
+		cmd := append([]string{"sftp", "-b", "-"}, u.config.sshOptions()...)
+		cmd = append(cmd, "--", u.host)
+		r := exec.Background(cmd, exec.WithStdin(fmt.Sprintf("%s %s %s\n", command, sftpQuote(file), sftpQuote(dest))), exec.WithTimeout(0))
+		if r.Err != nil {
+			return r.Err
+		}
+		if r.ExitCode != 0 {
+			return commandError("sftp", r.ExitCode, r.Stderr)
+		}
+		// sftp shows no progress without a terminal
+		u.report(size)
+		return nil
+	}
+
+	cmd := []string{"rsync", "--partial", "--times", "--protect-args", "--info=progress2", "-e", u.config.rsyncShell()}
+	if resume {
+		cmd = append(cmd, "--append-verify")
+	}
+	cmd = append(cmd, "--", file, u.host+":"+dest)
+	return stream(cmd, func(line string) {
+		if percent, ok := parsePercent(line); ok {
+			u.report(size * percent / 100)
+		}
+	})
+}
+
+func (u *uploader) remove(dest string) error {
+	_, err := u.config.remote("rm -f -- "+quote(dest), 2*time.Minute)
+	return err
+}
+
+// compares the uploaded file with the local file
+func (u *uploader) verify(dest string, size int64, hash string) error {
+	remoteSize, err := u.config.remoteSize(dest)
+	if err != nil {
+		return fmt.Errorf("could not check upload: %w", err)
+	}
+	if remoteSize != size {
+		return fmt.Errorf("upload is broken: size is %v instead of %v", remoteSize, size)
+	}
+	remoteHash, err := u.config.remoteHash(dest)
+	if err != nil {
+		return fmt.Errorf("could not check upload: %w", err)
+	}
+	if remoteHash != hash {
+		return fmt.Errorf("upload is broken: sha256 %s does not match %s", remoteHash, hash)
+	}
+	return nil
+}
+
+// written to the target by Sync, files in a directory without it are never deleted
+const syncMarker = ".backup-sync"
+
+// Sync makes the directory of the target a copy of dir with rsync. Files that are not in dir are deleted once the
+// directory has the marker of an earlier sync, so that a wrong target does not lose the files that are already there.
+// Afterwards the checksums of all files are compared with rsync.
+func Sync(c *Config, dir string, onProgress func(Progress)) (Result, error) {
+	start := time.Now()
+	result := Result{Target: c.URL()}
+	if err := c.Validate(); err != nil {
+		return result, err
+	}
+	if c.method() != MethodRsync {
+		return result, errors.New("syncing the backup directory needs rsync")
+	}
+	host, remoteDir, _ := c.parseTarget()
+
+	size, err := dirSize(dir)
+	if err != nil {
+		return result, err
+	}
+	result.Size = size
+
+	if _, err := c.remote("mkdir -p -- "+quote(remoteDir), 2*time.Minute); err != nil {
+		return result, fmt.Errorf("could not create %s: %w", remoteDir, err)
+	}
+	marker := path.Join(remoteDir, syncMarker)
+	out, err := c.remote(fmt.Sprintf("if [ -e %s ]; then echo synced; fi", quote(marker)), 2*time.Minute)
+	if err != nil {
+		return result, err
+	}
+	args := syncArgs(c, dir, host, remoteDir, strings.TrimSpace(out) == "synced")
+
+	cmd := append([]string{"rsync", "--archive", "--partial", "--protect-args", "--info=progress2"}, args...)
+	err = stream(cmd, func(line string) {
+		if percent, ok := parsePercent(line); ok && onProgress != nil {
+			onProgress(Progress{BytesDone: size * percent / 100, BytesTotal: size})
+		}
+	})
+	if err != nil {
+		return result, err
+	}
+
+	var changes []string
+	cmd = append([]string{"rsync", "--archive", "--checksum", "--dry-run", "--itemize-changes", "--protect-args"}, args...)
+	err = stream(cmd, func(line string) {
+		if line = strings.TrimSpace(line); line != "" {
+			changes = append(changes, line)
+		}
+	})
+	if err != nil {
+		return result, fmt.Errorf("could not check sync: %w", err)
+	}
+	if len(changes) > 0 {
+		return result, fmt.Errorf("sync is broken: %v files differ, e.g. %s", len(changes), changes[0])
+	}
+	if _, err := c.remote("touch -- "+quote(marker), 2*time.Minute); err != nil {
+		return result, fmt.Errorf("could not create %s: %w", marker, err)
+	}
+	result.Time = time.Since(start)
+	return result, nil
+}
+
+// Arguments of rsync after the options, the marker itself is never deleted or compared.
+func syncArgs(c *Config, dir string, host string, remoteDir string, synced bool) []string {
+	var args []string
+	if synced {
+		args = append(args, "--delete")
+	}
+	// the trailing slashes sync the content of the directories
+	return append(args, "--exclude=/"+syncMarker, "-e", c.rsyncShell(), "--",
+		strings.TrimSuffix(dir, "/")+"/", host+":"+strings.TrimSuffix(remoteDir, "/")+"/")
+}
+
+// Returns the percentage of a progress line of rsync, e.g. "  1,048,576  50%  1.00MB/s  0:00:01".
+func parsePercent(line string) (int64, bool) {
+	fields := strings.Fields(line)
+	if len(fields) < 2 || !strings.HasSuffix(fields[1], "%") {
+		return 0, false
+	}
+	percent, err := strconv.ParseInt(strings.TrimSuffix(fields[1], "%"), 10, 64)
+	if err != nil || percent < 0 || percent > 100 {
+		return 0, false
+	}
+	return percent, true
+}
+
+func hashFile(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(dir, func(path string, d iofs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
@@ -0,0 +1,269 @@
+// Package stream writes a backup straight into its archive. Nothing is copied into the backup directory first,
+// so a backup only needs the space of the archive instead of twice the space.
+//
+// The archive has the same layout as the archive of a backup directory. The only difference are repos, a clone
+// consists of many small files and would need a directory anyway, so they are added as git bundles github/<name>.bundle.
+package stream
+
+import (
+	"backup/internal/archive"
+	"backup/internal/commands"
+	"backup/internal/exec"
+	"backup/internal/files"
+	"backup/internal/github"
+	"backup/internal/manifest"
+	"backup/internal/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	iofs "io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+// A Backup is an archive that is written while the backup runs. Methods must not be called concurrently.
+type Backup struct {
+	stream   *archive.Stream
+	manifest manifest.Manifest
+	// paths that are in the archive, calls can be repeated after a failure without adding anything twice
+	added map[string]bool
+	// set when writing the archive failed, it is broken then and every further call fails
+	err error
+}
+
+// Start creates the archive of the zip config. Entries are prefixed with the name of the backup directory,
+// the same as when the directory is archived, but nothing is written to the directory.
+func Start(backupDir string, config zip.Config, onProgress func(archive.Progress)) (*Backup, error) {
+	if config.File == "" {
+		return nil, errors.New("no zip file specified, streaming needs an archive to write to")
+	}
+	file, err := config.TargetFile(config.File)
+	if err != nil {
+		return nil, fmt.Errorf("invalid zip file: %w", err)
+	}
+	opts, err := config.ArchiveOptions()
+	if err != nil {
+		return nil, fmt.Errorf("invalid zip config: %w", err)
+	}
+	opts.OnProgress = onProgress
+
+	s, err := archive.NewStream(file, filepath.Base(backupDir), opts)
+	if err != nil {
+		return nil, err
+	}
+	return &Backup{stream: s, manifest: manifest.New(backupDir), added: map[string]bool{}}, nil
+}
+
+// File returns the absolute path of the archive.
+func (b *Backup) File() string {
+	return b.stream.File()
+}
+
+// Err returns the error that broke the archive, nil if it can still be written.
+func (b *Backup) Err() error {
+	return b.err
+}
+
+// Manifest returns the entries of everything that was added so far.
+func (b *Backup) Manifest() manifest.Manifest {
+	return b.manifest
+}
+
+// adds a file to the archive and the manifest
+func (b *Backup) add(name string, info iofs.FileInfo, r io.Reader) (manifest.File, error) {
+	if b.err != nil {
+		return manifest.File{}, b.err
+	}
+	h := sha256.New()
+	if err := b.stream.AddFile(name, info, io.TeeReader(r, h)); err != nil {
+		b.err = fmt.Errorf("could not write archive: %w", err)
+		return manifest.File{}, b.err
+	}
+	file := manifest.NewFile(name, info)
+	file.SHA256 = hex.EncodeToString(h.Sum(nil))
+	b.manifest.Files = append(b.manifest.Files, file)
+	b.added[name] = true
+	return file, nil
+}
+
+// Repo clones a repo into a temporary directory and adds it as a bundle, the clone is removed afterwards.
+// The result is the one of the command that failed.
+func (b *Backup) Repo(repo github.Repo, token string) (exec.Result, error) {
+	if b.err != nil {
+		return exec.Result{}, b.err
+	}
+	name := path.Join("github", repo.Name+".bundle")
+	if b.added[name] {
+		return exec.Result{}, nil
+	}
+
+	tmp, err := os.MkdirTemp("", "backup-repo-*")
+	if err != nil {
+		return exec.Result{}, err
+	}
+	defer os.RemoveAll(tmp)
+
+	result := github.CloneRepo(repo, tmp, token)
+	if result.Err != nil || result.ExitCode != 0 {
+		return result, nil
+	}
+	repoDir := filepath.Join(tmp, repo.Name)
+	bundle := filepath.Join(tmp, repo.Name+".bundle")
+	result = github.BundleRepo(repoDir, bundle)
+	if result.Err != nil || result.ExitCode != 0 {
+		return result, nil
+	}
+
+	f, err := os.Open(bundle)
+	if err != nil {
+		return result, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return result, err
+	}
+	file, err := b.add(name, info, f)
+	if err != nil {
+		return result, err
+	}
+	// the bundle is not inside a directory of the repo, the manifest would not know where it belongs to
+	b.manifest.Files[len(b.manifest.Files)-1].Repo = repo.Name
+	b.manifest.Repos = append(b.manifest.Repos, manifest.NewRepo(repo.Name, file.Path, repoDir))
+	return result, nil
+}
+
+// Files adds a path from the config and everything below it that is not excluded, the same as files.CopyAll would
+// copy it into the backup directory. As many files as possible are added, Err of the result is the first error.
+// If the archive itself could not be written, b.Err() is set too.
+func (b *Backup) Files(absPath string, exclude []string) files.Result {
+	result := files.Result{Job: files.Job{Source: absPath, Target: path.Join("files", filepath.ToSlash(absPath))}}
+	fail := func(err error) {
+		if result.Err == nil {
+			result.Err = err
+		}
+		result.Failed++
+	}
+	if b.err != nil {
+		fail(b.err)
+		return result
+	}
+
+	err := files.Walk(absPath, exclude, func(p string, info iofs.FileInfo) error {
+		name := path.Join("files", filepath.ToSlash(p))
+		if b.added[name] {
+			return nil
+		}
+		mode := info.Mode()
+		switch {
+		case mode.IsDir():
+			if err := b.stream.AddDir(name, info); err != nil {
+				b.err = fmt.Errorf("could not write archive: %w", err)
+				return b.err
+			}
+		case mode&iofs.ModeSymlink != 0:
+			result.Files++
+			target, err := os.Readlink(p)
+			if err != nil {
+				fail(fmt.Errorf("%s: %w", p, err))
+				return nil
+			}
+			if err := b.stream.AddSymlink(name, info, target); err != nil {
+				b.err = fmt.Errorf("could not write archive: %w", err)
+				return b.err
+			}
+			file := manifest.NewFile(name, info)
+			file.Link = target
+			b.manifest.Files = append(b.manifest.Files, file)
+			b.added[name] = true
+		case mode.IsRegular():
+			result.Files++
+			result.Bytes += info.Size()
+			f, err := os.Open(p)
+			if err != nil {
+				fail(fmt.Errorf("%s: %w", p, err))
+				return nil
+			}
+			defer f.Close()
+			if _, err := b.add(name, info, f); err != nil {
+				return err
+			}
+		}
+		// sockets, devices, named pipes etc. are skipped like when copying
+		return nil
+	}, nil)
+	if err != nil && b.err == nil {
+		fail(err)
+	} else if b.err != nil {
+		fail(b.err)
+	}
+	return result
+}
+
+// Command runs a command and adds its output at commands/<name>. Like commands.Run, the output is only added if
+// the command succeeded.
+func (b *Backup) Command(c commands.Command) (exec.Result, error) {
+	if b.err != nil {
+		return exec.Result{}, b.err
+	}
+	name := path.Join("commands", c.Name)
+	if b.added[name] {
+		return exec.Result{}, nil
+	}
+	result, err := commands.Output(c)
+	if err != nil || result.Err != nil || result.ExitCode != 0 {
+		return result, err
+	}
+	info := archive.NewFileInfo(c.Name, int64(len(result.Stdout)), 0644, time.Now())
+	_, err = b.add(name, info, bytes.NewReader([]byte(result.Stdout)))
+	return result, err
+}
+
+// WriteManifest adds manifest.json with everything that was added so far, it should be the last file of the archive.
+func (b *Backup) WriteManifest() (manifest.Manifest, error) {
+	if b.err != nil {
+		return b.manifest, b.err
+	}
+	if b.added[manifest.FileName] {
+		return b.manifest, nil
+	}
+	data, err := manifest.Encode(b.manifest)
+	if err != nil {
+		return b.manifest, err
+	}
+	info := archive.NewFileInfo(manifest.FileName, int64(len(data)), 0644, time.Now())
+	if err := b.stream.AddFile(manifest.FileName, info, bytes.NewReader(data)); err != nil {
+		b.err = fmt.Errorf("could not write archive: %w", err)
+		return b.manifest, b.err
+	}
+	b.added[manifest.FileName] = true
+	return b.manifest, nil
+}
+
+// Close finishes the archive and moves it to its final name, nothing can be added afterwards.
+func (b *Backup) Close() (archive.Result, error) {
+	if b.err != nil {
+		b.stream.Abort()
+		return archive.Result{File: b.File()}, b.err
+	}
+	result, err := b.stream.Close()
+	if err != nil {
+		b.err = err
+	} else {
+		b.err = errors.New("archive was already closed")
+	}
+	return result, err
+}
+
+// Abort removes the partial archive.
+func (b *Backup) Abort() {
+	b.stream.Abort()
+	if b.err == nil {
+		b.err = errors.New("backup was aborted")
+	}
+}
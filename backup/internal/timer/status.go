@@ -0,0 +1,101 @@
+package timer
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+)
+
+type Status struct {
+	Service string
+	Timer   string
+	// the unit files exist
+	Installed bool
+	// OnCalendar of the timer unit
+	Schedule string
+	// e.g. "enabled" or "disabled"
+	Enabled string
+	// e.g. "active" or "inactive"
+	Active string
+	// as shown by systemd, empty if unknown
+	NextRun string
+	LastRun string
+	// the backup is running right now
+	Running bool
+	// result of the last run, e.g. "success" or "exit-code", empty if it never ran
+	Result   string
+	ExitCode int
+}
+
+// GetStatus returns what systemd knows about the units. Units that are not installed are no error.
+func GetStatus(name string) (Status, error) {
+	service, timer, err := Files(name)
+	if err != nil {
+		return Status{}, err
+	}
+	s := Status{Service: service, Timer: timer}
+	if _, err := os.Stat(timer); err != nil {
+		return s, nil
+	}
+	s.Installed = true
+	s.Schedule = readSchedule(timer)
+
+	t, err := show(name+".timer", "UnitFileState", "ActiveState", "NextElapseUSecRealtime", "LastTriggerUSec")
+	if err != nil {
+		return s, err
+	}
+	s.Enabled = t["UnitFileState"]
+	s.Active = t["ActiveState"]
+	s.NextRun = timestamp(t["NextElapseUSecRealtime"])
+	s.LastRun = timestamp(t["LastTriggerUSec"])
+
+	svc, err := show(name+".service", "ActiveState", "Result", "ExecMainStatus", "ExecMainExitTimestamp")
+	if err != nil {
+		return s, err
+	}
+	s.Running = svc["ActiveState"] == "activating"
+	if timestamp(svc["ExecMainExitTimestamp"]) != "" {
+		s.Result = svc["Result"]
+		s.ExitCode, _ = strconv.Atoi(svc["ExecMainStatus"])
+	}
+	return s, nil
+}
+
+// Returns the properties of a unit.
+func show(unit string, properties ...string) (map[string]string, error) {
+	output, err := systemctl("show", unit, "--property="+strings.Join(properties, ","))
+	if err != nil {
+		return nil, err
+	}
+	values := map[string]string{}
+	for _, line := range strings.Split(output, "\n") {
+		if key, value, ok := strings.Cut(line, "="); ok {
+			values[key] = value
+		}
+	}
+	return values, nil
+}
+
+// systemd shows timestamps that are not set as empty or n/a, and timers that never elapsed with a zero timestamp
+func timestamp(value string) string {
+	if value == "n/a" || value == "0" {
+		return ""
+	}
+	return value
+}
+
+func readSchedule(file string) string {
+	f, err := os.Open(file)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if value, ok := strings.CutPrefix(strings.TrimSpace(scanner.Text()), "OnCalendar="); ok {
+			return value
+		}
+	}
+	return ""
+}
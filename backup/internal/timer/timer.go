@@ -0,0 +1,202 @@
+// Package timer installs systemd user units that run backups on a schedule.
+//
+// A oneshot service runs the backup command and a timer starts it, both are written to the systemd user directory,
+// usually ~/.config/systemd/user. The output of the backups ends up in the journal.
+package timer
+
+import (
+	"backup/internal/exec"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// DefaultName is the name of the units if none is given, i.e. backup.service and backup.timer.
+const DefaultName = "backup"
+
+type Options struct {
+	// name of the units without suffix
+	Name string
+	// systemd calendar expression, e.g. "daily" or "Mon..Fri 02:00"
+	Schedule string
+	// command of the service, the first element is an absolute path
+	Command []string
+}
+
+var validName = regexp.MustCompile(`^[a-zA-Z0-9_.@-]+$`)
+
+// Validate checks the options, the schedule is checked with systemd-analyze if it is installed.
+func (o Options) Validate() error {
+	if !validName.MatchString(o.Name) {
+		return fmt.Errorf("invalid name %q: only letters, digits and _.@- are allowed", o.Name)
+	}
+	if len(o.Command) == 0 || !filepath.IsAbs(o.Command[0]) {
+		return errors.New("command must start with an absolute path")
+	}
+	if strings.TrimSpace(o.Schedule) == "" || strings.ContainsAny(o.Schedule, "\n") {
+		return fmt.Errorf("invalid schedule %q", o.Schedule)
+	}
+	if exec.CommandAvailable("systemd-analyze") == nil {
+		r := exec.Background([]string{"systemd-analyze", "calendar", o.Schedule}, exec.WithTimeout(10*time.Second))
+		if r.Err == nil && r.ExitCode != 0 {
+			return fmt.Errorf("invalid schedule %q: %s", o.Schedule, firstLine(r.Stderr))
+		}
+	}
+	return nil
+}
+
+// Service returns the content of the service unit.
+func (o Options) Service() string {
+	var quoted []string
+	for _, arg := range o.Command {
+		quoted = append(quoted, quote(arg))
+	}
+	return fmt.Sprintf(`[Unit]
+Description=Backup of your stuff
+
+[Service]
+Type=oneshot
+ExecStart=%s
+# exit code 2 means the backup was created but parts of it failed, the unit is marked as failed either way
+StandardOutput=journal
+StandardError=journal
+SyslogIdentifier=%s
+Nice=10
+IOSchedulingClass=idle
+`, strings.Join(quoted, " "), o.Name)
+}
+
+// Timer returns the content of the timer unit. Runs missed while the computer was off are started when it is back.
+func (o Options) Timer() string {
+	return fmt.Sprintf(`[Unit]
+Description=Scheduled backup of your stuff
+
+[Timer]
+OnCalendar=%s
+Persistent=true
+RandomizedDelaySec=5min
+
+[Install]
+WantedBy=timers.target
+`, o.Schedule)
+}
+
+// Quotes an argument of ExecStart, specifiers and environment variables are escaped so that they are used literally.
+func quote(arg string) string {
+	arg = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "%", "%%", "$", "$$").Replace(arg)
+	return `"` + arg + `"`
+}
+
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(strings.TrimSpace(s), "\n")
+	return line
+}
+
+// UnitDir returns the directory of systemd user units, $XDG_CONFIG_HOME/systemd/user or ~/.config/systemd/user.
+func UnitDir() (string, error) {
+	if dir := os.Getenv("XDG_CONFIG_HOME"); filepath.IsAbs(dir) {
+		return filepath.Join(dir, "systemd", "user"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".config", "systemd", "user"), nil
+}
+
+// Files returns the paths of the service and the timer unit.
+func Files(name string) (string, string, error) {
+	dir, err := UnitDir()
+	if err != nil {
+		return "", "", err
+	}
+	return filepath.Join(dir, name+".service"), filepath.Join(dir, name+".timer"), nil
+}
+
+// Install writes the units, replacing existing ones, and enables and starts the timer.
+func Install(o Options) error {
+	if err := o.Validate(); err != nil {
+		return err
+	}
+	service, timer, err := Files(o.Name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(service), 0755); err != nil {
+		return err
+	}
+	if err := writeFile(service, o.Service()); err != nil {
+		return err
+	}
+	if err := writeFile(timer, o.Timer()); err != nil {
+		return err
+	}
+	if _, err := systemctl("daemon-reload"); err != nil {
+		return err
+	}
+	if _, err := systemctl("enable", o.Name+".timer"); err != nil {
+		return err
+	}
+	// restarts the timer if it was installed before so that a new schedule applies
+	_, err = systemctl("restart", o.Name+".timer")
+	return err
+}
+
+// Uninstall stops and disables the timer and removes both units. Units that do not exist are ignored.
+func Uninstall(name string) ([]string, error) {
+	service, timer, err := Files(name)
+	if err != nil {
+		return nil, err
+	}
+	exists := false
+	for _, f := range []string{service, timer} {
+		if _, err := os.Stat(f); err == nil {
+			exists = true
+		}
+	}
+	if !exists {
+		return nil, fmt.Errorf("%s is not installed", timer)
+	}
+	if _, err := systemctl("disable", "--now", name+".timer"); err != nil {
+		return nil, err
+	}
+	var removed []string
+	for _, f := range []string{timer, service} {
+		err := os.Remove(f)
+		if err == nil {
+			removed = append(removed, f)
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return removed, err
+		}
+	}
+	_, err = systemctl("daemon-reload")
+	return removed, err
+}
+
+func writeFile(file string, content string) error {
+	tmp := file + ".tmp"
+	if err := os.WriteFile(tmp, []byte(content), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+// Runs systemctl for the user manager, returns its output.
+func systemctl(args ...string) (string, error) {
+	if err := exec.CommandAvailable("systemctl"); err != nil {
+		return "", fmt.Errorf("no systemctl found: %w", err)
+	}
+	cmd := append([]string{"systemctl", "--user"}, args...)
+	r := exec.Background(cmd, exec.WithTimeout(30*time.Second))
+	if r.Err != nil {
+		return "", fmt.Errorf("%s: %w", strings.Join(cmd, " "), r.Err)
+	}
+	if r.ExitCode != 0 {
+		return "", fmt.Errorf("%s exited with code %v: %s", strings.Join(cmd, " "), r.ExitCode, firstLine(r.Stderr))
+	}
+	return r.Stdout, nil
+}
@@ -1,10 +1,19 @@
 package ui
 
 import (
+	"backup/internal/archivebrowser"
 	"backup/internal/config"
+	"backup/internal/daemon"
 	"backup/internal/dirselect"
+	"backup/internal/filebrowser"
+	"backup/internal/files"
 	"backup/internal/github"
+	"backup/internal/pipeline"
+	"backup/internal/preflight"
+	"backup/internal/restore"
+	"backup/internal/settings"
 	"backup/internal/style"
+	"backup/internal/verify"
 	"backup/internal/zip"
 	"fmt"
 	"io"
@@ -35,10 +44,20 @@ const (
 	stateDirSelect
 	stateZip
 	stateGithub
+	stateVerify
+	stateRestore
+	statePreflight
+	stateFiles
+	stateFileBrowser
+	stateSettings
+	statePipeline
+	stateArchiveBrowser
+	stateDaemon
 )
 
 type model struct {
-	config config.Config
+	configFile string
+	config     config.Config
 
 	state state
 	// if true a dialog to confirm quit will be shown
@@ -50,9 +69,18 @@ type model struct {
 	helpView             help.Model
 	keyMap               keyMap
 
-	dirSelectModel *dirselect.Model
-	zipModel       *zip.Model
-	githubModel    *github.Model
+	dirSelectModel      *dirselect.Model
+	zipModel            *zip.Model
+	githubModel         *github.Model
+	verifyModel         *verify.Model
+	restoreModel        *restore.Model
+	preflightModel      *preflight.Model
+	filesModel          *files.Model
+	fileBrowserModel    *filebrowser.Model
+	settingsModel       *settings.Model
+	pipelineModel       *pipeline.Model
+	archiveBrowserModel *archivebrowser.Model
+	daemonModel         *daemon.Model
 
 	styles style.Styles
 
@@ -88,7 +116,8 @@ func NewModel(configFile string) *model {
 	helpView.Styles = styles.HelpStyles
 
 	return &model{
-		config: config,
+		configFile: configFile,
+		config:     config,
 
 		state:       initialState,
 		confirmQuit: false,
@@ -101,9 +130,18 @@ func NewModel(configFile string) *model {
 
 		// instead of keeping track of each child/nested model we could have used a single generic "innerModel" field of type tea.Model
 		// but sometimes it can be useful to have the concrete types e.g. to call a method not part of the tea.Model interface
-		dirSelectModel: nil,
-		zipModel:       nil,
-		githubModel:    nil,
+		dirSelectModel:      nil,
+		zipModel:            nil,
+		githubModel:         nil,
+		verifyModel:         nil,
+		restoreModel:        nil,
+		preflightModel:      nil,
+		filesModel:          nil,
+		fileBrowserModel:    nil,
+		settingsModel:       nil,
+		pipelineModel:       nil,
+		archiveBrowserModel: nil,
+		daemonModel:         nil,
 
 		styles: styles,
 	}
@@ -113,6 +151,22 @@ func (m *model) Init() tea.Cmd {
 	return nil
 }
 
+// Releases the run lock of a screen that is still working when quitting, otherwise the next run finds a stale lock.
+func (m *model) releaseLocks() {
+	if m.filesModel != nil {
+		m.filesModel.ReleaseLock()
+	}
+	if m.githubModel != nil {
+		m.githubModel.ReleaseLock()
+	}
+	if m.zipModel != nil {
+		m.zipModel.ReleaseLock()
+	}
+	if m.pipelineModel != nil {
+		m.pipelineModel.ReleaseLock()
+	}
+}
+
 func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	if m.confirmQuit {
 		// process any other messages as usual e.g. inner models might have async commands running that will return a message while the confirm quit dialog is still open
@@ -120,6 +174,7 @@ func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		case tea.KeyMsg:
 			switch {
 			case key.Matches(msg, m.keyMap.ConfirmQuit):
+				m.releaseLocks()
 				return m, tea.Quit
 			case key.Matches(msg, m.keyMap.CancelQuit):
 				m.confirmQuit = false
@@ -173,6 +228,42 @@ func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 					m.state = stateGithub
 					m.githubModel = github.NewModel(m.config.BackupDir, m.config.Github, m.styles)
 					cmd = m.githubModel.Init()
+				case mainMenuItemVerify:
+					m.state = stateVerify
+					m.verifyModel = verify.NewModel(m.defaultBackupPath(), m.styles)
+					cmd = m.verifyModel.Init()
+				case mainMenuItemRestore:
+					m.state = stateRestore
+					m.restoreModel = restore.NewModel(m.defaultBackupPath(), m.styles)
+					cmd = m.restoreModel.Init()
+				case mainMenuItemPreflight:
+					m.state = statePreflight
+					m.preflightModel = preflight.NewModel(m.config, m.styles)
+					cmd = m.preflightModel.Init()
+				case mainMenuItemFiles:
+					m.state = stateFiles
+					m.filesModel = files.NewModel(m.config.BackupDir, files.Config{Paths: m.config.Files, Exclude: m.config.Exclude, Workers: m.config.CopyWorkers}, m.styles)
+					cmd = m.filesModel.Init()
+				case mainMenuItemFileBrowser:
+					m.state = stateFileBrowser
+					m.fileBrowserModel = filebrowser.NewModel(m.configFile, m.config.Files, m.styles)
+					cmd = m.fileBrowserModel.Init()
+				case mainMenuItemSettings:
+					m.state = stateSettings
+					m.settingsModel = settings.NewModel(m.configFile, m.styles)
+					cmd = m.settingsModel.Init()
+				case mainMenuItemPipeline:
+					m.state = statePipeline
+					m.pipelineModel = pipeline.NewModel(m.config, m.styles)
+					cmd = m.pipelineModel.Init()
+				case mainMenuItemArchiveBrowser:
+					m.state = stateArchiveBrowser
+					m.archiveBrowserModel = archivebrowser.NewModel(m.defaultBackupPath(), m.styles)
+					cmd = m.archiveBrowserModel.Init()
+				case mainMenuItemDaemon:
+					m.state = stateDaemon
+					m.daemonModel = daemon.NewModel(m.config, m.styles)
+					cmd = m.daemonModel.Init()
 				}
 				// will call SetSize on the nested model we just created
 				m.SetSize(m.width, m.height)
@@ -198,6 +289,12 @@ func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		case zip.Done:
 			m.zipModel = nil
 			m.state = stateMainMenu
+			if msg.Browse != "" {
+				m.state = stateArchiveBrowser
+				m.archiveBrowserModel = archivebrowser.NewModel(msg.Browse, m.styles)
+				m.SetSize(m.width, m.height)
+				cmd = m.archiveBrowserModel.Open()
+			}
 		default:
 			_, cmd = m.zipModel.Update(msg)
 		}
@@ -209,10 +306,102 @@ func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		default:
 			_, cmd = m.githubModel.Update(msg)
 		}
+	case stateVerify:
+		switch msg := msg.(type) {
+		case verify.Done:
+			m.verifyModel = nil
+			m.state = stateMainMenu
+		default:
+			_, cmd = m.verifyModel.Update(msg)
+		}
+	case stateRestore:
+		switch msg := msg.(type) {
+		case restore.Done:
+			m.restoreModel = nil
+			m.state = stateMainMenu
+		default:
+			_, cmd = m.restoreModel.Update(msg)
+		}
+	case statePreflight:
+		switch msg := msg.(type) {
+		case preflight.Done:
+			m.preflightModel = nil
+			m.state = stateMainMenu
+		default:
+			_, cmd = m.preflightModel.Update(msg)
+		}
+	case stateFiles:
+		switch msg := msg.(type) {
+		case files.Done:
+			m.filesModel = nil
+			m.state = stateMainMenu
+		default:
+			_, cmd = m.filesModel.Update(msg)
+		}
+	case stateFileBrowser:
+		switch msg := msg.(type) {
+		case filebrowser.Done:
+			m.config.Files = msg.Files
+			m.fileBrowserModel = nil
+			m.state = stateMainMenu
+		default:
+			_, cmd = m.fileBrowserModel.Update(msg)
+		}
+	case stateSettings:
+		switch msg := msg.(type) {
+		case settings.Done:
+			if msg.Saved {
+				// reload so that defaults are applied again
+				config, err := config.LoadConfig(m.configFile)
+				if err == nil {
+					m.config = config
+					m.mainMenuItemDelegate.backupDir = m.config.BackupDir
+				} else {
+					log.Println("error reloading config:", err)
+				}
+			}
+			m.settingsModel = nil
+			m.state = stateMainMenu
+		default:
+			_, cmd = m.settingsModel.Update(msg)
+		}
+	case statePipeline:
+		switch msg := msg.(type) {
+		case pipeline.Done:
+			m.pipelineModel = nil
+			m.state = stateMainMenu
+		default:
+			_, cmd = m.pipelineModel.Update(msg)
+		}
+	case stateArchiveBrowser:
+		switch msg := msg.(type) {
+		case archivebrowser.Done:
+			m.archiveBrowserModel = nil
+			m.state = stateMainMenu
+		default:
+			_, cmd = m.archiveBrowserModel.Update(msg)
+		}
+	case stateDaemon:
+		switch msg := msg.(type) {
+		case daemon.Done:
+			m.daemonModel = nil
+			m.state = stateMainMenu
+		default:
+			_, cmd = m.daemonModel.Update(msg)
+		}
 	}
 	return m, cmd
 }
 
+// Returns the zip file from the config or the backup directory if there is none.
+// Encrypted archives cannot be read directly, the backup directory is used instead.
+func (m *model) defaultBackupPath() string {
+	if m.config.Zip.File != "" && !m.config.Zip.Encryption.Enabled() {
+		return m.config.Zip.File
+	}
+	return m.config.BackupDir
+}
+
 func (m *model) SetSize(width, height int) {
 	m.width = width
 	m.height = height
@@ -247,6 +436,33 @@ func (m *model) SetSize(width, height int) {
 	if m.githubModel != nil {
 		m.githubModel.SetSize(innerWidth, innerHeight)
 	}
+	if m.verifyModel != nil {
+		m.verifyModel.SetSize(innerWidth, innerHeight)
+	}
+	if m.restoreModel != nil {
+		m.restoreModel.SetSize(innerWidth, innerHeight)
+	}
+	if m.preflightModel != nil {
+		m.preflightModel.SetSize(innerWidth, innerHeight)
+	}
+	if m.filesModel != nil {
+		m.filesModel.SetSize(innerWidth, innerHeight)
+	}
+	if m.fileBrowserModel != nil {
+		m.fileBrowserModel.SetSize(innerWidth, innerHeight)
+	}
+	if m.settingsModel != nil {
+		m.settingsModel.SetSize(innerWidth, innerHeight)
+	}
+	if m.pipelineModel != nil {
+		m.pipelineModel.SetSize(innerWidth, innerHeight)
+	}
+	if m.archiveBrowserModel != nil {
+		m.archiveBrowserModel.SetSize(innerWidth, innerHeight)
+	}
+	if m.daemonModel != nil {
+		m.daemonModel.SetSize(innerWidth, innerHeight)
+	}
 }
 
 func (m *model) View() string {
@@ -293,6 +509,24 @@ func (m *model) View() string {
 		content = m.zipModel.View()
 	case stateGithub:
 		content = m.githubModel.View()
+	case stateVerify:
+		content = m.verifyModel.View()
+	case stateRestore:
+		content = m.restoreModel.View()
+	case statePreflight:
+		content = m.preflightModel.View()
+	case stateFiles:
+		content = m.filesModel.View()
+	case stateFileBrowser:
+		content = m.fileBrowserModel.View()
+	case stateSettings:
+		content = m.settingsModel.View()
+	case statePipeline:
+		content = m.pipelineModel.View()
+	case stateArchiveBrowser:
+		content = m.archiveBrowserModel.View()
+	case stateDaemon:
+		content = m.daemonModel.View()
 	}
 	return styles.ViewStyle.Render(content)
 }
@@ -376,6 +610,15 @@ const (
 	mainMenuItemDirSelect int = iota
 	mainMenuItemZip
 	mainMenuItemGithub
+	mainMenuItemVerify
+	mainMenuItemRestore
+	mainMenuItemPreflight
+	mainMenuItemFiles
+	mainMenuItemFileBrowser
+	mainMenuItemSettings
+	mainMenuItemPipeline
+	mainMenuItemArchiveBrowser
+	mainMenuItemDaemon
 )
 
 type mainMenuItem int
@@ -387,8 +630,17 @@ func (i mainMenuItem) FilterValue() string {
 
 var mainMenuItems = []list.Item{
 	mainMenuItem(mainMenuItemDirSelect),
+	mainMenuItem(mainMenuItemPipeline),
 	mainMenuItem(mainMenuItemZip),
 	mainMenuItem(mainMenuItemGithub),
+	mainMenuItem(mainMenuItemVerify),
+	mainMenuItem(mainMenuItemRestore),
+	mainMenuItem(mainMenuItemArchiveBrowser),
+	mainMenuItem(mainMenuItemPreflight),
+	mainMenuItem(mainMenuItemDaemon),
+	mainMenuItem(mainMenuItemFiles),
+	mainMenuItem(mainMenuItemFileBrowser),
+	mainMenuItem(mainMenuItemSettings),
 }
 
 type mainMenuItemDelegate struct {
@@ -422,10 +674,37 @@ func (d mainMenuItemDelegate) Render(w io.Writer, m list.Model, index int, listI
 		description = d.backupDir
 	case mainMenuItemZip:
 		title = "Zip"
-		description = "Zip Backup Directory"
+		description = "Zip or tar the backup directory"
 	case mainMenuItemGithub:
 		title = "GitHub"
 		description = "Backup your repos"
+	case mainMenuItemVerify:
+		title = "Verify"
+		description = "Check a backup directory or archive"
+	case mainMenuItemRestore:
+		title = "Restore"
+		description = "Restore files from a backup"
+	case mainMenuItemArchiveBrowser:
+		title = "Browse Archive"
+		description = "Look into a backup archive and extract files"
+	case mainMenuItemPreflight:
+		title = "Pre-flight Check"
+		description = "Estimate backup size and check free space"
+	case mainMenuItemFiles:
+		title = "Files"
+		description = "Copy local files from the config to the backup directory"
+	case mainMenuItemFileBrowser:
+		title = "Browse Files"
+		description = "Choose the files and directories to backup"
+	case mainMenuItemSettings:
+		title = "Settings"
+		description = "View and edit the config file"
+	case mainMenuItemDaemon:
+		title = "Daemon"
+		description = "Status of the scheduled backups"
+	case mainMenuItemPipeline:
+		title = "Run Full Backup"
+		description = "GitHub, files, commands and zip in one go"
 	default:
 		return
 	}
@@ -0,0 +1,365 @@
+package verify
+
+import (
+	"backup/internal/fs"
+	"backup/internal/style"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/help"
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/list"
+	"github.com/charmbracelet/bubbles/spinner"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+type state int
+
+const (
+	stateInput state = iota
+	stateVerifying
+	stateResult
+)
+
+type Model struct {
+	state      state
+	inputError error
+	result     Result
+
+	textInput    textinput.Model
+	problemsList list.Model
+	spinner      spinner.Model
+	helpView     help.Model
+	keyMap       keyMap
+
+	styles style.Styles
+
+	width  int
+	height int
+}
+
+// The given path is used as the initial value of the input, e.g. the zip file from the config.
+func NewModel(path string, styles style.Styles) *Model {
+	ti := textinput.New()
+	ti.CharLimit = 250
+	ti.Width = 40
+	ti.SetValue(path)
+	ti.Focus()
+
+	helpView := help.New()
+	helpView.Styles = styles.HelpStyles
+
+	keyMap := defaultKeyMap()
+
+	problemsList := list.New(nil, problemItemDelegate{}, 0, 0)
+	problemsList.SetFilteringEnabled(false)
+	problemsList.SetShowHelp(false)
+	problemsList.DisableQuitKeybindings()
+	problemsList.SetShowStatusBar(false)
+	problemsList.SetShowPagination(true)
+	problemsList.SetShowTitle(false)
+	problemsList.KeyMap = keyMap.listKeyMap()
+
+	return &Model{
+		state:        stateInput,
+		textInput:    ti,
+		problemsList: problemsList,
+		spinner: spinner.New(
+			spinner.WithSpinner(spinner.Dot),
+			spinner.WithStyle(styles.ListItemSelectedStyle),
+		),
+		helpView: helpView,
+		keyMap:   keyMap,
+		styles:   styles,
+	}
+}
+
+func (m *Model) Init() tea.Cmd {
+	return textinput.Blink
+}
+
+func (m *Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	var cmd tea.Cmd
+
+	switch m.state {
+	case stateInput:
+		switch msg := msg.(type) {
+		case tea.KeyMsg:
+			switch {
+			case key.Matches(msg, m.keyMap.InputConfirm):
+				path := strings.TrimSpace(m.textInput.Value())
+				if path == "" {
+					m.inputError = errors.New("path cannot be empty")
+					break
+				}
+				absPath, err := fs.AbsPath(path)
+				if err != nil {
+					m.inputError = err
+					break
+				}
+				m.inputError = nil
+				m.state = stateVerifying
+				cmd = tea.Batch(verifyCmd(absPath), m.spinner.Tick)
+			case key.Matches(msg, m.keyMap.InputBack):
+				cmd = done()
+			default:
+				m.textInput, cmd = m.textInput.Update(msg)
+			}
+		default:
+			m.textInput, cmd = m.textInput.Update(msg)
+		}
+	case stateVerifying:
+		switch msg := msg.(type) {
+		case verifyResult:
+			if msg.err != nil {
+				m.state = stateInput
+				m.inputError = msg.err
+			} else {
+				m.state = stateResult
+				m.result = msg.result
+				items := make([]list.Item, len(msg.result.Problems))
+				for i, p := range msg.result.Problems {
+					items[i] = p
+				}
+				cmd = m.problemsList.SetItems(items)
+				m.setListSize()
+			}
+		case spinner.TickMsg:
+			m.spinner, cmd = m.spinner.Update(msg)
+		}
+	case stateResult:
+		switch msg := msg.(type) {
+		case tea.KeyMsg:
+			switch {
+			case key.Matches(msg, m.keyMap.ResultReturn):
+				cmd = done()
+			case key.Matches(msg, m.keyMap.ResultAgain):
+				m.state = stateInput
+			default:
+				m.problemsList, cmd = m.problemsList.Update(msg)
+			}
+		default:
+			m.problemsList, cmd = m.problemsList.Update(msg)
+		}
+	}
+
+	return m, cmd
+}
+
+func (m *Model) SetSize(width, height int) {
+	m.width = width
+	m.height = height
+	m.helpView.Width = width
+	m.setListSize()
+}
+
+func (m *Model) setListSize() {
+	// 2 lines for title, 4 lines for summary, 2 lines for help, plus 2 empty lines
+	listHeight := m.height - 10
+	if listHeight < 2 {
+		listHeight = 2
+	}
+	m.problemsList.SetSize(m.width, listHeight)
+}
+
+func (m *Model) View() string {
+	styles := m.styles
+	var content string
+
+	switch m.state {
+	case stateInput:
+		parts := []string{
+			styles.TitleStyle.Render("Verify Backup"),
+			"",
+			styles.NormalTextStyle.Render("Enter backup directory or archive"),
+			"",
+			m.textInput.View(),
+			"",
+		}
+		if m.inputError != nil {
+			parts = append(parts, styles.ErrorTextStyle.Render(m.inputError.Error()), "")
+		}
+		parts = append(parts, m.helpView.ShortHelpView(m.keyMap.inputKeys()))
+		content = lipgloss.JoinVertical(lipgloss.Left, parts...)
+	case stateVerifying:
+		content = lipgloss.JoinVertical(
+			lipgloss.Left,
+			styles.TitleStyle.Render("Verify Backup"),
+			"",
+			fmt.Sprintf(
+				"%s %s",
+				styles.NormalTextStyle.UnsetWidth().Render("Verifying"),
+				m.spinner.View(),
+			),
+		)
+	case stateResult:
+		result := m.result
+		source := "Checked against manifest"
+		if !result.HasManifest {
+			source = "No manifest found, only checked that files can be read"
+		}
+		parts := []string{
+			styles.TitleStyle.Render("Verify Backup"),
+			"",
+			styles.NormalTextStyle.Render(result.Source),
+			styles.NormalTextStyle.Render(source),
+		}
+		if result.Ok() {
+			parts = append(parts, styles.NormalTextStyle.Render(fmt.Sprintf("All %v files ok", result.Checked)), "")
+		} else {
+			parts = append(
+				parts,
+				styles.ErrorTextStyle.Render(fmt.Sprintf(
+					"%v missing, %v changed, %v corrupt (%v files checked)",
+					result.Count(StatusMissing),
+					result.Count(StatusChanged),
+					result.Count(StatusCorrupt),
+					result.Checked,
+				)),
+				"",
+				m.problemsList.View(),
+				"",
+			)
+		}
+		parts = append(parts, m.helpView.ShortHelpView(m.keyMap.resultKeys()))
+		content = lipgloss.JoinVertical(lipgloss.Left, parts...)
+	}
+
+	return content
+}
+
+type verifyResult struct {
+	result Result
+	err    error
+}
+
+func verifyCmd(path string) tea.Cmd {
+	return func() tea.Msg {
+		result, err := Verify(path)
+		return verifyResult{result: result, err: err}
+	}
+}
+
+type problemItemDelegate struct{}
+
+func (d problemItemDelegate) Height() int {
+	return 1
+}
+
+func (d problemItemDelegate) Spacing() int {
+	return 0
+}
+
+func (d problemItemDelegate) Update(msg tea.Msg, m *list.Model) tea.Cmd {
+	return nil
+}
+
+var statusStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("#de0d18")).Width(8)
+var itemStyle = lipgloss.NewStyle().PaddingLeft(4)
+var selectedItemStyle = lipgloss.NewStyle().PaddingLeft(2).Foreground(lipgloss.Color("170"))
+
+func (d problemItemDelegate) Render(w io.Writer, m list.Model, index int, listItem list.Item) {
+	p, ok := listItem.(Problem)
+	if !ok {
+		return
+	}
+
+	s := fmt.Sprintf("%s %s", statusStyle.Render(p.Status.String()), p.Path)
+	if p.Detail != "" {
+		s = fmt.Sprintf("%s (%s)", s, p.Detail)
+	}
+
+	if index == m.Index() {
+		s = selectedItemStyle.Render("> " + s)
+	} else {
+		s = itemStyle.Render(s)
+	}
+	fmt.Fprint(w, s)
+}
+
+type Done struct{}
+
+func done() tea.Cmd {
+	return func() tea.Msg {
+		return Done{}
+	}
+}
+
+type keyMap struct {
+	InputConfirm key.Binding
+	InputBack    key.Binding
+
+	CursorUp   key.Binding
+	CursorDown key.Binding
+	PrevPage   key.Binding
+	NextPage   key.Binding
+
+	ResultAgain  key.Binding
+	ResultReturn key.Binding
+}
+
+func defaultKeyMap() keyMap {
+	return keyMap{
+		InputConfirm: key.NewBinding(
+			key.WithKeys("enter"),
+			key.WithHelp("enter", "verify"),
+		),
+		InputBack: key.NewBinding(
+			key.WithKeys("esc"),
+			key.WithHelp("esc", "back"),
+		),
+		CursorUp: key.NewBinding(
+			key.WithKeys("k"),
+			key.WithHelp("k", "up"),
+		),
+		CursorDown: key.NewBinding(
+			key.WithKeys("j"),
+			key.WithHelp("j", "down"),
+		),
+		PrevPage: key.NewBinding(
+			key.WithKeys("h"),
+			key.WithHelp("h", "prev page"),
+		),
+		NextPage: key.NewBinding(
+			key.WithKeys("l"),
+			key.WithHelp("l", "next page"),
+		),
+		ResultAgain: key.NewBinding(
+			key.WithKeys("v"),
+			key.WithHelp("v", "verify another"),
+		),
+		ResultReturn: key.NewBinding(
+			key.WithKeys("enter"),
+			key.WithHelp("enter", "return"),
+		),
+	}
+}
+
+func (m keyMap) listKeyMap() list.KeyMap {
+	return list.KeyMap{
+		CursorUp:             m.CursorUp,
+		CursorDown:           m.CursorDown,
+		PrevPage:             m.PrevPage,
+		NextPage:             m.NextPage,
+		GoToStart:            key.NewBinding(key.WithDisabled()),
+		GoToEnd:              key.NewBinding(key.WithDisabled()),
+		Filter:               key.NewBinding(key.WithDisabled()),
+		ClearFilter:          key.NewBinding(key.WithDisabled()),
+		CancelWhileFiltering: key.NewBinding(key.WithDisabled()),
+		AcceptWhileFiltering: key.NewBinding(key.WithDisabled()),
+		ShowFullHelp:         key.NewBinding(key.WithDisabled()),
+		CloseFullHelp:        key.NewBinding(key.WithDisabled()),
+	}
+}
+
+func (m keyMap) inputKeys() []key.Binding {
+	return []key.Binding{m.InputBack, m.InputConfirm}
+}
+
+func (m keyMap) resultKeys() []key.Binding {
+	return []key.Binding{m.CursorUp, m.CursorDown, m.PrevPage, m.NextPage, m.ResultAgain, m.ResultReturn}
+}
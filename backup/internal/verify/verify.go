@@ -0,0 +1,174 @@
+package verify
+
+import (
+	"backup/internal/archive"
+	"backup/internal/manifest"
+	"fmt"
+)
+
+type Status int
+
+const (
+	StatusOk Status = iota
+	// file is listed in the manifest but not part of the backup
+	StatusMissing
+	// file can be read but does not match the manifest
+	StatusChanged
+	// file cannot be read e.g. because of an I/O error or a checksum mismatch
+	StatusCorrupt
+)
+
+func (s Status) String() string {
+	switch s {
+	case StatusOk:
+		return "ok"
+	case StatusMissing:
+		return "missing"
+	case StatusChanged:
+		return "changed"
+	case StatusCorrupt:
+		return "corrupt"
+	default:
+		return "unknown"
+	}
+}
+
+type Problem struct {
+	Path   string
+	Status Status
+	Detail string
+}
+
+// implement the Item interface from the bubbles/list package
+func (p Problem) FilterValue() string {
+	return p.Path
+}
+
+type Result struct {
+	// backup directory or archive that was verified
+	Source string
+	// if false, files could only be checked for readability and against the checksums stored in the archive
+	HasManifest bool
+	Checked     int
+	Problems    []Problem
+}
+
+func (r Result) Ok() bool {
+	return len(r.Problems) == 0
+}
+
+func (r Result) Count(status Status) int {
+	n := 0
+	for _, p := range r.Problems {
+		if p.Status == status {
+			n++
+		}
+	}
+	return n
+}
+
+// Verify re-hashes every file of a backup directory or archive and compares the results against the manifest.
+// If there is no manifest, every file is read completely, which for zip files will also check the CRC-32 checksums.
+func Verify(path string) (Result, error) {
+	result := Result{Source: path}
+
+	r, err := archive.Open(path)
+	if err != nil {
+		return result, err
+	}
+	defer r.Close()
+
+	m, ok, err := loadManifest(r)
+	if err != nil {
+		return result, err
+	}
+	result.HasManifest = ok
+
+	if !ok {
+		for _, e := range r.Entries() {
+			result.Checked++
+			if e.IsSymlink() {
+				continue
+			}
+			if _, err := hashEntry(r, e.Path); err != nil {
+				result.Problems = append(result.Problems, Problem{Path: e.Path, Status: StatusCorrupt, Detail: err.Error()})
+			}
+		}
+		return result, nil
+	}
+
+	for _, f := range m.Files {
+		result.Checked++
+		e, ok := archive.Find(r, f.Path)
+		if !ok {
+			result.Problems = append(result.Problems, Problem{Path: f.Path, Status: StatusMissing})
+			continue
+		}
+
+		if f.IsSymlink() {
+			if e.Link != f.Link {
+				result.Problems = append(result.Problems, Problem{
+					Path:   f.Path,
+					Status: StatusChanged,
+					Detail: fmt.Sprintf("symlink target is %s, expected %s", e.Link, f.Link),
+				})
+			}
+			continue
+		}
+
+		hash, err := hashEntry(r, f.Path)
+		if err != nil {
+			result.Problems = append(result.Problems, Problem{Path: f.Path, Status: StatusCorrupt, Detail: err.Error()})
+		} else if e.Size != f.Size {
+			result.Problems = append(result.Problems, Problem{
+				Path:   f.Path,
+				Status: StatusChanged,
+				Detail: fmt.Sprintf("size is %v, expected %v", e.Size, f.Size),
+			})
+		} else if hash != f.SHA256 {
+			result.Problems = append(result.Problems, Problem{Path: f.Path, Status: StatusChanged, Detail: "checksum mismatch"})
+		}
+	}
+
+	return result, nil
+}
+
+func loadManifest(r archive.Reader) (manifest.Manifest, bool, error) {
+	if _, ok := archive.Find(r, manifest.FileName); !ok {
+		return manifest.Manifest{}, false, nil
+	}
+	rc, err := r.Open(manifest.FileName)
+	if err != nil {
+		return manifest.Manifest{}, false, err
+	}
+	defer rc.Close()
+	m, err := manifest.Decode(rc)
+	if err != nil {
+		return m, false, err
+	}
+	return m, true, nil
+}
+
+func hashEntry(r archive.Reader, path string) (string, error) {
+	rc, err := r.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+	return manifest.Hash(rc)
+}
+
+// Returns a short summary of the result e.g. to print on the command line.
+func (r Result) Summary() string {
+	if r.Ok() {
+		return fmt.Sprintf("all %v files ok", r.Checked)
+	}
+	return fmt.Sprintf(
+		"%v of %v files have problems: %v missing, %v changed, %v corrupt",
+		len(r.Problems),
+		r.Checked,
+		r.Count(StatusMissing),
+		r.Count(StatusChanged),
+		r.Count(StatusCorrupt),
+	)
+}
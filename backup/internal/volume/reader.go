@@ -0,0 +1,211 @@
+package volume
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// A Reader reads the volumes of an archive as if they were a single file.
+type Reader struct {
+	index   Index
+	files   []*os.File
+	offsets []int64
+}
+
+// Open opens all volumes of an index. Only the sizes are checked, use Verify to check the checksums.
+func Open(indexFile string) (*Reader, error) {
+	index, err := ReadIndex(indexFile)
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Dir(indexFile)
+
+	r := &Reader{index: index}
+	var offset int64
+	for _, v := range index.Volumes {
+		path := filepath.Join(dir, v.Name)
+		f, err := os.Open(path)
+		if err != nil {
+			r.Close()
+			return nil, fmt.Errorf("volume %s is missing: %w", v.Name, err)
+		}
+		r.files = append(r.files, f)
+		info, err := f.Stat()
+		if err != nil {
+			r.Close()
+			return nil, err
+		}
+		if info.Size() != v.Size {
+			r.Close()
+			return nil, fmt.Errorf("volume %s has %v bytes instead of %v", v.Name, info.Size(), v.Size)
+		}
+		r.offsets = append(r.offsets, offset)
+		offset += v.Size
+	}
+	return r, nil
+}
+
+func (r *Reader) Index() Index {
+	return r.index
+}
+
+// Size of the joined archive.
+func (r *Reader) Size() int64 {
+	return r.index.Size
+}
+
+func (r *Reader) ReadAt(p []byte, off int64) (int, error) {
+	if off >= r.index.Size {
+		return 0, io.EOF
+	}
+	// last volume that starts at or before off
+	i := sort.Search(len(r.offsets), func(i int) bool {
+		return r.offsets[i] > off
+	}) - 1
+
+	n := 0
+	for n < len(p) && i < len(r.files) {
+		m, err := r.files[i].ReadAt(p[n:], off+int64(n)-r.offsets[i])
+		n += m
+		if err == io.EOF {
+			i++
+			continue
+		}
+		if err != nil {
+			return n, err
+		}
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// NewReader returns a reader from the beginning of the joined archive.
+func (r *Reader) NewReader() io.Reader {
+	return io.NewSectionReader(r, 0, r.index.Size)
+}
+
+func (r *Reader) Close() error {
+	var err error
+	for _, f := range r.files {
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	r.files = nil
+	return err
+}
+
+type Problem struct {
+	Volume string
+	Detail string
+}
+
+type Progress struct {
+	BytesDone  int64
+	BytesTotal int64
+	// volume that is currently read
+	Current string
+}
+
+// how often progress is reported at most
+const progressInterval = 100 * time.Millisecond
+
+// Verify checks that all volumes exist and have the size and checksum of the index.
+// If output is not empty, the joined archive is written to it, but only if all volumes are ok.
+// Problems with single volumes are returned, err is only set if the volumes could not be checked at all.
+func Verify(indexFile string, output string, onProgress func(Progress)) ([]Problem, error) {
+	index, err := ReadIndex(indexFile)
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Dir(indexFile)
+
+	var out *os.File
+	if output != "" {
+		if err := os.MkdirAll(filepath.Dir(output), 0775); err != nil {
+			return nil, err
+		}
+		out, err = os.CreateTemp(filepath.Dir(output), filepath.Base(output)+".*.tmp")
+		if err != nil {
+			return nil, err
+		}
+		defer os.Remove(out.Name())
+		defer out.Close()
+	}
+
+	var problems []Problem
+	total := sha256.New()
+	progress := Progress{BytesTotal: index.Size}
+	var lastProgress time.Time
+	for _, v := range index.Volumes {
+		progress.Current = v.Name
+		f, err := os.Open(filepath.Join(dir, v.Name))
+		if err != nil {
+			problems = append(problems, Problem{Volume: v.Name, Detail: "missing"})
+			continue
+		}
+		h := sha256.New()
+		writers := []io.Writer{h, total}
+		if out != nil {
+			writers = append(writers, out)
+		}
+		n, err := io.Copy(io.MultiWriter(writers...), &progressReader{r: f, onRead: func(n int) {
+			progress.BytesDone += int64(n)
+			if onProgress != nil && time.Since(lastProgress) >= progressInterval {
+				lastProgress = time.Now()
+				onProgress(progress)
+			}
+		}})
+		f.Close()
+		switch {
+		case err != nil:
+			problems = append(problems, Problem{Volume: v.Name, Detail: err.Error()})
+		case n != v.Size:
+			problems = append(problems, Problem{Volume: v.Name, Detail: fmt.Sprintf("%v bytes instead of %v", n, v.Size)})
+		case hex.EncodeToString(h.Sum(nil)) != v.Sha256:
+			problems = append(problems, Problem{Volume: v.Name, Detail: "checksum mismatch"})
+		}
+	}
+	if onProgress != nil {
+		progress.Current = ""
+		onProgress(progress)
+	}
+
+	// only checked if all volumes are fine, otherwise it is wrong anyway
+	if len(problems) == 0 && hex.EncodeToString(total.Sum(nil)) != index.Sha256 {
+		problems = append(problems, Problem{Volume: index.File, Detail: "checksum of the joined archive does not match, volumes are in the wrong order"})
+	}
+	if len(problems) > 0 || out == nil {
+		return problems, nil
+	}
+
+	if err := out.Close(); err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(out.Name(), 0644); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(out.Name(), output); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+type progressReader struct {
+	r      io.Reader
+	onRead func(n int)
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.onRead(n)
+	return n, err
+}
@@ -0,0 +1,274 @@
+// Package volume splits archives into numbered volumes of a maximum size, e.g. for FAT32 drives or upload limits.
+//
+// An archive backup.tar.zst is written as backup.tar.zst.001, backup.tar.zst.002, ... and an index
+// backup.tar.zst.index.json that lists the volumes with their sizes and checksums.
+package volume
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// IndexExtension is appended to the name of the archive to get the name of the index.
+const IndexExtension = ".index.json"
+
+// while a volume is written it has this extension, volumes are only renamed when all of them were written
+const partialExtension = ".partial"
+
+type Volume struct {
+	// file name without directory, volumes are always next to the index
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	Sha256 string `json:"sha256"`
+}
+
+type Index struct {
+	// name of the archive when the volumes are joined
+	File string `json:"file"`
+	Size int64  `json:"size"`
+	// checksum of the joined archive
+	Sha256 string `json:"sha256"`
+	// maximum size of a volume
+	VolumeSize int64    `json:"volumeSize"`
+	Volumes    []Volume `json:"volumes"`
+}
+
+// IndexFile returns the name of the index of an archive.
+func IndexFile(file string) string {
+	return file + IndexExtension
+}
+
+// Name of the nth volume of an archive, starting at 1.
+func Name(file string, n int) string {
+	return fmt.Sprintf("%s.%03d", file, n)
+}
+
+var volumeNumber = regexp.MustCompile(`\.[0-9]{3,}$`)
+
+// FindIndex returns the index file for an index, a volume or an archive that was split, ok is false if there is none.
+func FindIndex(path string) (string, bool) {
+	var index string
+	switch {
+	case strings.HasSuffix(path, IndexExtension):
+		index = path
+	case volumeNumber.MatchString(path):
+		index = IndexFile(volumeNumber.ReplaceAllString(path, ""))
+	default:
+		index = IndexFile(path)
+	}
+	if info, err := os.Stat(index); err != nil || info.IsDir() {
+		return "", false
+	}
+	return index, true
+}
+
+// ParseSize parses sizes like 4G, 700M, 100K or a number of bytes. Units are powers of 1024.
+func ParseSize(input string) (int64, error) {
+	s := strings.TrimSpace(strings.ToUpper(input))
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "B"), "I")
+	multiplier := int64(1)
+	if s != "" {
+		switch s[len(s)-1] {
+		case 'K':
+			multiplier = 1024
+		case 'M':
+			multiplier = 1024 * 1024
+		case 'G':
+			multiplier = 1024 * 1024 * 1024
+		case 'T':
+			multiplier = 1024 * 1024 * 1024 * 1024
+		}
+		if multiplier > 1 {
+			s = s[:len(s)-1]
+		}
+	}
+	n, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid size %q, expected e.g. 4G, 700M or a number of bytes", input)
+	}
+	size := int64(n * float64(multiplier))
+	// the index and a few bytes of every volume are not worth splitting for
+	if size < 1024 {
+		return 0, fmt.Errorf("size %q is too small, it must be at least 1K", input)
+	}
+	return size, nil
+}
+
+// A Writer writes an archive as volumes of at most VolumeSize bytes.
+type Writer struct {
+	file       string
+	volumeSize int64
+
+	current     *os.File
+	currentSize int64
+	currentHash hash.Hash
+
+	size    int64
+	hash    hash.Hash
+	volumes []Volume
+	// partial files that were created
+	partials []string
+}
+
+// Create returns a writer for the volumes of file. Close has to be called to get the volumes and the index,
+// until then only partial files exist.
+func Create(file string, volumeSize int64) (*Writer, error) {
+	if volumeSize <= 0 {
+		return nil, errors.New("volume size must be greater than 0")
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0775); err != nil {
+		return nil, err
+	}
+	return &Writer{file: file, volumeSize: volumeSize, hash: sha256.New()}, nil
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		if w.current == nil || w.currentSize == w.volumeSize {
+			if err := w.next(); err != nil {
+				return written, err
+			}
+		}
+		chunk := p
+		if remaining := w.volumeSize - w.currentSize; int64(len(chunk)) > remaining {
+			chunk = chunk[:remaining]
+		}
+		n, err := w.current.Write(chunk)
+		w.currentHash.Write(chunk[:n])
+		w.hash.Write(chunk[:n])
+		w.currentSize += int64(n)
+		w.size += int64(n)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// finishes the current volume and starts the next one
+func (w *Writer) next() error {
+	if err := w.finish(); err != nil {
+		return err
+	}
+	name := Name(w.file, len(w.volumes)+1)
+	f, err := os.OpenFile(name+partialExtension, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	w.partials = append(w.partials, name+partialExtension)
+	w.current = f
+	w.currentSize = 0
+	w.currentHash = sha256.New()
+	return nil
+}
+
+func (w *Writer) finish() error {
+	if w.current == nil {
+		return nil
+	}
+	err := w.current.Close()
+	w.volumes = append(w.volumes, Volume{
+		Name:   filepath.Base(Name(w.file, len(w.volumes)+1)),
+		Size:   w.currentSize,
+		Sha256: hex.EncodeToString(w.currentHash.Sum(nil)),
+	})
+	w.current = nil
+	return err
+}
+
+// Close renames the volumes to their final names and writes the index.
+// Volumes of an earlier archive with the same name that are not part of this one are removed.
+func (w *Writer) Close() error {
+	// an empty archive still has one volume
+	if w.current == nil && len(w.volumes) == 0 {
+		if err := w.next(); err != nil {
+			return err
+		}
+	}
+	if err := w.finish(); err != nil {
+		return err
+	}
+
+	for i := range w.volumes {
+		name := Name(w.file, i+1)
+		if err := os.Rename(name+partialExtension, name); err != nil {
+			return err
+		}
+	}
+	w.partials = nil
+	for n := len(w.volumes) + 1; ; n++ {
+		if err := os.Remove(Name(w.file, n)); err != nil {
+			break
+		}
+	}
+
+	return WriteIndex(IndexFile(w.file), w.Index())
+}
+
+// Abort removes everything that was written so far.
+func (w *Writer) Abort() {
+	if w.current != nil {
+		w.current.Close()
+		w.current = nil
+	}
+	for _, p := range w.partials {
+		os.Remove(p)
+	}
+	w.partials = nil
+}
+
+// Index returns the index of the volumes that were written so far.
+func (w *Writer) Index() Index {
+	return Index{
+		File:       filepath.Base(w.file),
+		Size:       w.size,
+		Sha256:     hex.EncodeToString(w.hash.Sum(nil)),
+		VolumeSize: w.volumeSize,
+		Volumes:    w.volumes,
+	}
+}
+
+func WriteIndex(file string, index Index) error {
+	b, err := json.MarshalIndent(index, "", "    ")
+	if err != nil {
+		return err
+	}
+	tmp := file + partialExtension
+	if err := os.WriteFile(tmp, append(b, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+func ReadIndex(file string) (Index, error) {
+	var index Index
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return index, err
+	}
+	if err := json.Unmarshal(b, &index); err != nil {
+		return index, fmt.Errorf("invalid index %s: %w", file, err)
+	}
+	if len(index.Volumes) == 0 {
+		return index, fmt.Errorf("invalid index %s: no volumes", file)
+	}
+	for _, v := range index.Volumes {
+		// volumes are always next to the index, a path in the index must not point anywhere else
+		if v.Name != filepath.Base(v.Name) || v.Name == ".." {
+			return index, fmt.Errorf("invalid index %s: invalid volume name %q", file, v.Name)
+		}
+	}
+	return index, nil
+}
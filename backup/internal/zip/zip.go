@@ -1,22 +1,80 @@
 package zip
 
 import (
+	"backup/internal/archive"
+	"backup/internal/crypt"
 	"backup/internal/exec"
 	"backup/internal/fs"
+	"backup/internal/lock"
+	"backup/internal/manifest"
 	"backup/internal/style"
+	"backup/internal/volume"
 	"errors"
 	"fmt"
+	"io"
 	"time"
 
 	"github.com/charmbracelet/bubbles/help"
 	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/progress"
 	"github.com/charmbracelet/bubbles/textinput"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 )
 
 type Config struct {
-	File string `json:"file"`
+	File string `json:"file,omitempty"`
+	// zip, tar, tar.gz, tar.xz or tar.zst, zip if empty
+	Format string `json:"format,omitempty"`
+	// compression level, the default of the format if 0
+	Level int `json:"level,omitempty"`
+	// the archive is encrypted if set
+	Encryption *crypt.Config `json:"encryption,omitempty"`
+	// maximum size of a file, e.g. 4000M, bigger archives are split into volumes
+	SplitSize string `json:"splitSize,omitempty"`
+	// full backups are written straight into the archive, nothing is copied into the backup directory
+	Stream bool `json:"stream,omitempty"`
+}
+
+// ArchiveOptions returns the options to create an archive with the configured format and compression level.
+func (c Config) ArchiveOptions() (archive.Options, error) {
+	format, err := archive.ParseFormat(c.Format)
+	if err != nil {
+		return archive.Options{}, err
+	}
+	if err := archive.ValidateLevel(format, c.Level); err != nil {
+		return archive.Options{}, err
+	}
+	opts := archive.Options{Format: format, Level: c.Level}
+	if c.SplitSize != "" {
+		opts.SplitSize, err = volume.ParseSize(c.SplitSize)
+		if err != nil {
+			return archive.Options{}, fmt.Errorf("invalid split size: %w", err)
+		}
+	}
+	if c.Encryption.Enabled() {
+		// read the keys now so that a missing key file is reported before anything is written
+		keys, err := c.Encryption.Keys()
+		if err != nil {
+			return archive.Options{}, err
+		}
+		opts.Encrypt = func(w io.Writer) (io.WriteCloser, error) {
+			return crypt.Encrypt(w, keys)
+		}
+	}
+	return opts, nil
+}
+
+// TargetFile returns the absolute path of the archive, encrypted archives get an additional extension.
+func (c Config) TargetFile(file string) (string, error) {
+	absFile, err := fs.AbsPath(file)
+	if err != nil {
+		return "", err
+	}
+	if c.Encryption.Enabled() {
+		absFile = crypt.FileName(absFile)
+	}
+	return absFile, nil
 }
 
 type state int
@@ -35,13 +93,21 @@ type Model struct {
 	backupDir  string
 	config     Config
 	inputError error
+	file       string
+	format     archive.Format
+	opts       archive.Options
 	result     zipResult
+	progress   archive.Progress
+	updates    chan tea.Msg
+	// run lock of the backup directory while zipping
+	lock *lock.Lock
 
 	keyMap keyMap
 
-	textInput  textinput.Model
-	errorModel *exec.ErrorModel
-	help       help.Model
+	textInput   textinput.Model
+	errorModel  *exec.ErrorModel
+	progressBar progress.Model
+	help        help.Model
 
 	styles style.Styles
 
@@ -58,19 +124,27 @@ func NewModel(backupDir string, config Config, styles style.Styles) *Model {
 	}
 	zt.Focus()
 
+	// an invalid format in the config falls back to zip, the script reports it as an error
+	format, err := archive.ParseFormat(config.Format)
+	if err != nil {
+		format = archive.FormatZip
+	}
+
 	help := help.New()
 	help.Styles = styles.HelpStyles
 
 	return &Model{
-		state:      stateInput,
-		backupDir:  backupDir,
-		config:     config,
-		inputError: nil,
-		keyMap:     defaultKeyMap(),
-		textInput:  zt,
-		errorModel: nil,
-		help:       help,
-		styles:     styles,
+		state:       stateInput,
+		backupDir:   backupDir,
+		config:      config,
+		format:      format,
+		inputError:  nil,
+		keyMap:      defaultKeyMap(),
+		textInput:   zt,
+		errorModel:  nil,
+		progressBar: progress.New(progress.WithDefaultGradient()),
+		help:        help,
+		styles:      styles,
 	}
 }
 
@@ -88,19 +162,32 @@ func (m *Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			switch {
 			case key.Matches(msg, m.keyMap.inputConfirm):
 				file := m.textInput.Value()
-				if file == "" {
+				opts, err := m.archiveOptions()
+				if err != nil {
+					m.inputError = err
+				} else if file == "" {
 					m.inputError = errors.New("please type something, anything, I beg you")
 				} else {
-					absFile, err := fs.AbsPath(file)
+					absFile, err := m.config.TargetFile(file)
+					if err == nil {
+						m.lock, err = lock.Acquire(m.backupDir, "TUI Zip")
+					}
 					if err != nil {
 						m.inputError = err
 					} else {
 						m.inputError = nil
+						m.file = absFile
+						m.opts = opts
 						m.result = zipResult{}
 						m.state = stateZipping
-						cmd = zipBackupDir(m.backupDir, absFile)
+						// the manifest is created first so that it becomes part of the zip file
+						cmd = createManifest(m.backupDir)
 					}
 				}
+			case key.Matches(msg, m.keyMap.inputNextFormat):
+				m.selectFormat(1)
+			case key.Matches(msg, m.keyMap.inputPrevFormat):
+				m.selectFormat(-1)
 			case key.Matches(msg, m.keyMap.inputBack):
 				cmd = done()
 			default:
@@ -111,19 +198,39 @@ func (m *Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		}
 	case stateZipping:
 		switch msg := msg.(type) {
+		case manifestResult:
+			if msg.err == nil {
+				m.progress = archive.Progress{}
+				m.updates = zipBackupDir(m.backupDir, m.file, m.opts)
+				cmd = waitForUpdate(m.updates)
+			} else {
+				m.ReleaseLock()
+				m.state = stateError
+				m.errorModel = exec.NewErrorModel(exec.Result{ExitCode: -1, Err: msg.err}, m.styles)
+				m.errorModel.SetSize(m.width, m.height)
+			}
+		case progressMsg:
+			m.progress = archive.Progress(msg)
+			cmd = waitForUpdate(m.updates)
 		case zipResult:
-			if msg.result.ExitCode == 0 {
+			m.ReleaseLock()
+			if msg.err == nil {
 				m.state = stateSuccess
 			} else {
 				m.state = stateError
-				m.errorModel = exec.NewErrorModel(msg.result, m.styles)
+				m.errorModel = exec.NewErrorModel(exec.Result{Cmd: []string{"zip", m.backupDir, m.file}, ExitCode: -1, Err: msg.err}, m.styles)
 				m.errorModel.SetSize(m.width, m.height)
 			}
 			m.result = msg
 		}
 	case stateSuccess:
-		if msg, ok := msg.(tea.KeyMsg); ok && key.Matches(msg, m.keyMap.successContinue) {
-			cmd = done()
+		if msg, ok := msg.(tea.KeyMsg); ok {
+			switch {
+			case key.Matches(msg, m.keyMap.successContinue):
+				cmd = done()
+			case key.Matches(msg, m.keyMap.successBrowse) && m.browsable():
+				cmd = browse(m.result.result.File)
+			}
 		}
 	case stateError:
 		switch msg := msg.(type) {
@@ -138,10 +245,44 @@ func (m *Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	return m, cmd
 }
 
+// ReleaseLock releases the run lock if it is held, e.g. when the TUI quits while zipping.
+func (m *Model) ReleaseLock() {
+	m.lock.Release()
+	m.lock = nil
+}
+
+// Selects the next or previous format and changes the extension of the file accordingly.
+func (m *Model) selectFormat(step int) {
+	i := 0
+	for j, f := range archive.Formats {
+		if f == m.format {
+			i = j
+		}
+	}
+	i = (i + step + len(archive.Formats)) % len(archive.Formats)
+	m.format = archive.Formats[i]
+	m.textInput.SetValue(archive.ReplaceExtension(m.textInput.Value(), m.format))
+	m.textInput.CursorEnd()
+}
+
+// The configured level is only used with the configured format, other formats use their default level.
+func (m *Model) archiveOptions() (archive.Options, error) {
+	config := m.config
+	if format, err := archive.ParseFormat(config.Format); err != nil || format != m.format {
+		config.Level = 0
+	}
+	config.Format = string(m.format)
+	return config.ArchiveOptions()
+}
+
 func (m *Model) SetSize(width, height int) {
 	m.width = width
 	m.height = height
 	m.help.Width = width
+	m.progressBar.Width = width
+	if m.progressBar.Width > 60 {
+		m.progressBar.Width = 60
+	}
 	if m.errorModel != nil {
 		m.errorModel.SetSize(width, height)
 	}
@@ -154,12 +295,14 @@ func (m *Model) View() string {
 	switch m.state {
 	case stateInput:
 		parts := []string{
-			styles.TitleStyle.Render("Zip Backup Directory"),
+			styles.TitleStyle.Render("Archive Backup Directory"),
 			"",
 			styles.NormalTextStyle.Render("Enter filename"),
 			"",
 			m.textInput.View(),
 			"",
+			m.formatView(),
+			"",
 		}
 		if m.inputError != nil {
 			parts = append(parts, styles.ErrorTextStyle.Render(m.inputError.Error()), "")
@@ -170,18 +313,34 @@ func (m *Model) View() string {
 			parts...,
 		)
 	case stateZipping:
-		content = ""
-	case stateSuccess:
-		duration := m.result.result.Time.Round(time.Second)
+		p := m.progress
+		var percent float64
+		if p.BytesTotal > 0 {
+			percent = float64(p.BytesDone) / float64(p.BytesTotal)
+		}
 		content = lipgloss.JoinVertical(
 			lipgloss.Left,
-			styles.TitleStyle.Render("Success"),
-			"",
-			styles.NormalTextStyle.Render(fmt.Sprintf("Zipped %s in %s", m.result.file, duration)),
-			styles.NormalTextStyle.Render(fmt.Sprintf("Size %s", fileSizeString(m.result.size))),
+			styles.TitleStyle.Render("Archive Backup Directory"),
 			"",
-			m.help.ShortHelpView(m.keyMap.successKeys()),
+			m.progressBar.ViewAs(percent),
+			styles.NormalTextStyle.Render(fmt.Sprintf("%v of %v files, %s of %s", p.FilesDone, p.FilesTotal, fileSizeString(p.BytesDone), fileSizeString(p.BytesTotal))),
+			styles.ListItemDescriptionStyle.Render(p.Current),
 		)
+	case stateSuccess:
+		r := m.result.result
+		duration := r.Time.Round(time.Second)
+		parts := []string{
+			styles.TitleStyle.Render("Success"),
+			"",
+			styles.NormalTextStyle.Render(fmt.Sprintf("Archived %s in %s", r.File, duration)),
+			styles.NormalTextStyle.Render(fmt.Sprintf("%v files, size %s", r.Files, fileSizeString(r.ArchiveSize))),
+			styles.NormalTextStyle.Render(ratioString(r)),
+		}
+		if len(r.Volumes) > 1 {
+			parts = append(parts, styles.NormalTextStyle.Render(fmt.Sprintf("Split into %v volumes, index %s", len(r.Volumes), fs.BasePath(volume.IndexFile(r.File)))))
+		}
+		parts = append(parts, "", m.help.ShortHelpView(m.keyMap.successKeys(m.browsable())))
+		content = lipgloss.JoinVertical(lipgloss.Left, parts...)
 	case stateError:
 		content = m.errorModel.View()
 	}
@@ -189,6 +348,29 @@ func (m *Model) View() string {
 	return content
 }
 
+func (m *Model) formatView() string {
+	styles := m.styles
+	parts := []string{styles.NormalTextStyle.UnsetWidth().Render("Format ")}
+	for _, f := range archive.Formats {
+		if f == m.format {
+			parts = append(parts, styles.ListItemSelectedStyle.Render("["+string(f)+"]"))
+		} else {
+			parts = append(parts, styles.ListItemDescriptionStyle.Render(" "+string(f)+" "))
+		}
+	}
+	if m.config.Encryption.Enabled() {
+		parts = append(parts, styles.NormalTextStyle.UnsetWidth().Render(" encrypted"))
+	}
+	return lipgloss.JoinHorizontal(lipgloss.Top, parts...)
+}
+
+func ratioString(r archive.Result) string {
+	if r.Size == 0 {
+		return "nothing to compress"
+	}
+	return fmt.Sprintf("%s compressed to %.0f%%", fileSizeString(r.Size), float64(r.ArchiveSize)/float64(r.Size)*100)
+}
+
 func fileSizeString(size int64) string {
 	if size >= 1024*1024 {
 		return fmt.Sprintf("%vM", size/(1024*1024))
@@ -199,37 +381,65 @@ func fileSizeString(size int64) string {
 	}
 }
 
-type zipResult struct {
-	result exec.Result
-	file   string
-	size   int64
+type manifestResult struct {
+	err error
 }
 
-func zipBackupDir(dir string, file string) tea.Cmd {
-	// should work even if dir is /
-	base := fs.BasePath(dir)
-	parent := fs.ParentPath(dir)
-	// why change directory? because otherwise the zip file will contain all the parent directories of files
-	// e.g. when you unzip you will get home/username/backup/somefile instead of just backup/somefile
-	// sh starts a new shell, so we do not have to worry about changing directory back
-	cmd := []string{"sh", "-c", fmt.Sprintf("cd %s && zip -r %s %s", parent, file, base)}
-	return exec.ForegroundCmd(cmd, func(er exec.Result) tea.Msg {
-		result := zipResult{
-			result: er,
-			file:   file,
-			size:   -1,
+func createManifest(dir string) tea.Cmd {
+	return func() tea.Msg {
+		m, err := manifest.Create(dir)
+		if err != nil {
+			return manifestResult{err: fmt.Errorf("could not create manifest: %w", err)}
+		}
+		err = manifest.Write(dir, m)
+		if err != nil {
+			return manifestResult{err: fmt.Errorf("could not write manifest: %w", err)}
 		}
-		if er.ExitCode == 0 {
-			size, err := fs.FileSize(file)
-			if err == nil {
-				result.size = size
+		return manifestResult{}
+	}
+}
+
+type zipResult struct {
+	result archive.Result
+	err    error
+}
+
+type progressMsg archive.Progress
+
+// Zips in the background, progress updates and the final result are sent on the returned channel.
+func zipBackupDir(dir string, file string, opts archive.Options) chan tea.Msg {
+	// buffered so that zipping never has to wait for the UI, if an update is still pending the new one is dropped
+	updates := make(chan tea.Msg, 1)
+	go func() {
+		opts.OnProgress = func(p archive.Progress) {
+			if p.Done {
+				return
 			}
+			select {
+			case updates <- progressMsg(p):
+			default:
+			}
+		}
+		result, err := archive.Create(dir, file, opts)
+		// make sure the final message is not dropped
+		for len(updates) > 0 {
+			time.Sleep(10 * time.Millisecond)
 		}
-		return result
-	})
+		updates <- zipResult{result: result, err: err}
+	}()
+	return updates
 }
 
-type Done struct{}
+func waitForUpdate(updates chan tea.Msg) tea.Cmd {
+	return func() tea.Msg {
+		return <-updates
+	}
+}
+
+// Done is sent when returning to the main menu, Browse is set if the archive should be opened in the archive browser.
+type Done struct {
+	Browse string
+}
 
 func done() tea.Cmd {
 	return func() tea.Msg {
@@ -237,11 +447,25 @@ func done() tea.Cmd {
 	}
 }
 
+func browse(file string) tea.Cmd {
+	return func() tea.Msg {
+		return Done{Browse: file}
+	}
+}
+
+// Encrypted archives cannot be read without decrypting them first.
+func (m *Model) browsable() bool {
+	return !m.config.Encryption.Enabled()
+}
+
 type keyMap struct {
-	inputConfirm key.Binding
-	inputBack    key.Binding
+	inputConfirm    key.Binding
+	inputNextFormat key.Binding
+	inputPrevFormat key.Binding
+	inputBack       key.Binding
 
 	successContinue key.Binding
+	successBrowse   key.Binding
 }
 
 func defaultKeyMap() keyMap {
@@ -250,6 +474,14 @@ func defaultKeyMap() keyMap {
 			key.WithKeys("enter"),
 			key.WithHelp("enter", "confirm"),
 		),
+		inputNextFormat: key.NewBinding(
+			key.WithKeys("tab"),
+			key.WithHelp("tab", "next format"),
+		),
+		inputPrevFormat: key.NewBinding(
+			key.WithKeys("shift+tab"),
+			key.WithHelp("shift+tab", "previous format"),
+		),
 		inputBack: key.NewBinding(
 			key.WithKeys("esc"),
 			key.WithHelp("esc", "back"),
@@ -258,13 +490,20 @@ func defaultKeyMap() keyMap {
 			key.WithKeys("enter"),
 			key.WithHelp("enter", "continue"),
 		),
+		successBrowse: key.NewBinding(
+			key.WithKeys("b"),
+			key.WithHelp("b", "browse archive"),
+		),
 	}
 }
 
 func (m keyMap) inputKeys() []key.Binding {
-	return []key.Binding{m.inputBack, m.inputConfirm}
+	return []key.Binding{m.inputBack, m.inputNextFormat, m.inputConfirm}
 }
 
-func (m keyMap) successKeys() []key.Binding {
+func (m keyMap) successKeys(browsable bool) []key.Binding {
+	if browsable {
+		return []key.Binding{m.successBrowse, m.successContinue}
+	}
 	return []key.Binding{m.successContinue}
 }